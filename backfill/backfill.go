@@ -0,0 +1,202 @@
+// Package backfill catches up with the messages a channel posted while the listeners were
+// down. It remembers the last message processed of every channel and decides whether a
+// signal read from the history is still worth trading.
+package backfill
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/moneyscripter/teletrade/exchanges/paper"
+	"github.com/moneyscripter/teletrade/models"
+	"go.etcd.io/bbolt"
+)
+
+type Mode string
+
+const (
+	Off    Mode = "off"    // missed messages are not read
+	Record Mode = "record" // missed signals are recorded, never traded
+	Replay Mode = "replay" // missed signals still valid are traded, the others are recorded
+)
+
+// ParseMode returns the mode named s, Off when empty
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case "", Off:
+		return Off, nil
+	case Record, Replay:
+		return Mode(s), nil
+	}
+	return "", fmt.Errorf("unknown backfill mode %q", s)
+}
+
+const (
+	// DefaultMaxAge is used when no max age is configured
+	DefaultMaxAge = time.Hour
+	// DefaultLimit is the number of messages read per channel when no limit is configured
+	DefaultLimit = 100
+)
+
+// Entry records a signal read from the history of a channel
+type Entry struct {
+	Signal   models.Signal
+	Replayed bool
+	Reason   string // why it is not replayed
+	At       time.Time
+}
+
+var (
+	lastBucket     = []byte("last")
+	recordedBucket = []byte("recorded")
+)
+
+// Store remembers in bbolt the last message processed of every channel, by channel key since
+// channels may share a group, and the signals read from their history
+type Store struct {
+	db    *bbolt.DB
+	mutex *sync.Mutex
+}
+
+// NewBoltStore opens (or creates) the bbolt database at path
+func NewBoltStore(path string) (*Store, error) {
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("open backfill store: %w", err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{lastBucket, recordedBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &Store{db: db, mutex: &sync.Mutex{}}, nil
+}
+
+// LastID returns the last message processed of the channel, zero if none
+func (s *Store) LastID(channelKey string) (int, error) {
+	var last int
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		if v := tx.Bucket(lastBucket).Get([]byte(channelKey)); len(v) == 8 {
+			last = int(binary.BigEndian.Uint64(v))
+		}
+		return nil
+	})
+	return last, err
+}
+
+// Advance records messageID as the last message processed of the channel, it returns
+// false when a later message was already processed, i.e. the message is seen again
+func (s *Store) Advance(channelKey string, messageID int) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	advanced := false
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(lastBucket)
+		if v := bucket.Get([]byte(channelKey)); len(v) == 8 && int(binary.BigEndian.Uint64(v)) >= messageID {
+			return nil
+		}
+		advanced = true
+		return bucket.Put([]byte(channelKey), binary.BigEndian.AppendUint64(nil, uint64(messageID)))
+	})
+	return advanced, err
+}
+
+// Record keeps the signal read from the history for analytics
+func (s *Store) Record(entry Entry) error {
+	if entry.At.IsZero() {
+		entry.At = time.Now()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%020d-%s", entry.At.UnixNano(), entry.Signal.ID)
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(recordedBucket).Put([]byte(key), data)
+	})
+}
+
+// Recorded returns the recorded signals, the latest first, at most limit of them (zero for all)
+func (s *Store) Recorded(limit int) ([]Entry, error) {
+	var entries []Entry
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(recordedBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if limit > 0 && len(entries) >= limit {
+				break
+			}
+			var entry Entry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return fmt.Errorf("decode entry %s: %w", k, err)
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// StillValid tells whether a signal read from the history can still be traded: it was posted
+// within maxAge and the price never reached its entry since. Feeds without history, like
+// paper.TickerFeed, only tell the current price, so a range crossed and left is missed.
+func StillValid(ctx context.Context, feed paper.PriceFeed, signal models.Signal, maxAge time.Duration, now time.Time) (bool, string) {
+	if maxAge <= 0 {
+		maxAge = DefaultMaxAge
+	}
+	postedAt := signal.Source.PostedAt
+	if age := now.Sub(postedAt); age > maxAge {
+		return false, fmt.Sprintf("posted %s ago", age.Round(time.Second))
+	}
+	candles, err := feed.Since(ctx, signal.Market, postedAt)
+	if err != nil {
+		return false, fmt.Sprintf("no price: %v", err)
+	}
+	if len(candles) == 0 {
+		return false, "no price"
+	}
+	if Triggered(signal.Entry, candles) {
+		return false, "entry already reached"
+	}
+	return true, ""
+}
+
+// Triggered tells whether the price reached the entry range within the candles, either
+// trading inside it or jumping over it
+func Triggered(entry models.EntryRange, candles []paper.Candle) bool {
+	low, high := entry.Low().Float64(), entry.High().Float64()
+	side := func(price float64) int {
+		switch {
+		case price < low:
+			return -1
+		case price > high:
+			return 1
+		}
+		return 0
+	}
+	for _, c := range candles {
+		if c.Low <= high && c.High >= low {
+			return true
+		}
+	}
+	first, last := side(candles[0].Open), side(candles[len(candles)-1].Close)
+	return first != last
+}
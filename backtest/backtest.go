@@ -0,0 +1,319 @@
+// Package backtest replays the history of a channel through its parser and simulates every
+// signal against OHLCV candles, to know how the calls of the channel would have performed.
+package backtest
+
+import (
+	"fmt"
+	"github.com/moneyscripter/teletrade/channels"
+	"github.com/moneyscripter/teletrade/exchanges/paper"
+	"github.com/moneyscripter/teletrade/models"
+	"math"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusInvalid      Status = "invalid"       // the signal can not be simulated, see Outcome.Error
+	StatusNotTriggered Status = "not_triggered" // the entry was never reached
+	StatusStopped      Status = "stopped"       // the stop loss closed the rest of the position
+	StatusLiquidated   Status = "liquidated"    // the price reached the liquidation price before the stop loss
+	StatusAllTargets   Status = "all_targets"   // every target was reached
+	StatusTimeout      Status = "timeout"       // closed at market after Options.MaxDuration
+	StatusOpen         Status = "open"          // still open at the end of the candles, valued at the last close
+)
+
+// Options of the simulation, rates are fractions (0.0005 is 0.05%)
+type Options struct {
+	Fee         float64       // taker fee paid on the entry and every exit
+	Expiry      time.Duration // entries not reached within it are dropped, zero waits until the end of the candles
+	MaxDuration time.Duration // positions still open after it are closed at market, zero never closes them
+	RiskPercent float64       // percent of the equity used as margin of each trade, for the drawdown
+	Breakeven   bool          // the stop loss moves to the entry once the first target is hit
+}
+
+// Outcome is the simulated result of one signal
+type Outcome struct {
+	MessageID  int64     `json:"message_id"`
+	Date       time.Time `json:"date"`
+	Market     string    `json:"market"`
+	Position   string    `json:"position"`
+	Leverage   int       `json:"leverage"`
+	Entry      float64   `json:"entry"`
+	StopLoss   float64   `json:"stop_loss"`
+	Targets    []float64 `json:"targets"`
+	Status     Status    `json:"status"`
+	EntryTime  time.Time `json:"entry_time,omitempty"`
+	ExitTime   time.Time `json:"exit_time,omitempty"`
+	ExitPrice  float64   `json:"exit_price,omitempty"` // average of the exits
+	TargetsHit int       `json:"targets_hit"`
+	Return     float64   `json:"return"` // on the margin, fees included, 0.25 is +25%
+	Error      string    `json:"error,omitempty"`
+}
+
+// Closed reports whether the outcome counts in the statistics
+func (o Outcome) Closed() bool {
+	switch o.Status {
+	case StatusStopped, StatusLiquidated, StatusAllTargets, StatusTimeout:
+		return true
+	}
+	return false
+}
+
+type Summary struct {
+	Messages    int     `json:"messages"`
+	Signals     int     `json:"signals"`
+	Triggered   int     `json:"triggered"`
+	Closed      int     `json:"closed"`
+	Wins        int     `json:"wins"`
+	Losses      int     `json:"losses"`
+	WinRate     float64 `json:"win_rate"`     // wins over closed trades
+	Expectancy  float64 `json:"expectancy"`   // mean return of the closed trades
+	MaxDrawdown float64 `json:"max_drawdown"` // largest fall of the equity from a peak, 0.2 is -20%
+	FinalEquity float64 `json:"final_equity"` // starting from 1, RiskPercent of it used as margin of each trade
+}
+
+type Report struct {
+	Channel  string    `json:"channel"`
+	Summary  Summary   `json:"summary"`
+	Outcomes []Outcome `json:"outcomes"`
+}
+
+// CandleSource provides the candles of a market, sorted by time
+type CandleSource interface {
+	Candles(market string) ([]paper.Candle, error)
+}
+
+// DirCandles loads the candles of a market from <dir>/<market>.csv, see paper.LoadCandles
+type DirCandles struct {
+	dir     string
+	mutex   *sync.Mutex
+	candles map[string][]paper.Candle
+}
+
+// NewDirCandles is a constructor for DirCandles
+func NewDirCandles(dir string) *DirCandles {
+	return &DirCandles{
+		dir:     dir,
+		mutex:   &sync.Mutex{},
+		candles: make(map[string][]paper.Candle),
+	}
+}
+
+func (d *DirCandles) Candles(market string) ([]paper.Candle, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if candles, ok := d.candles[market]; ok {
+		return candles, nil
+	}
+	candles, err := paper.LoadCandles(filepath.Join(d.dir, market+".csv"))
+	if err != nil {
+		return nil, err
+	}
+	d.candles[market] = candles
+	return candles, nil
+}
+
+// Run parses every message with parser and simulates the signals found
+func Run(channel string, parser channels.Channels, messages []Message, source CandleSource, opts Options) Report {
+	report := Report{Channel: channel, Outcomes: []Outcome{}}
+	report.Summary.Messages = len(messages)
+	for _, message := range messages {
+		signal, ok := parser.ParsSignal(message.Text)
+		if !ok {
+			continue
+		}
+		outcome := Outcome{
+			MessageID: message.ID,
+			Date:      message.Date,
+			Market:    signal.Market,
+			Position:  string(signal.Side),
+		}
+		candles, err := source.Candles(signal.Market)
+		if err != nil {
+			outcome.Status = StatusInvalid
+			outcome.Error = fmt.Sprintf("no candles: %v", err)
+		} else {
+			outcome = Simulate(outcome, signal, candles, opts)
+		}
+		report.Outcomes = append(report.Outcomes, outcome)
+	}
+	report.Summary = summarize(report.Summary.Messages, report.Outcomes, opts)
+	return report
+}
+
+// Simulate walks the candles opened after the signal was posted: the entry point is a stop
+// order, then each target closes an equal share of the position and the stop loss the rest.
+// The stop loss is checked before the targets within a candle, including the entry candle,
+// and moves to the entry after the first target with Options.Breakeven.
+func Simulate(outcome Outcome, signal models.Signal, candles []paper.Candle, opts Options) Outcome {
+	invalid := func(format string, args ...interface{}) Outcome {
+		outcome.Status = StatusInvalid
+		outcome.Error = fmt.Sprintf(format, args...)
+		return outcome
+	}
+
+	if err := signal.Validate(0); err != nil {
+		return invalid("%v", err)
+	}
+	long := signal.Side == models.Long
+	outcome.Leverage = signal.Leverage
+	outcome.Entry = signal.Entry.From.Float64()
+	outcome.StopLoss = signal.StopLoss.Float64()
+	for _, target := range signal.Targets {
+		outcome.Targets = append(outcome.Targets, target.Float64())
+	}
+
+	start := sort.Search(len(candles), func(i int) bool { return !candles[i].Time.Before(outcome.Date) })
+	if start == len(candles) {
+		return invalid("no candles after %s", outcome.Date.Format(time.RFC3339))
+	}
+	candles = candles[start:]
+
+	// Entry
+	triggerUp := outcome.Entry >= candles[0].Open
+	entered := -1
+	for i, c := range candles {
+		if opts.Expiry > 0 && c.Time.Sub(outcome.Date) > opts.Expiry {
+			break
+		}
+		if (triggerUp && c.High >= outcome.Entry) || (!triggerUp && c.Low <= outcome.Entry) {
+			entered = i
+			if (triggerUp && c.Open > outcome.Entry) || (!triggerUp && c.Open < outcome.Entry) {
+				outcome.Entry = c.Open // gap
+			}
+			outcome.EntryTime = c.Time
+			break
+		}
+	}
+	if entered < 0 {
+		outcome.Status = StatusNotTriggered
+		return outcome
+	}
+
+	leverage := float64(outcome.Leverage)
+	liquidation := outcome.Entry * (1 - 1/leverage)
+	if !long {
+		liquidation = outcome.Entry * (1 + 1/leverage)
+	}
+	stopLoss := outcome.StopLoss
+	share := 1 / float64(len(outcome.Targets))
+	remaining := 1.0
+	var exits []exit
+	closeAll := func(price float64, at time.Time, status Status) Outcome {
+		exits = append(exits, exit{share: remaining, price: price})
+		outcome.Status = status
+		outcome.ExitTime = at
+		return settle(outcome, long, exits, opts.Fee)
+	}
+
+	for i := entered; i < len(candles); i++ {
+		c := candles[i]
+		if opts.MaxDuration > 0 && c.Time.Sub(outcome.EntryTime) >= opts.MaxDuration {
+			return closeAll(c.Open, c.Time, StatusTimeout)
+		}
+
+		liquidated := (long && c.Low <= liquidation && liquidation > stopLoss) || (!long && c.High >= liquidation && liquidation < stopLoss)
+		if liquidated {
+			outcome.Status = StatusLiquidated
+			outcome.ExitTime = c.Time
+			outcome.ExitPrice = liquidation
+			outcome.Return = -1
+			return outcome
+		}
+		if (long && c.Low <= stopLoss) || (!long && c.High >= stopLoss) {
+			price := stopLoss
+			if i > entered && ((long && c.Open < price) || (!long && c.Open > price)) {
+				price = c.Open // gap
+			}
+			return closeAll(price, c.Time, StatusStopped)
+		}
+		if i == entered {
+			continue
+		}
+
+		for outcome.TargetsHit < len(outcome.Targets) {
+			target := outcome.Targets[outcome.TargetsHit]
+			if (long && c.High < target) || (!long && c.Low > target) {
+				break
+			}
+			outcome.TargetsHit++
+			if outcome.TargetsHit == len(outcome.Targets) {
+				return closeAll(target, c.Time, StatusAllTargets)
+			}
+			exits = append(exits, exit{share: share, price: target})
+			remaining -= share
+			if opts.Breakeven {
+				stopLoss = outcome.Entry
+			}
+		}
+	}
+
+	last := candles[len(candles)-1]
+	return closeAll(last.Close, last.Time, StatusOpen)
+}
+
+type exit struct {
+	share float64
+	price float64
+}
+
+// settle computes the average exit and the return on the margin, net of fees
+func settle(outcome Outcome, long bool, exits []exit, fee float64) Outcome {
+	leverage := float64(outcome.Leverage)
+	direction := 1.0
+	if !long {
+		direction = -1
+	}
+	gross, exitNotional := 0.0, 0.0
+	for _, e := range exits {
+		gross += e.share * direction * (e.price - outcome.Entry) / outcome.Entry
+		exitNotional += e.share * e.price / outcome.Entry
+		outcome.ExitPrice += e.share * e.price
+	}
+	outcome.Return = math.Max(gross*leverage-fee*leverage*(1+exitNotional), -1)
+	return outcome
+}
+
+// summarize computes the statistics of the closed trades, the equity follows them in exit order
+func summarize(messages int, outcomes []Outcome, opts Options) Summary {
+	summary := Summary{Messages: messages, Signals: len(outcomes), FinalEquity: 1}
+
+	var closed []Outcome
+	for _, o := range outcomes {
+		if o.Status != StatusInvalid && o.Status != StatusNotTriggered {
+			summary.Triggered++
+		}
+		if o.Closed() {
+			closed = append(closed, o)
+		}
+	}
+	summary.Closed = len(closed)
+	if len(closed) == 0 {
+		return summary
+	}
+
+	sort.SliceStable(closed, func(i, j int) bool { return closed[i].ExitTime.Before(closed[j].ExitTime) })
+	risk := opts.RiskPercent / 100
+	peak := 1.0
+	total := 0.0
+	for _, o := range closed {
+		if o.Return > 0 {
+			summary.Wins++
+		} else {
+			summary.Losses++
+		}
+		total += o.Return
+
+		summary.FinalEquity *= 1 + risk*o.Return
+		peak = math.Max(peak, summary.FinalEquity)
+		summary.MaxDrawdown = math.Max(summary.MaxDrawdown, (peak-summary.FinalEquity)/peak)
+	}
+	summary.WinRate = float64(summary.Wins) / float64(len(closed))
+	summary.Expectancy = total / float64(len(closed))
+	return summary
+}
@@ -0,0 +1,143 @@
+package backtest
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/moneyscripter/teletrade/exchanges/paper"
+	"github.com/moneyscripter/teletrade/models"
+)
+
+var start = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// candles are hourly from start, each given as open, high, low and close
+func candles(prices ...[4]float64) []paper.Candle {
+	var candles []paper.Candle
+	for i, p := range prices {
+		candles = append(candles, paper.Candle{Time: start.Add(time.Duration(i) * time.Hour), Open: p[0], High: p[1], Low: p[2], Close: p[3]})
+	}
+	return candles
+}
+
+func long(leverage int) models.Signal {
+	return models.Signal{
+		Market:   "BTCUSDT",
+		Side:     models.Long,
+		Entry:    models.EntryRange{From: models.MustDecimal("100"), To: models.MustDecimal("100")},
+		Targets:  []models.Decimal{models.MustDecimal("110"), models.MustDecimal("120")},
+		StopLoss: models.MustDecimal("90"),
+		Leverage: leverage,
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestSimulate(t *testing.T) {
+	tests := []struct {
+		name       string
+		signal     models.Signal
+		candles    []paper.Candle
+		opts       Options
+		status     Status
+		targetsHit int
+		exitPrice  float64
+		ret        float64
+	}{
+		{
+			name:   "stop moved to breakeven after TP1",
+			signal: long(10),
+			candles: candles(
+				[4]float64{99, 101, 98, 100},  // entry
+				[4]float64{100, 111, 99, 108}, // TP1, half closed at 110
+				[4]float64{108, 109, 99, 100}, // back to the entry
+			),
+			opts:       Options{Breakeven: true},
+			status:     StatusStopped,
+			targetsHit: 1,
+			exitPrice:  105,
+			ret:        0.5, // half of the position made 10%, on 10x
+		},
+		{
+			name:   "stop kept after TP1",
+			signal: long(10),
+			candles: candles(
+				[4]float64{99, 101, 98, 100},
+				[4]float64{100, 111, 99, 108},
+				[4]float64{108, 109, 99, 100},
+			),
+			status:     StatusOpen,
+			targetsHit: 1,
+			exitPrice:  105,
+			ret:        0.5,
+		},
+		{
+			name:   "stop hit first",
+			signal: long(5),
+			candles: candles(
+				[4]float64{99, 101, 98, 100},
+				[4]float64{100, 109, 89, 95}, // the stop is checked before the targets
+				[4]float64{95, 130, 95, 125},
+			),
+			opts:      Options{Breakeven: true},
+			status:    StatusStopped,
+			exitPrice: 90,
+			ret:       -0.5,
+		},
+		{
+			name:   "all targets with fees",
+			signal: long(10),
+			candles: candles(
+				[4]float64{99, 101, 98, 100},
+				[4]float64{100, 125, 99, 120},
+			),
+			opts:       Options{Fee: 0.001},
+			status:     StatusAllTargets,
+			targetsHit: 2,
+			exitPrice:  115,
+			ret:        1.5 - 0.001*10*(1+1.15),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := Simulate(Outcome{Date: start}, tt.signal, tt.candles, tt.opts)
+			if o.Status != tt.status || o.TargetsHit != tt.targetsHit || !near(o.ExitPrice, tt.exitPrice) || !near(o.Return, tt.ret) {
+				t.Fatalf("got %s with %d targets, exit %v and return %v, want %s with %d targets, exit %v and return %v",
+					o.Status, o.TargetsHit, o.ExitPrice, o.Return, tt.status, tt.targetsHit, tt.exitPrice, tt.ret)
+			}
+			if o.StopLoss != 90 {
+				t.Fatalf("reported stop loss %v, want the one of the signal", o.StopLoss)
+			}
+		})
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	opts := Options{Breakeven: true, RiskPercent: 10}
+	win := Simulate(Outcome{Date: start}, long(10), candles(
+		[4]float64{99, 101, 98, 100},
+		[4]float64{100, 111, 99, 108},
+		[4]float64{108, 109, 99, 100},
+		[4]float64{100, 101, 99, 100},
+	), opts)
+	loss := Simulate(Outcome{Date: start}, long(5), candles(
+		[4]float64{99, 101, 98, 100},
+		[4]float64{100, 109, 89, 95},
+	), opts)
+	notTriggered := Simulate(Outcome{Date: start}, long(10), candles(
+		[4]float64{95, 99, 94, 98},
+		[4]float64{98, 99, 80, 85},
+	), opts)
+	invalid := Simulate(Outcome{Date: start}, models.Signal{}, nil, opts)
+
+	summary := summarize(6, []Outcome{win, notTriggered, loss, invalid}, opts)
+	if summary.Messages != 6 || summary.Signals != 4 || summary.Triggered != 2 || summary.Closed != 2 || summary.Wins != 1 || summary.Losses != 1 {
+		t.Fatalf("unexpected counts %+v", summary)
+	}
+	// The loss exits first: 1 × (1 - 10% × 0.5) × (1 + 10% × 0.5)
+	if !near(summary.WinRate, 0.5) || !near(summary.Expectancy, 0) || !near(summary.MaxDrawdown, 0.05) || !near(summary.FinalEquity, 0.9975) {
+		t.Fatalf("unexpected statistics %+v", summary)
+	}
+}
@@ -0,0 +1,114 @@
+package backtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is a channel post of an exported history
+type Message struct {
+	ID   int64
+	Date time.Time
+	Text string
+}
+
+// telegramExport is the result.json written by Telegram Desktop's "Export chat history"
+type telegramExport struct {
+	Name     string `json:"name"`
+	ID       int64  `json:"id"`
+	Messages []struct {
+		ID           int64           `json:"id"`
+		Type         string          `json:"type"`
+		Date         string          `json:"date"`
+		DateUnixtime string          `json:"date_unixtime"`
+		Text         json.RawMessage `json:"text"`
+	} `json:"messages"`
+}
+
+// LoadTelegramExport reads the messages of a Telegram Desktop JSON export, sorted by date
+func LoadTelegramExport(path string) ([]Message, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var export telegramExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("invalid telegram export %s: %v", path, err)
+	}
+
+	var messages []Message
+	for _, m := range export.Messages {
+		if m.Type != "message" {
+			continue // service messages, e.g. pinned or joined
+		}
+		date, err := exportDate(m.DateUnixtime, m.Date)
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %v", m.ID, err)
+		}
+		text, err := exportText(m.Text)
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %v", m.ID, err)
+		}
+		if text == "" {
+			continue
+		}
+		messages = append(messages, Message{ID: m.ID, Date: date, Text: text})
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Date.Before(messages[j].Date)
+	})
+	return messages, nil
+}
+
+func exportDate(unixtime, date string) (time.Time, error) {
+	if unixtime != "" {
+		seconds, err := strconv.ParseInt(unixtime, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date_unixtime %q", unixtime)
+		}
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	// Older exports only have the local time of the exporting machine
+	t, err := time.ParseInLocation("2006-01-02T15:04:05", date, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", date)
+	}
+	return t.UTC(), nil
+}
+
+// exportText flattens the text of an exported message, a string or a list of strings and entities
+func exportText(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 {
+		return "", nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+
+	var parts []json.RawMessage
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", fmt.Errorf("invalid text: %v", err)
+	}
+	var builder strings.Builder
+	for _, part := range parts {
+		var s string
+		if err := json.Unmarshal(part, &s); err == nil {
+			builder.WriteString(s)
+			continue
+		}
+		var entity struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(part, &entity); err != nil {
+			return "", fmt.Errorf("invalid text entity: %v", err)
+		}
+		builder.WriteString(entity.Text)
+	}
+	return builder.String(), nil
+}
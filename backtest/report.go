@@ -0,0 +1,67 @@
+package backtest
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func WriteJSON(w io.Writer, report Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// WriteCSV writes one row per outcome, the summary is left to the JSON report
+func WriteCSV(w io.Writer, report Report) error {
+	writer := csv.NewWriter(w)
+	header := []string{
+		"message_id", "date", "market", "position", "leverage", "entry", "stop_loss", "targets",
+		"status", "entry_time", "exit_time", "exit_price", "targets_hit", "return", "error",
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, o := range report.Outcomes {
+		targets := make([]string, 0, len(o.Targets))
+		for _, t := range o.Targets {
+			targets = append(targets, formatFloat(t))
+		}
+		record := []string{
+			strconv.FormatInt(o.MessageID, 10),
+			formatTime(o.Date),
+			o.Market,
+			o.Position,
+			strconv.Itoa(o.Leverage),
+			formatFloat(o.Entry),
+			formatFloat(o.StopLoss),
+			strings.Join(targets, " "),
+			string(o.Status),
+			formatTime(o.EntryTime),
+			formatTime(o.ExitTime),
+			formatFloat(o.ExitPrice),
+			strconv.Itoa(o.TargetsHit),
+			formatFloat(o.Return),
+			o.Error,
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
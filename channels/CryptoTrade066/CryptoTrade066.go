@@ -3,110 +3,172 @@ package CryptoTrade066
 import (
 	"github.com/moneyscripter/teletrade/channels"
 	"github.com/moneyscripter/teletrade/models"
+	"github.com/moneyscripter/teletrade/normalize"
+	"regexp"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type cryptoTrade0066 struct {
 }
 
+// The id of the channel is resolved from its username by the client
+func init() {
+	channels.MustRegister(channels.Parser{
+		Name:     "CryptoTrade066",
+		Username: "CryptoTrade066",
+		URL:      "https://t.me/CryptoTrade066",
+		New: func() (channels.Channels, error) {
+			return NewCryptoTrade0066(), nil
+		},
+	})
+}
+
 // NewCryptoTrade0066 is a constructor for cryptoTrade0066
-func NewCryptoTrade0066() (channels.Channels, int64) {
-	return cryptoTrade0066{}, 1261856999
+func NewCryptoTrade0066() channels.Channels {
+	return cryptoTrade0066{}
+}
+
+type field int
+
+const (
+	noField field = iota
+	marketField
+	sideField
+	entryField
+	targetField
+	stopLossField
+	leverageField
+)
+
+// labels of the fields as posted by the channel, the longest spelling of a field first
+var labels = []struct {
+	field  field
+	labels []string
+}{
+	{marketField, []string{"نام ارز", "نام", "ارز"}},
+	{sideField, []string{"نوع پوزیشن", "پوزیشن", "نوع معامله"}},
+	{entryField, []string{"نقطه ورود", "نقاط ورود", "ورود"}},
+	{targetField, []string{"تارگت", "هدف"}},
+	{stopLossField, []string{"حد ضرر", "حدضرر", "استاپ"}},
+	{leverageField, []string{"اهرم", "لوریج"}},
 }
 
+// number matches a price, grouped in thousands or not, e.g. "62,500" once normalized from
+// "۶۲٬۵۰۰" or "۶۲،۵۰۰". ParseDecimal drops the separators.
+var number = regexp.MustCompile(`[0-9]{1,3}(?:,[0-9]{3})+(?:\.[0-9]+)?|[0-9]+(?:\.[0-9]+)?`)
+
+// ParsSignal reads the labeled lines of the post, e.g. "🔹نقطه ورود : ۰.۵۲ - ۰.۵۵". The value of
+// a line is what follows its first colon, entries may be a range and targets are either
+// listed on one line or numbered on their own lines.
 func (c cryptoTrade0066) ParsSignal(message string) (models.Signal, bool) {
-	mustFound := []string{
-		"نام",
-		"نوع پوزیشن",
-		"نقطه ورود",
-		"تارگت",
-		"حدضرر",
-		"اهرم",
+	var market, position, stopLoss, leverage string
+	var entryPoints, targets []string
+	for _, line := range strings.Split(message, "\n") {
+		f, value := tokenize(line)
+		switch f {
+		case marketField:
+			if market == "" {
+				market = value
+			}
+		case sideField:
+			if position == "" {
+				position = value
+			}
+		case entryField:
+			if len(entryPoints) == 0 {
+				entryPoints = number.FindAllString(value, 2)
+			}
+		case targetField:
+			targets = append(targets, number.FindAllString(value, -1)...)
+		case stopLossField:
+			if stopLoss == "" {
+				stopLoss = first(number.FindAllString(value, 1))
+			}
+		case leverageField:
+			if leverage == "" {
+				leverage = first(number.FindAllString(value, 1))
+			}
+		}
+	}
+	if market == "" || position == "" || len(entryPoints) == 0 || len(targets) == 0 || stopLoss == "" || leverage == "" {
+		return models.Signal{}, false
 	}
 
-	signal := models.Signal{}
-	lines := strings.Split(message, "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "سیگنال") {
-			break
-		}
+	side, ok := normalize.FindSide(position)
+	if !ok {
+		return models.Signal{}, false
 	}
-	for i, key := range mustFound {
-		flag := false
-		for _, line := range lines {
-			if strings.Contains(line, key) {
-				flag = true
-				if i == 0 {
-					parts := strings.Split(line, ":")
-					if len(parts) != 2 {
-						flag = false
-						break
-					}
-					parts[1] = strings.ReplaceAll(parts[1], " ", "")
-					parts[1] = strings.ReplaceAll(parts[1], "-", "")
-					parts[1] = strings.ReplaceAll(parts[1], "/", "")
-					signal.Market = parts[1]
-				}
-				if i == 1 {
-					parts := strings.Split(line, ":")
-					if len(parts) != 2 {
-						flag = false
-						break
-					}
-					parts[1] = strings.ReplaceAll(parts[1], " ", "")
-					parts[1] = strings.ReplaceAll(parts[1], "-", "")
-					parts[1] = strings.ReplaceAll(parts[1], "/", "")
-					signal.Position = parts[1]
-				}
-				if i == 2 {
-					parts := strings.Split(line, ":")
-					if len(parts) != 2 {
-						flag = false
-						break
-					}
-					parts[1] = strings.ReplaceAll(parts[1], " ", "")
-					parts[1] = strings.ReplaceAll(parts[1], "-", "")
-					parts[1] = strings.ReplaceAll(parts[1], "/", "")
-					signal.EntryPoints = append(signal.EntryPoints, parts[1])
-				}
-				if i == 3 {
-					parts := strings.Split(line, ":")
-					if len(parts) != 2 {
-						flag = false
-						break
-					}
-					parts[1] = strings.ReplaceAll(parts[1], " ", "")
-					parts[1] = strings.ReplaceAll(parts[1], "-", "")
-					parts[1] = strings.ReplaceAll(parts[1], "/", "")
-					signal.Targets = append(signal.Targets, parts[1])
-				}
-				if i == 4 {
-					parts := strings.Split(line, ":")
-					if len(parts) != 2 {
-						flag = false
-						break
-					}
-					parts[1] = strings.ReplaceAll(parts[1], " ", "")
-					parts[1] = strings.ReplaceAll(parts[1], "-", "")
-					parts[1] = strings.ReplaceAll(parts[1], "/", "")
-					signal.StopLoss = parts[1]
-				}
-				if i == 5 {
-					parts := strings.Split(line, ":")
-					if len(parts) != 2 {
-						flag = false
-						break
-					}
-					parts[1] = strings.ReplaceAll(parts[1], " ", "")
-					parts[1] = strings.ReplaceAll(parts[1], "-", "")
-					parts[1] = strings.ReplaceAll(parts[1], "/", "")
-					signal.Leverage = parts[1]
+	signal, err := models.ParseSignal(normalizeMarket(market), side, entryPoints, targets, stopLoss, leverage)
+	if err != nil {
+		return models.Signal{}, false
+	}
+	signal.MarginMode = marginMode(message)
+	return signal, true
+}
+
+func (c cryptoTrade0066) ParsAction(message string) []models.Action {
+	return channels.ParseAction(message)
+}
+
+// tokenize finds the field of the line and its value, bullets and direction marks aside
+func tokenize(line string) (field, string) {
+	line = strings.TrimLeftFunc(normalize.Text(line), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for _, l := range labels {
+		for _, label := range l.labels {
+			if !strings.HasPrefix(line, label) {
+				continue
+			}
+			rest := line[len(label):]
+			if i := strings.IndexAny(rest, ":："); i >= 0 {
+				_, size := utf8.DecodeRuneInString(rest[i:])
+				return l.field, strings.TrimSpace(rest[i+size:])
+			}
+			rest = strings.TrimSpace(rest)
+			if l.field == targetField {
+				// "تارگت ۱ ۱۲۰" without colon, the first number is the index of the target
+				if numbers := number.FindAllStringIndex(rest, -1); len(numbers) > 1 {
+					rest = rest[numbers[0][1]:]
 				}
 			}
+			return l.field, rest
 		}
-		if !flag {
-			return models.Signal{}, false
+	}
+	return noField, ""
+}
+
+// normalizeMarket keeps the letters and digits of the pair, USDT is implied when missing
+func normalizeMarket(value string) string {
+	market := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
 		}
+		return -1
+	}, value)
+	if market != "" && !strings.HasSuffix(market, "USDT") {
+		market += "USDT"
 	}
-	return signal, true
+	return market
+}
+
+func marginMode(message string) models.MarginMode {
+	lower := strings.ToLower(message)
+	switch {
+	case strings.Contains(lower, "کراس") || strings.Contains(lower, "cross"):
+		return models.Cross
+	case strings.Contains(lower, "ایزوله") || strings.Contains(lower, "isolated"):
+		return models.Isolated
+	}
+	return ""
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
 }
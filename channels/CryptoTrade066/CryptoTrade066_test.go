@@ -0,0 +1,89 @@
+package CryptoTrade066_test
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/moneyscripter/teletrade/channels/CryptoTrade066"
+	"github.com/moneyscripter/teletrade/models"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/*.json with the signals parsed now")
+
+// rejected are the messages parsed as signals that Validate has to refuse, with the
+// field it refuses them for
+var rejected = map[string]string{
+	"13-wrong-side-stop-loss": "StopLoss",
+}
+
+// Every testdata/<name>.txt message comes with <name>.json holding the expected signal, or null
+func TestParsSignal(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no message in testdata")
+	}
+
+	parser := CryptoTrade066.NewCryptoTrade0066()
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".txt")
+		t.Run(name, func(t *testing.T) {
+			message, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var parsed *models.Signal
+			if signal, ok := parser.ParsSignal(string(message)); ok {
+				parsed = &signal
+			}
+
+			expectedPath := strings.TrimSuffix(path, ".txt") + ".json"
+			if *update {
+				got, err := json.MarshalIndent(parsed, "", "  ")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(expectedPath, append(got, '\n'), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			data, err := os.ReadFile(expectedPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var expected *models.Signal
+			if err := json.Unmarshal(data, &expected); err != nil {
+				t.Fatalf("decode %s: %v", expectedPath, err)
+			}
+			got, _ := json.Marshal(parsed)
+			want, _ := json.Marshal(expected)
+			if string(got) != string(want) {
+				t.Fatalf("got  %s\nwant %s", got, want)
+			}
+			if parsed == nil {
+				return
+			}
+
+			err = parsed.Validate(0)
+			field, ok := rejected[name]
+			if !ok {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var errs models.ValidationErrors
+			if !errors.As(err, &errs) || errs[0].Field != field {
+				t.Fatalf("got %v, want the %s rejected", err, field)
+			}
+		})
+	}
+}
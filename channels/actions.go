@@ -0,0 +1,205 @@
+package channels
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/moneyscripter/teletrade/models"
+	"github.com/moneyscripter/teletrade/normalize"
+)
+
+// The keywords of the actions, a phrase matches whole words only
+var (
+	cancelPhrases    = phrases("cancel", "کنسل", "لغو")
+	breakevenPhrases = phrases("risk free", "breakeven", "break even", "ریسک فری", "ریسکفری")
+	stopPhrases      = phrases("stop", "sl", "استاپ", "حدضرر", "حد ضرر")
+	entryPhrases     = phrases("entry", "ورود")
+	closePhrases     = phrases("save profit", "ببندید", "سیو سود")
+	closeVerbs       = phrases("close", "exit", "بستن", "خروج")
+	percentMarkers   = []string{"%", "٪", "درصد"}
+)
+
+// A close verb only tells to close in the imperative, e.g. "close", "please close now",
+// "close 50%" or "۵۰ درصد بستن", the words around it are limited to these and percents
+var (
+	closeLeads   = []string{"please", "pls", "now", "لطفا", "الان"}
+	closeObjects = []string{"now", "all", "everything", "it", "the", "your", "position", "positions", "trade", "half", "rest", "here", "همه", "الان", "کامل"}
+)
+
+// Words making a clause conditional, e.g. "cancel if it breaks 0.50", such a clause is no action
+var conditionals = []string{"if", "when", "once", "unless", "اگر", "اگه", "وقتی"}
+
+// Words joining the clauses of a message, each clause holds an action at most
+var conjunctions = []string{"and", "then", "&", "و"}
+
+// Negations before a keyword in English, after it in Persian, e.g. "don't cancel" or "کنسل نکنید",
+// anywhere in the clause
+var (
+	negationsBefore = []string{"not", "don't", "dont", "never", "no"}
+	negationsAfter  = []string{"نکنید", "نکن", "نشود", "نشه"}
+)
+
+// ParseAction recognizes the usual English and Persian follow-ups of a signal, e.g.
+// "close 50%", "risk free" or "استاپ به نقطه ورود", and returns every action of the
+// message in order, e.g. "close 50% and move stop to entry" is a close and a stop move.
+// The message is expected to be a reply to the signal, informative follow-ups like
+// "target 1 hit" or "stopped at entry" are not actions, and neither are conditional ones like
+// "cancel if it breaks 0.50".
+func ParseAction(message string) []models.Action {
+	var actions []models.Action
+	for _, clause := range clauses(words(strings.ToLower(normalize.Text(message)))) {
+		if action, ok := parseClause(clause); ok && !slices.Contains(actions, action) {
+			actions = append(actions, action)
+		}
+	}
+	return actions
+}
+
+func parseClause(words []string) (models.Action, bool) {
+	if slices.ContainsFunc(words, func(w string) bool { return slices.Contains(conditionals, w) }) {
+		return models.Action{}, false
+	}
+	switch {
+	case find(words, cancelPhrases) >= 0:
+		return models.Action{Kind: models.ActionCancelEntry}, true
+	case find(words, breakevenPhrases) >= 0:
+		return models.Action{Kind: models.ActionMoveStopLoss}, true
+	case find(words, stopPhrases) >= 0:
+		if find(words, entryPhrases) >= 0 {
+			return models.Action{Kind: models.ActionMoveStopLoss}, true
+		}
+		for _, w := range words[find(words, stopPhrases):] {
+			if price, err := models.ParseDecimal(w); err == nil {
+				return models.Action{Kind: models.ActionMoveStopLoss, Price: price}, true
+			}
+		}
+	case find(words, closePhrases) >= 0 || closes(words):
+		action := models.Action{Kind: models.ActionClose}
+		for i := 1; i < len(words); i++ {
+			if !slices.Contains(percentMarkers, words[i]) {
+				continue
+			}
+			if percent, err := models.ParseDecimal(words[i-1]); err == nil {
+				action.Percent = percent
+				break
+			}
+		}
+		return action, true
+	}
+	return models.Action{}, false
+}
+
+// phrases splits every phrase into its words
+func phrases(values ...string) [][]string {
+	split := make([][]string, 0, len(values))
+	for _, value := range values {
+		split = append(split, strings.Fields(value))
+	}
+	return split
+}
+
+// find returns the index of the word following the first phrase found in words that isn't
+// negated, or -1
+func find(words []string, phrases [][]string) int {
+	for i := range words {
+		for _, phrase := range phrases {
+			if end := match(words, i, phrase); end >= 0 {
+				return end
+			}
+		}
+	}
+	return -1
+}
+
+// closes tells whether words hold a close verb in the imperative, not e.g. "price is close to
+// TP1" or "exit zone is 0.55"
+func closes(words []string) bool {
+	for i := range words {
+		for _, verb := range closeVerbs {
+			end := match(words, i, verb)
+			if end < 0 || slices.ContainsFunc(words[:i], func(w string) bool { return !closeWord(w, closeLeads) }) {
+				continue
+			}
+			if end == len(words) || closeWord(words[end], closeObjects) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// closeWord tells whether w may stand next to a close verb, a percent or one of words
+func closeWord(w string, words []string) bool {
+	if _, err := models.ParseDecimal(w); err == nil {
+		return true
+	}
+	return slices.Contains(percentMarkers, w) || slices.Contains(words, w)
+}
+
+// match returns the index of the word following phrase when it starts words[i:] and isn't
+// negated, or -1
+func match(words []string, i int, phrase []string) int {
+	end := i + len(phrase)
+	if end > len(words) || !slices.Equal(words[i:end], phrase) {
+		return -1
+	}
+	negation := func(negations []string) func(string) bool {
+		return func(w string) bool { return slices.Contains(negations, w) }
+	}
+	if slices.ContainsFunc(words[:i], negation(negationsBefore)) || slices.ContainsFunc(words[end:], negation(negationsAfter)) {
+		return -1
+	}
+	return end
+}
+
+// words splits text into words, numbers and percent signs. The end of a sentence or a line
+// ends a clause and is kept as an empty word, but for the dots and commas inside a number.
+func words(text string) []string {
+	runes := []rune(text)
+	var words []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = nil
+		}
+	}
+	for i, r := range runes {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '\'' || r == '’':
+			if r == '’' {
+				r = '\''
+			}
+			word = append(word, r)
+		case (r == '.' || r == ',') && i > 0 && i+1 < len(runes) && unicode.IsDigit(runes[i-1]) && unicode.IsDigit(runes[i+1]):
+			word = append(word, r)
+		case r == '%' || r == '٪' || r == '&':
+			flush()
+			words = append(words, string(r))
+		case strings.ContainsRune(".,;!?\n؛؟", r):
+			flush()
+			words = append(words, "")
+		default:
+			flush()
+		}
+	}
+	flush()
+	return words
+}
+
+// clauses splits words on punctuation and conjunctions
+func clauses(words []string) [][]string {
+	var clauses [][]string
+	start := 0
+	for i := 0; i <= len(words); i++ {
+		if i < len(words) && words[i] != "" && !slices.Contains(conjunctions, words[i]) {
+			continue
+		}
+		if i > start {
+			clauses = append(clauses, words[start:i])
+		}
+		start = i + 1
+	}
+	return clauses
+}
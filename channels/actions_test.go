@@ -0,0 +1,66 @@
+package channels_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/moneyscripter/teletrade/channels"
+	"github.com/moneyscripter/teletrade/models"
+)
+
+func TestParseAction(t *testing.T) {
+	cancel := models.Action{Kind: models.ActionCancelEntry}
+	breakeven := models.Action{Kind: models.ActionMoveStopLoss}
+	closeAll := models.Action{Kind: models.ActionClose}
+	closeHalf := models.Action{Kind: models.ActionClose, Percent: models.MustDecimal("50")}
+	stopAt := func(price string) models.Action {
+		return models.Action{Kind: models.ActionMoveStopLoss, Price: models.MustDecimal(price)}
+	}
+
+	tests := []struct {
+		message string
+		want    []models.Action
+	}{
+		{"Cancel", []models.Action{cancel}},
+		{"don't cancel", nil},
+		{"Do not cancel this one", nil},
+		{"I cancelled my order", nil},
+		{"سیگنال کنسل شد", []models.Action{cancel}},
+		{"کنسل نکنید", nil},
+		{"Risk-free now", []models.Action{breakeven}},
+		{"ریسک‌فری کنید", []models.Action{breakeven}},
+		{"Move SL to entry", []models.Action{breakeven}},
+		{"استاپ به نقطه ورود", []models.Action{breakeven}},
+		{"stopped at entry", nil},
+		{"SL: 1,250.5", []models.Action{stopAt("1250.5")}},
+		{"move stop to ۱۰۵", []models.Action{stopAt("105")}},
+		{"also wait for a retest", nil},
+		{"slightly above the entry", nil},
+		{"Target 1 hit 🎯", nil},
+		{"close", []models.Action{closeAll}},
+		{"save profit 50 %", []models.Action{closeHalf}},
+		{"۵۰ درصد ببندید", []models.Action{closeHalf}},
+		{"Close 50% and move stop to entry", []models.Action{closeHalf, breakeven}},
+		{"Close 50%. Stop to 105", []models.Action{closeHalf, stopAt("105")}},
+		{"۵۰٪ ببندید و استاپ به ورود", []models.Action{closeHalf, breakeven}},
+		{"Please close now", []models.Action{closeAll}},
+		{"Exit all positions", []models.Action{closeAll}},
+		{"۵۰ درصد بستن", []models.Action{closeHalf}},
+		{"price is close to TP1", nil},
+		{"close to TP1", nil},
+		{"exit zone is 0.55", nil},
+		{"منطقه خروج ۰.۵۵", nil},
+		{"don't ever close", nil},
+		{"cancel if it breaks 0.50", nil},
+		{"اگر ۰.۵۰ را شکست کنسل", nil},
+		{"no need to cancel", nil},
+		{"move stop to entry once TP1 hits", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			if got := channels.ParseAction(tt.message); !slices.Equal(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,8 +4,7 @@ import "github.com/moneyscripter/teletrade/models"
 
 type Channels interface {
 	ParsSignal(message string) (models.Signal, bool)
-}
-
-var AvailableChannels = map[string]string{
-	"CryptoTrade066": "https://t.me/CryptoTrade066",
+	// ParsAction parses the actions of a follow-up managing the signal the message replies
+	// to, none when the message is not one
+	ParsAction(message string) []models.Action
 }
@@ -0,0 +1,97 @@
+package channels
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Parser is a channel known to the bot, listed to the users and listened to by the client.
+// The client finds the channel by Username, or by URL when it is an invite link, and falls
+// back to ChannelID for channels having neither. Signals posted in a group or a private
+// chat are followed too, narrowed down to a forum topic and to the posts of some authors.
+type Parser struct {
+	Name      string  // key of the channel, e.g. CryptoTrade066
+	ChannelID int64   // optional when the channel has a username or an invite link
+	Username  string  // public username without the @, empty for private channels
+	URL       string  // t.me link shown to the users, public or invite
+	Source    string  // "channel" (the default), "group" or "private"
+	TopicID   int     // forum topic of the signals, zero for all of them
+	Authors   []int64 // user ids of the analysts, the other members' posts are ignored, everyone's when empty
+	New       func() (Channels, error)
+}
+
+var (
+	registryMutex = &sync.RWMutex{}
+	registry      = make(map[string]Parser)
+)
+
+// Register adds the parser of a channel, names are unique and so are channel ids but for
+// the channels narrowing a shared group down to a topic or to some authors
+func Register(parser Parser) error {
+	if parser.Name == "" || parser.New == nil {
+		return errors.New("parser needs a name and a constructor")
+	}
+	if parser.ChannelID == 0 && parser.Username == "" && parser.URL == "" {
+		return fmt.Errorf("channel %s needs a channel id, a username or a link", parser.Name)
+	}
+	switch parser.Source {
+	case "", "channel", "group", "private":
+	default:
+		return fmt.Errorf("channel %s has unknown source %q", parser.Name, parser.Source)
+	}
+	if parser.TopicID != 0 && parser.Source != "group" {
+		return fmt.Errorf("channel %s has a topic but is not a group", parser.Name)
+	}
+	// Channel posts have no author, they would all be dropped
+	if len(parser.Authors) > 0 && (parser.Source == "" || parser.Source == "channel") {
+		return fmt.Errorf("channel %s filters authors but channel posts have none", parser.Name)
+	}
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	for _, registered := range registry {
+		if registered.Name == parser.Name {
+			return fmt.Errorf("channel %s is already registered", parser.Name)
+		}
+		if parser.ChannelID != 0 && registered.ChannelID == parser.ChannelID &&
+			registered.TopicID == parser.TopicID && len(registered.Authors) == 0 && len(parser.Authors) == 0 {
+			return fmt.Errorf("channel id %d is already registered by %s", parser.ChannelID, registered.Name)
+		}
+	}
+	registry[parser.Name] = parser
+	return nil
+}
+
+// MustRegister is Register for the parsers registering themselves in init, it panics on conflicts
+func MustRegister(parser Parser) {
+	if err := Register(parser); err != nil {
+		panic(err)
+	}
+}
+
+// Lookup returns the parser registered under name
+func Lookup(name string) (Parser, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	parser, ok := registry[name]
+	return parser, ok
+}
+
+// Registered returns every registered parser, sorted by name
+func Registered() []Parser {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	parsers := make([]Parser, 0, len(registry))
+	for _, parser := range registry {
+		parsers = append(parsers, parser)
+	}
+	sort.Slice(parsers, func(i, j int) bool {
+		return parsers[i].Name < parsers[j].Name
+	})
+	return parsers
+}
@@ -0,0 +1,292 @@
+package template
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/moneyscripter/teletrade/channels"
+	"github.com/moneyscripter/teletrade/models"
+	"github.com/moneyscripter/teletrade/normalize"
+)
+
+type field struct {
+	labels []string // lower case, the longest first
+	regex  *regexp.Regexp
+}
+
+type parser struct {
+	template Template
+	fields   map[string]*field
+	number   *regexp.Regexp
+}
+
+const (
+	marketField     = "market"
+	sideField       = "side"
+	entryField      = "entry"
+	targetsField    = "targets"
+	stopLossField   = "stop_loss"
+	leverageField   = "leverage"
+	marginModeField = "margin_mode"
+)
+
+// NewParser compiles the template into the parser of its channel
+func NewParser(t Template) (channels.Channels, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	if len(t.Separators) == 0 {
+		t.Separators = defaultSeparators
+	}
+	if t.Numbers.DecimalSeparator == "" {
+		t.Numbers.DecimalSeparator = "."
+	}
+
+	p := &parser{template: t, fields: make(map[string]*field)}
+	for name, f := range map[string]Field{
+		marketField:     t.Fields.Market,
+		sideField:       t.Fields.Side,
+		entryField:      t.Fields.Entry,
+		targetsField:    t.Fields.Targets,
+		stopLossField:   t.Fields.StopLoss,
+		leverageField:   t.Fields.Leverage,
+		marginModeField: t.Fields.MarginMode,
+	} {
+		compiled := &field{}
+		for _, label := range f.Labels {
+			compiled.labels = append(compiled.labels, strings.ToLower(normalize.Text(label)))
+		}
+		sort.Slice(compiled.labels, func(i, j int) bool {
+			return len(compiled.labels[i]) > len(compiled.labels[j])
+		})
+		if f.Regex != "" {
+			compiled.regex = regexp.MustCompile(f.Regex) // checked by Validate
+		}
+		p.fields[name] = compiled
+	}
+
+	// Messages are normalized, their digits are ASCII and their Arabic separators are the usual ones
+	t.Numbers.DecimalSeparator = normalize.Digits(t.Numbers.DecimalSeparator)
+	t.Numbers.ThousandsSeparator = normalize.Digits(t.Numbers.ThousandsSeparator)
+	digit := `[0-9]`
+	decimal := regexp.QuoteMeta(t.Numbers.DecimalSeparator)
+	number := digit + `+(?:(?:` + decimal + `)` + digit + `+)?`
+	if t.Numbers.ThousandsSeparator != "" {
+		thousands := regexp.QuoteMeta(t.Numbers.ThousandsSeparator)
+		number = digit + `{1,3}(?:` + thousands + digit + `{3})+(?:(?:` + decimal + `)` + digit + `+)?|` + number
+	}
+	p.number = regexp.MustCompile(number)
+	return p, nil
+}
+
+func (p *parser) ParsSignal(message string) (models.Signal, bool) {
+	message = normalize.Text(message)
+	values := p.values(message)
+
+	market := p.market(first(values[marketField]))
+	var entryPoints, targets, stopLoss []string
+	for _, value := range values[entryField] {
+		entryPoints = append(entryPoints, p.numbers(value)...)
+	}
+	for _, value := range values[targetsField] {
+		targets = append(targets, p.numbers(value)...)
+	}
+	for _, value := range values[stopLossField] {
+		stopLoss = append(stopLoss, p.numbers(value)...)
+	}
+	if market == "" || len(entryPoints) == 0 || len(targets) == 0 || len(stopLoss) == 0 {
+		return models.Signal{}, false
+	}
+
+	side, ok := p.side(message, values[sideField])
+	if !ok {
+		return models.Signal{}, false
+	}
+
+	leverage := ""
+	if numbers := p.numbers(first(values[leverageField])); len(numbers) > 0 {
+		leverage = numbers[0]
+	} else if p.template.Leverage > 0 {
+		leverage = strconv.Itoa(p.template.Leverage)
+	}
+
+	signal, err := models.ParseSignal(market, string(side), entryPoints, targets, stopLoss[0], leverage)
+	if err != nil {
+		return models.Signal{}, false
+	}
+	signal.MarginMode = p.marginMode(message, values[marginModeField])
+	return signal, true
+}
+
+func (p *parser) ParsAction(message string) []models.Action {
+	return channels.ParseAction(message)
+}
+
+// values finds the values of every field. A line belongs to the field whose label comes first
+// on it, the value being what follows the first separator after the label (e.g. "TP1: 110"),
+// or the rest of the line without separator, the index of a numbered target aside (e.g. "TP1 110").
+func (p *parser) values(message string) map[string][]string {
+	values := make(map[string][]string)
+	for name, f := range p.fields {
+		if f.regex == nil {
+			continue
+		}
+		for _, match := range f.regex.FindAllStringSubmatch(message, -1) {
+			value := match[0]
+			if len(match) > 1 {
+				value = match[1]
+			}
+			values[name] = append(values[name], value)
+		}
+	}
+
+	for _, line := range strings.Split(message, "\n") {
+		lower := strings.ToLower(line)
+		best, bestIndex, bestLabel := "", -1, ""
+		for name, f := range p.fields {
+			if f.regex != nil {
+				continue
+			}
+			for _, label := range f.labels {
+				i := labelIndex(lower, label)
+				if i >= 0 && (bestIndex < 0 || i < bestIndex || (i == bestIndex && len(label) > len(bestLabel))) {
+					best, bestIndex, bestLabel = name, i, label
+				}
+			}
+		}
+		if bestIndex < 0 {
+			continue
+		}
+		value, separated := p.afterSeparator(lower[bestIndex+len(bestLabel):])
+		if best == targetsField && !separated {
+			value = p.withoutIndex(value)
+		}
+		values[best] = append(values[best], value)
+	}
+	return values
+}
+
+// labelIndex returns the index of the first occurrence of label starting a word of line, or -1
+func labelIndex(line, label string) int {
+	for offset := 0; offset < len(line); {
+		i := strings.Index(line[offset:], label)
+		if i < 0 {
+			return -1
+		}
+		i += offset
+		previous, _ := utf8.DecodeLastRuneInString(line[:i])
+		if i == 0 || !(unicode.IsLetter(previous) || unicode.IsDigit(previous)) {
+			return i
+		}
+		_, size := utf8.DecodeRuneInString(line[i:])
+		offset = i + size
+	}
+	return -1
+}
+
+// afterSeparator returns what follows the first separator of rest, or all of rest when it has none
+func (p *parser) afterSeparator(rest string) (string, bool) {
+	index, length := -1, 0
+	for _, separator := range p.template.Separators {
+		if i := strings.Index(rest, separator); i >= 0 && (index < 0 || i < index) {
+			index, length = i, len(separator)
+		}
+	}
+	if index < 0 {
+		return strings.TrimSpace(rest), false
+	}
+	return strings.TrimSpace(rest[index+length:]), true
+}
+
+// withoutIndex drops the index of "1 110" in "TP1 110" or "target 1 110": a whole number
+// below 100 followed by other numbers
+func (p *parser) withoutIndex(value string) string {
+	numbers := p.number.FindAllStringIndex(value, -1)
+	if len(numbers) < 2 || numbers[0][0] != 0 {
+		return value
+	}
+	if index := value[:numbers[0][1]]; len(index) > 2 || strings.Trim(index, "0123456789") != "" {
+		return value
+	}
+	return strings.TrimSpace(value[numbers[0][1]:])
+}
+
+// numbers returns the numbers of value in the usual notation
+func (p *parser) numbers(value string) []string {
+	var numbers []string
+	for _, number := range p.number.FindAllString(value, -1) {
+		if p.template.Numbers.ThousandsSeparator != "" {
+			number = strings.ReplaceAll(number, p.template.Numbers.ThousandsSeparator, "")
+		}
+		number = strings.ReplaceAll(number, p.template.Numbers.DecimalSeparator, ".")
+		numbers = append(numbers, number)
+	}
+	return numbers
+}
+
+// market keeps the letters and digits of the pair, appending the quote when it's missing
+func (p *parser) market(value string) string {
+	market := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return -1
+	}, first(strings.Fields(strings.NewReplacer("/", "", "-", "", "_", "").Replace(value))))
+	quote := strings.ToUpper(p.template.Quote)
+	if market != "" && quote != "" && !strings.HasSuffix(market, quote) {
+		market += quote
+	}
+	return market
+}
+
+// side resolves the side field, or the whole message when the template has no side field
+func (p *parser) side(message string, values []string) (models.Side, bool) {
+	text := strings.ToLower(first(values))
+	f := p.fields[sideField]
+	if len(f.labels) == 0 && f.regex == nil {
+		text = strings.ToLower(message)
+	}
+	for _, side := range []models.Side{models.Long, models.Short} {
+		for _, synonym := range p.template.SideSynonyms[string(side)] {
+			if strings.Contains(text, strings.ToLower(normalize.Text(synonym))) {
+				return side, true
+			}
+		}
+	}
+	if side, ok := normalize.FindSide(text); ok {
+		return models.Side(side), true
+	}
+	return "", false
+}
+
+// marginMode resolves the margin mode field, or the whole message when only synonyms are given
+func (p *parser) marginMode(message string, values []string) models.MarginMode {
+	text := strings.ToLower(first(values))
+	f := p.fields[marginModeField]
+	if len(f.labels) == 0 && f.regex == nil {
+		if len(p.template.MarginModes) == 0 {
+			return ""
+		}
+		text = strings.ToLower(message)
+	}
+	for _, mode := range []models.MarginMode{models.Cross, models.Isolated} {
+		synonyms := append([]string{string(mode)}, p.template.MarginModes[string(mode)]...)
+		for _, synonym := range synonyms {
+			if strings.Contains(text, strings.ToLower(normalize.Text(synonym))) {
+				return mode
+			}
+		}
+	}
+	return ""
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -0,0 +1,67 @@
+package template_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/moneyscripter/teletrade/channels/template"
+	"github.com/moneyscripter/teletrade/models"
+)
+
+func TestParsSignal(t *testing.T) {
+	parser, err := template.NewParser(template.Template{
+		Name:     "Sample",
+		Username: "Sample",
+		Quote:    "USDT",
+		Fields: template.Fields{
+			Market:   template.Field{Labels: []string{"pair"}},
+			Side:     template.Field{Labels: []string{"position"}},
+			Entry:    template.Field{Labels: []string{"entry"}},
+			Targets:  template.Field{Labels: []string{"target", "tp"}},
+			StopLoss: template.Field{Labels: []string{"sl"}},
+			Leverage: template.Field{Labels: []string{"leverage"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		lines   []string
+		targets string
+	}{
+		{"separated", []string{"TP1: 110", "TP2: 120"}, "110 120"},
+		{"numbered without separator", []string{"TP1 110", "TP2 120"}, "110 120"},
+		{"spaced index without separator", []string{"target 1 110", "target 2 120"}, "110 120"},
+		{"list without separator", []string{"targets 110 120 130"}, "110 120 130"},
+		{"single target without separator", []string{"target 110"}, "110"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := strings.Join(append([]string{
+				"Pair: BTC",
+				"Position: long",
+				"Entry: 100",
+				// "sl" inside "also" is not the stop loss label
+				"Also wait for the 4h close",
+				"SL: 90",
+				"Leverage: 10",
+			}, tt.lines...), "\n")
+			signal, ok := parser.ParsSignal(message)
+			if !ok {
+				t.Fatal("no signal")
+			}
+			var targets []string
+			for _, target := range signal.Targets {
+				targets = append(targets, target.String())
+			}
+			if got := strings.Join(targets, " "); got != tt.targets {
+				t.Fatalf("targets are %s, want %s", got, tt.targets)
+			}
+			if signal.Market != "BTCUSDT" || signal.Side != models.Long || signal.StopLoss.String() != "90" || signal.Entry.From.String() != "100" {
+				t.Fatalf("unexpected signal %+v", signal)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+// Package template parses the signals of a channel from a declarative template, so a new
+// channel is onboarded by adding a YAML or JSON file instead of a Go package.
+//
+//	name: SampleSignals
+//	username: SampleSignals
+//	url: https://t.me/SampleSignals
+//	source: group        # channel (default), group or private
+//	topic_id: 12         # forum topic of the signals, all of them when omitted
+//	authors: [123456789] # user ids of the analysts, everyone when omitted
+//	quote: USDT
+//	fields:
+//	  market:    {labels: ["pair", "نام"]}
+//	  side:      {labels: ["position", "نوع پوزیشن"]}
+//	  entry:     {labels: ["entry", "نقطه ورود"]}
+//	  targets:   {labels: ["target", "tp", "تارگت"]}
+//	  stop_loss: {labels: ["stop", "sl", "حدضرر"]}
+//	  leverage:  {labels: ["leverage", "اهرم"]}
+//	side_synonyms:
+//	  long: ["لانگ", "buy"]
+//	  short: ["شورت", "sell"]
+package template
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/moneyscripter/teletrade/channels"
+	"github.com/spf13/viper"
+)
+
+// Field tells where the value of a field is found, either on the lines containing one of
+// Labels (the text after the label and the separators) or in the first group of the
+// matches of Regex over the whole message
+type Field struct {
+	Labels []string `mapstructure:"labels"`
+	Regex  string   `mapstructure:"regex"`
+}
+
+type Fields struct {
+	Market     Field `mapstructure:"market"`
+	Side       Field `mapstructure:"side"` // without labels nor regex the side synonyms are searched in the whole message
+	Entry      Field `mapstructure:"entry"`
+	Targets    Field `mapstructure:"targets"`
+	StopLoss   Field `mapstructure:"stop_loss"`
+	Leverage   Field `mapstructure:"leverage"`
+	MarginMode Field `mapstructure:"margin_mode"`
+}
+
+// NumberFormat of the prices posted by the channel, Persian and Arabic digits are always accepted
+type NumberFormat struct {
+	DecimalSeparator   string `mapstructure:"decimal_separator"`   // defaults to "."
+	ThousandsSeparator string `mapstructure:"thousands_separator"` // none by default
+}
+
+type Template struct {
+	Name         string              `mapstructure:"name"`
+	ChannelID    int64               `mapstructure:"channel_id"` // optional with a username or an invite url
+	Username     string              `mapstructure:"username"`   // public username of the channel, without the @
+	URL          string              `mapstructure:"url"`        // t.me link of the channel, an invite link for private ones
+	Source       string              `mapstructure:"source"`     // channel, group or private, channel when empty
+	TopicID      int                 `mapstructure:"topic_id"`   // forum topic of the signals
+	Authors      []int64             `mapstructure:"authors"`    // only these users' posts are parsed
+	Quote        string              `mapstructure:"quote"`      // appended to markets posted without it, e.g. BTC -> BTCUSDT
+	Separators   []string            `mapstructure:"separators"` // between a label and its value, defaults to ":" and "="
+	Fields       Fields              `mapstructure:"fields"`
+	SideSynonyms map[string][]string `mapstructure:"side_synonyms"` // long and short, on top of the usual spellings
+	MarginModes  map[string][]string `mapstructure:"margin_modes"`  // cross and isolated
+	Numbers      NumberFormat        `mapstructure:"numbers"`
+	Leverage     int                 `mapstructure:"default_leverage"` // used when the message has no leverage
+}
+
+var defaultSeparators = []string{":", "=", "："}
+
+// Load reads the template at path, its format is told by the extension (.yaml, .yml or .json)
+func Load(path string) (Template, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return Template{}, fmt.Errorf("read template %s: %w", path, err)
+	}
+	var t Template
+	if err := v.Unmarshal(&t); err != nil {
+		return Template{}, fmt.Errorf("decode template %s: %w", path, err)
+	}
+	if err := t.Validate(); err != nil {
+		return Template{}, fmt.Errorf("template %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// LoadDir reads every template of dir, sorted by file name
+func LoadDir(dir string) ([]Template, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".yaml", ".yml", ".json":
+			if !entry.IsDir() {
+				names = append(names, entry.Name())
+			}
+		}
+	}
+	sort.Strings(names)
+
+	var templates []Template
+	for _, name := range names {
+		t, err := Load(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+// Register adds the channel of the template to the channels registry
+func Register(t Template) error {
+	if _, err := NewParser(t); err != nil {
+		return fmt.Errorf("template %s: %w", t.Name, err)
+	}
+	return channels.Register(channels.Parser{
+		Name:      t.Name,
+		ChannelID: t.ChannelID,
+		Username:  strings.TrimPrefix(t.Username, "@"),
+		URL:       t.URL,
+		Source:    t.Source,
+		TopicID:   t.TopicID,
+		Authors:   t.Authors,
+		New: func() (channels.Channels, error) {
+			parser, err := NewParser(t)
+			return parser, err
+		},
+	})
+}
+
+// Validate checks the template is complete and its regexes compile
+func (t Template) Validate() error {
+	if t.Name == "" {
+		return errors.New("name is missing")
+	}
+	if t.ChannelID == 0 && t.Username == "" && t.URL == "" {
+		return errors.New("channel_id, username and url are missing, one of them is required")
+	}
+	required := map[string]Field{
+		"market":    t.Fields.Market,
+		"entry":     t.Fields.Entry,
+		"targets":   t.Fields.Targets,
+		"stop_loss": t.Fields.StopLoss,
+	}
+	if t.Leverage == 0 {
+		required["leverage"] = t.Fields.Leverage
+	}
+	for name, field := range required {
+		if len(field.Labels) == 0 && field.Regex == "" {
+			return fmt.Errorf("field %s has neither labels nor regex", name)
+		}
+	}
+	for _, field := range []Field{t.Fields.Market, t.Fields.Side, t.Fields.Entry, t.Fields.Targets,
+		t.Fields.StopLoss, t.Fields.Leverage, t.Fields.MarginMode} {
+		if field.Regex == "" {
+			continue
+		}
+		if _, err := regexp.Compile(field.Regex); err != nil {
+			return fmt.Errorf("invalid regex %q: %v", field.Regex, err)
+		}
+	}
+	for side := range t.SideSynonyms {
+		if side != "long" && side != "short" {
+			return fmt.Errorf("unknown side %q in side_synonyms", side)
+		}
+	}
+	for mode := range t.MarginModes {
+		if mode != "cross" && mode != "isolated" {
+			return fmt.Errorf("unknown margin mode %q in margin_modes", mode)
+		}
+	}
+	return nil
+}
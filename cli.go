@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/moneyscripter/teletrade/config"
+	"github.com/moneyscripter/teletrade/telegram_engine/client"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+)
+
+const usageText = `Usage: teletrade [command] [flags]
+
+Commands:
+  run           listen to the channels and trade their signals (default)
+  login         log the telegram accounts in from the terminal
+  fill-peers    cache the peers of every dialog of the accounts
+  list-dialogs  list the chats and channels of the accounts with their ids
+  help          show this help
+
+Flags:
+`
+
+// cliOptions are the flags shared by the commands
+type cliOptions struct {
+	envFile         string
+	phone           string
+	fillPeerStorage bool
+}
+
+func main() {
+	command, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	var cli cliOptions
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	configPath := flags.String("config", os.Getenv("CONFIG_PATH"), "config file, found in ./app/config, ./config or . when empty")
+	flags.StringVar(&cli.envFile, "env", ".env", "environment file loaded by the telegram accounts, ignored when missing")
+	flags.StringVar(&cli.phone, "phone", "", "phone of the only account to use, every account when empty")
+	flags.BoolVar(&cli.fillPeerStorage, "fill-peer-storage", false, "cache the peers of every dialog before listening")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usageText)
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	switch command {
+	case "run", "login", "fill-peers", "list-dialogs":
+	case "help":
+		flags.SetOutput(os.Stdout)
+		flags.Usage()
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", command)
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	config.LoadConfig(*configPath)
+	if command == "run" {
+		run(cli)
+		return
+	}
+	if err := accountCommand(command, cli); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", command, err)
+		os.Exit(1)
+	}
+}
+
+// telegramAccounts returns the listening accounts of the config, the only one of -phone if set
+func telegramAccounts(cli cliOptions) []client.Account {
+	telegramClient := config.AppConfig.TelegramClient
+
+	var accounts []client.Account
+	add := func(phone string, appID int, appHash string, channels []string, sessionDir string) {
+		if cli.phone != "" && cli.phone != phone {
+			return
+		}
+		if appID == 0 {
+			appID = telegramClient.AppID
+		}
+		if appHash == "" {
+			appHash = telegramClient.AppHash
+		}
+		accounts = append(accounts, client.Account{
+			Phone:    phone,
+			AppID:    appID,
+			AppHash:  appHash,
+			Channels: channels,
+			Options: client.Options{
+				SessionDir:      sessionDir,
+				EnvFile:         cli.envFile,
+				FillPeerStorage: cli.fillPeerStorage,
+				RateInterval:    telegramClient.RateInterval,
+				RateBurst:       telegramClient.RateBurst,
+				BackfillLimit:   config.AppConfig.Backfill.Limit,
+			},
+		})
+	}
+	// The account of the top level keys is the only one, unless it has no phone either
+	if len(telegramClient.Accounts) == 0 && telegramClient.Phone != "" {
+		add(telegramClient.Phone, telegramClient.AppID, telegramClient.AppHash, nil, "")
+	}
+	for _, account := range telegramClient.Accounts {
+		add(account.Phone, account.AppID, account.AppHash, account.Channels, account.SessionDir)
+	}
+	return accounts
+}
+
+// accountCommand runs a one-off command on every account, one after the other since the
+// login may prompt in the terminal
+func accountCommand(command string, cli cliOptions) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	accounts := telegramAccounts(cli)
+	if len(accounts) == 0 && cli.phone == "" {
+		return errors.New("no telegram account configured")
+	}
+	if len(accounts) == 0 {
+		return fmt.Errorf("no account has phone %s", cli.phone)
+	}
+	for _, account := range accounts {
+		engine := &client.Engine{
+			Phone:   account.Phone,
+			AppID:   account.AppID,
+			AppHash: account.AppHash,
+			Options: account.Options,
+		}
+		switch command {
+		case "login":
+			if err := engine.Login(ctx); err != nil {
+				return fmt.Errorf("account %s: %w", account.Phone, err)
+			}
+		case "fill-peers":
+			if err := engine.FillPeers(ctx); err != nil {
+				return fmt.Errorf("account %s: %w", account.Phone, err)
+			}
+		case "list-dialogs":
+			dialogs, err := engine.Dialogs(ctx)
+			if err != nil {
+				return fmt.Errorf("account %s: %w", account.Phone, err)
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(w, "KIND\tID\tUSERNAME\tTITLE\n")
+			for _, dialog := range dialogs {
+				username := ""
+				if dialog.Username != "" {
+					username = "@" + dialog.Username
+				}
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", dialog.Kind, dialog.ID, username, dialog.Title)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
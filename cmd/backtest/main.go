@@ -0,0 +1,101 @@
+// Command backtest simulates the signals of an exported channel history against OHLCV candles.
+//
+//	backtest -channel CryptoTrade066 -history result.json -candles ./candles -format csv > outcomes.csv
+//	backtest -template SampleSignals.yaml -history result.json -candles ./candles
+//
+// The history is the result.json of Telegram Desktop's "Export chat history" (JSON format),
+// candles are read from <candles>/<market>.csv, see paper.LoadCandles.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/moneyscripter/teletrade/backtest"
+	"github.com/moneyscripter/teletrade/channels"
+	_ "github.com/moneyscripter/teletrade/channels/CryptoTrade066"
+	"github.com/moneyscripter/teletrade/channels/template"
+	"io"
+	"os"
+	"time"
+)
+
+func main() {
+	channel := flag.String("channel", "CryptoTrade066", "parser of the channel")
+	templatePath := flag.String("template", "", "parser template of the channel, overrides -channel")
+	history := flag.String("history", "result.json", "exported history of the channel")
+	candles := flag.String("candles", "candles", "directory of <market>.csv candle files")
+	format := flag.String("format", "json", "output format, json or csv")
+	output := flag.String("out", "", "output file, stdout when empty")
+	fee := flag.Float64("fee", 0.0005, "taker fee, fraction of the notional")
+	expiry := flag.Duration("expiry", 72*time.Hour, "entries not reached within it are dropped, 0 never drops them")
+	maxDuration := flag.Duration("max-duration", 0, "positions open for longer are closed at market, 0 never closes them")
+	risk := flag.Float64("risk", 10, "percent of the equity used as margin of each trade")
+	breakeven := flag.Bool("breakeven", false, "move the stop loss to the entry once the first target is hit")
+	flag.Parse()
+
+	if err := run(*channel, *templatePath, *history, *candles, *format, *output, backtest.Options{
+		Fee:         *fee,
+		Expiry:      *expiry,
+		MaxDuration: *maxDuration,
+		RiskPercent: *risk,
+		Breakeven:   *breakeven,
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "backtest: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(channel, templatePath, history, candles, format, output string, opts backtest.Options) error {
+	// Checked before the output file is created, a typo never wipes an existing report
+	if format != "json" && format != "csv" {
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	var parser channels.Channels
+	registered, ok := channels.Lookup(channel)
+	if ok {
+		var err error
+		if parser, err = registered.New(); err != nil {
+			return err
+		}
+	}
+	if templatePath != "" {
+		t, err := template.Load(templatePath)
+		if err != nil {
+			return err
+		}
+		if parser, err = template.NewParser(t); err != nil {
+			return err
+		}
+		channel, ok = t.Name, true
+	}
+	if !ok {
+		return fmt.Errorf("unknown channel %q", channel)
+	}
+	messages, err := backtest.LoadTelegramExport(history)
+	if err != nil {
+		return err
+	}
+	report := backtest.Run(channel, parser, messages, backtest.NewDirCandles(candles), opts)
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if format == "json" {
+		return backtest.WriteJSON(w, report)
+	}
+	if err := backtest.WriteCSV(w, report); err != nil {
+		return err
+	}
+	s := report.Summary
+	fmt.Fprintf(os.Stderr, "%d signals, %d closed, win rate %.1f%%, expectancy %.2f%%, max drawdown %.2f%%\n",
+		s.Signals, s.Closed, s.WinRate*100, s.Expectancy*100, s.MaxDrawdown*100)
+	return nil
+}
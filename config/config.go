@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/spf13/viper"
 	"strings"
+	"time"
 )
 
 var AppConfig *config // global app config
@@ -11,16 +12,87 @@ var AppConfig *config // global app config
 type config struct {
 	TelegramClient telegramClient `mapstructure:"telegram_client"`
 	TelegramBot    telegramBot    `mapstructure:"telegram_bot"`
+	Secrets        secrets        `mapstructure:"secrets"`
+	Execution      execution      `mapstructure:"execution"`
+	Paper          paper          `mapstructure:"paper"`
+	Dedup          dedup          `mapstructure:"dedup"`
+	Channels       channels       `mapstructure:"channels"`
+	Backfill       backfill       `mapstructure:"backfill"`
 }
 
 type telegramClient struct {
-	Phone   string `mapstructure:"phone"`
-	AppID   int    `mapstructure:"app_id"`
-	AppHash string `mapstructure:"app_hash"`
+	Phone    string            `mapstructure:"phone"`
+	AppID    int               `mapstructure:"app_id"`
+	AppHash  string            `mapstructure:"app_hash"`
+	Accounts []telegramAccount `mapstructure:"accounts"` // listening accounts, the phone above alone when empty
+	Login    login             `mapstructure:"login"`
+
+	RateInterval time.Duration `mapstructure:"rate_interval"` // minimum interval between the requests of an account, defaults to 100ms
+	RateBurst    int           `mapstructure:"rate_burst"`    // requests of an account sent at once, defaults to 5
+}
+
+// login of the accounts without a terminal, the code and password are asked to the admins of
+// the bot or through the HTTP endpoint. The terminal is used when neither is configured.
+type login struct {
+	Timeout   time.Duration `mapstructure:"timeout"`    // wait for each answer, defaults to 10m
+	Retries   int           `mapstructure:"retries"`    // attempts after wrong or late answers, defaults to 3
+	HTTPAddr  string        `mapstructure:"http_addr"`  // e.g. 127.0.0.1:8088, serves GET and POST /login
+	HTTPToken string        `mapstructure:"http_token"` // bearer token of the endpoint, expected from the environment
+}
+
+type telegramAccount struct {
+	Phone      string   `mapstructure:"phone"`
+	AppID      int      `mapstructure:"app_id"`      // defaults to telegram_client.app_id
+	AppHash    string   `mapstructure:"app_hash"`    // defaults to telegram_client.app_hash
+	Channels   []string `mapstructure:"channels"`    // channels listened to by the account, all of them when empty
+	SessionDir string   `mapstructure:"session_dir"` // defaults to session/phone-<digits of the phone>
 }
 
 type telegramBot struct {
-	Token string `mapstructure:"token"`
+	Token  string  `mapstructure:"token"`
+	DBPath string  `mapstructure:"db_path"` // bbolt file holding subscribed users, defaults to users.bolt.db
+	Admins []int64 `mapstructure:"admins"`  // chat ids answering the login questions of the accounts
+}
+
+type secrets struct {
+	MasterKey     string `mapstructure:"master_key"`      // base64 encoded 32 bytes key
+	MasterKeyFile string `mapstructure:"master_key_file"` // used when master_key is empty
+}
+
+type execution struct {
+	Workers   int    `mapstructure:"workers"`    // trades executed concurrently
+	PerUser   int    `mapstructure:"per_user"`   // trades of a single user executed concurrently
+	QueueSize int    `mapstructure:"queue_size"` // trades waiting for a free worker
+	DBPath    string `mapstructure:"db_path"`    // bbolt file journaling the trades, defaults to trades.bolt.db
+}
+
+type paper struct {
+	DBPath          string        `mapstructure:"db_path"`          // bbolt file holding the simulated wallets, defaults to paper.bolt.db
+	InitialBalance  float64       `mapstructure:"initial_balance"`  // USDT of a new wallet, defaults to 1000
+	TakerFee        float64       `mapstructure:"taker_fee"`        // fraction of the notional, e.g. 0.0005
+	MakerFee        float64       `mapstructure:"maker_fee"`        // fraction of the notional, e.g. 0.0002
+	Slippage        float64       `mapstructure:"slippage"`         // fraction of the price lost on market fills
+	FundingRate     float64       `mapstructure:"funding_rate"`     // paid by longs to shorts every funding interval
+	FundingInterval time.Duration `mapstructure:"funding_interval"` // e.g. "8h", zero disables funding
+	CandlesDir      string        `mapstructure:"candles_dir"`      // replays <market>.csv candles when set, live Binance prices otherwise
+	CandleStep      time.Duration `mapstructure:"candle_step"`      // wall time each replayed candle lasts, defaults to 1s
+}
+
+type dedup struct {
+	DBPath string        `mapstructure:"db_path"` // bbolt file of the admitted signals, defaults to signals.bolt.db
+	Window time.Duration `mapstructure:"window"`  // reposts of a call within it are dropped, defaults to 24h
+}
+
+type channels struct {
+	TemplatesDir string `mapstructure:"templates_dir"` // YAML/JSON parser templates of extra channels, see channels/template
+}
+
+// backfill of the messages the channels posted while the listeners were down
+type backfill struct {
+	Mode   string        `mapstructure:"mode"`    // off, record or replay, defaults to off
+	MaxAge time.Duration `mapstructure:"max_age"` // missed signals older than it are only recorded, defaults to 1h
+	Limit  int           `mapstructure:"limit"`   // messages read from the history of each channel, defaults to 100
+	DBPath string        `mapstructure:"db_path"` // bbolt file of the last message processed of each channel, defaults to backfill.bolt.db
 }
 
 func LoadConfig(path string) {
@@ -41,6 +113,11 @@ func LoadConfig(path string) {
 
 	viper.AutomaticEnv() // read in environment variables that match
 
+	// Secrets are expected from the environment, keys must be known to viper to be unmarshalled
+	viper.SetDefault("secrets.master_key", "")
+	viper.SetDefault("secrets.master_key_file", "")
+	viper.SetDefault("telegram_client.login.http_token", "")
+
 	err := viper.ReadInConfig() // Find and read the config file
 	if err != nil {             // Handle errors reading the config file
 		panic(fmt.Errorf("fatal error config file: %w", err))
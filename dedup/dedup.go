@@ -0,0 +1,236 @@
+// Package dedup suppresses the signals a channel already sent, so a repost or an edit of a
+// call never opens a second trade.
+package dedup
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moneyscripter/teletrade/models"
+	"go.etcd.io/bbolt"
+)
+
+type Reason string
+
+const (
+	SameMessage Reason = "same_message" // the very same telegram message was received again
+	SameText    Reason = "same_text"    // the channel posted the same text again within the window
+	SameSignal  Reason = "same_signal"  // the channel posted the same call, worded differently, within the window
+)
+
+// Drop records why a signal was suppressed
+type Drop struct {
+	SignalID    string
+	Source      models.Source
+	Reason      Reason
+	DuplicateOf string // ID of the signal admitted first
+	At          time.Time
+}
+
+// DefaultWindow is used when no window is configured
+const DefaultWindow = 24 * time.Hour
+
+var (
+	seenBucket    = []byte("seen")
+	droppedBucket = []byte("dropped")
+	expiryBucket  = []byte("expiry") // <posted at>-<key of seen> of the texts and calls, in time order
+)
+
+// seen is the signal admitted under a key of the seen bucket
+type seen struct {
+	SignalID string
+	PostedAt time.Time
+}
+
+// Store remembers the admitted signals in bbolt, the same message is never admitted twice
+// while the same text or call of a channel is admitted again once window has passed.
+type Store struct {
+	db     *bbolt.DB
+	window time.Duration
+	mutex  *sync.Mutex
+}
+
+// NewBoltStore opens (or creates) the bbolt database at path
+func NewBoltStore(path string, window time.Duration) (*Store, error) {
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("open dedup store: %w", err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{seenBucket, droppedBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(expiryBucket) != nil {
+			return nil
+		}
+		// Stores written before the expiry bucket existed get it from what they have seen
+		if _, err := tx.CreateBucket(expiryBucket); err != nil {
+			return err
+		}
+		return tx.Bucket(seenBucket).ForEach(func(k, v []byte) error {
+			if strings.HasPrefix(string(k), "message:") {
+				return nil
+			}
+			var previous seen
+			if err := json.Unmarshal(v, &previous); err != nil {
+				return fmt.Errorf("decode %s: %w", k, err)
+			}
+			return tx.Bucket(expiryBucket).Put(expiryKey(previous.PostedAt, string(k)), k)
+		})
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	return &Store{db: db, window: window, mutex: &sync.Mutex{}}, nil
+}
+
+// Admit remembers the signal and returns nil, or records and returns why it's a duplicate.
+// The signal is expected to be attributed to its message, see models.Signal.Attribute.
+func (s *Store) Admit(signal models.Signal) (*Drop, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	source := signal.Source
+	postedAt := source.PostedAt
+	if postedAt.IsZero() {
+		postedAt = time.Now()
+	}
+	keys := []struct {
+		reason Reason
+		key    string
+	}{
+		{SameMessage, fmt.Sprintf("message:%d:%d", source.ChannelID, source.MessageID)},
+		{SameText, fmt.Sprintf("text:%d:%s", source.ChannelID, source.TextHash)},
+		{SameSignal, fmt.Sprintf("signal:%d:%s", source.ChannelID, signal.Fingerprint())},
+	}
+
+	var drop *Drop
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(seenBucket)
+		for _, k := range keys {
+			v := bucket.Get([]byte(k.key))
+			if v == nil {
+				continue
+			}
+			var previous seen
+			if err := json.Unmarshal(v, &previous); err != nil {
+				return fmt.Errorf("decode %s: %w", k.key, err)
+			}
+			if k.reason != SameMessage && postedAt.Sub(previous.PostedAt) >= s.window {
+				continue
+			}
+			drop = &Drop{
+				SignalID:    signal.ID,
+				Source:      source,
+				Reason:      k.reason,
+				DuplicateOf: previous.SignalID,
+				At:          time.Now(),
+			}
+			data, err := json.Marshal(drop)
+			if err != nil {
+				return err
+			}
+			key := fmt.Sprintf("%020d-%s", drop.At.UnixNano(), signal.ID)
+			return tx.Bucket(droppedBucket).Put([]byte(key), data)
+		}
+
+		data, err := json.Marshal(seen{SignalID: signal.ID, PostedAt: postedAt})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := bucket.Put([]byte(k.key), data); err != nil {
+				return err
+			}
+			if k.reason == SameMessage {
+				continue
+			}
+			if err := tx.Bucket(expiryBucket).Put(expiryKey(postedAt, k.key), []byte(k.key)); err != nil {
+				return err
+			}
+		}
+		return s.prune(tx, postedAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return drop, nil
+}
+
+// expiryKey sorts the keys of seen by the time they were posted at
+func expiryKey(postedAt time.Time, key string) []byte {
+	return []byte(fmt.Sprintf("%020d-%s", postedAt.UnixNano(), key))
+}
+
+// prune forgets the texts and calls older than the window, messages are kept to never admit
+// them twice. Only the expired part of the expiry bucket is read.
+func (s *Store) prune(tx *bbolt.Tx, now time.Time) error {
+	bucket := tx.Bucket(seenBucket)
+	expiry := tx.Bucket(expiryBucket)
+	limit := expiryKey(now.Add(-s.window), "~")
+
+	var expired, forgotten [][]byte
+	c := expiry.Cursor()
+	for k, v := c.First(); k != nil && string(k) <= string(limit); k, v = c.Next() {
+		expired = append(expired, append([]byte(nil), k...))
+		// A text or call seen again since then stays until its latest post expires
+		data := bucket.Get(v)
+		if data == nil {
+			continue
+		}
+		var previous seen
+		if err := json.Unmarshal(data, &previous); err != nil {
+			return fmt.Errorf("decode %s: %w", v, err)
+		}
+		if now.Sub(previous.PostedAt) >= s.window {
+			forgotten = append(forgotten, append([]byte(nil), v...))
+		}
+	}
+	for _, k := range forgotten {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	for _, k := range expired {
+		if err := expiry.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Drops returns the recorded drops, the latest first, at most limit of them (zero for all)
+func (s *Store) Drops(limit int) ([]Drop, error) {
+	var drops []Drop
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(droppedBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if limit > 0 && len(drops) >= limit {
+				break
+			}
+			var drop Drop
+			if err := json.Unmarshal(v, &drop); err != nil {
+				return fmt.Errorf("decode drop %s: %w", k, err)
+			}
+			drops = append(drops, drop)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return drops, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,109 @@
+package dedup
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/moneyscripter/teletrade/models"
+	"go.etcd.io/bbolt"
+)
+
+func signal(messageID int, postedAt time.Time, text, target string) models.Signal {
+	s := models.Signal{
+		Market:   "BTCUSDT",
+		Side:     models.Long,
+		Entry:    models.EntryRange{From: models.MustDecimal("100"), To: models.MustDecimal("100")},
+		Targets:  []models.Decimal{models.MustDecimal(target)},
+		StopLoss: models.MustDecimal("90"),
+		Leverage: 10,
+	}
+	s.Attribute(1, messageID, postedAt, text)
+	return s
+}
+
+// seenKeys returns the keys of the seen bucket and the size of the expiry bucket
+func seenKeys(t *testing.T, s *Store) (keys []string, expiries int) {
+	t.Helper()
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		expiries = tx.Bucket(expiryBucket).Stats().KeyN
+		return tx.Bucket(seenBucket).ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys, expiries
+}
+
+func TestAdmitAndPrune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.db")
+	store, err := NewBoltStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	admit := func(s models.Signal) *Drop {
+		t.Helper()
+		drop, err := store.Admit(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return drop
+	}
+
+	if drop := admit(signal(1, start, "BTC long 100", "110")); drop != nil {
+		t.Fatalf("first signal dropped: %+v", drop)
+	}
+	if drop := admit(signal(1, start, "BTC long 100", "110")); drop == nil || drop.Reason != SameMessage {
+		t.Fatalf("got %+v, want the same message dropped", drop)
+	}
+	if drop := admit(signal(2, start.Add(time.Minute), "BTC long 100", "110")); drop == nil || drop.Reason != SameText {
+		t.Fatalf("got %+v, want the same text dropped", drop)
+	}
+	if drop := admit(signal(3, start.Add(2*time.Minute), "BTC long now at 100", "110")); drop == nil || drop.Reason != SameSignal {
+		t.Fatalf("got %+v, want the same call dropped", drop)
+	}
+	if keys, expiries := seenKeys(t, store); len(keys) != 3 || expiries != 2 {
+		t.Fatalf("seen %v with %d expiries, want 3 keys and 2 expiries", keys, expiries)
+	}
+
+	// Another call once the window has passed prunes the first one, its message stays
+	if drop := admit(signal(4, start.Add(2*time.Hour), "ETH long 100", "120")); drop != nil {
+		t.Fatalf("other call dropped: %+v", drop)
+	}
+	keys, expiries := seenKeys(t, store)
+	if len(keys) != 4 || expiries != 2 {
+		t.Fatalf("seen %v with %d expiries, want 4 keys and 2 expiries", keys, expiries)
+	}
+	if drop := admit(signal(5, start.Add(2*time.Hour), "BTC long 100", "110")); drop != nil {
+		t.Fatalf("call reposted after the window dropped: %+v", drop)
+	}
+
+	// Stores written without the expiry bucket get it when they are opened
+	err = store.db.Update(func(tx *bbolt.Tx) error {
+		return tx.DeleteBucket(expiryBucket)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if store, err = NewBoltStore(path, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, expiries := seenKeys(t, store); expiries != 4 {
+		t.Fatalf("%d expiries rebuilt, want 4", expiries)
+	}
+	if drop := admit(signal(6, start.Add(4*time.Hour), "SOL long 100", "130")); drop != nil {
+		t.Fatalf("other call dropped: %+v", drop)
+	}
+	if keys, expiries := seenKeys(t, store); len(keys) != 6 || expiries != 2 {
+		t.Fatalf("seen %v with %d expiries, want 6 keys and 2 expiries", keys, expiries)
+	}
+}
@@ -0,0 +1,726 @@
+package binance
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/moneyscripter/teletrade/exchanges"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	binanceBaseURL = "https://fapi.binance.com"
+	recvWindow     = "5000"
+
+	// errNoNeedToChangeMarginType is returned when the margin type is already the requested one
+	errNoNeedToChangeMarginType = -4046
+)
+
+type engine struct {
+	ApiKey    string
+	SecretKey string
+	baseURL   string
+
+	mutex   *sync.Mutex
+	symbols map[string]symbolInfo // symbol -> exchangeInfo filters, cached as they barely change
+}
+
+func NewBinanceEngine(apiKey, secretKey string) exchanges.Exchanges {
+	return NewBinanceEngineWithBaseURL(apiKey, secretKey, binanceBaseURL)
+}
+
+// NewBinanceEngineWithBaseURL points the engine to another server, e.g. binancetest.Server
+func NewBinanceEngineWithBaseURL(apiKey, secretKey, baseURL string) exchanges.Exchanges {
+	return &engine{
+		ApiKey:    apiKey,
+		SecretKey: secretKey,
+		baseURL:   baseURL,
+		mutex:     &sync.Mutex{},
+		symbols:   make(map[string]symbolInfo),
+	}
+}
+
+func (c *engine) Name() string {
+	return "Binance"
+}
+
+type premiumIndex struct {
+	Symbol     string `json:"symbol"`
+	MarkPrice  string `json:"markPrice"`
+	IndexPrice string `json:"indexPrice"`
+}
+
+type tickerPrice struct {
+	Symbol string `json:"symbol"`
+	Price  string `json:"price"`
+}
+
+func (c *engine) Ticker(ctx context.Context, market string) (exchanges.Ticker, error) {
+	params := url.Values{"symbol": {market}}
+
+	var mark premiumIndex
+	if err := c.call(ctx, "/fapi/v1/premiumIndex", http.MethodGet, params, false, &mark); err != nil {
+		return exchanges.Ticker{}, err
+	}
+	var last tickerPrice
+	if err := c.call(ctx, "/fapi/v1/ticker/price", http.MethodGet, params, false, &last); err != nil {
+		return exchanges.Ticker{}, err
+	}
+
+	return exchanges.Ticker{
+		Market:    market,
+		LastPrice: last.Price,
+		MarkPrice: mark.MarkPrice,
+	}, nil
+}
+
+type filter struct {
+	FilterType string `json:"filterType"`
+	TickSize   string `json:"tickSize"`
+	MinQty     string `json:"minQty"`
+	StepSize   string `json:"stepSize"`
+	Notional   string `json:"notional"`
+}
+
+type symbolInfo struct {
+	Symbol            string   `json:"symbol"`
+	Status            string   `json:"status"`
+	PricePrecision    int      `json:"pricePrecision"`
+	QuantityPrecision int      `json:"quantityPrecision"`
+	Filters           []filter `json:"filters"`
+}
+
+func (s symbolInfo) filter(filterType string) filter {
+	for _, f := range s.Filters {
+		if f.FilterType == filterType {
+			return f
+		}
+	}
+	return filter{}
+}
+
+type exchangeInfo struct {
+	Symbols []symbolInfo `json:"symbols"`
+}
+
+func (c *engine) symbol(ctx context.Context, market string) (symbolInfo, error) {
+	c.mutex.Lock()
+	info, ok := c.symbols[market]
+	c.mutex.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	var resp exchangeInfo
+	if err := c.call(ctx, "/fapi/v1/exchangeInfo", http.MethodGet, url.Values{}, false, &resp); err != nil {
+		return symbolInfo{}, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, s := range resp.Symbols {
+		c.symbols[s.Symbol] = s
+	}
+	if info, ok := c.symbols[market]; ok {
+		return info, nil
+	}
+	return symbolInfo{}, fmt.Errorf("unknown market %s", market)
+}
+
+type leverageBracket struct {
+	Symbol   string `json:"symbol"`
+	Brackets []struct {
+		Bracket         int `json:"bracket"`
+		InitialLeverage int `json:"initialLeverage"`
+	} `json:"brackets"`
+}
+
+func (c *engine) MarketInfo(ctx context.Context, market string) (exchanges.MarketInfo, error) {
+	s, err := c.symbol(ctx, market)
+	if err != nil {
+		return exchanges.MarketInfo{}, err
+	}
+
+	var brackets []leverageBracket
+	err = c.call(ctx, "/fapi/v1/leverageBracket", http.MethodGet, url.Values{"symbol": {market}}, true, &brackets)
+	if err != nil {
+		return exchanges.MarketInfo{}, err
+	}
+	maxLeverage := 0
+	for _, b := range brackets {
+		for _, bracket := range b.Brackets {
+			if bracket.InitialLeverage > maxLeverage {
+				maxLeverage = bracket.InitialLeverage
+			}
+		}
+	}
+
+	lotSize := s.filter("LOT_SIZE")
+	return exchanges.MarketInfo{
+		Market:          market,
+		MinAmount:       lotSize.MinQty,
+		AmountPrecision: precision(lotSize.StepSize),
+		PricePrecision:  precision(s.filter("PRICE_FILTER").TickSize),
+		MaxLeverage:     maxLeverage,
+	}, nil
+}
+
+type balance struct {
+	Asset              string `json:"asset"`
+	Balance            string `json:"balance"`
+	CrossWalletBalance string `json:"crossWalletBalance"`
+	CrossUnPnl         string `json:"crossUnPnl"`
+	AvailableBalance   string `json:"availableBalance"`
+	MaxWithdrawAmount  string `json:"maxWithdrawAmount"`
+}
+
+func (c *engine) Balance(ctx context.Context, asset string) (exchanges.Balance, error) {
+	var resp []balance
+	if err := c.call(ctx, "/fapi/v2/balance", http.MethodGet, url.Values{}, true, &resp); err != nil {
+		return exchanges.Balance{}, err
+	}
+	for _, b := range resp {
+		if b.Asset != asset {
+			continue
+		}
+		total, _ := strconv.ParseFloat(b.Balance, 64)
+		available, _ := strconv.ParseFloat(b.AvailableBalance, 64)
+		return exchanges.Balance{
+			Asset:     b.Asset,
+			Available: b.AvailableBalance,
+			Frozen:    strconv.FormatFloat(math.Max(total-available, 0), 'f', -1, 64),
+		}, nil
+	}
+	return exchanges.Balance{Asset: asset, Available: "0", Frozen: "0"}, nil
+}
+
+func (c *engine) SetLeverage(ctx context.Context, market string, leverage int, mode exchanges.MarginMode) error {
+	marginType := "CROSSED"
+	if mode == exchanges.Isolated {
+		marginType = "ISOLATED"
+	}
+	err := c.call(ctx, "/fapi/v1/marginType", http.MethodPost, url.Values{
+		"symbol":     {market},
+		"marginType": {marginType},
+	}, true, nil)
+	if err != nil && !isAPIError(err, errNoNeedToChangeMarginType) {
+		return fmt.Errorf("failed to set margin type: %v", err)
+	}
+	return c.call(ctx, "/fapi/v1/leverage", http.MethodPost, url.Values{
+		"symbol":   {market},
+		"leverage": {strconv.Itoa(leverage)},
+	}, true, nil)
+}
+
+type order struct {
+	OrderID       int64  `json:"orderId"`
+	Symbol        string `json:"symbol"`
+	Status        string `json:"status"`
+	ClientOrderID string `json:"clientOrderId"`
+	Price         string `json:"price"`
+	OrigQty       string `json:"origQty"`
+	ExecutedQty   string `json:"executedQty"`
+	Type          string `json:"type"` // LIMIT, MARKET, STOP, STOP_MARKET, TAKE_PROFIT, TAKE_PROFIT_MARKET
+	Side          string `json:"side"` // BUY or SELL
+	StopPrice     string `json:"stopPrice"`
+	ReduceOnly    bool   `json:"reduceOnly"`
+	ClosePosition bool   `json:"closePosition"`
+	WorkingType   string `json:"workingType"`
+}
+
+func (o order) isStop() bool {
+	return o.Type != "LIMIT" && o.Type != "MARKET"
+}
+
+func (o order) toOrder() exchanges.Order {
+	orderType := exchanges.LimitOrder
+	if strings.HasSuffix(o.Type, "MARKET") {
+		orderType = exchanges.MarketOrder
+	}
+	return exchanges.Order{
+		ID:           strconv.FormatInt(o.OrderID, 10),
+		Market:       o.Symbol,
+		Side:         exchanges.Side(strings.ToLower(o.Side)),
+		Type:         orderType,
+		Amount:       o.OrigQty,
+		Price:        o.Price,
+		TriggerPrice: o.StopPrice,
+	}
+}
+
+func (c *engine) PlaceOrder(ctx context.Context, r exchanges.OrderRequest) (exchanges.Order, error) {
+	s, err := c.symbol(ctx, r.Market)
+	if err != nil {
+		return exchanges.Order{}, err
+	}
+	params := url.Values{
+		"symbol":           {r.Market},
+		"side":             {strings.ToUpper(string(r.Side))},
+		"quantity":         {roundQuantity(s, r.Amount)},
+		"newClientOrderId": {clientOrderID()},
+	}
+	if r.Type == exchanges.MarketOrder {
+		params.Set("type", "MARKET")
+	} else {
+		params.Set("type", "LIMIT")
+		params.Set("price", roundPrice(s, r.Price))
+		params.Set("timeInForce", "GTC")
+	}
+	if r.ReduceOnly {
+		params.Set("reduceOnly", "true")
+	}
+
+	var resp order
+	if err := c.call(ctx, "/fapi/v1/order", http.MethodPost, params, true, &resp); err != nil {
+		return exchanges.Order{}, err
+	}
+	return resp.toOrder(), nil
+}
+
+func (c *engine) PlaceStopOrder(ctx context.Context, r exchanges.StopOrderRequest) (exchanges.Order, error) {
+	s, err := c.symbol(ctx, r.Market)
+	if err != nil {
+		return exchanges.Order{}, err
+	}
+	params := url.Values{
+		"symbol":           {r.Market},
+		"side":             {strings.ToUpper(string(r.Side))},
+		"quantity":         {roundQuantity(s, r.Amount)},
+		"stopPrice":        {roundPrice(s, r.TriggerPrice)},
+		"workingType":      {"MARK_PRICE"},
+		"newClientOrderId": {clientOrderID()},
+	}
+	// STOP orders only trigger on a breakout, a trigger already crossed by the mark price
+	// would be rejected so it is placed as a TAKE_PROFIT order instead
+	ticker, err := c.Ticker(ctx, r.Market)
+	if err != nil {
+		return exchanges.Order{}, err
+	}
+	orderType := "STOP"
+	if crossed(r.Side, ticker.MarkPrice, r.TriggerPrice) {
+		orderType = "TAKE_PROFIT"
+	}
+	if r.Type == exchanges.MarketOrder {
+		params.Set("type", orderType+"_MARKET")
+	} else {
+		params.Set("type", orderType)
+		params.Set("price", roundPrice(s, r.Price))
+		params.Set("timeInForce", "GTC")
+	}
+	if r.ReduceOnly {
+		params.Set("reduceOnly", "true")
+	}
+
+	var resp order
+	if err := c.call(ctx, "/fapi/v1/order", http.MethodPost, params, true, &resp); err != nil {
+		return exchanges.Order{}, err
+	}
+	return resp.toOrder(), nil
+}
+
+func (c *engine) AmendOrder(ctx context.Context, market, orderID, amount, price string) (exchanges.Order, error) {
+	o, err := c.findOrder(ctx, market, orderID)
+	if err != nil {
+		return exchanges.Order{}, err
+	}
+	s, err := c.symbol(ctx, market)
+	if err != nil {
+		return exchanges.Order{}, err
+	}
+	if amount == "" {
+		amount = o.OrigQty
+	}
+	if price == "" {
+		price = o.Price
+	}
+
+	var resp order
+	err = c.call(ctx, "/fapi/v1/order", http.MethodPut, url.Values{
+		"symbol":   {market},
+		"orderId":  {orderID},
+		"side":     {o.Side},
+		"quantity": {roundQuantity(s, amount)},
+		"price":    {roundPrice(s, price)},
+	}, true, &resp)
+	if err != nil {
+		return exchanges.Order{}, err
+	}
+	return resp.toOrder(), nil
+}
+
+// AmendStopOrder cancels the stop order and places it again, Binance only modifies limit orders
+func (c *engine) AmendStopOrder(ctx context.Context, market, orderID, amount, triggerPrice string) (exchanges.Order, error) {
+	o, err := c.findOrder(ctx, market, orderID)
+	if err != nil {
+		return exchanges.Order{}, err
+	}
+	if err := c.CancelStopOrder(ctx, market, orderID); err != nil {
+		return exchanges.Order{}, err
+	}
+	converted := o.toOrder()
+	if amount == "" {
+		amount = converted.Amount
+	}
+	if triggerPrice == "" {
+		triggerPrice = converted.TriggerPrice
+	}
+	return c.PlaceStopOrder(ctx, exchanges.StopOrderRequest{
+		Market:       market,
+		Side:         converted.Side,
+		Type:         converted.Type,
+		Amount:       amount,
+		Price:        converted.Price,
+		TriggerPrice: triggerPrice,
+		ReduceOnly:   o.ReduceOnly,
+	})
+}
+
+func (c *engine) findOrder(ctx context.Context, market, orderID string) (order, error) {
+	orders, err := c.openOrders(ctx, market)
+	if err != nil {
+		return order{}, err
+	}
+	for _, o := range orders {
+		if strconv.FormatInt(o.OrderID, 10) == orderID {
+			return o, nil
+		}
+	}
+	return order{}, fmt.Errorf("order %s not found", orderID)
+}
+
+func (c *engine) CancelOrder(ctx context.Context, market, orderID string) error {
+	return c.call(ctx, "/fapi/v1/order", http.MethodDelete, url.Values{
+		"symbol":  {market},
+		"orderId": {orderID},
+	}, true, nil)
+}
+
+// CancelStopOrder is the same as CancelOrder, stop orders are regular orders on Binance
+func (c *engine) CancelStopOrder(ctx context.Context, market, orderID string) error {
+	return c.CancelOrder(ctx, market, orderID)
+}
+
+func (c *engine) CancelAllOrders(ctx context.Context, market string) error {
+	return c.call(ctx, "/fapi/v1/allOpenOrders", http.MethodDelete, url.Values{
+		"symbol": {market},
+	}, true, nil)
+}
+
+func (c *engine) openOrders(ctx context.Context, market string) ([]order, error) {
+	var resp []order
+	err := c.call(ctx, "/fapi/v1/openOrders", http.MethodGet, url.Values{
+		"symbol": {market},
+	}, true, &resp)
+	return resp, err
+}
+
+func (c *engine) OpenOrders(ctx context.Context, market string) ([]exchanges.Order, error) {
+	resp, err := c.openOrders(ctx, market)
+	if err != nil {
+		return nil, err
+	}
+	var orders []exchanges.Order
+	for _, o := range resp {
+		if !o.isStop() {
+			orders = append(orders, o.toOrder())
+		}
+	}
+	return orders, nil
+}
+
+func (c *engine) OpenStopOrders(ctx context.Context, market string) ([]exchanges.Order, error) {
+	resp, err := c.openOrders(ctx, market)
+	if err != nil {
+		return nil, err
+	}
+	var orders []exchanges.Order
+	for _, o := range resp {
+		if o.isStop() {
+			orders = append(orders, o.toOrder())
+		}
+	}
+	return orders, nil
+}
+
+type positionRisk struct {
+	Symbol           string `json:"symbol"`
+	PositionAmt      string `json:"positionAmt"` // negative for short positions
+	EntryPrice       string `json:"entryPrice"`
+	MarkPrice        string `json:"markPrice"`
+	UnRealizedProfit string `json:"unRealizedProfit"`
+	Leverage         string `json:"leverage"`
+	MarginType       string `json:"marginType"`
+	PositionSide     string `json:"positionSide"`
+}
+
+func (c *engine) positions(ctx context.Context, market string) ([]positionRisk, error) {
+	var resp []positionRisk
+	err := c.call(ctx, "/fapi/v2/positionRisk", http.MethodGet, url.Values{
+		"symbol": {market},
+	}, true, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every symbol is reported, positions without amount are closed
+	var open []positionRisk
+	for _, p := range resp {
+		if amount, err := strconv.ParseFloat(p.PositionAmt, 64); err == nil && amount != 0 {
+			open = append(open, p)
+		}
+	}
+	return open, nil
+}
+
+func (c *engine) Positions(ctx context.Context, market string) ([]exchanges.Position, error) {
+	resp, err := c.positions(ctx, market)
+	if err != nil {
+		return nil, err
+	}
+	orders, err := c.openOrders(ctx, market)
+	if err != nil {
+		return nil, err
+	}
+
+	positions := make([]exchanges.Position, 0, len(resp))
+	for _, p := range resp {
+		side := exchanges.Buy
+		amount := p.PositionAmt
+		if strings.HasPrefix(amount, "-") {
+			side = exchanges.Sell
+			amount = strings.TrimPrefix(amount, "-")
+		}
+		position := exchanges.Position{
+			Market:        p.Symbol,
+			Side:          side,
+			Amount:        amount,
+			EntryPrice:    p.EntryPrice,
+			Leverage:      p.Leverage,
+			UnrealizedPnl: p.UnRealizedProfit,
+		}
+		// TP/SL are reduce-only orders closing the position
+		for _, o := range orders {
+			if !o.ClosePosition {
+				continue
+			}
+			switch o.Type {
+			case "TAKE_PROFIT_MARKET":
+				position.TakeProfit = o.StopPrice
+			case "STOP_MARKET":
+				position.StopLoss = o.StopPrice
+			}
+		}
+		positions = append(positions, position)
+	}
+	return positions, nil
+}
+
+// SetPositionTPSL replaces the closing TAKE_PROFIT_MARKET / STOP_MARKET orders of the position
+func (c *engine) SetPositionTPSL(ctx context.Context, market, takeProfit, stopLoss string) error {
+	positions, err := c.positions(ctx, market)
+	if err != nil {
+		return err
+	}
+	if len(positions) == 0 {
+		return fmt.Errorf("no open position on %s", market)
+	}
+	s, err := c.symbol(ctx, market)
+	if err != nil {
+		return err
+	}
+	orders, err := c.openOrders(ctx, market)
+	if err != nil {
+		return err
+	}
+
+	closingSide := "SELL"
+	if strings.HasPrefix(positions[0].PositionAmt, "-") {
+		closingSide = "BUY"
+	}
+	replace := func(orderType, price string) error {
+		for _, o := range orders {
+			if o.ClosePosition && o.Type == orderType {
+				if err := c.CancelOrder(ctx, market, strconv.FormatInt(o.OrderID, 10)); err != nil {
+					return err
+				}
+			}
+		}
+		return c.call(ctx, "/fapi/v1/order", http.MethodPost, url.Values{
+			"symbol":           {market},
+			"side":             {closingSide},
+			"type":             {orderType},
+			"stopPrice":        {roundPrice(s, price)},
+			"closePosition":    {"true"},
+			"workingType":      {"MARK_PRICE"},
+			"newClientOrderId": {clientOrderID()},
+		}, true, nil)
+	}
+
+	if takeProfit != "" {
+		if err := replace("TAKE_PROFIT_MARKET", takeProfit); err != nil {
+			return fmt.Errorf("failed to place TP: %v", err)
+		}
+	}
+	if stopLoss != "" {
+		if err := replace("STOP_MARKET", stopLoss); err != nil {
+			return fmt.Errorf("failed to place SL: %v", err)
+		}
+	}
+	return nil
+}
+
+func (c *engine) ClosePosition(ctx context.Context, market, amount string) error {
+	positions, err := c.positions(ctx, market)
+	if err != nil {
+		return err
+	}
+	if len(positions) == 0 {
+		return fmt.Errorf("no open position on %s", market)
+	}
+
+	side := exchanges.Sell
+	if strings.HasPrefix(positions[0].PositionAmt, "-") {
+		side = exchanges.Buy
+	}
+	if amount == "" {
+		amount = strings.TrimPrefix(positions[0].PositionAmt, "-")
+	}
+	_, err = c.PlaceOrder(ctx, exchanges.OrderRequest{
+		Market:     market,
+		Side:       side,
+		Type:       exchanges.MarketOrder,
+		Amount:     amount,
+		ReduceOnly: true,
+	})
+	return err
+}
+
+type apiError struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func (e apiError) Error() string {
+	return fmt.Sprintf("got error on calling: %d %s", e.Code, e.Msg)
+}
+
+func isAPIError(err error, code int) bool {
+	apiErr, ok := err.(apiError)
+	return ok && apiErr.Code == code
+}
+
+// call sends every parameter in the query string, signed ones get the timestamp and signature appended
+func (c *engine) call(ctx context.Context, path, method string, params url.Values, signed bool, out interface{}) error {
+	queryParams := params.Encode()
+	if signed {
+		params.Set("timestamp", strconv.FormatInt(time.Now().UnixMilli(), 10))
+		params.Set("recvWindow", recvWindow)
+		queryParams = params.Encode()
+		queryParams += "&signature=" + generateSignature(c.SecretKey, queryParams)
+	}
+
+	uri := fmt.Sprintf("%s%s", c.baseURL, path)
+	if queryParams != "" {
+		uri += "?" + queryParams
+	}
+	req, err := http.NewRequestWithContext(ctx, method, uri, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("X-MBX-APIKEY", c.ApiKey)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr apiError
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Code != 0 {
+			return apiErr
+		}
+		return fmt.Errorf("unexpected response status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+	return nil
+}
+
+func generateSignature(secret, preparedStr string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(preparedStr))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// crossed reports whether a buy trigger is below the mark price or a sell trigger above it
+func crossed(side exchanges.Side, markPrice, triggerPrice string) bool {
+	mark, err := strconv.ParseFloat(markPrice, 64)
+	if err != nil {
+		return false
+	}
+	trigger, err := strconv.ParseFloat(triggerPrice, 64)
+	if err != nil {
+		return false
+	}
+	if side == exchanges.Buy {
+		return trigger < mark
+	}
+	return trigger > mark
+}
+
+// roundQuantity rounds the quantity down to the LOT_SIZE step of the symbol
+func roundQuantity(s symbolInfo, quantity string) string {
+	return roundToStep(quantity, s.filter("LOT_SIZE").StepSize, math.Floor)
+}
+
+// roundPrice rounds the price to the nearest PRICE_FILTER tick of the symbol
+func roundPrice(s symbolInfo, price string) string {
+	return roundToStep(price, s.filter("PRICE_FILTER").TickSize, math.Round)
+}
+
+func roundToStep(value, step string, round func(float64) float64) string {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return value
+	}
+	st, err := strconv.ParseFloat(step, 64)
+	if err != nil || st <= 0 {
+		return value
+	}
+	return strconv.FormatFloat(round(v/st+1e-9)*st, 'f', precision(step), 64)
+}
+
+// precision returns the number of decimal places of a step like 0.001
+func precision(step string) int {
+	if !strings.Contains(step, ".") {
+		return 0
+	}
+	step = strings.TrimRight(step, "0")
+	return len(step) - strings.IndexByte(step, '.') - 1
+}
+
+func clientOrderID() string {
+	return "teletrade-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+}
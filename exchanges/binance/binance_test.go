@@ -0,0 +1,238 @@
+package binance_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/moneyscripter/teletrade/exchanges"
+	"github.com/moneyscripter/teletrade/exchanges/binance"
+	"github.com/moneyscripter/teletrade/exchanges/binance/binancetest"
+)
+
+func newEngine(t *testing.T) (exchanges.Exchanges, *binancetest.Server) {
+	t.Helper()
+	server := binancetest.NewServer("key", "secret")
+	t.Cleanup(server.Close)
+	server.AddSymbol(binancetest.Symbol{
+		Symbol:      "BTCUSDT",
+		MinQty:      0.001,
+		StepSize:    0.001,
+		TickSize:    0.1,
+		MaxLeverage: 125,
+	})
+	server.SetPrice("BTCUSDT", 100)
+	return binance.NewBinanceEngineWithBaseURL("key", "secret", server.URL), server
+}
+
+func TestRounding(t *testing.T) {
+	ctx := context.Background()
+	engine, server := newEngine(t)
+
+	info, err := engine.MarketInfo(ctx, "BTCUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.MinAmount != "0.001" || info.AmountPrecision != 3 || info.PricePrecision != 1 || info.MaxLeverage != 125 {
+		t.Fatalf("unexpected market info %+v", info)
+	}
+
+	// The fake rejects quantities and prices off the LOT_SIZE and PRICE_FILTER steps
+	o, err := engine.PlaceOrder(ctx, exchanges.OrderRequest{
+		Market: "BTCUSDT",
+		Side:   exchanges.Buy,
+		Type:   exchanges.LimitOrder,
+		Amount: "0.12345",
+		Price:  "95.26",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Quantities are rounded down, prices to the nearest tick
+	if o.Amount != "0.123" || o.Price != "95.3" {
+		t.Fatalf("order is %s at %s, want 0.123 at 95.3", o.Amount, o.Price)
+	}
+
+	stop, err := engine.PlaceStopOrder(ctx, exchanges.StopOrderRequest{
+		Market:       "BTCUSDT",
+		Side:         exchanges.Buy,
+		Type:         exchanges.MarketOrder,
+		Amount:       "0.0019",
+		TriggerPrice: "110.04",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stop.Amount != "0.001" || stop.TriggerPrice != "110" {
+		t.Fatalf("stop order is %s at %s, want 0.001 at 110", stop.Amount, stop.TriggerPrice)
+	}
+	if orders := server.Orders(); len(orders) != 2 {
+		t.Fatalf("%d orders placed, want 2", len(orders))
+	}
+}
+
+func TestStopOrTakeProfitEntry(t *testing.T) {
+	tests := []struct {
+		name    string
+		side    exchanges.Side
+		trigger string
+		want    string
+		fillAt  float64
+	}{
+		{"long breakout", exchanges.Buy, "110", "STOP_MARKET", 111},
+		{"long pullback", exchanges.Buy, "90", "TAKE_PROFIT_MARKET", 89},
+		{"short breakdown", exchanges.Sell, "90", "STOP_MARKET", 89},
+		{"short pullback", exchanges.Sell, "110", "TAKE_PROFIT_MARKET", 111},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, server := newEngine(t)
+			_, err := engine.PlaceStopOrder(context.Background(), exchanges.StopOrderRequest{
+				Market:       "BTCUSDT",
+				Side:         tt.side,
+				Type:         exchanges.MarketOrder,
+				Amount:       "0.01",
+				TriggerPrice: tt.trigger,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			orders := server.Orders()
+			if len(orders) != 1 || orders[0].Type != tt.want {
+				t.Fatalf("placed %+v, want a %s order", orders, tt.want)
+			}
+
+			// The entry fills once the price reaches the trigger
+			server.SetPrice("BTCUSDT", tt.fillAt)
+			if _, ok := server.Position("BTCUSDT"); !ok {
+				t.Fatal("entry is not filled")
+			}
+		})
+	}
+}
+
+func TestSetLeverageToleratesNoChange(t *testing.T) {
+	ctx := context.Background()
+	engine, server := newEngine(t)
+
+	// The account starts in cross margin, asking for it again returns -4046
+	if err := engine.SetLeverage(ctx, "BTCUSDT", 10, exchanges.Cross); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.SetLeverage(ctx, "BTCUSDT", 20, exchanges.Isolated); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.SetLeverage(ctx, "BTCUSDT", 20, exchanges.Isolated); err != nil {
+		t.Fatal(err)
+	}
+	if server.MarginType("BTCUSDT") != "ISOLATED" || server.Leverage("BTCUSDT") != 20 {
+		t.Fatalf("margin type %s and leverage %d, want ISOLATED and 20", server.MarginType("BTCUSDT"), server.Leverage("BTCUSDT"))
+	}
+
+	// Other errors are not tolerated
+	if err := engine.SetLeverage(ctx, "BTCUSDT", 200, exchanges.Isolated); err == nil {
+		t.Fatal("leverage above the maximum is accepted")
+	}
+}
+
+func TestReduceOnlyTPSL(t *testing.T) {
+	ctx := context.Background()
+	engine, server := newEngine(t)
+
+	_, err := engine.PlaceOrder(ctx, exchanges.OrderRequest{
+		Market: "BTCUSDT",
+		Side:   exchanges.Buy,
+		Type:   exchanges.MarketOrder,
+		Amount: "0.1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.SetPositionTPSL(ctx, "BTCUSDT", "120", "95"); err != nil {
+		t.Fatal(err)
+	}
+	// Replacing the stop loss cancels the previous one
+	if err := engine.SetPositionTPSL(ctx, "BTCUSDT", "", "99"); err != nil {
+		t.Fatal(err)
+	}
+
+	stops, err := engine.OpenStopOrders(ctx, "BTCUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stops) != 2 {
+		t.Fatalf("%d TP/SL orders are open, want 2", len(stops))
+	}
+	for _, o := range server.Orders() {
+		if o.Type == "MARKET" || o.Status != "NEW" {
+			continue
+		}
+		if !o.ClosePosition || o.Side != "SELL" {
+			t.Fatalf("TP/SL order %+v doesn't close the long position", o)
+		}
+	}
+	positions, err := engine.Positions(ctx, "BTCUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(positions) != 1 || positions[0].TakeProfit != "120" || positions[0].StopLoss != "99" {
+		t.Fatalf("unexpected positions %+v", positions)
+	}
+
+	// A reduce-only order never opens a position
+	_, err = engine.PlaceStopOrder(ctx, exchanges.StopOrderRequest{
+		Market:       "BTCUSDT",
+		Side:         exchanges.Sell,
+		Type:         exchanges.MarketOrder,
+		Amount:       "0.5",
+		TriggerPrice: "110",
+		ReduceOnly:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetPrice("BTCUSDT", 115)
+	if _, ok := server.Position("BTCUSDT"); ok {
+		t.Fatal("position is still open")
+	}
+
+	// The TP/SL close the position and expire with it
+	stops, err = engine.OpenStopOrders(ctx, "BTCUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stops) != 0 {
+		t.Fatalf("%d TP/SL orders are left open", len(stops))
+	}
+}
+
+func TestClosePosition(t *testing.T) {
+	ctx := context.Background()
+	engine, server := newEngine(t)
+
+	_, err := engine.PlaceOrder(ctx, exchanges.OrderRequest{
+		Market: "BTCUSDT",
+		Side:   exchanges.Sell,
+		Type:   exchanges.MarketOrder,
+		Amount: "0.1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.ClosePosition(ctx, "BTCUSDT", "0.04"); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := server.Position("BTCUSDT"); p.Amount > -0.0599 || p.Amount < -0.0601 {
+		t.Fatalf("position is %v after a partial close, want -0.06", p.Amount)
+	}
+	if err := engine.ClosePosition(ctx, "BTCUSDT", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Position("BTCUSDT"); ok {
+		t.Fatal("position is still open")
+	}
+	for _, o := range server.Orders()[1:] {
+		if !o.ReduceOnly {
+			t.Fatalf("closing order %+v is not reduce-only", o)
+		}
+	}
+}
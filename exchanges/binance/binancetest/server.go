@@ -0,0 +1,649 @@
+// Package binancetest provides an in-memory fake of the Binance USDT-M futures REST API,
+// to run the Binance engine offline. The account is in one-way mode, conditional and
+// market orders are filled against the prices set with SetPrice.
+package binancetest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Symbol struct {
+	Symbol      string // e.g. BTCUSDT
+	MinQty      float64
+	StepSize    float64
+	TickSize    float64
+	MaxLeverage int
+}
+
+type Order struct {
+	OrderID       int64
+	Symbol        string
+	Side          string // BUY or SELL
+	Type          string // LIMIT, MARKET, STOP, STOP_MARKET, TAKE_PROFIT, TAKE_PROFIT_MARKET
+	Quantity      float64
+	Price         float64
+	StopPrice     float64
+	ReduceOnly    bool
+	ClosePosition bool
+	ClientID      string
+	Status        string
+}
+
+// triggered reports whether price reaches the stop price of a conditional order
+func (o *Order) triggered(price float64) bool {
+	breakout := strings.HasPrefix(o.Type, "STOP")
+	if (o.Side == "BUY") == breakout {
+		return price >= o.StopPrice
+	}
+	return price <= o.StopPrice
+}
+
+func (o *Order) conditional() bool {
+	return o.Type != "LIMIT" && o.Type != "MARKET"
+}
+
+type Position struct {
+	Symbol     string
+	Amount     float64 // negative for short positions
+	EntryPrice float64
+	Leverage   int
+}
+
+// Server is a fake Binance futures exchange, requests must be signed with APIKey and SecretKey
+type Server struct {
+	*httptest.Server
+
+	APIKey    string
+	SecretKey string
+
+	mutex      *sync.Mutex
+	symbols    map[string]Symbol
+	prices     map[string]float64
+	balance    float64
+	leverage   map[string]int
+	marginType map[string]string
+	orders     map[int64]*Order
+	positions  map[string]*Position
+	nextID     int64
+}
+
+// NewServer starts a fake exchange with 1000 USDT of available balance
+func NewServer(apiKey, secretKey string) *Server {
+	s := &Server{
+		APIKey:     apiKey,
+		SecretKey:  secretKey,
+		mutex:      &sync.Mutex{},
+		symbols:    make(map[string]Symbol),
+		prices:     make(map[string]float64),
+		balance:    1000,
+		leverage:   make(map[string]int),
+		marginType: make(map[string]string),
+		orders:     make(map[int64]*Order),
+		positions:  make(map[string]*Position),
+		nextID:     1000,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fapi/v1/premiumIndex", s.public(s.premiumIndex))
+	mux.HandleFunc("/fapi/v1/ticker/price", s.public(s.tickerPrice))
+	mux.HandleFunc("/fapi/v1/exchangeInfo", s.public(s.exchangeInfo))
+	mux.HandleFunc("/fapi/v1/leverageBracket", s.signed(s.leverageBracket))
+	mux.HandleFunc("/fapi/v2/balance", s.signed(s.balances))
+	mux.HandleFunc("/fapi/v1/marginType", s.signed(s.setMarginType))
+	mux.HandleFunc("/fapi/v1/leverage", s.signed(s.setLeverage))
+	mux.HandleFunc("/fapi/v1/order", s.signed(s.order))
+	mux.HandleFunc("/fapi/v1/allOpenOrders", s.signed(s.cancelAll))
+	mux.HandleFunc("/fapi/v1/openOrders", s.signed(s.openOrders))
+	mux.HandleFunc("/fapi/v2/positionRisk", s.signed(s.positionRisk))
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *Server) AddSymbol(symbol Symbol) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.symbols[symbol.Symbol] = symbol
+}
+
+func (s *Server) SetBalance(available float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.balance = available
+}
+
+// SetPrice moves the mark and last price of symbol, filling every conditional order it crosses
+func (s *Server) SetPrice(symbol string, price float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.prices[symbol] = price
+	for _, o := range s.sortedOrders() {
+		if o.Symbol != symbol || !o.conditional() || o.Status != "NEW" || !o.triggered(price) {
+			continue
+		}
+		o.Status = "FILLED"
+		quantity := o.Quantity
+		if o.ClosePosition {
+			p, ok := s.positions[symbol]
+			if !ok {
+				o.Status = "EXPIRED"
+				continue
+			}
+			quantity = math.Abs(p.Amount)
+		}
+		s.fill(o, quantity, price)
+	}
+}
+
+func (s *Server) Balance() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.balance
+}
+
+// Position returns a copy of the open position of symbol
+func (s *Server) Position(symbol string) (Position, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, ok := s.positions[symbol]
+	if !ok {
+		return Position{}, false
+	}
+	return *p, true
+}
+
+// Orders returns a copy of every order ever placed
+func (s *Server) Orders() []Order {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var orders []Order
+	for _, o := range s.sortedOrders() {
+		orders = append(orders, *o)
+	}
+	return orders
+}
+
+func (s *Server) Leverage(symbol string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.leverage[symbol]
+}
+
+func (s *Server) MarginType(symbol string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.marginType[symbol]
+}
+
+func (s *Server) sortedOrders() []*Order {
+	orders := make([]*Order, 0, len(s.orders))
+	for id := int64(1000); id <= s.nextID; id++ {
+		if o, ok := s.orders[id]; ok {
+			orders = append(orders, o)
+		}
+	}
+	return orders
+}
+
+// fill applies an executed order to the position of its symbol and the balance
+func (s *Server) fill(o *Order, quantity, price float64) {
+	leverage := s.leverage[o.Symbol]
+	if leverage == 0 {
+		leverage = 20
+	}
+	signed := quantity
+	if o.Side == "SELL" {
+		signed = -quantity
+	}
+
+	p, ok := s.positions[o.Symbol]
+	if !ok || (p.Amount > 0) == (signed > 0) {
+		if o.ReduceOnly || o.ClosePosition {
+			return
+		}
+		s.balance -= quantity * price / float64(leverage)
+		if !ok {
+			s.positions[o.Symbol] = &Position{
+				Symbol:     o.Symbol,
+				Amount:     signed,
+				EntryPrice: price,
+				Leverage:   leverage,
+			}
+			return
+		}
+		p.EntryPrice = (p.EntryPrice*math.Abs(p.Amount) + price*quantity) / (math.Abs(p.Amount) + quantity)
+		p.Amount += signed
+		return
+	}
+
+	// Reducing, orders are never allowed to flip the position in this fake
+	quantity = math.Min(quantity, math.Abs(p.Amount))
+	pnl := (price - p.EntryPrice) * quantity
+	if p.Amount < 0 {
+		pnl = -pnl
+	}
+	s.balance += quantity*p.EntryPrice/float64(p.Leverage) + pnl
+	if p.Amount > 0 {
+		p.Amount -= quantity
+	} else {
+		p.Amount += quantity
+	}
+	// Amounts are floats, a position reduced to a rounding error is closed
+	if math.Abs(p.Amount) < 1e-9 {
+		delete(s.positions, o.Symbol)
+		// closePosition orders are expired along with the position
+		for _, other := range s.orders {
+			if other.Symbol == o.Symbol && other.ClosePosition && other.Status == "NEW" {
+				other.Status = "EXPIRED"
+			}
+		}
+	}
+}
+
+type handler func(w http.ResponseWriter, r *http.Request)
+
+func (s *Server) public(h handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		h(w, r)
+	}
+}
+
+// signed checks the api key, the signature of the query string and the recvWindow before calling h
+func (s *Server) signed(h handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-MBX-APIKEY") != s.APIKey {
+			writeError(w, -2015, "Invalid API-key, IP, or permissions for action.")
+			return
+		}
+		query, signature, ok := strings.Cut(r.URL.RawQuery, "&signature=")
+		mac := hmac.New(sha256.New, []byte(s.SecretKey))
+		mac.Write([]byte(query))
+		if !ok || !hmac.Equal([]byte(signature), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+			writeError(w, -1022, "Signature for this request is not valid.")
+			return
+		}
+		if r.URL.Query().Get("timestamp") == "" {
+			writeError(w, -1102, "Mandatory parameter 'timestamp' was not sent, was empty/null, or malformed.")
+			return
+		}
+		if window, err := strconv.Atoi(r.URL.Query().Get("recvWindow")); err != nil || window > 60000 {
+			writeError(w, -1131, "recvWindow must be less than 60000")
+			return
+		}
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		h(w, r)
+	}
+}
+
+func (s *Server) premiumIndex(w http.ResponseWriter, r *http.Request) {
+	symbol := r.URL.Query().Get("symbol")
+	price, ok := s.prices[symbol]
+	if !ok {
+		writeError(w, -1121, "Invalid symbol.")
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"symbol":          symbol,
+		"markPrice":       formatFloat(price),
+		"indexPrice":      formatFloat(price),
+		"lastFundingRate": "0.0001",
+		"time":            0,
+	})
+}
+
+func (s *Server) tickerPrice(w http.ResponseWriter, r *http.Request) {
+	symbol := r.URL.Query().Get("symbol")
+	price, ok := s.prices[symbol]
+	if !ok {
+		writeError(w, -1121, "Invalid symbol.")
+		return
+	}
+	writeJSON(w, map[string]interface{}{"symbol": symbol, "price": formatFloat(price), "time": 0})
+}
+
+func (s *Server) exchangeInfo(w http.ResponseWriter, r *http.Request) {
+	symbols := []map[string]interface{}{}
+	for _, symbol := range s.symbols {
+		symbols = append(symbols, map[string]interface{}{
+			"symbol":            symbol.Symbol,
+			"status":            "TRADING",
+			"contractType":      "PERPETUAL",
+			"quoteAsset":        "USDT",
+			"marginAsset":       "USDT",
+			"pricePrecision":    precision(symbol.TickSize),
+			"quantityPrecision": precision(symbol.StepSize),
+			"filters": []map[string]string{
+				{"filterType": "PRICE_FILTER", "tickSize": formatFloat(symbol.TickSize)},
+				{"filterType": "LOT_SIZE", "minQty": formatFloat(symbol.MinQty), "stepSize": formatFloat(symbol.StepSize)},
+				{"filterType": "MIN_NOTIONAL", "notional": "5"},
+			},
+		})
+	}
+	writeJSON(w, map[string]interface{}{"timezone": "UTC", "symbols": symbols})
+}
+
+func (s *Server) leverageBracket(w http.ResponseWriter, r *http.Request) {
+	symbol, ok := s.symbols[r.URL.Query().Get("symbol")]
+	if !ok {
+		writeError(w, -1121, "Invalid symbol.")
+		return
+	}
+	writeJSON(w, []map[string]interface{}{{
+		"symbol": symbol.Symbol,
+		"brackets": []map[string]interface{}{
+			{"bracket": 1, "initialLeverage": maxLeverage(symbol), "notionalCap": 50000, "notionalFloor": 0, "maintMarginRatio": 0.004},
+		},
+	}})
+}
+
+func (s *Server) balances(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, []map[string]string{{
+		"asset":              "USDT",
+		"balance":            formatFloat(s.balance),
+		"crossWalletBalance": formatFloat(s.balance),
+		"crossUnPnl":         "0",
+		"availableBalance":   formatFloat(s.balance),
+		"maxWithdrawAmount":  formatFloat(s.balance),
+	}})
+}
+
+func (s *Server) setMarginType(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	symbol := q.Get("symbol")
+	if _, ok := s.symbols[symbol]; !ok {
+		writeError(w, -1121, "Invalid symbol.")
+		return
+	}
+	marginType := q.Get("marginType")
+	if marginType != "ISOLATED" && marginType != "CROSSED" {
+		writeError(w, -1116, "Invalid marginType.")
+		return
+	}
+	current := s.marginType[symbol]
+	if current == "" {
+		current = "CROSSED"
+	}
+	if current == marginType {
+		writeError(w, -4046, "No need to change margin type.")
+		return
+	}
+	if _, ok := s.positions[symbol]; ok {
+		writeError(w, -4048, "Margin type cannot be changed if there exists position.")
+		return
+	}
+	s.marginType[symbol] = marginType
+	writeJSON(w, map[string]interface{}{"code": 200, "msg": "success"})
+}
+
+func (s *Server) setLeverage(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	symbol, ok := s.symbols[q.Get("symbol")]
+	if !ok {
+		writeError(w, -1121, "Invalid symbol.")
+		return
+	}
+	leverage, err := strconv.Atoi(q.Get("leverage"))
+	if err != nil || leverage < 1 || leverage > maxLeverage(symbol) {
+		writeError(w, -4028, "Leverage is not valid")
+		return
+	}
+	s.leverage[symbol.Symbol] = leverage
+	writeJSON(w, map[string]interface{}{"leverage": leverage, "maxNotionalValue": "50000", "symbol": symbol.Symbol})
+}
+
+func (s *Server) order(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.newOrder(w, r)
+	case http.MethodPut:
+		s.modifyOrder(w, r)
+	case http.MethodDelete:
+		o, ok := s.findOrder(r)
+		if !ok {
+			writeError(w, -2011, "Unknown order sent.")
+			return
+		}
+		o.Status = "CANCELED"
+		writeJSON(w, orderJSON(o))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) findOrder(r *http.Request) (*Order, bool) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("orderId"), 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	o, ok := s.orders[id]
+	if !ok || o.Status != "NEW" || o.Symbol != r.URL.Query().Get("symbol") {
+		return nil, false
+	}
+	return o, true
+}
+
+func (s *Server) newOrder(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	symbol, ok := s.symbols[q.Get("symbol")]
+	if !ok {
+		writeError(w, -1121, "Invalid symbol.")
+		return
+	}
+	side := q.Get("side")
+	if side != "BUY" && side != "SELL" {
+		writeError(w, -1117, "Invalid side.")
+		return
+	}
+
+	s.nextID++
+	o := &Order{
+		OrderID:       s.nextID,
+		Symbol:        symbol.Symbol,
+		Side:          side,
+		Type:          q.Get("type"),
+		ReduceOnly:    q.Get("reduceOnly") == "true",
+		ClosePosition: q.Get("closePosition") == "true",
+		ClientID:      q.Get("newClientOrderId"),
+		Status:        "NEW",
+	}
+	if !o.ClosePosition {
+		quantity, err := strconv.ParseFloat(q.Get("quantity"), 64)
+		if err != nil || quantity < symbol.MinQty || !onStep(quantity, symbol.StepSize) {
+			writeError(w, -1111, "Precision is over the maximum defined for this asset.")
+			return
+		}
+		o.Quantity = quantity
+	}
+	if v := q.Get("price"); v != "" {
+		o.Price, _ = strconv.ParseFloat(v, 64)
+		if !onStep(o.Price, symbol.TickSize) {
+			writeError(w, -1111, "Precision is over the maximum defined for this asset.")
+			return
+		}
+	}
+
+	price := s.prices[symbol.Symbol]
+	switch o.Type {
+	case "MARKET":
+		o.Status = "FILLED"
+		s.fill(o, o.Quantity, price)
+	case "LIMIT":
+		if q.Get("timeInForce") == "" {
+			writeError(w, -1102, "Mandatory parameter 'timeInForce' was not sent, was empty/null, or malformed.")
+			return
+		}
+	case "STOP", "STOP_MARKET", "TAKE_PROFIT", "TAKE_PROFIT_MARKET":
+		var err error
+		o.StopPrice, err = strconv.ParseFloat(q.Get("stopPrice"), 64)
+		if err != nil || !onStep(o.StopPrice, symbol.TickSize) {
+			writeError(w, -1102, "Mandatory parameter 'stopPrice' was not sent, was empty/null, or malformed.")
+			return
+		}
+		if o.triggered(price) {
+			writeError(w, -2021, "Order would immediately trigger.")
+			return
+		}
+	default:
+		writeError(w, -1116, "Invalid orderType.")
+		return
+	}
+	s.orders[o.OrderID] = o
+	writeJSON(w, orderJSON(o))
+}
+
+// modifyOrder amends the quantity and price of a LIMIT order, the only kind Binance modifies
+func (s *Server) modifyOrder(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	o, ok := s.findOrder(r)
+	if !ok {
+		writeError(w, -2013, "Order does not exist.")
+		return
+	}
+	if o.Type != "LIMIT" || o.Side != q.Get("side") {
+		writeError(w, -4237, "Only limit order supports modification.")
+		return
+	}
+	quantity, err := strconv.ParseFloat(q.Get("quantity"), 64)
+	if err != nil {
+		writeError(w, -1102, "Mandatory parameter 'quantity' was not sent, was empty/null, or malformed.")
+		return
+	}
+	price, err := strconv.ParseFloat(q.Get("price"), 64)
+	if err != nil {
+		writeError(w, -1102, "Mandatory parameter 'price' was not sent, was empty/null, or malformed.")
+		return
+	}
+	o.Quantity = quantity
+	o.Price = price
+	writeJSON(w, orderJSON(o))
+}
+
+func (s *Server) cancelAll(w http.ResponseWriter, r *http.Request) {
+	symbol := r.URL.Query().Get("symbol")
+	for _, o := range s.orders {
+		if o.Symbol == symbol && o.Status == "NEW" {
+			o.Status = "CANCELED"
+		}
+	}
+	writeJSON(w, map[string]interface{}{"code": 200, "msg": "The operation of cancel all open order is done."})
+}
+
+func (s *Server) openOrders(w http.ResponseWriter, r *http.Request) {
+	orders := []map[string]interface{}{}
+	for _, o := range s.sortedOrders() {
+		if o.Symbol == r.URL.Query().Get("symbol") && o.Status == "NEW" {
+			orders = append(orders, orderJSON(o))
+		}
+	}
+	writeJSON(w, orders)
+}
+
+// positionRisk reports every symbol like Binance does, closed ones with a zero amount
+func (s *Server) positionRisk(w http.ResponseWriter, r *http.Request) {
+	positions := []map[string]string{}
+	for symbol := range s.symbols {
+		if q := r.URL.Query().Get("symbol"); q != "" && q != symbol {
+			continue
+		}
+		p, ok := s.positions[symbol]
+		if !ok {
+			p = &Position{Symbol: symbol, Leverage: s.leverage[symbol]}
+		}
+		marginType := strings.ToLower(s.marginType[symbol])
+		if marginType == "" || marginType == "crossed" {
+			marginType = "cross"
+		}
+		positions = append(positions, map[string]string{
+			"symbol":           p.Symbol,
+			"positionAmt":      formatFloat(p.Amount),
+			"entryPrice":       formatFloat(p.EntryPrice),
+			"markPrice":        formatFloat(s.prices[symbol]),
+			"unRealizedProfit": formatFloat((s.prices[symbol] - p.EntryPrice) * p.Amount),
+			"leverage":         strconv.Itoa(p.Leverage),
+			"marginType":       marginType,
+			"positionSide":     "BOTH",
+		})
+	}
+	writeJSON(w, positions)
+}
+
+func orderJSON(o *Order) map[string]interface{} {
+	return map[string]interface{}{
+		"orderId":       o.OrderID,
+		"symbol":        o.Symbol,
+		"status":        o.Status,
+		"clientOrderId": o.ClientID,
+		"price":         formatFloat(o.Price),
+		"origQty":       formatFloat(o.Quantity),
+		"executedQty":   "0",
+		"type":          o.Type,
+		"side":          o.Side,
+		"stopPrice":     formatFloat(o.StopPrice),
+		"reduceOnly":    o.ReduceOnly,
+		"closePosition": o.ClosePosition,
+		"workingType":   "MARK_PRICE",
+		"positionSide":  "BOTH",
+	}
+}
+
+func maxLeverage(symbol Symbol) int {
+	if symbol.MaxLeverage == 0 {
+		return 20
+	}
+	return symbol.MaxLeverage
+}
+
+// onStep reports whether v is a multiple of step, like the LOT_SIZE and PRICE_FILTER filters require
+func onStep(v, step float64) bool {
+	if step <= 0 {
+		return true
+	}
+	n := v / step
+	return math.Abs(n-math.Round(n)) < 1e-6
+}
+
+func precision(step float64) int {
+	s := formatFloat(step)
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		return len(s) - i - 1
+	}
+	return 0
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_, _ = fmt.Fprintf(w, `{"code":%d,"msg":%q}`, code, msg)
+}
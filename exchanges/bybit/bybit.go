@@ -0,0 +1,714 @@
+package bybit
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/moneyscripter/teletrade/exchanges"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	bybitBaseURL = "https://api.bybit.com"
+	recvWindow   = "5000"
+	category     = "linear"
+
+	// errLeverageNotModified is returned when the leverage is already the requested one
+	errLeverageNotModified = 110043
+)
+
+type engine struct {
+	ApiKey    string
+	SecretKey string
+	baseURL   string
+
+	mutex       *sync.Mutex
+	instruments map[string]instrument // symbol -> lot size and price filters, cached as they barely change
+}
+
+func NewBybitEngine(apiKey, secretKey string) exchanges.Exchanges {
+	return NewBybitEngineWithBaseURL(apiKey, secretKey, bybitBaseURL)
+}
+
+// NewBybitEngineWithBaseURL points the engine to another server, e.g. bybittest.Server
+func NewBybitEngineWithBaseURL(apiKey, secretKey, baseURL string) exchanges.Exchanges {
+	return &engine{
+		ApiKey:      apiKey,
+		SecretKey:   secretKey,
+		baseURL:     baseURL,
+		mutex:       &sync.Mutex{},
+		instruments: make(map[string]instrument),
+	}
+}
+
+func (c *engine) Name() string {
+	return "Bybit"
+}
+
+type ticker struct {
+	Symbol    string `json:"symbol"`
+	LastPrice string `json:"lastPrice"`
+	MarkPrice string `json:"markPrice"`
+}
+
+func (c *engine) Ticker(ctx context.Context, market string) (exchanges.Ticker, error) {
+	var resp struct {
+		List []ticker `json:"list"`
+	}
+	err := c.call(ctx, "/v5/market/tickers", http.MethodGet, map[string]string{
+		"category": category,
+		"symbol":   market,
+	}, false, &resp)
+	if err != nil {
+		return exchanges.Ticker{}, err
+	}
+	if len(resp.List) == 0 {
+		return exchanges.Ticker{}, fmt.Errorf("unknown market %s", market)
+	}
+	return exchanges.Ticker{
+		Market:    market,
+		LastPrice: resp.List[0].LastPrice,
+		MarkPrice: resp.List[0].MarkPrice,
+	}, nil
+}
+
+type instrument struct {
+	Symbol         string `json:"symbol"`
+	Status         string `json:"status"`
+	LeverageFilter struct {
+		MinLeverage  string `json:"minLeverage"`
+		MaxLeverage  string `json:"maxLeverage"`
+		LeverageStep string `json:"leverageStep"`
+	} `json:"leverageFilter"`
+	PriceFilter struct {
+		TickSize string `json:"tickSize"`
+	} `json:"priceFilter"`
+	LotSizeFilter struct {
+		MinOrderQty string `json:"minOrderQty"`
+		MaxOrderQty string `json:"maxOrderQty"`
+		QtyStep     string `json:"qtyStep"`
+	} `json:"lotSizeFilter"`
+}
+
+func (c *engine) instrument(ctx context.Context, market string) (instrument, error) {
+	c.mutex.Lock()
+	info, ok := c.instruments[market]
+	c.mutex.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	var resp struct {
+		List []instrument `json:"list"`
+	}
+	err := c.call(ctx, "/v5/market/instruments-info", http.MethodGet, map[string]string{
+		"category": category,
+		"symbol":   market,
+	}, false, &resp)
+	if err != nil {
+		return instrument{}, err
+	}
+	if len(resp.List) == 0 {
+		return instrument{}, fmt.Errorf("unknown market %s", market)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.instruments[market] = resp.List[0]
+	return resp.List[0], nil
+}
+
+func (c *engine) MarketInfo(ctx context.Context, market string) (exchanges.MarketInfo, error) {
+	i, err := c.instrument(ctx, market)
+	if err != nil {
+		return exchanges.MarketInfo{}, err
+	}
+	maxLeverage, err := strconv.ParseFloat(i.LeverageFilter.MaxLeverage, 64)
+	if err != nil {
+		return exchanges.MarketInfo{}, fmt.Errorf("invalid max leverage %q: %v", i.LeverageFilter.MaxLeverage, err)
+	}
+	return exchanges.MarketInfo{
+		Market:          market,
+		MinAmount:       i.LotSizeFilter.MinOrderQty,
+		AmountPrecision: precision(i.LotSizeFilter.QtyStep),
+		PricePrecision:  precision(i.PriceFilter.TickSize),
+		MaxLeverage:     int(maxLeverage),
+	}, nil
+}
+
+type walletBalance struct {
+	AccountType string `json:"accountType"`
+	Coin        []struct {
+		Coin            string `json:"coin"`
+		WalletBalance   string `json:"walletBalance"`
+		Locked          string `json:"locked"`
+		TotalOrderIM    string `json:"totalOrderIM"`
+		TotalPositionIM string `json:"totalPositionIM"`
+	} `json:"coin"`
+}
+
+func (c *engine) Balance(ctx context.Context, asset string) (exchanges.Balance, error) {
+	var resp struct {
+		List []walletBalance `json:"list"`
+	}
+	err := c.call(ctx, "/v5/account/wallet-balance", http.MethodGet, map[string]string{
+		"accountType": "UNIFIED",
+		"coin":        asset,
+	}, true, &resp)
+	if err != nil {
+		return exchanges.Balance{}, err
+	}
+	for _, account := range resp.List {
+		for _, coin := range account.Coin {
+			if coin.Coin != asset {
+				continue
+			}
+			// Empty fields are zero, the unified account leaves some of them blank
+			parse := func(v string) float64 {
+				f, _ := strconv.ParseFloat(v, 64)
+				return f
+			}
+			frozen := parse(coin.Locked) + parse(coin.TotalOrderIM) + parse(coin.TotalPositionIM)
+			available := math.Max(parse(coin.WalletBalance)-frozen, 0)
+			return exchanges.Balance{
+				Asset:     asset,
+				Available: strconv.FormatFloat(available, 'f', -1, 64),
+				Frozen:    strconv.FormatFloat(frozen, 'f', -1, 64),
+			}, nil
+		}
+	}
+	return exchanges.Balance{Asset: asset, Available: "0", Frozen: "0"}, nil
+}
+
+type accountInfo struct {
+	MarginMode string `json:"marginMode"` // REGULAR_MARGIN, ISOLATED_MARGIN or PORTFOLIO_MARGIN
+}
+
+// SetLeverage sets the leverage of market. The margin mode is account wide on Bybit, switching
+// it changes the margin of every open position, so it is only switched when the account is not
+// in the requested mode yet. Bybit refuses the switch while positions are open, the trade fails then.
+func (c *engine) SetLeverage(ctx context.Context, market string, leverage int, mode exchanges.MarginMode) error {
+	var info accountInfo
+	if err := c.call(ctx, "/v5/account/info", http.MethodGet, map[string]string{}, true, &info); err != nil {
+		return fmt.Errorf("failed to get margin mode: %v", err)
+	}
+	marginMode := "REGULAR_MARGIN"
+	if mode == exchanges.Isolated {
+		marginMode = "ISOLATED_MARGIN"
+	}
+	// Regular and portfolio margin are both cross margin
+	if (mode == exchanges.Isolated) != (info.MarginMode == "ISOLATED_MARGIN") {
+		err := c.call(ctx, "/v5/account/set-margin-mode", http.MethodPost, map[string]string{
+			"setMarginMode": marginMode,
+		}, true, nil)
+		if err != nil {
+			return fmt.Errorf("failed to switch the account from %s to %s: %v", info.MarginMode, marginMode, err)
+		}
+	}
+
+	err := c.call(ctx, "/v5/position/set-leverage", http.MethodPost, map[string]string{
+		"category":     category,
+		"symbol":       market,
+		"buyLeverage":  strconv.Itoa(leverage),
+		"sellLeverage": strconv.Itoa(leverage),
+	}, true, nil)
+	if err != nil && !isAPIError(err, errLeverageNotModified) {
+		return err
+	}
+	return nil
+}
+
+type createdOrder struct {
+	OrderID     string `json:"orderId"`
+	OrderLinkID string `json:"orderLinkId"`
+}
+
+func (c *engine) PlaceOrder(ctx context.Context, r exchanges.OrderRequest) (exchanges.Order, error) {
+	i, err := c.instrument(ctx, r.Market)
+	if err != nil {
+		return exchanges.Order{}, err
+	}
+	params := map[string]string{
+		"category":    category,
+		"symbol":      r.Market,
+		"side":        side(r.Side),
+		"qty":         roundQuantity(i, r.Amount),
+		"orderLinkId": clientOrderID(),
+	}
+	if r.Type == exchanges.MarketOrder {
+		params["orderType"] = "Market"
+	} else {
+		params["orderType"] = "Limit"
+		params["price"] = roundPrice(i, r.Price)
+		params["timeInForce"] = "GTC"
+	}
+	if r.ReduceOnly {
+		params["reduceOnly"] = "true"
+	}
+
+	var resp createdOrder
+	if err := c.call(ctx, "/v5/order/create", http.MethodPost, params, true, &resp); err != nil {
+		return exchanges.Order{}, err
+	}
+	return exchanges.Order{
+		ID:     resp.OrderID,
+		Market: r.Market,
+		Side:   r.Side,
+		Type:   r.Type,
+		Amount: params["qty"],
+		Price:  params["price"],
+	}, nil
+}
+
+// PlaceStopOrder places a conditional order, triggered when the mark price moves from its current side to TriggerPrice
+func (c *engine) PlaceStopOrder(ctx context.Context, r exchanges.StopOrderRequest) (exchanges.Order, error) {
+	i, err := c.instrument(ctx, r.Market)
+	if err != nil {
+		return exchanges.Order{}, err
+	}
+	ticker, err := c.Ticker(ctx, r.Market)
+	if err != nil {
+		return exchanges.Order{}, err
+	}
+	triggerPrice := roundPrice(i, r.TriggerPrice)
+	params := map[string]string{
+		"category":         category,
+		"symbol":           r.Market,
+		"side":             side(r.Side),
+		"qty":              roundQuantity(i, r.Amount),
+		"triggerPrice":     triggerPrice,
+		"triggerDirection": triggerDirection(ticker.MarkPrice, triggerPrice),
+		"triggerBy":        "MarkPrice",
+		"orderLinkId":      clientOrderID(),
+	}
+	if r.Type == exchanges.MarketOrder {
+		params["orderType"] = "Market"
+	} else {
+		params["orderType"] = "Limit"
+		params["price"] = roundPrice(i, r.Price)
+		params["timeInForce"] = "GTC"
+	}
+	if r.ReduceOnly {
+		params["reduceOnly"] = "true"
+	}
+
+	var resp createdOrder
+	if err := c.call(ctx, "/v5/order/create", http.MethodPost, params, true, &resp); err != nil {
+		return exchanges.Order{}, err
+	}
+	return exchanges.Order{
+		ID:           resp.OrderID,
+		Market:       r.Market,
+		Side:         r.Side,
+		Type:         r.Type,
+		Amount:       params["qty"],
+		Price:        params["price"],
+		TriggerPrice: triggerPrice,
+	}, nil
+}
+
+func (c *engine) AmendOrder(ctx context.Context, market, orderID, amount, price string) (exchanges.Order, error) {
+	return c.amend(ctx, market, orderID, amount, price, "")
+}
+
+func (c *engine) AmendStopOrder(ctx context.Context, market, orderID, amount, triggerPrice string) (exchanges.Order, error) {
+	return c.amend(ctx, market, orderID, amount, "", triggerPrice)
+}
+
+func (c *engine) amend(ctx context.Context, market, orderID, amount, price, triggerPrice string) (exchanges.Order, error) {
+	i, err := c.instrument(ctx, market)
+	if err != nil {
+		return exchanges.Order{}, err
+	}
+	params := map[string]string{
+		"category": category,
+		"symbol":   market,
+		"orderId":  orderID,
+	}
+	if amount != "" {
+		params["qty"] = roundQuantity(i, amount)
+	}
+	if price != "" {
+		params["price"] = roundPrice(i, price)
+	}
+	if triggerPrice != "" {
+		params["triggerPrice"] = roundPrice(i, triggerPrice)
+	}
+	if err := c.call(ctx, "/v5/order/amend", http.MethodPost, params, true, nil); err != nil {
+		return exchanges.Order{}, err
+	}
+
+	orders, err := c.openOrders(ctx, market, "")
+	if err != nil {
+		return exchanges.Order{}, err
+	}
+	for _, o := range orders {
+		if o.OrderID == orderID {
+			return o.toOrder(), nil
+		}
+	}
+	return exchanges.Order{}, fmt.Errorf("order %s not found", orderID)
+}
+
+func (c *engine) CancelOrder(ctx context.Context, market, orderID string) error {
+	return c.call(ctx, "/v5/order/cancel", http.MethodPost, map[string]string{
+		"category": category,
+		"symbol":   market,
+		"orderId":  orderID,
+	}, true, nil)
+}
+
+// CancelStopOrder is the same as CancelOrder, conditional orders share the order endpoints on Bybit
+func (c *engine) CancelStopOrder(ctx context.Context, market, orderID string) error {
+	return c.CancelOrder(ctx, market, orderID)
+}
+
+func (c *engine) CancelAllOrders(ctx context.Context, market string) error {
+	return c.call(ctx, "/v5/order/cancel-all", http.MethodPost, map[string]string{
+		"category": category,
+		"symbol":   market,
+	}, true, nil)
+}
+
+type order struct {
+	OrderID      string `json:"orderId"`
+	Symbol       string `json:"symbol"`
+	Side         string `json:"side"`      // Buy or Sell
+	OrderType    string `json:"orderType"` // Market or Limit
+	Price        string `json:"price"`
+	Qty          string `json:"qty"`
+	TriggerPrice string `json:"triggerPrice"`
+	OrderStatus  string `json:"orderStatus"`
+	ReduceOnly   bool   `json:"reduceOnly"`
+}
+
+func (o order) toOrder() exchanges.Order {
+	orderType := exchanges.LimitOrder
+	if o.OrderType == "Market" {
+		orderType = exchanges.MarketOrder
+	}
+	return exchanges.Order{
+		ID:           o.OrderID,
+		Market:       o.Symbol,
+		Side:         exchanges.Side(strings.ToLower(o.Side)),
+		Type:         orderType,
+		Amount:       o.Qty,
+		Price:        o.Price,
+		TriggerPrice: o.TriggerPrice,
+	}
+}
+
+// openOrders lists the open orders of market, orderFilter is Order, StopOrder or empty for both
+func (c *engine) openOrders(ctx context.Context, market, orderFilter string) ([]order, error) {
+	params := map[string]string{
+		"category": category,
+		"symbol":   market,
+		"openOnly": "0",
+	}
+	if orderFilter != "" {
+		params["orderFilter"] = orderFilter
+	}
+	var resp struct {
+		List []order `json:"list"`
+	}
+	if err := c.call(ctx, "/v5/order/realtime", http.MethodGet, params, true, &resp); err != nil {
+		return nil, err
+	}
+	return resp.List, nil
+}
+
+func (c *engine) OpenOrders(ctx context.Context, market string) ([]exchanges.Order, error) {
+	resp, err := c.openOrders(ctx, market, "Order")
+	if err != nil {
+		return nil, err
+	}
+	orders := make([]exchanges.Order, 0, len(resp))
+	for _, o := range resp {
+		orders = append(orders, o.toOrder())
+	}
+	return orders, nil
+}
+
+func (c *engine) OpenStopOrders(ctx context.Context, market string) ([]exchanges.Order, error) {
+	resp, err := c.openOrders(ctx, market, "StopOrder")
+	if err != nil {
+		return nil, err
+	}
+	orders := make([]exchanges.Order, 0, len(resp))
+	for _, o := range resp {
+		orders = append(orders, o.toOrder())
+	}
+	return orders, nil
+}
+
+type position struct {
+	Symbol        string `json:"symbol"`
+	Side          string `json:"side"` // Buy, Sell or empty when there is no position
+	Size          string `json:"size"`
+	AvgPrice      string `json:"avgPrice"`
+	Leverage      string `json:"leverage"`
+	TakeProfit    string `json:"takeProfit"`
+	StopLoss      string `json:"stopLoss"`
+	UnrealisedPnl string `json:"unrealisedPnl"`
+	PositionIdx   int    `json:"positionIdx"`
+}
+
+func (c *engine) positions(ctx context.Context, market string) ([]position, error) {
+	var resp struct {
+		List []position `json:"list"`
+	}
+	err := c.call(ctx, "/v5/position/list", http.MethodGet, map[string]string{
+		"category": category,
+		"symbol":   market,
+	}, true, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	// Closed positions are still listed with a zero size
+	var open []position
+	for _, p := range resp.List {
+		if size, err := strconv.ParseFloat(p.Size, 64); err == nil && size != 0 {
+			open = append(open, p)
+		}
+	}
+	return open, nil
+}
+
+func (c *engine) Positions(ctx context.Context, market string) ([]exchanges.Position, error) {
+	resp, err := c.positions(ctx, market)
+	if err != nil {
+		return nil, err
+	}
+	positions := make([]exchanges.Position, 0, len(resp))
+	for _, p := range resp {
+		positions = append(positions, exchanges.Position{
+			Market:        p.Symbol,
+			Side:          exchanges.Side(strings.ToLower(p.Side)),
+			Amount:        p.Size,
+			EntryPrice:    p.AvgPrice,
+			TakeProfit:    zeroAsEmpty(p.TakeProfit),
+			StopLoss:      zeroAsEmpty(p.StopLoss),
+			Leverage:      p.Leverage,
+			UnrealizedPnl: p.UnrealisedPnl,
+		})
+	}
+	return positions, nil
+}
+
+func (c *engine) SetPositionTPSL(ctx context.Context, market, takeProfit, stopLoss string) error {
+	positions, err := c.positions(ctx, market)
+	if err != nil {
+		return err
+	}
+	if len(positions) == 0 {
+		return fmt.Errorf("no open position on %s", market)
+	}
+	i, err := c.instrument(ctx, market)
+	if err != nil {
+		return err
+	}
+
+	params := map[string]string{
+		"category":    category,
+		"symbol":      market,
+		"tpslMode":    "Full",
+		"positionIdx": strconv.Itoa(positions[0].PositionIdx),
+	}
+	if takeProfit != "" {
+		params["takeProfit"] = roundPrice(i, takeProfit)
+		params["tpTriggerBy"] = "MarkPrice"
+	}
+	if stopLoss != "" {
+		params["stopLoss"] = roundPrice(i, stopLoss)
+		params["slTriggerBy"] = "MarkPrice"
+	}
+	return c.call(ctx, "/v5/position/trading-stop", http.MethodPost, params, true, nil)
+}
+
+func (c *engine) ClosePosition(ctx context.Context, market, amount string) error {
+	positions, err := c.positions(ctx, market)
+	if err != nil {
+		return err
+	}
+	if len(positions) == 0 {
+		return fmt.Errorf("no open position on %s", market)
+	}
+
+	p := positions[0]
+	if amount == "" {
+		amount = p.Size
+	}
+	_, err = c.PlaceOrder(ctx, exchanges.OrderRequest{
+		Market:     market,
+		Side:       exchanges.Side(strings.ToLower(p.Side)).Opposite(),
+		Type:       exchanges.MarketOrder,
+		Amount:     amount,
+		ReduceOnly: true,
+	})
+	return err
+}
+
+type response struct {
+	RetCode int             `json:"retCode"`
+	RetMsg  string          `json:"retMsg"`
+	Result  json.RawMessage `json:"result"`
+}
+
+type apiError struct {
+	Code int
+	Msg  string
+}
+
+func (e apiError) Error() string {
+	return fmt.Sprintf("got error on calling: %d %s", e.Code, e.Msg)
+}
+
+func isAPIError(err error, code int) bool {
+	apiErr, ok := err.(apiError)
+	return ok && apiErr.Code == code
+}
+
+// call sends params in the query string of GET requests and as a JSON body otherwise,
+// signed requests carry the HMAC of timestamp, api key, recv window and payload in the X-BAPI-* headers
+func (c *engine) call(ctx context.Context, path, method string, params map[string]string, signed bool, out interface{}) error {
+	var payload string
+	var body io.Reader
+	uri := fmt.Sprintf("%s%s", c.baseURL, path)
+	if method == http.MethodGet {
+		query := url.Values{}
+		for k, v := range params {
+			query.Set(k, v)
+		}
+		payload = query.Encode()
+		if payload != "" {
+			uri += "?" + payload
+		}
+	} else {
+		jsonBody, err := json.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request body: %v", err)
+		}
+		payload = string(jsonBody)
+		body = bytes.NewReader(jsonBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, uri, body)
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if signed {
+		timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		req.Header.Set("X-BAPI-API-KEY", c.ApiKey)
+		req.Header.Set("X-BAPI-TIMESTAMP", timestamp)
+		req.Header.Set("X-BAPI-RECV-WINDOW", recvWindow)
+		req.Header.Set("X-BAPI-SIGN", generateSignature(c.SecretKey, timestamp+c.ApiKey+recvWindow+payload))
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status: %d, body: %s", resp.StatusCode, string(respBody))
+	}
+
+	var r response
+	if err := json.Unmarshal(respBody, &r); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+	if r.RetCode != 0 {
+		return apiError{Code: r.RetCode, Msg: r.RetMsg}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(r.Result, out); err != nil {
+		return fmt.Errorf("failed to unmarshal result: %v", err)
+	}
+	return nil
+}
+
+func generateSignature(secret, preparedStr string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(preparedStr))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func side(s exchanges.Side) string {
+	if s == exchanges.Sell {
+		return "Sell"
+	}
+	return "Buy"
+}
+
+// triggerDirection is 1 when the price has to rise to the trigger price and 2 when it has to fall
+func triggerDirection(markPrice, triggerPrice string) string {
+	mark, _ := strconv.ParseFloat(markPrice, 64)
+	trigger, _ := strconv.ParseFloat(triggerPrice, 64)
+	if trigger >= mark {
+		return "1"
+	}
+	return "2"
+}
+
+func zeroAsEmpty(v string) string {
+	if f, err := strconv.ParseFloat(v, 64); err != nil || f == 0 {
+		return ""
+	}
+	return v
+}
+
+// roundQuantity rounds the quantity down to the qtyStep of the instrument
+func roundQuantity(i instrument, quantity string) string {
+	return roundToStep(quantity, i.LotSizeFilter.QtyStep, math.Floor)
+}
+
+// roundPrice rounds the price to the nearest tickSize of the instrument
+func roundPrice(i instrument, price string) string {
+	return roundToStep(price, i.PriceFilter.TickSize, math.Round)
+}
+
+func roundToStep(value, step string, round func(float64) float64) string {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return value
+	}
+	st, err := strconv.ParseFloat(step, 64)
+	if err != nil || st <= 0 {
+		return value
+	}
+	return strconv.FormatFloat(round(v/st+1e-9)*st, 'f', precision(step), 64)
+}
+
+// precision returns the number of decimal places of a step like 0.001
+func precision(step string) int {
+	if !strings.Contains(step, ".") {
+		return 0
+	}
+	step = strings.TrimRight(step, "0")
+	return len(step) - strings.IndexByte(step, '.') - 1
+}
+
+func clientOrderID() string {
+	return "teletrade-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+}
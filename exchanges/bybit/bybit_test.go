@@ -0,0 +1,232 @@
+package bybit_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/moneyscripter/teletrade/exchanges"
+	"github.com/moneyscripter/teletrade/exchanges/bybit"
+	"github.com/moneyscripter/teletrade/exchanges/bybit/bybittest"
+)
+
+func newEngine(t *testing.T) (exchanges.Exchanges, *bybittest.Server) {
+	t.Helper()
+	server := bybittest.NewServer("key", "secret")
+	t.Cleanup(server.Close)
+	return bybit.NewBybitEngineWithBaseURL("key", "secret", server.URL), server
+}
+
+// The market data comes from the responses recorded on the real API
+func TestRecordedMarketData(t *testing.T) {
+	ctx := context.Background()
+	engine, _ := newEngine(t)
+
+	tests := []struct {
+		market string
+		want   exchanges.MarketInfo
+		mark   string
+	}{
+		{"BTCUSDT", exchanges.MarketInfo{Market: "BTCUSDT", MinAmount: "0.001", AmountPrecision: 3, PricePrecision: 1, MaxLeverage: 100}, "67423.16"},
+		{"ETHUSDT", exchanges.MarketInfo{Market: "ETHUSDT", MinAmount: "0.01", AmountPrecision: 2, PricePrecision: 2, MaxLeverage: 100}, "3682.77"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.market, func(t *testing.T) {
+			info, err := engine.MarketInfo(ctx, tt.market)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info != tt.want {
+				t.Fatalf("got %+v, want %+v", info, tt.want)
+			}
+			ticker, err := engine.Ticker(ctx, tt.market)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ticker.MarkPrice != tt.mark {
+				t.Fatalf("mark price is %s, want %s", ticker.MarkPrice, tt.mark)
+			}
+		})
+	}
+
+	if _, err := engine.MarketInfo(ctx, "DOGEUSDT"); err == nil {
+		t.Fatal("unrecorded market is known")
+	}
+}
+
+func TestEntryAndTPSL(t *testing.T) {
+	ctx := context.Background()
+	engine, server := newEngine(t)
+	server.SetPrice("BTCUSDT", 67000)
+
+	if err := engine.SetLeverage(ctx, "BTCUSDT", 10, exchanges.Cross); err != nil {
+		t.Fatal(err)
+	}
+	// Setting the same leverage again is tolerated
+	if err := engine.SetLeverage(ctx, "BTCUSDT", 10, exchanges.Cross); err != nil {
+		t.Fatal(err)
+	}
+
+	// Amounts and prices are rounded to the recorded lot size and tick
+	entry, err := engine.PlaceStopOrder(ctx, exchanges.StopOrderRequest{
+		Market:       "BTCUSDT",
+		Side:         exchanges.Buy,
+		Type:         exchanges.MarketOrder,
+		Amount:       "0.0159",
+		TriggerPrice: "68000.04",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Amount != "0.015" || entry.TriggerPrice != "68000.0" {
+		t.Fatalf("entry is %s at %s, want 0.015 at 68000.0", entry.Amount, entry.TriggerPrice)
+	}
+	if orders := server.Orders(); len(orders) != 1 || orders[0].TriggerDirection != 1 {
+		t.Fatalf("entry above the price doesn't trigger on a rise: %+v", orders)
+	}
+
+	server.SetPrice("BTCUSDT", 68100)
+	positions, err := engine.Positions(ctx, "BTCUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(positions) != 1 || positions[0].Side != exchanges.Buy || positions[0].Amount != "0.015" {
+		t.Fatalf("unexpected positions %+v", positions)
+	}
+
+	if err := engine.SetPositionTPSL(ctx, "BTCUSDT", "70000", "67000"); err != nil {
+		t.Fatal(err)
+	}
+	positions, err = engine.Positions(ctx, "BTCUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if positions[0].TakeProfit != "70000" || positions[0].StopLoss != "67000" {
+		t.Fatalf("unexpected TP/SL %+v", positions[0])
+	}
+
+	server.SetPrice("BTCUSDT", 66900)
+	if _, ok := server.Position("BTCUSDT"); ok {
+		t.Fatal("position is not closed by its stop loss")
+	}
+}
+
+func TestCancelAmendAndClose(t *testing.T) {
+	ctx := context.Background()
+	engine, server := newEngine(t)
+	server.SetPrice("ETHUSDT", 3700)
+
+	limit, err := engine.PlaceOrder(ctx, exchanges.OrderRequest{
+		Market: "ETHUSDT",
+		Side:   exchanges.Sell,
+		Type:   exchanges.LimitOrder,
+		Amount: "0.5",
+		Price:  "3750",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop, err := engine.PlaceStopOrder(ctx, exchanges.StopOrderRequest{
+		Market:       "ETHUSDT",
+		Side:         exchanges.Sell,
+		Type:         exchanges.MarketOrder,
+		Amount:       "0.5",
+		TriggerPrice: "3600",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	amended, err := engine.AmendOrder(ctx, "ETHUSDT", limit.ID, "", "3760")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amended.ID != limit.ID || amended.Price != "3760" {
+		t.Fatalf("unexpected amended order %+v", amended)
+	}
+	amendedStop, err := engine.AmendStopOrder(ctx, "ETHUSDT", stop.ID, "0.3", "3650")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amendedStop.Amount != "0.3" || amendedStop.TriggerPrice != "3650" {
+		t.Fatalf("unexpected amended stop order %+v", amendedStop)
+	}
+
+	if err := engine.CancelOrder(ctx, "ETHUSDT", limit.ID); err != nil {
+		t.Fatal(err)
+	}
+	orders, err := engine.OpenOrders(ctx, "ETHUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stops, err := engine.OpenStopOrders(ctx, "ETHUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 0 || len(stops) != 1 {
+		t.Fatalf("%d orders and %d stop orders are open, want 0 and 1", len(orders), len(stops))
+	}
+
+	// The stop order opens a short, closed in two steps
+	server.SetPrice("ETHUSDT", 3640)
+	if p, ok := server.Position("ETHUSDT"); !ok || p.Side != "Sell" || p.Size != 0.3 {
+		t.Fatalf("unexpected position %+v", p)
+	}
+	if err := engine.ClosePosition(ctx, "ETHUSDT", "0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.ClosePosition(ctx, "ETHUSDT", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Position("ETHUSDT"); ok {
+		t.Fatal("position is still open")
+	}
+}
+
+func TestMarginModeSwitch(t *testing.T) {
+	ctx := context.Background()
+	engine, server := newEngine(t)
+
+	_, err := engine.PlaceOrder(ctx, exchanges.OrderRequest{
+		Market: "ETHUSDT",
+		Side:   exchanges.Buy,
+		Type:   exchanges.MarketOrder,
+		Amount: "0.1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The account is already in cross margin, the open position doesn't get in the way
+	if err := engine.SetLeverage(ctx, "BTCUSDT", 5, exchanges.Cross); err != nil {
+		t.Fatal(err)
+	}
+	// Switching the account to isolated margin would change the open position, the trade fails alone
+	err = engine.SetLeverage(ctx, "BTCUSDT", 5, exchanges.Isolated)
+	if err == nil || !strings.Contains(err.Error(), "open positions") {
+		t.Fatalf("got %v, want the switch refused", err)
+	}
+	if server.MarginMode() != "REGULAR_MARGIN" {
+		t.Fatalf("margin mode is %s", server.MarginMode())
+	}
+
+	if err := engine.ClosePosition(ctx, "ETHUSDT", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.SetLeverage(ctx, "BTCUSDT", 5, exchanges.Isolated); err != nil {
+		t.Fatal(err)
+	}
+	if server.MarginMode() != "ISOLATED_MARGIN" || server.Leverage("BTCUSDT") != 5 {
+		t.Fatalf("margin mode %s and leverage %d, want ISOLATED_MARGIN and 5", server.MarginMode(), server.Leverage("BTCUSDT"))
+	}
+}
+
+func TestSignatureRejected(t *testing.T) {
+	_, server := newEngine(t)
+	engine := bybit.NewBybitEngineWithBaseURL("key", "wrong secret", server.URL)
+
+	_, err := engine.Balance(context.Background(), "USDT")
+	if err == nil || !strings.Contains(err.Error(), "10004") {
+		t.Fatalf("got %v, want a signature error", err)
+	}
+}
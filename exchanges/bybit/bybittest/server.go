@@ -0,0 +1,679 @@
+// Package bybittest provides a fake of the Bybit v5 linear perpetual REST API, to run the
+// Bybit engine offline. Market data is replayed from responses recorded on the real API
+// (see the recorded directory) while the account, orders and positions are kept in memory
+// and filled against the prices set with SetPrice.
+package bybittest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//go:embed recorded/*.json
+var recorded embed.FS
+
+type Order struct {
+	OrderID          string
+	Symbol           string
+	Side             string // Buy or Sell
+	OrderType        string // Market or Limit
+	Qty              float64
+	Price            float64
+	TriggerPrice     float64
+	TriggerDirection int // 1 triggers when the price rises to TriggerPrice, 2 when it falls
+	ReduceOnly       bool
+	OrderLinkID      string
+	Status           string // New, Untriggered, Filled, Cancelled or Deactivated
+}
+
+func (o *Order) conditional() bool {
+	return o.TriggerPrice > 0
+}
+
+type Position struct {
+	Symbol     string
+	Side       string // Buy or Sell
+	Size       float64
+	AvgPrice   float64
+	Leverage   int
+	TakeProfit float64
+	StopLoss   float64
+}
+
+// Server is a fake Bybit exchange, requests must be signed with APIKey and SecretKey
+type Server struct {
+	*httptest.Server
+
+	APIKey    string
+	SecretKey string
+
+	mutex       *sync.Mutex
+	instruments map[string]map[string]interface{} // recorded instruments-info entries
+	tickers     map[string]map[string]interface{} // recorded tickers entries
+	prices      map[string]float64
+	balance     float64
+	marginMode  string
+	leverage    map[string]int
+	orders      map[string]*Order
+	positions   map[string]*Position
+	nextID      int64
+}
+
+// NewServer starts a fake exchange with 1000 USDT in the unified wallet,
+// prices start from the recorded mark prices
+func NewServer(apiKey, secretKey string) *Server {
+	s := &Server{
+		APIKey:      apiKey,
+		SecretKey:   secretKey,
+		mutex:       &sync.Mutex{},
+		instruments: loadRecorded("recorded/instruments-info.json"),
+		tickers:     loadRecorded("recorded/tickers.json"),
+		prices:      make(map[string]float64),
+		balance:     1000,
+		marginMode:  "REGULAR_MARGIN",
+		leverage:    make(map[string]int),
+		orders:      make(map[string]*Order),
+		positions:   make(map[string]*Position),
+		nextID:      1000,
+	}
+	for symbol, t := range s.tickers {
+		s.prices[symbol], _ = strconv.ParseFloat(t["markPrice"].(string), 64)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v5/market/instruments-info", s.public(s.instrumentsInfo))
+	mux.HandleFunc("/v5/market/tickers", s.public(s.marketTickers))
+	mux.HandleFunc("/v5/account/wallet-balance", s.signed(s.walletBalance))
+	mux.HandleFunc("/v5/account/info", s.signed(s.accountInfo))
+	mux.HandleFunc("/v5/account/set-margin-mode", s.signed(s.setMarginMode))
+	mux.HandleFunc("/v5/position/set-leverage", s.signed(s.setLeverage))
+	mux.HandleFunc("/v5/order/create", s.signed(s.createOrder))
+	mux.HandleFunc("/v5/order/amend", s.signed(s.amendOrder))
+	mux.HandleFunc("/v5/order/cancel", s.signed(s.cancelOrder))
+	mux.HandleFunc("/v5/order/cancel-all", s.signed(s.cancelAll))
+	mux.HandleFunc("/v5/order/realtime", s.signed(s.realtimeOrders))
+	mux.HandleFunc("/v5/position/list", s.signed(s.positionList))
+	mux.HandleFunc("/v5/position/trading-stop", s.signed(s.tradingStop))
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// loadRecorded indexes the list of a recorded response by symbol
+func loadRecorded(name string) map[string]map[string]interface{} {
+	data, err := recorded.ReadFile(name)
+	if err != nil {
+		panic(err)
+	}
+	var resp struct {
+		Result struct {
+			List []map[string]interface{} `json:"list"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		panic(fmt.Errorf("invalid recording %s: %v", name, err))
+	}
+	bySymbol := make(map[string]map[string]interface{})
+	for _, entry := range resp.Result.List {
+		bySymbol[entry["symbol"].(string)] = entry
+	}
+	return bySymbol
+}
+
+func (s *Server) SetBalance(walletBalance float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.balance = walletBalance
+}
+
+// SetPrice moves the mark and last price of symbol, filling every conditional order and TP/SL it crosses
+func (s *Server) SetPrice(symbol string, price float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.prices[symbol] = price
+	for _, o := range s.sortedOrders() {
+		if o.Symbol != symbol || o.Status != "Untriggered" {
+			continue
+		}
+		if (o.TriggerDirection == 1 && price >= o.TriggerPrice) || (o.TriggerDirection == 2 && price <= o.TriggerPrice) {
+			o.Status = "Filled"
+			s.fill(symbol, o.Side, o.Qty, price, o.ReduceOnly)
+		}
+	}
+
+	p, ok := s.positions[symbol]
+	if !ok {
+		return
+	}
+	long := p.Side == "Buy"
+	hitTP := p.TakeProfit > 0 && ((long && price >= p.TakeProfit) || (!long && price <= p.TakeProfit))
+	hitSL := p.StopLoss > 0 && ((long && price <= p.StopLoss) || (!long && price >= p.StopLoss))
+	if hitTP || hitSL {
+		s.fill(symbol, opposite(p.Side), p.Size, price, true)
+	}
+}
+
+// Balance returns the wallet balance, margin of the open positions excluded
+func (s *Server) Balance() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.balance
+}
+
+// Position returns a copy of the open position of symbol
+func (s *Server) Position(symbol string) (Position, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, ok := s.positions[symbol]
+	if !ok {
+		return Position{}, false
+	}
+	return *p, true
+}
+
+// Orders returns a copy of every order ever placed
+func (s *Server) Orders() []Order {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var orders []Order
+	for _, o := range s.sortedOrders() {
+		orders = append(orders, *o)
+	}
+	return orders
+}
+
+func (s *Server) Leverage(symbol string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.leverage[symbol]
+}
+
+func (s *Server) MarginMode() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.marginMode
+}
+
+func (s *Server) sortedOrders() []*Order {
+	orders := make([]*Order, 0, len(s.orders))
+	for id := int64(1000); id <= s.nextID; id++ {
+		if o, ok := s.orders[strconv.FormatInt(id, 10)]; ok {
+			orders = append(orders, o)
+		}
+	}
+	return orders
+}
+
+// fill applies an executed order to the position of symbol and the wallet, opening the
+// position reserves its initial margin and closing it releases the margin with the pnl
+func (s *Server) fill(symbol, side string, qty, price float64, reduceOnly bool) {
+	leverage := s.leverage[symbol]
+	if leverage == 0 {
+		leverage = 10
+	}
+
+	p, ok := s.positions[symbol]
+	if !ok || p.Side == side {
+		if reduceOnly {
+			return
+		}
+		s.balance -= qty * price / float64(leverage)
+		if !ok {
+			s.positions[symbol] = &Position{
+				Symbol:   symbol,
+				Side:     side,
+				Size:     qty,
+				AvgPrice: price,
+				Leverage: leverage,
+			}
+			return
+		}
+		p.AvgPrice = (p.AvgPrice*p.Size + price*qty) / (p.Size + qty)
+		p.Size += qty
+		return
+	}
+
+	qty = math.Min(qty, p.Size)
+	pnl := (price - p.AvgPrice) * qty
+	if p.Side == "Sell" {
+		pnl = -pnl
+	}
+	s.balance += qty*p.AvgPrice/float64(p.Leverage) + pnl
+	p.Size -= qty
+	if p.Size <= 0 {
+		delete(s.positions, symbol)
+		// Reduce-only conditional orders are deactivated along with the position
+		for _, o := range s.orders {
+			if o.Symbol == symbol && o.ReduceOnly && o.Status == "Untriggered" {
+				o.Status = "Deactivated"
+			}
+		}
+	}
+}
+
+type handler func(w http.ResponseWriter, params map[string]string)
+
+func (s *Server) public(h handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		h(w, queryParams(r))
+	}
+}
+
+// signed checks the X-BAPI-* headers, the signature covers the query string of GET
+// requests and the JSON body of the others
+func (s *Server) signed(h handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-BAPI-API-KEY") != s.APIKey {
+			writeError(w, 10003, "API key is invalid.")
+			return
+		}
+		timestamp, err := strconv.ParseInt(r.Header.Get("X-BAPI-TIMESTAMP"), 10, 64)
+		window, windowErr := strconv.ParseInt(r.Header.Get("X-BAPI-RECV-WINDOW"), 10, 64)
+		if err != nil || windowErr != nil || math.Abs(float64(time.Now().UnixMilli()-timestamp)) > float64(window) {
+			writeError(w, 10002, "invalid request, please check your server timestamp or recv_window param")
+			return
+		}
+
+		params := map[string]string{}
+		payload := r.URL.RawQuery
+		if r.Method == http.MethodGet {
+			params = queryParams(r)
+		} else {
+			body, err := io.ReadAll(r.Body)
+			if err != nil || json.Unmarshal(body, &params) != nil {
+				writeError(w, 10001, "params error: invalid json body")
+				return
+			}
+			payload = string(body)
+		}
+
+		origin := r.Header.Get("X-BAPI-TIMESTAMP") + s.APIKey + r.Header.Get("X-BAPI-RECV-WINDOW") + payload
+		mac := hmac.New(sha256.New, []byte(s.SecretKey))
+		mac.Write([]byte(origin))
+		if !hmac.Equal([]byte(r.Header.Get("X-BAPI-SIGN")), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+			writeError(w, 10004, fmt.Sprintf("error sign! origin_string[%s]", origin))
+			return
+		}
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		h(w, params)
+	}
+}
+
+func queryParams(r *http.Request) map[string]string {
+	params := map[string]string{}
+	for k, v := range r.URL.Query() {
+		params[k] = v[0]
+	}
+	return params
+}
+
+func (s *Server) instrumentsInfo(w http.ResponseWriter, params map[string]string) {
+	list := []interface{}{}
+	for symbol, instrument := range s.instruments {
+		if params["symbol"] == "" || params["symbol"] == symbol {
+			list = append(list, instrument)
+		}
+	}
+	writeResult(w, map[string]interface{}{"category": "linear", "list": list, "nextPageCursor": ""})
+}
+
+func (s *Server) marketTickers(w http.ResponseWriter, params map[string]string) {
+	list := []interface{}{}
+	for symbol, recordedTicker := range s.tickers {
+		if params["symbol"] != "" && params["symbol"] != symbol {
+			continue
+		}
+		t := make(map[string]interface{}, len(recordedTicker))
+		for k, v := range recordedTicker {
+			t[k] = v
+		}
+		t["lastPrice"] = formatFloat(s.prices[symbol])
+		t["markPrice"] = formatFloat(s.prices[symbol])
+		list = append(list, t)
+	}
+	writeResult(w, map[string]interface{}{"category": "linear", "list": list})
+}
+
+func (s *Server) walletBalance(w http.ResponseWriter, params map[string]string) {
+	if params["accountType"] != "UNIFIED" {
+		writeError(w, 10001, "accountType only support UNIFIED.")
+		return
+	}
+	positionIM := 0.0
+	for _, p := range s.positions {
+		positionIM += p.Size * p.AvgPrice / float64(p.Leverage)
+	}
+	// The fake wallet holds the released balance, the reported one includes the position margin
+	walletBalance := s.balance + positionIM
+	writeResult(w, map[string]interface{}{"list": []map[string]interface{}{{
+		"accountType":           "UNIFIED",
+		"totalWalletBalance":    formatFloat(walletBalance),
+		"totalAvailableBalance": formatFloat(s.balance),
+		"coin": []map[string]string{{
+			"coin":                "USDT",
+			"walletBalance":       formatFloat(walletBalance),
+			"equity":              formatFloat(walletBalance),
+			"locked":              "0",
+			"totalOrderIM":        "0",
+			"totalPositionIM":     formatFloat(positionIM),
+			"availableToWithdraw": "",
+		}},
+	}}})
+}
+
+func (s *Server) accountInfo(w http.ResponseWriter, params map[string]string) {
+	writeResult(w, map[string]interface{}{
+		"marginMode":          s.marginMode,
+		"unifiedMarginStatus": 4,
+		"isMasterTrader":      false,
+		"spotHedgingStatus":   "OFF",
+	})
+}
+
+func (s *Server) setMarginMode(w http.ResponseWriter, params map[string]string) {
+	mode := params["setMarginMode"]
+	if mode != "REGULAR_MARGIN" && mode != "ISOLATED_MARGIN" && mode != "PORTFOLIO_MARGIN" {
+		writeError(w, 10001, "params error: setMarginMode invalid")
+		return
+	}
+	if mode != s.marginMode && len(s.positions) > 0 {
+		writeError(w, 3400045, "Set margin mode failed, there are open positions")
+		return
+	}
+	s.marginMode = mode
+	writeResult(w, map[string]interface{}{"reasons": []interface{}{}})
+}
+
+func (s *Server) setLeverage(w http.ResponseWriter, params map[string]string) {
+	instrument, ok := s.instruments[params["symbol"]]
+	if !ok {
+		writeError(w, 10001, "params error: symbol invalid")
+		return
+	}
+	maxLeverage, _ := strconv.ParseFloat(instrument["leverageFilter"].(map[string]interface{})["maxLeverage"].(string), 64)
+	leverage, err := strconv.Atoi(params["buyLeverage"])
+	if err != nil || params["sellLeverage"] != params["buyLeverage"] || leverage < 1 || float64(leverage) > maxLeverage {
+		writeError(w, 10001, "leverage invalid")
+		return
+	}
+	if s.leverage[params["symbol"]] == leverage {
+		writeError(w, 110043, "leverage not modified")
+		return
+	}
+	s.leverage[params["symbol"]] = leverage
+	writeResult(w, map[string]interface{}{})
+}
+
+func (s *Server) createOrder(w http.ResponseWriter, params map[string]string) {
+	symbol := params["symbol"]
+	instrument, ok := s.instruments[symbol]
+	if !ok || params["category"] != "linear" {
+		writeError(w, 10001, "params error: symbol invalid")
+		return
+	}
+	lotSize := instrument["lotSizeFilter"].(map[string]interface{})
+	tickSize := instrument["priceFilter"].(map[string]interface{})["tickSize"].(string)
+
+	qty, err := strconv.ParseFloat(params["qty"], 64)
+	minQty, _ := strconv.ParseFloat(lotSize["minOrderQty"].(string), 64)
+	if err != nil || qty < minQty || !onStep(params["qty"], lotSize["qtyStep"].(string)) {
+		writeError(w, 10001, "Qty invalid")
+		return
+	}
+	if params["side"] != "Buy" && params["side"] != "Sell" {
+		writeError(w, 10001, "params error: side invalid")
+		return
+	}
+	if params["orderType"] != "Market" && params["orderType"] != "Limit" {
+		writeError(w, 10001, "params error: orderType invalid")
+		return
+	}
+
+	s.nextID++
+	o := &Order{
+		OrderID:     strconv.FormatInt(s.nextID, 10),
+		Symbol:      symbol,
+		Side:        params["side"],
+		OrderType:   params["orderType"],
+		Qty:         qty,
+		ReduceOnly:  params["reduceOnly"] == "true",
+		OrderLinkID: params["orderLinkId"],
+		Status:      "New",
+	}
+	if v := params["price"]; v != "" {
+		if !onStep(v, tickSize) {
+			writeError(w, 10001, "params error: price invalid")
+			return
+		}
+		o.Price, _ = strconv.ParseFloat(v, 64)
+	}
+
+	price := s.prices[symbol]
+	if v := params["triggerPrice"]; v != "" {
+		if !onStep(v, tickSize) {
+			writeError(w, 10001, "params error: triggerPrice invalid")
+			return
+		}
+		o.TriggerPrice, _ = strconv.ParseFloat(v, 64)
+		o.TriggerDirection, _ = strconv.Atoi(params["triggerDirection"])
+		switch {
+		case o.TriggerDirection == 1 && o.TriggerPrice <= price:
+			writeError(w, 110092, fmt.Sprintf("expect Rising, but trigger_price[%s] <= current[%s]??MarkPrice", v, formatFloat(price)))
+			return
+		case o.TriggerDirection == 2 && o.TriggerPrice >= price:
+			writeError(w, 110093, fmt.Sprintf("expect Falling, but trigger_price[%s] >= current[%s]??MarkPrice", v, formatFloat(price)))
+			return
+		case o.TriggerDirection != 1 && o.TriggerDirection != 2:
+			writeError(w, 10001, "params error: triggerDirection invalid")
+			return
+		}
+		o.Status = "Untriggered"
+	} else if o.OrderType == "Market" {
+		if _, ok := s.positions[symbol]; o.ReduceOnly && !ok {
+			writeError(w, 110017, "current position is zero, cannot fix reduce-only order qty")
+			return
+		}
+		o.Status = "Filled"
+		s.fill(symbol, o.Side, qty, price, o.ReduceOnly)
+	}
+	s.orders[o.OrderID] = o
+	writeResult(w, map[string]string{"orderId": o.OrderID, "orderLinkId": o.OrderLinkID})
+}
+
+func (s *Server) findOrder(params map[string]string) (*Order, bool) {
+	o, ok := s.orders[params["orderId"]]
+	if !ok || o.Symbol != params["symbol"] || (o.Status != "New" && o.Status != "Untriggered") {
+		return nil, false
+	}
+	return o, true
+}
+
+func (s *Server) amendOrder(w http.ResponseWriter, params map[string]string) {
+	o, ok := s.findOrder(params)
+	if !ok {
+		writeError(w, 110001, "order not exists or too late to replace")
+		return
+	}
+	if v := params["qty"]; v != "" {
+		o.Qty, _ = strconv.ParseFloat(v, 64)
+	}
+	if v := params["price"]; v != "" {
+		o.Price, _ = strconv.ParseFloat(v, 64)
+	}
+	if v := params["triggerPrice"]; v != "" {
+		if !o.conditional() {
+			writeError(w, 10001, "params error: triggerPrice only for conditional order")
+			return
+		}
+		o.TriggerPrice, _ = strconv.ParseFloat(v, 64)
+	}
+	writeResult(w, map[string]string{"orderId": o.OrderID, "orderLinkId": o.OrderLinkID})
+}
+
+func (s *Server) cancelOrder(w http.ResponseWriter, params map[string]string) {
+	o, ok := s.findOrder(params)
+	if !ok {
+		writeError(w, 110001, "order not exists or too late to cancel")
+		return
+	}
+	o.Status = "Cancelled"
+	writeResult(w, map[string]string{"orderId": o.OrderID, "orderLinkId": o.OrderLinkID})
+}
+
+func (s *Server) cancelAll(w http.ResponseWriter, params map[string]string) {
+	list := []map[string]string{}
+	for _, o := range s.sortedOrders() {
+		if o.Symbol == params["symbol"] && (o.Status == "New" || o.Status == "Untriggered") {
+			o.Status = "Cancelled"
+			list = append(list, map[string]string{"orderId": o.OrderID, "orderLinkId": o.OrderLinkID})
+		}
+	}
+	writeResult(w, map[string]interface{}{"list": list, "success": "1"})
+}
+
+func (s *Server) realtimeOrders(w http.ResponseWriter, params map[string]string) {
+	list := []map[string]interface{}{}
+	for _, o := range s.sortedOrders() {
+		if o.Symbol != params["symbol"] || (o.Status != "New" && o.Status != "Untriggered") {
+			continue
+		}
+		if (params["orderFilter"] == "Order" && o.conditional()) || (params["orderFilter"] == "StopOrder" && !o.conditional()) {
+			continue
+		}
+		stopOrderType := ""
+		if o.conditional() {
+			stopOrderType = "Stop"
+		}
+		list = append(list, map[string]interface{}{
+			"orderId":          o.OrderID,
+			"orderLinkId":      o.OrderLinkID,
+			"symbol":           o.Symbol,
+			"side":             o.Side,
+			"orderType":        o.OrderType,
+			"price":            formatFloat(o.Price),
+			"qty":              formatFloat(o.Qty),
+			"triggerPrice":     formatFloat(o.TriggerPrice),
+			"triggerDirection": o.TriggerDirection,
+			"triggerBy":        "MarkPrice",
+			"orderStatus":      o.Status,
+			"stopOrderType":    stopOrderType,
+			"reduceOnly":       o.ReduceOnly,
+			"positionIdx":      0,
+			"timeInForce":      "GTC",
+		})
+	}
+	writeResult(w, map[string]interface{}{"category": "linear", "list": list, "nextPageCursor": ""})
+}
+
+// positionList reports the position of symbol like Bybit does, a closed one with a zero size
+func (s *Server) positionList(w http.ResponseWriter, params map[string]string) {
+	symbol := params["symbol"]
+	p, ok := s.positions[symbol]
+	if !ok {
+		p = &Position{Symbol: symbol, Leverage: s.leverage[symbol]}
+	}
+	pnl := (s.prices[symbol] - p.AvgPrice) * p.Size
+	if p.Side == "Sell" {
+		pnl = -pnl
+	}
+	writeResult(w, map[string]interface{}{"category": "linear", "list": []map[string]interface{}{{
+		"positionIdx":    0,
+		"symbol":         p.Symbol,
+		"side":           p.Side,
+		"size":           formatFloat(p.Size),
+		"avgPrice":       formatFloat(p.AvgPrice),
+		"markPrice":      formatFloat(s.prices[symbol]),
+		"leverage":       strconv.Itoa(p.Leverage),
+		"takeProfit":     formatFloat(p.TakeProfit),
+		"stopLoss":       formatFloat(p.StopLoss),
+		"tpslMode":       "Full",
+		"unrealisedPnl":  formatFloat(pnl),
+		"positionStatus": "Normal",
+	}}})
+}
+
+func (s *Server) tradingStop(w http.ResponseWriter, params map[string]string) {
+	p, ok := s.positions[params["symbol"]]
+	if !ok {
+		writeError(w, 10001, "can not set tp/sl/ts for zero position")
+		return
+	}
+	if v := params["takeProfit"]; v != "" {
+		p.TakeProfit, _ = strconv.ParseFloat(v, 64)
+	}
+	if v := params["stopLoss"]; v != "" {
+		p.StopLoss, _ = strconv.ParseFloat(v, 64)
+	}
+	writeResult(w, map[string]interface{}{})
+}
+
+func opposite(side string) string {
+	if side == "Buy" {
+		return "Sell"
+	}
+	return "Buy"
+}
+
+// onStep reports whether v is a multiple of step, like the lot size and price filters require
+func onStep(v, step string) bool {
+	value, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return false
+	}
+	st, err := strconv.ParseFloat(step, 64)
+	if err != nil || st <= 0 {
+		return true
+	}
+	n := value / st
+	return math.Abs(n-math.Round(n)) < 1e-6
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func writeResult(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"retCode":    0,
+		"retMsg":     "OK",
+		"result":     result,
+		"retExtInfo": map[string]interface{}{},
+		"time":       time.Now().UnixMilli(),
+	})
+}
+
+// writeError answers with HTTP 200 like Bybit, errors are only told by retCode
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"retCode":    code,
+		"retMsg":     msg,
+		"result":     map[string]interface{}{},
+		"retExtInfo": map[string]interface{}{},
+		"time":       time.Now().UnixMilli(),
+	})
+}
@@ -10,8 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/moneyscripter/teletrade/exchanges"
-	"github.com/moneyscripter/teletrade/models"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"strconv"
 	"time"
@@ -19,136 +18,163 @@ import (
 
 const (
 	coinexBaseURL = "https://api.coinex.com"
+	marketType    = "FUTURES"
 )
 
-type OrderRequest struct {
-	Market string `json:"market"`
-	Type   string `json:"type"` // "limit" or "market"
-	Side   string `json:"side"` // "buy" or "sell"
-	Amount string `json:"amount"`
-	Price  string `json:"price,omitempty"`
-}
-
 type engine struct {
 	ApiKey    string
 	SecretKey string
+	baseURL   string
 }
 
 func NewCoinexEngine(apiKey, secretKey string) exchanges.Exchanges {
 	return &engine{
 		ApiKey:    apiKey,
 		SecretKey: secretKey,
+		baseURL:   coinexBaseURL,
 	}
 }
 
-//func main() {
-//	// Example usage
-//	signal := models.Signal{
-//		Position:    "sell",
-//		EntryPoints: []string{"28.92"},                      // Example entry point
-//		Targets:     []string{"27.988", "27.706", "27.340"}, // Example targets
-//		StopLoss:    "30.140",                               // Example stop loss
-//		Leverage:    "10",                                   // Example leverage
-//	}
-//
-//	market := "ORDIUSDT" // Example market
-//	amount := "0.5"      // Example amount
-//
-//	instance := engine{
-//		ApiKey:    "111532590CC3499C8C5931D388A726D5",
-//		SecretKey: "2882C8E506CFC5D3617FD41D5CC66F881906B4200A4A9BBF",
-//	}
-//	err := instance.executeStrategy(signal, market, amount)
-//	if err != nil {
-//		fmt.Printf("Error executing strategy: %v\n", err)
-//	}
-//}
-
-func (c *engine) Execute(ctx context.Context, signal models.Signal) error {
-	leverage, err := strconv.Atoi(signal.Leverage)
+func (c *engine) Name() string {
+	return "Coinex"
+}
+
+type ticker struct {
+	Market     string `json:"market"`
+	Last       string `json:"last"`
+	Open       string `json:"open"`
+	Close      string `json:"close"`
+	High       string `json:"high"`
+	Low        string `json:"low"`
+	Volume     string `json:"volume"`
+	VolumeSell string `json:"volume_sell"`
+	VolumeBuy  string `json:"volume_buy"`
+	Value      string `json:"value"`
+	IndexPrice string `json:"index_price"`
+	MarkPrice  string `json:"mark_price"`
+	Period     int    `json:"period"`
+}
+
+func (c *engine) Ticker(ctx context.Context, market string) (exchanges.Ticker, error) {
+	response, err := c.call(ctx, "/v2/futures/ticker", "GET", fmt.Sprintf(
+		"?market=%s",
+		market), nil)
 	if err != nil {
-		return err
+		return exchanges.Ticker{}, err
 	}
-	positionAmount, err := c.calPositionAmount(signal.Market, leverage)
-	if err != nil {
-		return err
+
+	var resp []ticker
+	if err = decodeData(response, &resp); err != nil {
+		return exchanges.Ticker{}, err
 	}
-	// Step 1: Place the initial order (using the first entry point)
-	entryPrice := signal.EntryPoints[0]
-	_, err = c.placeStopOrder(signal.Position, signal.Market, positionAmount, entryPrice)
-	if err != nil {
-		return fmt.Errorf("failed to place initial order: %v", err)
+	if len(resp) == 0 {
+		return exchanges.Ticker{}, fmt.Errorf("no ticker for market %s", market)
 	}
-	fmt.Printf("Order placed [ market: %s, position: %s, entry price: %s ]\n", signal.Market, signal.Position, entryPrice)
 
-	err = c.waitPositionOpened(signal.Market)
+	return exchanges.Ticker{
+		Market:    resp[0].Market,
+		LastPrice: resp[0].Last,
+		MarkPrice: resp[0].MarkPrice,
+	}, nil
+}
+
+type market struct {
+	Market            string   `json:"market"`
+	TakerFeeRate      string   `json:"taker_fee_rate"`
+	MakerFeeRate      string   `json:"maker_fee_rate"`
+	MinAmount         string   `json:"min_amount"`
+	BaseCcy           string   `json:"base_ccy"`
+	QuoteCcy          string   `json:"quote_ccy"`
+	BaseCcyPrecision  int      `json:"base_ccy_precision"`
+	QuoteCcyPrecision int      `json:"quote_ccy_precision"`
+	Leverage          []string `json:"leverage"`
+	TickSize          string   `json:"tick_size"`
+}
+
+func (c *engine) MarketInfo(ctx context.Context, marketName string) (exchanges.MarketInfo, error) {
+	response, err := c.call(ctx, "/v2/futures/market", "GET", fmt.Sprintf(
+		"?market=%s",
+		marketName), nil)
 	if err != nil {
-		return fmt.Errorf("waiting for opened position failed: %v", err)
+		return exchanges.MarketInfo{}, err
 	}
 
-	// Step 2: Place stop-loss and take profit orders
-	//err = c.placeTakeProfitAndStopLossOrders(signal, market, amount)
-	//if err != nil {
-	//	return fmt.Errorf("failed to place TP/SL orders: %v", err)
-	//}
-	fmt.Printf("Position entered - market: %s, position: %s, entry price: %s\n", signal.Market, signal.Position, entryPrice)
-	err = c.placeTP(signal.Market, signal.Targets[0])
-	if err != nil {
-		return fmt.Errorf("failed to place TP: %v", err)
+	var resp []market
+	if err = decodeData(response, &resp); err != nil {
+		return exchanges.MarketInfo{}, err
 	}
-	err = c.placeSL(signal.Market, signal.StopLoss)
-	if err != nil {
-		return fmt.Errorf("failed to place SL: %v", err)
+	if len(resp) == 0 {
+		return exchanges.MarketInfo{}, fmt.Errorf("unknown market %s", marketName)
 	}
 
-	// Step 3: Monitor the position
-	err = c.waitPositionClosed(signal.Market)
-	if err != nil {
-		return fmt.Errorf("failed to monitor position: %v", err)
+	maxLeverage := 0
+	for _, l := range resp[0].Leverage {
+		if v, err := strconv.Atoi(l); err == nil && v > maxLeverage {
+			maxLeverage = v
+		}
 	}
-	fmt.Printf("Position closed - market: %s, position: %s, entry price: %s\n", signal.Market, signal.Position, entryPrice)
+	return exchanges.MarketInfo{
+		Market:          resp[0].Market,
+		MinAmount:       resp[0].MinAmount,
+		AmountPrecision: resp[0].BaseCcyPrecision,
+		PricePrecision:  resp[0].QuoteCcyPrecision,
+		MaxLeverage:     maxLeverage,
+	}, nil
+}
 
-	return nil
+type balance struct {
+	Ccy           string `json:"ccy"`
+	Available     string `json:"available"`
+	Frozen        string `json:"frozen"`
+	Margin        string `json:"margin"`
+	UnrealizedPnl string `json:"unrealized_pnl"`
+	Transferrable string `json:"transferrable"`
 }
 
-func (c *engine) placeTakeProfitAndStopLossOrders(signal models.Signal, market, amount string) error {
-	// Step 2a: Place the stop-loss order (opposite side of the initial position)
-	oppositeSide := "sell"
-	if signal.Position == "sell" {
-		oppositeSide = "buy"
-	}
-	_, err := c.placeOrder(oppositeSide, market, amount, signal.StopLoss)
+func (c *engine) Balance(ctx context.Context, asset string) (exchanges.Balance, error) {
+	response, err := c.call(ctx, "/v2/assets/futures/balance", "GET", "", nil)
 	if err != nil {
-		return fmt.Errorf("failed to place stop-loss order: %v", err)
+		return exchanges.Balance{}, err
 	}
 
-	// Step 2b: Place take profit orders
-	m, err := strconv.Atoi(amount)
-	if err != nil {
-		return fmt.Errorf("failed to convert amount to int: %v", err)
+	var resp []balance
+	if err = decodeData(response, &resp); err != nil {
+		return exchanges.Balance{}, err
 	}
 
-	x := 0
-	eachAmount := m / len(signal.Targets)
-	if eachAmount*len(signal.Targets) != m {
-		x = m - eachAmount*len(signal.Targets)
-	}
-	for i, target := range signal.Targets {
-		newAmount := strconv.Itoa(eachAmount)
-		if i == 0 {
-			newAmount = strconv.Itoa(eachAmount + x)
-		}
-		_, err := c.placeStopOrder(oppositeSide, market, newAmount, target)
-		if err != nil {
-			return fmt.Errorf("failed to place take profit order at target %s: %v", target, err)
+	for _, b := range resp {
+		if b.Ccy == asset {
+			return exchanges.Balance{
+				Asset:     b.Ccy,
+				Available: b.Available,
+				Frozen:    b.Frozen,
+			}, nil
 		}
 	}
+	return exchanges.Balance{Asset: asset, Available: "0", Frozen: "0"}, nil
+}
 
-	return nil
+type adjustLeverageRequest struct {
+	Market     string `json:"market"`
+	MarketType string `json:"market_type"`
+	MarginMode string `json:"margin_mode"`
+	Leverage   int    `json:"leverage"`
 }
 
-type placeOrderResponse struct {
-	OrderId          string `json:"order_id"`
+func (c *engine) SetLeverage(ctx context.Context, market string, leverage int, mode exchanges.MarginMode) error {
+	req := adjustLeverageRequest{
+		Market:     market,
+		MarketType: marketType,
+		MarginMode: string(mode),
+		Leverage:   leverage,
+	}
+	rr, _ := json.Marshal(req)
+	_, err := c.call(ctx, "/v2/futures/adjust-position-leverage", "POST", "", rr)
+	return err
+}
+
+type order struct {
+	OrderId          int64  `json:"order_id"`
 	Market           string `json:"market"`
 	MarketType       string `json:"market_type"`
 	Side             string `json:"side"`
@@ -169,49 +195,76 @@ type placeOrderResponse struct {
 	CreatedAt        int64  `json:"created_at"`
 	UpdatedAt        int64  `json:"updated_at"`
 }
+
+func (o order) toOrder() exchanges.Order {
+	return exchanges.Order{
+		ID:     strconv.FormatInt(o.OrderId, 10),
+		Market: o.Market,
+		Side:   exchanges.Side(o.Side),
+		Type:   exchanges.OrderType(o.Type),
+		Amount: o.Amount,
+		Price:  o.Price,
+	}
+}
+
 type CreateOrder struct {
 	Market     string `json:"market"`
 	MarketType string `json:"market_type"`
 	Side       string `json:"side"`
 	Type       string `json:"type"`
 	Amount     string `json:"amount"`
-	Price      string `json:"price"`
+	Price      string `json:"price,omitempty"`
 }
 
-func (c *engine) placeOrder(side, market, amount, target string) (string, error) {
-	//params := fmt.Sprintf("?market=%s&market_type=%s&side=%s&type=%s&amount=%s&price=%s",
-	//market, "FUTURES", side, "limit", amount, target)
-
+// PlaceOrder ignores ReduceOnly, CoinEx has no such flag on futures orders
+func (c *engine) PlaceOrder(ctx context.Context, r exchanges.OrderRequest) (exchanges.Order, error) {
 	req := CreateOrder{
-		Market:     market,
-		MarketType: "FUTURES",
-		Side:       side,
-		Type:       "market",
-		Amount:     amount,
-		Price:      target,
+		Market:     r.Market,
+		MarketType: marketType,
+		Side:       string(r.Side),
+		Type:       string(r.Type),
+		Amount:     r.Amount,
+		Price:      r.Price,
 	}
 	rr, _ := json.Marshal(req)
-	response, err := c.call("/v2/futures/order", "POST", "", rr)
+	response, err := c.call(ctx, "/v2/futures/order", "POST", "", rr)
 	if err != nil {
-		return "", err
-	}
-
-	resp2, ok := response.(map[string]interface{})
-	if !ok {
-		return "", errors.New("response is not a map")
+		return exchanges.Order{}, err
 	}
 
-	var resp placeOrderResponse
-	err = MapJsonToStruct(resp2, &resp)
-	if err != nil {
-		return "", err
+	var resp order
+	if err = decodeData(response, &resp); err != nil {
+		return exchanges.Order{}, err
 	}
+	return resp.toOrder(), nil
+}
 
-	return resp.OrderId, nil
+type stopOrder struct {
+	StopId           int64  `json:"stop_id"`
+	Market           string `json:"market"`
+	MarketType       string `json:"market_type"`
+	Side             string `json:"side"`
+	Type             string `json:"type"`
+	Amount           string `json:"amount"`
+	Price            string `json:"price"`
+	TriggerPrice     string `json:"trigger_price"`
+	TriggerDirection string `json:"trigger_direction"`
+	TriggerPriceType string `json:"trigger_price_type"`
+	ClientId         string `json:"client_id"`
+	CreatedAt        int64  `json:"created_at"`
+	UpdatedAt        int64  `json:"updated_at"`
 }
 
-type placeStopOrderResponse struct {
-	StopId int64 `json:"stop_id"`
+func (o stopOrder) toOrder() exchanges.Order {
+	return exchanges.Order{
+		ID:           strconv.FormatInt(o.StopId, 10),
+		Market:       o.Market,
+		Side:         exchanges.Side(o.Side),
+		Type:         exchanges.OrderType(o.Type),
+		Amount:       o.Amount,
+		Price:        o.Price,
+		TriggerPrice: o.TriggerPrice,
+	}
 }
 
 type CreateStopOrder struct {
@@ -220,94 +273,193 @@ type CreateStopOrder struct {
 	Side             string `json:"side"`
 	Type             string `json:"type"`
 	Amount           string `json:"amount"`
+	Price            string `json:"price,omitempty"`
 	TriggerPriceType string `json:"trigger_price_type"`
 	TriggerPrice     string `json:"trigger_price"`
 }
 
-func (c *engine) placeStopOrder(side, market, amount, target string) (string, error) {
+func (c *engine) PlaceStopOrder(ctx context.Context, r exchanges.StopOrderRequest) (exchanges.Order, error) {
 	req := CreateStopOrder{
-		Market:           market,
-		MarketType:       "FUTURES",
-		Side:             side,
-		Type:             "market",
-		Amount:           amount,
+		Market:           r.Market,
+		MarketType:       marketType,
+		Side:             string(r.Side),
+		Type:             string(r.Type),
+		Amount:           r.Amount,
+		Price:            r.Price,
 		TriggerPriceType: "mark_price",
-		TriggerPrice:     target,
+		TriggerPrice:     r.TriggerPrice,
 	}
 	rr, _ := json.Marshal(req)
-	response, err := c.call("/v2/futures/stop-order", "POST", "", rr)
+	response, err := c.call(ctx, "/v2/futures/stop-order", "POST", "", rr)
 	if err != nil {
-		return "", err
-	}
+		return exchanges.Order{}, err
+	}
+
+	var resp stopOrder
+	if err = decodeData(response, &resp); err != nil {
+		return exchanges.Order{}, err
+	}
+	return exchanges.Order{
+		ID:           strconv.FormatInt(resp.StopId, 10),
+		Market:       r.Market,
+		Side:         r.Side,
+		Type:         r.Type,
+		Amount:       r.Amount,
+		Price:        r.Price,
+		TriggerPrice: r.TriggerPrice,
+	}, nil
+}
 
-	resp2, ok := response.(map[string]interface{})
-	if !ok {
-		return "", errors.New("response is not a map")
-	}
+type modifyOrderRequest struct {
+	Market     string `json:"market"`
+	MarketType string `json:"market_type"`
+	OrderId    int64  `json:"order_id"`
+	Amount     string `json:"amount,omitempty"`
+	Price      string `json:"price,omitempty"`
+}
 
-	var resp placeStopOrderResponse
-	err = MapJsonToStruct(resp2, &resp)
+func (c *engine) AmendOrder(ctx context.Context, market, orderID, amount, price string) (exchanges.Order, error) {
+	id, err := strconv.ParseInt(orderID, 10, 64)
 	if err != nil {
-		return "", err
+		return exchanges.Order{}, fmt.Errorf("invalid order id %q: %v", orderID, err)
+	}
+	req := modifyOrderRequest{
+		Market:     market,
+		MarketType: marketType,
+		OrderId:    id,
+		Amount:     amount,
+		Price:      price,
+	}
+	rr, _ := json.Marshal(req)
+	response, err := c.call(ctx, "/v2/futures/modify-order", "POST", "", rr)
+	if err != nil {
+		return exchanges.Order{}, err
 	}
 
-	return strconv.FormatInt(resp.StopId, 10), nil
+	var resp order
+	if err = decodeData(response, &resp); err != nil {
+		return exchanges.Order{}, err
+	}
+	return resp.toOrder(), nil
 }
 
-type placeTakeProfitRequest struct {
-	Market          string `json:"market"`
-	MarketType      string `json:"market_type"`
-	TakeProfitType  string `json:"take_profit_type"`
-	TakeProfitPrice string `json:"take_profit_price"`
+type modifyStopOrderRequest struct {
+	Market       string `json:"market"`
+	MarketType   string `json:"market_type"`
+	StopId       int64  `json:"stop_id"`
+	Amount       string `json:"amount,omitempty"`
+	TriggerPrice string `json:"trigger_price,omitempty"`
 }
 
-func (c *engine) placeTP(market, target string) error {
-	req := placeTakeProfitRequest{
-		Market:          market,
-		MarketType:      "FUTURES",
-		TakeProfitType:  "mark_price",
-		TakeProfitPrice: target,
+func (c *engine) AmendStopOrder(ctx context.Context, market, orderID, amount, triggerPrice string) (exchanges.Order, error) {
+	id, err := strconv.ParseInt(orderID, 10, 64)
+	if err != nil {
+		return exchanges.Order{}, fmt.Errorf("invalid stop order id %q: %v", orderID, err)
+	}
+	req := modifyStopOrderRequest{
+		Market:       market,
+		MarketType:   marketType,
+		StopId:       id,
+		Amount:       amount,
+		TriggerPrice: triggerPrice,
 	}
 	rr, _ := json.Marshal(req)
-	response, err := c.call("/v2/futures/set-position-take-profit", "POST", "", rr)
+	_, err = c.call(ctx, "/v2/futures/modify-stop-order", "POST", "", rr)
 	if err != nil {
-		return err
-	}
+		return exchanges.Order{}, err
+	}
+	return exchanges.Order{
+		ID:           orderID,
+		Market:       market,
+		Amount:       amount,
+		TriggerPrice: triggerPrice,
+	}, nil
+}
+
+type cancelOrderRequest struct {
+	Market     string `json:"market"`
+	MarketType string `json:"market_type"`
+	OrderId    int64  `json:"order_id,omitempty"`
+	StopId     int64  `json:"stop_id,omitempty"`
+}
 
-	_, ok := response.(map[string]interface{})
-	if !ok {
-		return errors.New("response is not a map")
+func (c *engine) CancelOrder(ctx context.Context, market, orderID string) error {
+	id, err := strconv.ParseInt(orderID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid order id %q: %v", orderID, err)
 	}
+	rr, _ := json.Marshal(cancelOrderRequest{Market: market, MarketType: marketType, OrderId: id})
+	_, err = c.call(ctx, "/v2/futures/cancel-order", "POST", "", rr)
+	return err
+}
 
-	return nil
+func (c *engine) CancelStopOrder(ctx context.Context, market, orderID string) error {
+	id, err := strconv.ParseInt(orderID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid stop order id %q: %v", orderID, err)
+	}
+	rr, _ := json.Marshal(cancelOrderRequest{Market: market, MarketType: marketType, StopId: id})
+	_, err = c.call(ctx, "/v2/futures/cancel-stop-order", "POST", "", rr)
+	return err
 }
 
-type placeStopLossRequest struct {
-	Market        string `json:"market"`
-	MarketType    string `json:"market_type"`
-	StopLossType  string `json:"stop_loss_type"`
-	StopLossPrice string `json:"stop_loss_price"`
+type CancelAllOrders struct {
+	Market     string `json:"market"`
+	MarketType string `json:"market_type"`
 }
 
-func (c *engine) placeSL(market, target string) error {
-	req := placeStopLossRequest{
-		Market:        market,
-		MarketType:    "FUTURES",
-		StopLossType:  "mark_price",
-		StopLossPrice: target,
+func (c *engine) CancelAllOrders(ctx context.Context, market string) error {
+	req := CancelAllOrders{
+		Market:     market,
+		MarketType: marketType,
 	}
 	rr, _ := json.Marshal(req)
-	response, err := c.call("/v2/futures/set-position-stop-loss", "POST", "", rr)
+	_, err := c.call(ctx, "/v2/futures/cancel-all-order", "POST", "", rr)
 	if err != nil {
 		return err
 	}
 
-	_, ok := response.(map[string]interface{})
-	if !ok {
-		return errors.New("response is not a map")
+	return nil
+}
+
+func (c *engine) OpenOrders(ctx context.Context, market string) ([]exchanges.Order, error) {
+	response, err := c.call(ctx, "/v2/futures/pending-order", "GET", fmt.Sprintf(
+		"?market=%s&market_type=%s",
+		market, marketType), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp []order
+	if err = decodeData(response, &resp); err != nil {
+		return nil, err
 	}
 
-	return nil
+	orders := make([]exchanges.Order, 0, len(resp))
+	for _, o := range resp {
+		orders = append(orders, o.toOrder())
+	}
+	return orders, nil
+}
+
+func (c *engine) OpenStopOrders(ctx context.Context, market string) ([]exchanges.Order, error) {
+	response, err := c.call(ctx, "/v2/futures/pending-stop-order", "GET", fmt.Sprintf(
+		"?market=%s&market_type=%s",
+		market, marketType), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp []stopOrder
+	if err = decodeData(response, &resp); err != nil {
+		return nil, err
+	}
+
+	orders := make([]exchanges.Order, 0, len(resp))
+	for _, o := range resp {
+		orders = append(orders, o.toOrder())
+	}
+	return orders, nil
 }
 
 type Position struct {
@@ -343,219 +495,109 @@ type Position struct {
 	UpdatedAt              int64  `json:"updated_at"`
 }
 
-type openPositionResponse []Position
-
-func (c *engine) getOpenPosition(market string) ([]Position, error) {
-	response, err := c.call("/v2/futures/pending-position", "GET", fmt.Sprintf(
+func (c *engine) Positions(ctx context.Context, market string) ([]exchanges.Position, error) {
+	response, err := c.call(ctx, "/v2/futures/pending-position", "GET", fmt.Sprintf(
 		"?market=%s&market_type=%s",
-		market, "FUTURES"), nil)
+		market, marketType), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	resp2, ok := response.([]interface{})
-	if !ok {
-		return nil, errors.New("response is not a map")
+	var resp []Position
+	if err = decodeData(response, &resp); err != nil {
+		return nil, err
 	}
 
-	var resp openPositionResponse
-	for _, v := range resp2 {
-		var pos Position
-		err = MapJsonToStruct(v.(map[string]interface{}), &pos)
-		if err != nil {
-			return nil, err
+	positions := make([]exchanges.Position, 0, len(resp))
+	for _, p := range resp {
+		side := exchanges.Buy
+		if p.Side == "short" {
+			side = exchanges.Sell
 		}
-		resp = append(resp, pos)
-	}
-
-	return resp, nil
+		positions = append(positions, exchanges.Position{
+			Market:        p.Market,
+			Side:          side,
+			Amount:        p.OpenInterest,
+			EntryPrice:    p.AvgEntryPrice,
+			TakeProfit:    p.TakeProfitPrice,
+			StopLoss:      p.StopLossPrice,
+			Leverage:      p.Leverage,
+			UnrealizedPnl: p.UnrealizedPnl,
+		})
+	}
+	return positions, nil
 }
 
-func (c *engine) calPositionAmount(market string, leverage int) (string, error) {
-	info, err := c.marketInfo(market)
-	if err != nil {
-		return "", err
-	}
-	lastPrice, err := strconv.ParseFloat(info[0].Last, 64)
-	if err != nil {
-		return "", err
-	}
-
-	balances, err := c.balances()
-	if err != nil {
-		return "", err
-	}
-
-	availableBalance := 0.
-	for _, b := range balances {
-		if b.Ccy == "USDT" {
-			availableBalance, err = strconv.ParseFloat(b.Available, 64)
-			if err != nil {
-				return "", err
-			}
-		}
-	}
-	if availableBalance < 2 {
-		return "", errors.New("not enough balance")
-	}
-
-	availableBalance = (10 / 100) * availableBalance
-	if availableBalance < 2 {
-		availableBalance = 2
-	}
-
-	// Calculate the position amount based on the available balance
-	// and the leverage
-	positionAmount := (availableBalance * float64(leverage)) / lastPrice
-	return strconv.FormatFloat(positionAmount, 'f', -1, 64), nil
+type placeTakeProfitRequest struct {
+	Market          string `json:"market"`
+	MarketType      string `json:"market_type"`
+	TakeProfitType  string `json:"take_profit_type"`
+	TakeProfitPrice string `json:"take_profit_price"`
 }
 
-type marketInfo struct {
-	Market     string `json:"market"`
-	Last       string `json:"last"`
-	Open       string `json:"open"`
-	Close      string `json:"close"`
-	High       string `json:"high"`
-	Low        string `json:"low"`
-	Volume     string `json:"volume"`
-	VolumeSell string `json:"volume_sell"`
-	VolumeBuy  string `json:"volume_buy"`
-	Value      string `json:"value"`
-	IndexPrice string `json:"index_price"`
-	MarkPrice  string `json:"mark_price"`
-	Period     int    `json:"period"`
+type placeStopLossRequest struct {
+	Market        string `json:"market"`
+	MarketType    string `json:"market_type"`
+	StopLossType  string `json:"stop_loss_type"`
+	StopLossPrice string `json:"stop_loss_price"`
 }
 
-func (c *engine) marketInfo(market string) ([]marketInfo, error) {
-	response, err := c.call("/v2/futures/ticker", "GET", fmt.Sprintf(
-		"?market=%s",
-		market), nil)
-	if err != nil {
-		return nil, err
-	}
-
-	resp2, ok := response.([]interface{})
-	if !ok {
-		return nil, errors.New("response is not a map")
-	}
-
-	var resp []marketInfo
-	for _, v := range resp2 {
-		var pos marketInfo
-		err = MapJsonToStruct(v.(map[string]interface{}), &pos)
-		if err != nil {
-			return nil, err
+func (c *engine) SetPositionTPSL(ctx context.Context, market, takeProfit, stopLoss string) error {
+	if takeProfit != "" {
+		req := placeTakeProfitRequest{
+			Market:          market,
+			MarketType:      marketType,
+			TakeProfitType:  "mark_price",
+			TakeProfitPrice: takeProfit,
+		}
+		rr, _ := json.Marshal(req)
+		if _, err := c.call(ctx, "/v2/futures/set-position-take-profit", "POST", "", rr); err != nil {
+			return fmt.Errorf("failed to place TP: %v", err)
 		}
-		resp = append(resp, pos)
-	}
-
-	return resp, nil
-}
-
-type balance struct {
-	Ccy           string `json:"ccy"`
-	Available     string `json:"available"`
-	Frozen        string `json:"frozen"`
-	Margin        string `json:"margin"`
-	UnrealizedPnl string `json:"unrealized_pnl"`
-	Transferrable string `json:"transferrable"`
-}
-
-func (c *engine) balances() ([]balance, error) {
-	response, err := c.call("/v2/assets/futures/balance", "GET", "", nil)
-	if err != nil {
-		return nil, err
-	}
-
-	resp2, ok := response.([]interface{})
-	if !ok {
-		return nil, errors.New("response is not a map")
 	}
-
-	var resp []balance
-	for _, v := range resp2 {
-		var pos balance
-		err = MapJsonToStruct(v.(map[string]interface{}), &pos)
-		if err != nil {
-			return nil, err
+	if stopLoss != "" {
+		req := placeStopLossRequest{
+			Market:        market,
+			MarketType:    marketType,
+			StopLossType:  "mark_price",
+			StopLossPrice: stopLoss,
+		}
+		rr, _ := json.Marshal(req)
+		if _, err := c.call(ctx, "/v2/futures/set-position-stop-loss", "POST", "", rr); err != nil {
+			return fmt.Errorf("failed to place SL: %v", err)
 		}
-		resp = append(resp, pos)
 	}
-
-	return resp, nil
+	return nil
 }
 
-type CancelAllOrders struct {
+type closePositionRequest struct {
 	Market     string `json:"market"`
 	MarketType string `json:"market_type"`
+	Type       string `json:"type"`
+	Amount     string `json:"amount,omitempty"`
 }
 
-func (c *engine) cancelAllOrders(market string) error {
-	req := CancelAllOrders{
+func (c *engine) ClosePosition(ctx context.Context, market, amount string) error {
+	req := closePositionRequest{
 		Market:     market,
-		MarketType: "FUTURES",
+		MarketType: marketType,
+		Type:       string(exchanges.MarketOrder),
+		Amount:     amount,
 	}
 	rr, _ := json.Marshal(req)
-	_, err := c.call("/v2/futures/cancel-all-order", "POST", "", rr)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (c *engine) waitPositionOpened(market string) error {
-	// Step 3: Monitor the position (simplified, would normally involve more robust logic)
-
-	for {
-		time.Sleep(1 / 2 * time.Second) // Simplified monitoring interval
-
-		// Check if the position is still open
-		positions, err := c.getOpenPosition(market)
-		if err != nil {
-			return fmt.Errorf("failed to check position status: %v", err)
-		}
-
-		if len(positions) > 0 {
-			return nil
-		}
-	}
+	_, err := c.call(ctx, "/v2/futures/close-position", "POST", "", rr)
+	return err
 }
 
-func (c *engine) waitPositionClosed(market string) error {
-	// Step 3: Monitor the position (simplified, would normally involve more robust logic)
-
-	for {
-		time.Sleep(1 / 2 * time.Second) // Simplified monitoring interval
-
-		// Check if the position is still open
-		positions, err := c.getOpenPosition(market)
-		if err != nil {
-			return fmt.Errorf("failed to check position status: %v", err)
-		}
-
-		if len(positions) == 0 {
-			// If position is closed, cancel all open orders in this market
-			err := c.cancelAllOrders(market)
-			if err != nil {
-				return fmt.Errorf("failed to cancel all orders: %v", err)
-			}
-			break
-		}
-	}
-
-	return nil
-}
-
-func (c *engine) call(url, method, queryParams string, requestBody []byte) (interface{}, error) {
+func (c *engine) call(ctx context.Context, url, method, queryParams string, requestBody []byte) (interface{}, error) {
 	// Step 1: Generate the timestamp and signature
 	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
 	preparedStr := method + url + queryParams + string(requestBody) + timestamp
 	signature := generateSignature(c.SecretKey, preparedStr)
 
-	uri := fmt.Sprintf("%s%s%s", coinexBaseURL, url, queryParams)
+	uri := fmt.Sprintf("%s%s%s", c.baseURL, url, queryParams)
 	// Step 2: Create the HTTP request
-	req, err := http.NewRequest(method, uri, bytes.NewReader(requestBody))
+	req, err := http.NewRequestWithContext(ctx, method, uri, bytes.NewReader(requestBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
 	}
@@ -574,7 +616,7 @@ func (c *engine) call(url, method, queryParams string, requestBody []byte) (inte
 	}
 	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response body: %v", err)
 	}
@@ -583,7 +625,6 @@ func (c *engine) call(url, method, queryParams string, requestBody []byte) (inte
 		return nil, fmt.Errorf("unexpected response status: %d, body: %s", resp.StatusCode, string(body))
 	}
 
-	// Parse response to get pending orders
 	var response map[string]interface{}
 	err = json.Unmarshal(body, &response)
 	if err != nil {
@@ -591,10 +632,10 @@ func (c *engine) call(url, method, queryParams string, requestBody []byte) (inte
 	}
 
 	if response["message"] != "OK" {
-		return nil, errors.New("got error on calling: " + response["message"].(string))
+		return nil, fmt.Errorf("got error on calling: %v", response["message"])
 	}
 
-	// TODO: its not a map some times and instead we are using the array of interface ->>>> []interface{}
+	// data is either a map or an array of maps, see decodeData
 	return response["data"], nil
 }
 
@@ -604,17 +645,14 @@ func generateSignature(secret, preparedStr string) string {
 	return hex.EncodeToString(mac.Sum(nil))
 }
 
-func MapJsonToStruct(myMap map[string]interface{}, s interface{}) error {
-	// Marshal the map to JSON
-	jsonData, err := json.Marshal(myMap)
-	if err != nil {
-		return err
+// decodeData decodes the data of a response (a map or an array of maps) into s
+func decodeData(data interface{}, s interface{}) error {
+	if data == nil {
+		return errors.New("response has no data")
 	}
-
-	// Unmarshal JSON data into the struct
-	if err := json.Unmarshal(jsonData, s); err != nil {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
 		return err
 	}
-
-	return nil
+	return json.Unmarshal(jsonData, s)
 }
@@ -2,13 +2,159 @@ package exchanges
 
 import (
 	"context"
+	"errors"
+	"fmt"
+
 	"github.com/moneyscripter/teletrade/models"
 )
 
+// ErrTradeCanceled is the cancel cause of trades stopped on purpose (e.g. the user stopped trading),
+// any other cancellation is a shutdown and the trade is expected to be resumed later.
+var ErrTradeCanceled = errors.New("trade canceled")
+
+// Exchanges is the REST plumbing of a futures exchange. Prices and amounts are decimal
+// strings, formatted the way the exchange expects them. Markets are named like BTCUSDT.
 type Exchanges interface {
-	Execute(ctx context.Context, signal models.Signal) error
+	Name() string
+
+	Ticker(ctx context.Context, market string) (Ticker, error)
+	MarketInfo(ctx context.Context, market string) (MarketInfo, error)
+	Balance(ctx context.Context, asset string) (Balance, error)
+
+	SetLeverage(ctx context.Context, market string, leverage int, mode MarginMode) error
+
+	PlaceOrder(ctx context.Context, req OrderRequest) (Order, error)
+	PlaceStopOrder(ctx context.Context, req StopOrderRequest) (Order, error)
+	AmendOrder(ctx context.Context, market, orderID, amount, price string) (Order, error)
+	AmendStopOrder(ctx context.Context, market, orderID, amount, triggerPrice string) (Order, error)
+	CancelOrder(ctx context.Context, market, orderID string) error
+	CancelStopOrder(ctx context.Context, market, orderID string) error
+	CancelAllOrders(ctx context.Context, market string) error
+	OpenOrders(ctx context.Context, market string) ([]Order, error)
+	OpenStopOrders(ctx context.Context, market string) ([]Order, error)
+
+	Positions(ctx context.Context, market string) ([]Position, error)
+	// SetPositionTPSL sets the take profit and stop loss of the open position, empty prices are left untouched
+	SetPositionTPSL(ctx context.Context, market, takeProfit, stopLoss string) error
+	// ClosePosition closes amount of the open position at market price, an empty amount closes all of it
+	ClosePosition(ctx context.Context, market, amount string) error
+}
+
+// Trader follows trades on an exchange, from the signal to the closed position
+type Trader interface {
+	// Execute places the trade from scratch and follows it until it's closed
+	Execute(ctx context.Context, trade *models.Trade) error
+	// Resume reconciles an unfinished trade with the real state on the exchange and follows it until it's closed
+	Resume(ctx context.Context, trade *models.Trade) error
+}
+
+type Side string
+
+const (
+	Buy  Side = "buy"
+	Sell Side = "sell"
+)
+
+func (s Side) Opposite() Side {
+	if s == Buy {
+		return Sell
+	}
+	return Buy
+}
+
+// SideFromPosition maps the side of a signal to the order side opening it
+func SideFromPosition(position models.Side) (Side, error) {
+	switch position {
+	case models.Long:
+		return Buy, nil
+	case models.Short:
+		return Sell, nil
+	}
+	return "", fmt.Errorf("unknown position %q", position)
+}
+
+type OrderType string
+
+const (
+	MarketOrder OrderType = "market"
+	LimitOrder  OrderType = "limit"
+)
+
+type MarginMode string
+
+const (
+	Cross    MarginMode = "cross"
+	Isolated MarginMode = "isolated"
+)
+
+type Ticker struct {
+	Market    string
+	LastPrice string
+	MarkPrice string
+}
+
+type MarketInfo struct {
+	Market          string
+	MinAmount       string
+	AmountPrecision int // decimal places of amounts
+	PricePrecision  int // decimal places of prices
+	MaxLeverage     int
+}
+
+type Balance struct {
+	Asset     string
+	Available string
+	Frozen    string
 }
 
+type OrderRequest struct {
+	Market     string
+	Side       Side
+	Type       OrderType
+	Amount     string
+	Price      string // limit orders only
+	ReduceOnly bool
+}
+
+// StopOrderRequest is an order placed once the mark price reaches TriggerPrice
+type StopOrderRequest struct {
+	Market       string
+	Side         Side
+	Type         OrderType
+	Amount       string
+	Price        string // limit orders only
+	TriggerPrice string
+	ReduceOnly   bool
+}
+
+type Order struct {
+	ID           string
+	Market       string
+	Side         Side
+	Type         OrderType
+	Amount       string
+	Price        string
+	TriggerPrice string // stop orders only
+}
+
+type Position struct {
+	Market        string
+	Side          Side // side of the order that opened the position
+	Amount        string
+	EntryPrice    string
+	TakeProfit    string
+	StopLoss      string
+	Leverage      string
+	UnrealizedPnl string
+}
+
+// PaperExchange is the simulated exchange, it needs no credentials nor has a website
+const PaperExchange = "Paper"
+
 var AvailableExchanges = map[string]string{
-	"Coinex": "https://www.coinex.com",
+	"Binance": "https://www.binance.com",
+	"Bybit":   "https://www.bybit.com",
+	"Coinex":  "https://www.coinex.com",
+	"Paper":   "",
+	"Toobit":  "https://www.toobit.com",
 }
@@ -0,0 +1,162 @@
+package paper
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/moneyscripter/teletrade/exchanges"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+type Order struct {
+	ID           string
+	Market       string
+	Side         exchanges.Side
+	Type         exchanges.OrderType
+	Amount       float64
+	Price        float64
+	TriggerPrice float64 // zero once a stop order is triggered, or for regular orders
+	TriggerUp    bool    // the stop triggers when the price rises to TriggerPrice
+	ReduceOnly   bool
+	CreatedAt    time.Time // feed time, only the candles opened later can fill the order
+}
+
+type Position struct {
+	Market      string
+	Side        exchanges.Side
+	Amount      float64
+	EntryPrice  float64
+	Leverage    int
+	Margin      float64
+	TakeProfit  float64
+	StopLoss    float64
+	ProtectedAt time.Time // feed time of the last TP/SL change
+	FundedAt    time.Time // feed time of the last funding payment
+}
+
+// Account is the simulated USDT wallet of a user, with its orders and positions
+type Account struct {
+	mutex *sync.Mutex
+	save  func(*Account) error
+
+	Balance   float64 // wallet balance, margin of the open positions excluded
+	Leverage  map[string]int
+	Orders    []*Order
+	Positions map[string]*Position
+	NextID    int64
+
+	seen map[string]time.Time // last candle matched per market
+}
+
+func newAccount(balance float64) *Account {
+	return &Account{
+		mutex:     &sync.Mutex{},
+		Balance:   balance,
+		Leverage:  make(map[string]int),
+		Positions: make(map[string]*Position),
+		seen:      make(map[string]time.Time),
+	}
+}
+
+// Accounts keeps one Account per user in a bbolt database
+type Accounts struct {
+	db             *bbolt.DB
+	initialBalance float64
+
+	mutex    *sync.Mutex
+	accounts map[int64]*Account
+}
+
+var accountsBucket = []byte("accounts")
+
+// NewBoltAccounts opens (or creates) the bbolt database at path, new accounts start with initialBalance USDT
+func NewBoltAccounts(path string, initialBalance float64) (*Accounts, error) {
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("open paper accounts: %w", err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(accountsBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &Accounts{
+		db:             db,
+		initialBalance: initialBalance,
+		mutex:          &sync.Mutex{},
+		accounts:       make(map[int64]*Account),
+	}, nil
+}
+
+// Get returns the account of chatID, the same one to every caller, and creates it on first use
+func (a *Accounts) Get(chatID int64) (*Account, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if account, ok := a.accounts[chatID]; ok {
+		return account, nil
+	}
+
+	account := newAccount(a.initialBalance)
+	err := a.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(accountsBucket).Get(itob(chatID))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, account)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load paper account of %d: %v", chatID, err)
+	}
+	if account.Leverage == nil {
+		account.Leverage = make(map[string]int)
+	}
+	if account.Positions == nil {
+		account.Positions = make(map[string]*Position)
+	}
+	account.save = func(account *Account) error {
+		data, err := json.Marshal(account)
+		if err != nil {
+			return err
+		}
+		return a.db.Update(func(tx *bbolt.Tx) error {
+			return tx.Bucket(accountsBucket).Put(itob(chatID), data)
+		})
+	}
+
+	a.accounts[chatID] = account
+	return account, nil
+}
+
+// Reset gives chatID a fresh account with the initial balance
+func (a *Accounts) Reset(chatID int64) error {
+	account, err := a.Get(chatID)
+	if err != nil {
+		return err
+	}
+	account.mutex.Lock()
+	defer account.mutex.Unlock()
+
+	fresh := newAccount(a.initialBalance)
+	account.Balance = fresh.Balance
+	account.Leverage = fresh.Leverage
+	account.Orders = nil
+	account.Positions = fresh.Positions
+	return account.save(account)
+}
+
+func (a *Accounts) Close() error {
+	return a.db.Close()
+}
+
+func itob(v int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return b
+}
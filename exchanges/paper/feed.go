@@ -0,0 +1,213 @@
+package paper
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"github.com/moneyscripter/teletrade/exchanges"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Candle is an OHLCV bar, Time is its opening time
+type Candle struct {
+	Time   time.Time
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	Volume float64
+}
+
+// PriceFeed provides the prices the simulated orders are filled against
+type PriceFeed interface {
+	// Current returns the candle in progress, its Close is the last price
+	Current(ctx context.Context, market string) (Candle, error)
+	// Since returns the candles opened after since, up to the current one
+	Since(ctx context.Context, market string, since time.Time) ([]Candle, error)
+}
+
+// CandleFeed replays the candles of <Dir>/<market>.csv, each one lasting Step of wall time
+// from the creation of the feed. The last candle stays current once the file is exhausted.
+type CandleFeed struct {
+	Dir  string
+	Step time.Duration
+
+	start   time.Time
+	mutex   *sync.Mutex
+	candles map[string][]Candle
+}
+
+// NewCandleFeed is a constructor for CandleFeed
+func NewCandleFeed(dir string, step time.Duration) *CandleFeed {
+	if step <= 0 {
+		step = time.Second
+	}
+	return &CandleFeed{
+		Dir:     dir,
+		Step:    step,
+		start:   time.Now(),
+		mutex:   &sync.Mutex{},
+		candles: make(map[string][]Candle),
+	}
+}
+
+// replayed returns the candles of market replayed so far
+func (f *CandleFeed) replayed(market string) ([]Candle, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	candles, ok := f.candles[market]
+	if !ok {
+		var err error
+		candles, err = LoadCandles(filepath.Join(f.Dir, market+".csv"))
+		if err != nil {
+			return nil, err
+		}
+		if len(candles) == 0 {
+			return nil, fmt.Errorf("no candles for %s", market)
+		}
+		f.candles[market] = candles
+	}
+
+	current := int(time.Since(f.start) / f.Step)
+	if current >= len(candles) {
+		current = len(candles) - 1
+	}
+	return candles[:current+1], nil
+}
+
+func (f *CandleFeed) Current(ctx context.Context, market string) (Candle, error) {
+	candles, err := f.replayed(market)
+	if err != nil {
+		return Candle{}, err
+	}
+	return candles[len(candles)-1], nil
+}
+
+func (f *CandleFeed) Since(ctx context.Context, market string, since time.Time) ([]Candle, error) {
+	candles, err := f.replayed(market)
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range candles {
+		if c.Time.After(since) {
+			return candles[i:], nil
+		}
+	}
+	return nil, nil
+}
+
+// TickerFeed stands in for a live price provider by polling the ticker of a real exchange,
+// every price is a flat candle opened when it is read
+type TickerFeed struct {
+	exchange exchanges.Exchanges
+}
+
+// NewTickerFeed is a constructor for TickerFeed, only the public market data of exchange is used
+func NewTickerFeed(exchange exchanges.Exchanges) *TickerFeed {
+	return &TickerFeed{exchange: exchange}
+}
+
+func (f *TickerFeed) Current(ctx context.Context, market string) (Candle, error) {
+	ticker, err := f.exchange.Ticker(ctx, market)
+	if err != nil {
+		return Candle{}, err
+	}
+	price, err := strconv.ParseFloat(ticker.LastPrice, 64)
+	if err != nil {
+		return Candle{}, fmt.Errorf("invalid price %q of %s: %v", ticker.LastPrice, market, err)
+	}
+	return Candle{Time: time.Now(), Open: price, High: price, Low: price, Close: price}, nil
+}
+
+func (f *TickerFeed) Since(ctx context.Context, market string, since time.Time) ([]Candle, error) {
+	c, err := f.Current(ctx, market)
+	if err != nil {
+		return nil, err
+	}
+	return []Candle{c}, nil
+}
+
+// LoadCandles reads a CSV file of time,open,high,low,close[,volume] rows sorted by time,
+// a header row is skipped. Time is either unix seconds, unix milliseconds or RFC3339.
+func LoadCandles(path string) ([]Candle, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var candles []Candle
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if len(record) < 5 {
+			return nil, fmt.Errorf("%s:%d: expected time,open,high,low,close[,volume]", path, line)
+		}
+
+		candle, err := parseCandle(record)
+		if err != nil {
+			if line == 1 {
+				continue // header
+			}
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if len(candles) > 0 && !candle.Time.After(candles[len(candles)-1].Time) {
+			return nil, fmt.Errorf("%s:%d: candles are not sorted by time", path, line)
+		}
+		candles = append(candles, candle)
+	}
+	return candles, nil
+}
+
+func parseCandle(record []string) (Candle, error) {
+	t, err := parseTime(record[0])
+	if err != nil {
+		return Candle{}, err
+	}
+	var values [5]float64
+	for i := 1; i < len(record) && i <= 5; i++ {
+		values[i-1], err = strconv.ParseFloat(strings.TrimSpace(record[i]), 64)
+		if err != nil {
+			return Candle{}, fmt.Errorf("invalid value %q", record[i])
+		}
+	}
+	return Candle{
+		Time:   t,
+		Open:   values[0],
+		High:   values[1],
+		Low:    values[2],
+		Close:  values[3],
+		Volume: values[4],
+	}, nil
+}
+
+func parseTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if n > 1e12 {
+			return time.UnixMilli(n).UTC(), nil
+		}
+		return time.Unix(n, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", value)
+	}
+	return t, nil
+}
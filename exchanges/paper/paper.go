@@ -0,0 +1,598 @@
+// Package paper simulates a USDT-M futures exchange, so channels and strategies can be tried
+// without funds. Orders are matched against a PriceFeed in one-way mode: orders against the
+// side of the open position only reduce it.
+package paper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/moneyscripter/teletrade/exchanges"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultLeverage = 1
+	maxLeverage     = 125
+	quoteAsset      = "USDT"
+)
+
+var ErrInsufficientBalance = errors.New("insufficient balance")
+
+// Fees are the trading costs applied by the simulation, rates are fractions (0.0005 is 0.05%)
+type Fees struct {
+	Taker           float64       // paid on market fills and triggered stop orders
+	Maker           float64       // paid on resting limit fills
+	Slippage        float64       // adverse price move applied to market fills
+	FundingRate     float64       // paid by longs to shorts, or the other way when negative
+	FundingInterval time.Duration // zero disables funding
+}
+
+type engine struct {
+	account *Account
+	feed    PriceFeed
+	fees    Fees
+}
+
+// NewPaperEngine is a constructor for the paper exchange of account
+func NewPaperEngine(account *Account, feed PriceFeed, fees Fees) exchanges.Exchanges {
+	return &engine{
+		account: account,
+		feed:    feed,
+		fees:    fees,
+	}
+}
+
+func (e *engine) Name() string {
+	return exchanges.PaperExchange
+}
+
+func (e *engine) Ticker(ctx context.Context, market string) (exchanges.Ticker, error) {
+	c, err := e.feed.Current(ctx, market)
+	if err != nil {
+		return exchanges.Ticker{}, err
+	}
+	return exchanges.Ticker{
+		Market:    market,
+		LastPrice: formatFloat(c.Close),
+		MarkPrice: formatFloat(c.Close),
+	}, nil
+}
+
+// MarketInfo derives the precisions from the price, an amount step is worth about 0.01 USDT
+func (e *engine) MarketInfo(ctx context.Context, market string) (exchanges.MarketInfo, error) {
+	c, err := e.feed.Current(ctx, market)
+	if err != nil {
+		return exchanges.MarketInfo{}, err
+	}
+	if c.Close <= 0 {
+		return exchanges.MarketInfo{}, fmt.Errorf("invalid price %v of %s", c.Close, market)
+	}
+	amountPrecision := clamp(int(math.Ceil(math.Log10(c.Close)))+2, 0, 8)
+	return exchanges.MarketInfo{
+		Market:          market,
+		MinAmount:       strconv.FormatFloat(math.Pow10(-amountPrecision), 'f', amountPrecision, 64),
+		AmountPrecision: amountPrecision,
+		PricePrecision:  clamp(6-int(math.Ceil(math.Log10(c.Close))), 0, 10),
+		MaxLeverage:     maxLeverage,
+	}, nil
+}
+
+func (e *engine) Balance(ctx context.Context, asset string) (exchanges.Balance, error) {
+	e.account.mutex.Lock()
+	defer e.account.mutex.Unlock()
+
+	if asset != quoteAsset {
+		return exchanges.Balance{Asset: asset, Available: "0", Frozen: "0"}, nil
+	}
+	frozen := 0.0
+	for _, p := range e.account.Positions {
+		frozen += p.Margin
+	}
+	return exchanges.Balance{
+		Asset:     asset,
+		Available: formatFloat(math.Max(e.account.Balance, 0)),
+		Frozen:    formatFloat(frozen),
+	}, nil
+}
+
+// SetLeverage keeps the leverage of the next positions of market, margin is always isolated per position
+func (e *engine) SetLeverage(ctx context.Context, market string, leverage int, mode exchanges.MarginMode) error {
+	if leverage < 1 || leverage > maxLeverage {
+		return fmt.Errorf("invalid leverage %d", leverage)
+	}
+	e.account.mutex.Lock()
+	defer e.account.mutex.Unlock()
+
+	e.account.Leverage[market] = leverage
+	return e.account.save(e.account)
+}
+
+func (e *engine) PlaceOrder(ctx context.Context, r exchanges.OrderRequest) (exchanges.Order, error) {
+	amount, err := parsePositive(r.Amount)
+	if err != nil {
+		return exchanges.Order{}, fmt.Errorf("invalid amount: %v", err)
+	}
+	order := &Order{
+		Market:     r.Market,
+		Side:       r.Side,
+		Type:       r.Type,
+		Amount:     amount,
+		ReduceOnly: r.ReduceOnly,
+	}
+	if r.Type == exchanges.LimitOrder {
+		if order.Price, err = parsePositive(r.Price); err != nil {
+			return exchanges.Order{}, fmt.Errorf("invalid price: %v", err)
+		}
+	}
+	return e.place(ctx, order)
+}
+
+// PlaceStopOrder triggers when the price moves from its current side to TriggerPrice
+func (e *engine) PlaceStopOrder(ctx context.Context, r exchanges.StopOrderRequest) (exchanges.Order, error) {
+	amount, err := parsePositive(r.Amount)
+	if err != nil {
+		return exchanges.Order{}, fmt.Errorf("invalid amount: %v", err)
+	}
+	order := &Order{
+		Market:     r.Market,
+		Side:       r.Side,
+		Type:       r.Type,
+		Amount:     amount,
+		ReduceOnly: r.ReduceOnly,
+	}
+	if order.TriggerPrice, err = parsePositive(r.TriggerPrice); err != nil {
+		return exchanges.Order{}, fmt.Errorf("invalid trigger price: %v", err)
+	}
+	if r.Type == exchanges.LimitOrder {
+		if order.Price, err = parsePositive(r.Price); err != nil {
+			return exchanges.Order{}, fmt.Errorf("invalid price: %v", err)
+		}
+	}
+	return e.place(ctx, order)
+}
+
+func (e *engine) place(ctx context.Context, order *Order) (exchanges.Order, error) {
+	current, err := e.sync(ctx, order.Market)
+	if err != nil {
+		return exchanges.Order{}, err
+	}
+	e.account.mutex.Lock()
+	defer e.account.mutex.Unlock()
+
+	e.account.NextID++
+	order.ID = strconv.FormatInt(e.account.NextID, 10)
+	order.CreatedAt = current.Time
+	order.TriggerUp = order.TriggerPrice >= current.Close
+
+	if order.TriggerPrice == 0 && order.Type == exchanges.MarketOrder {
+		if err := e.fill(order, current.Close, true, current.Time); err != nil {
+			return exchanges.Order{}, err
+		}
+	} else {
+		e.account.Orders = append(e.account.Orders, order)
+	}
+	if err := e.account.save(e.account); err != nil {
+		return exchanges.Order{}, err
+	}
+	return order.toOrder(), nil
+}
+
+func (e *engine) AmendOrder(ctx context.Context, market, orderID, amount, price string) (exchanges.Order, error) {
+	return e.amend(ctx, market, orderID, amount, func(o *Order, v float64) { o.Price = v }, price)
+}
+
+func (e *engine) AmendStopOrder(ctx context.Context, market, orderID, amount, triggerPrice string) (exchanges.Order, error) {
+	return e.amend(ctx, market, orderID, amount, func(o *Order, v float64) { o.TriggerPrice = v }, triggerPrice)
+}
+
+func (e *engine) amend(ctx context.Context, market, orderID, amount string, setPrice func(*Order, float64), price string) (exchanges.Order, error) {
+	if _, err := e.sync(ctx, market); err != nil {
+		return exchanges.Order{}, err
+	}
+	e.account.mutex.Lock()
+	defer e.account.mutex.Unlock()
+
+	for _, o := range e.account.Orders {
+		if o.ID != orderID || o.Market != market {
+			continue
+		}
+		if amount != "" {
+			v, err := parsePositive(amount)
+			if err != nil {
+				return exchanges.Order{}, fmt.Errorf("invalid amount: %v", err)
+			}
+			o.Amount = v
+		}
+		if price != "" {
+			v, err := parsePositive(price)
+			if err != nil {
+				return exchanges.Order{}, fmt.Errorf("invalid price: %v", err)
+			}
+			setPrice(o, v)
+		}
+		if err := e.account.save(e.account); err != nil {
+			return exchanges.Order{}, err
+		}
+		return o.toOrder(), nil
+	}
+	return exchanges.Order{}, fmt.Errorf("order %s not found", orderID)
+}
+
+func (e *engine) CancelOrder(ctx context.Context, market, orderID string) error {
+	if _, err := e.sync(ctx, market); err != nil {
+		return err
+	}
+	e.account.mutex.Lock()
+	defer e.account.mutex.Unlock()
+
+	for i, o := range e.account.Orders {
+		if o.ID == orderID && o.Market == market {
+			e.account.Orders = append(e.account.Orders[:i], e.account.Orders[i+1:]...)
+			return e.account.save(e.account)
+		}
+	}
+	return fmt.Errorf("order %s not found", orderID)
+}
+
+func (e *engine) CancelStopOrder(ctx context.Context, market, orderID string) error {
+	return e.CancelOrder(ctx, market, orderID)
+}
+
+func (e *engine) CancelAllOrders(ctx context.Context, market string) error {
+	if _, err := e.sync(ctx, market); err != nil {
+		return err
+	}
+	e.account.mutex.Lock()
+	defer e.account.mutex.Unlock()
+
+	e.account.Orders = e.account.remainingOrders(func(o *Order) bool { return o.Market != market })
+	return e.account.save(e.account)
+}
+
+func (e *engine) OpenOrders(ctx context.Context, market string) ([]exchanges.Order, error) {
+	return e.openOrders(ctx, market, false)
+}
+
+func (e *engine) OpenStopOrders(ctx context.Context, market string) ([]exchanges.Order, error) {
+	return e.openOrders(ctx, market, true)
+}
+
+func (e *engine) openOrders(ctx context.Context, market string, stop bool) ([]exchanges.Order, error) {
+	if _, err := e.sync(ctx, market); err != nil {
+		return nil, err
+	}
+	e.account.mutex.Lock()
+	defer e.account.mutex.Unlock()
+
+	var orders []exchanges.Order
+	for _, o := range e.account.Orders {
+		if o.Market == market && (o.TriggerPrice > 0) == stop {
+			orders = append(orders, o.toOrder())
+		}
+	}
+	return orders, nil
+}
+
+func (e *engine) Positions(ctx context.Context, market string) ([]exchanges.Position, error) {
+	current, err := e.sync(ctx, market)
+	if err != nil {
+		return nil, err
+	}
+	e.account.mutex.Lock()
+	defer e.account.mutex.Unlock()
+
+	p, ok := e.account.Positions[market]
+	if !ok {
+		return nil, nil
+	}
+	position := exchanges.Position{
+		Market:        p.Market,
+		Side:          p.Side,
+		Amount:        formatFloat(p.Amount),
+		EntryPrice:    formatFloat(p.EntryPrice),
+		Leverage:      strconv.Itoa(p.Leverage),
+		UnrealizedPnl: formatFloat(pnl(p, p.Amount, current.Close)),
+	}
+	if p.TakeProfit > 0 {
+		position.TakeProfit = formatFloat(p.TakeProfit)
+	}
+	if p.StopLoss > 0 {
+		position.StopLoss = formatFloat(p.StopLoss)
+	}
+	return []exchanges.Position{position}, nil
+}
+
+func (e *engine) SetPositionTPSL(ctx context.Context, market, takeProfit, stopLoss string) error {
+	current, err := e.sync(ctx, market)
+	if err != nil {
+		return err
+	}
+	e.account.mutex.Lock()
+	defer e.account.mutex.Unlock()
+
+	p, ok := e.account.Positions[market]
+	if !ok {
+		return fmt.Errorf("no open position on %s", market)
+	}
+	if takeProfit != "" {
+		if p.TakeProfit, err = parsePositive(takeProfit); err != nil {
+			return fmt.Errorf("invalid take profit: %v", err)
+		}
+	}
+	if stopLoss != "" {
+		if p.StopLoss, err = parsePositive(stopLoss); err != nil {
+			return fmt.Errorf("invalid stop loss: %v", err)
+		}
+	}
+	p.ProtectedAt = current.Time
+	return e.account.save(e.account)
+}
+
+func (e *engine) ClosePosition(ctx context.Context, market, amount string) error {
+	current, err := e.sync(ctx, market)
+	if err != nil {
+		return err
+	}
+	e.account.mutex.Lock()
+	defer e.account.mutex.Unlock()
+
+	p, ok := e.account.Positions[market]
+	if !ok {
+		return fmt.Errorf("no open position on %s", market)
+	}
+	order := &Order{Market: market, Side: p.Side.Opposite(), Type: exchanges.MarketOrder, Amount: p.Amount, ReduceOnly: true}
+	if amount != "" {
+		if order.Amount, err = parsePositive(amount); err != nil {
+			return fmt.Errorf("invalid amount: %v", err)
+		}
+	}
+	if err := e.fill(order, current.Close, true, current.Time); err != nil {
+		return err
+	}
+	return e.account.save(e.account)
+}
+
+// sync matches the orders and positions of market against the candles the feed produced since
+// the last call, and returns the current candle
+func (e *engine) sync(ctx context.Context, market string) (Candle, error) {
+	e.account.mutex.Lock()
+	since := e.account.seen[market]
+	e.account.mutex.Unlock()
+
+	candles, err := e.feed.Since(ctx, market, since)
+	if err != nil {
+		return Candle{}, err
+	}
+	if len(candles) == 0 {
+		return e.feed.Current(ctx, market)
+	}
+
+	e.account.mutex.Lock()
+	defer e.account.mutex.Unlock()
+
+	changed := false
+	for _, c := range candles {
+		if !c.Time.After(e.account.seen[market]) {
+			continue
+		}
+		e.account.seen[market] = c.Time
+		if e.match(market, c) {
+			changed = true
+		}
+	}
+	if changed {
+		if err := e.account.save(e.account); err != nil {
+			return Candle{}, err
+		}
+	}
+	return candles[len(candles)-1], nil
+}
+
+// match fills the orders, TP/SL and funding of market reached within the candle c
+func (e *engine) match(market string, c Candle) bool {
+	changed := e.fund(market, c)
+
+	// Filled orders leave the book, and so do the rejected ones
+	var filled []*Order
+	for _, o := range e.account.Orders {
+		if o.Market != market || !c.Time.After(o.CreatedAt) {
+			continue
+		}
+		if o.TriggerPrice > 0 {
+			if (o.TriggerUp && c.High < o.TriggerPrice) || (!o.TriggerUp && c.Low > o.TriggerPrice) {
+				continue
+			}
+			changed = true
+			if o.Type == exchanges.LimitOrder {
+				// Triggered stop limit orders rest as limit orders
+				o.TriggerPrice = 0
+				continue
+			}
+			// Gaps fill at the opening price rather than the trigger
+			price := o.TriggerPrice
+			if (o.TriggerUp && c.Open > price) || (!o.TriggerUp && c.Open < price) {
+				price = c.Open
+			}
+			if err := e.fill(o, price, true, c.Time); err != nil {
+				// Like on an exchange the triggered order is rejected, it leaves the open orders unfilled
+				fmt.Printf("paper order %s of %s is rejected: %v\n", o.ID, market, err)
+			}
+			filled = append(filled, o)
+			continue
+		}
+
+		if (o.Side == exchanges.Buy && c.Low <= o.Price) || (o.Side == exchanges.Sell && c.High >= o.Price) {
+			if err := e.fill(o, o.Price, false, c.Time); err != nil {
+				fmt.Printf("paper order %s of %s is rejected: %v\n", o.ID, market, err)
+			}
+			filled = append(filled, o)
+			changed = true
+		}
+	}
+	if len(filled) > 0 {
+		e.account.Orders = e.account.remainingOrders(func(o *Order) bool {
+			for _, f := range filled {
+				if f == o {
+					return false
+				}
+			}
+			return true
+		})
+	}
+
+	p, ok := e.account.Positions[market]
+	if !ok || !c.Time.After(p.ProtectedAt) {
+		return changed
+	}
+	long := p.Side == exchanges.Buy
+	// The stop loss is assumed first when a candle reaches both
+	var exit float64
+	switch {
+	case p.StopLoss > 0 && ((long && c.Low <= p.StopLoss) || (!long && c.High >= p.StopLoss)):
+		exit = p.StopLoss
+	case p.TakeProfit > 0 && ((long && c.High >= p.TakeProfit) || (!long && c.Low <= p.TakeProfit)):
+		exit = p.TakeProfit
+	default:
+		return changed
+	}
+	closing := &Order{Market: market, Side: p.Side.Opposite(), Type: exchanges.MarketOrder, Amount: p.Amount, ReduceOnly: true}
+	if err := e.fill(closing, exit, true, c.Time); err != nil {
+		fmt.Printf("paper position of %s is not closed at %v: %v\n", market, exit, err)
+		return changed
+	}
+	return true
+}
+
+// fund charges the funding payments of the position of market due by the candle c
+func (e *engine) fund(market string, c Candle) bool {
+	p, ok := e.account.Positions[market]
+	if !ok || e.fees.FundingInterval <= 0 || e.fees.FundingRate == 0 {
+		return false
+	}
+	changed := false
+	for !p.FundedAt.Add(e.fees.FundingInterval).After(c.Time) {
+		p.FundedAt = p.FundedAt.Add(e.fees.FundingInterval)
+		payment := p.Amount * c.Open * e.fees.FundingRate
+		if p.Side == exchanges.Sell {
+			payment = -payment
+		}
+		e.account.Balance -= payment
+		changed = true
+	}
+	return changed
+}
+
+// fill executes the order at price, market orders (taker) slip and pay the taker fee
+func (e *engine) fill(o *Order, price float64, taker bool, at time.Time) error {
+	fee := e.fees.Maker
+	if taker {
+		fee = e.fees.Taker
+		if o.Side == exchanges.Buy {
+			price *= 1 + e.fees.Slippage
+		} else {
+			price *= 1 - e.fees.Slippage
+		}
+	}
+
+	p, ok := e.account.Positions[o.Market]
+	if ok && p.Side != o.Side {
+		amount := math.Min(o.Amount, p.Amount)
+		margin := p.Margin * amount / p.Amount
+		e.account.Balance += margin + pnl(p, amount, price) - amount*price*fee
+		p.Amount -= amount
+		p.Margin -= margin
+		if p.Amount <= 1e-12 {
+			delete(e.account.Positions, o.Market)
+			// Reduce-only orders have nothing left to reduce
+			e.account.Orders = e.account.remainingOrders(func(other *Order) bool {
+				return other.Market != o.Market || !other.ReduceOnly
+			})
+		}
+		return nil
+	}
+	if o.ReduceOnly {
+		return nil
+	}
+
+	leverage := e.account.Leverage[o.Market]
+	if leverage == 0 {
+		leverage = defaultLeverage
+	}
+	margin := o.Amount * price / float64(leverage)
+	cost := margin + o.Amount*price*fee
+	if cost > e.account.Balance {
+		return ErrInsufficientBalance
+	}
+	e.account.Balance -= cost
+
+	if !ok {
+		e.account.Positions[o.Market] = &Position{
+			Market:     o.Market,
+			Side:       o.Side,
+			Amount:     o.Amount,
+			EntryPrice: price,
+			Leverage:   leverage,
+			Margin:     margin,
+			FundedAt:   at,
+		}
+		return nil
+	}
+	p.EntryPrice = (p.EntryPrice*p.Amount + price*o.Amount) / (p.Amount + o.Amount)
+	p.Amount += o.Amount
+	p.Margin += margin
+	return nil
+}
+
+func (a *Account) remainingOrders(keep func(*Order) bool) []*Order {
+	var orders []*Order
+	for _, o := range a.Orders {
+		if keep(o) {
+			orders = append(orders, o)
+		}
+	}
+	return orders
+}
+
+func (o *Order) toOrder() exchanges.Order {
+	order := exchanges.Order{
+		ID:     o.ID,
+		Market: o.Market,
+		Side:   o.Side,
+		Type:   o.Type,
+		Amount: formatFloat(o.Amount),
+		Price:  formatFloat(o.Price),
+	}
+	if o.TriggerPrice > 0 {
+		order.TriggerPrice = formatFloat(o.TriggerPrice)
+	}
+	return order
+}
+
+func pnl(p *Position, amount, price float64) float64 {
+	if p.Side == exchanges.Sell {
+		return (p.EntryPrice - price) * amount
+	}
+	return (price - p.EntryPrice) * amount
+}
+
+func parsePositive(v string) (float64, error) {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, err
+	}
+	if f <= 0 {
+		return 0, fmt.Errorf("%s is not positive", v)
+	}
+	return f, nil
+}
+
+func clamp(v, min, max int) int {
+	return int(math.Max(float64(min), math.Min(float64(max), float64(v))))
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,119 @@
+package paper_test
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/moneyscripter/teletrade/exchanges"
+	"github.com/moneyscripter/teletrade/exchanges/paper"
+	"github.com/moneyscripter/teletrade/exchanges/strategy"
+	"github.com/moneyscripter/teletrade/models"
+)
+
+// feed serves the candles pushed by the test
+type feed struct {
+	mutex   sync.Mutex
+	candles map[string][]paper.Candle
+}
+
+func (f *feed) push(market string, price, high float64) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	at := time.Unix(0, 0)
+	if candles := f.candles[market]; len(candles) > 0 {
+		at = candles[len(candles)-1].Time.Add(time.Minute)
+	}
+	f.candles[market] = append(f.candles[market], paper.Candle{Time: at, Open: price, High: high, Low: price, Close: price})
+}
+
+func (f *feed) Current(ctx context.Context, market string) (paper.Candle, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	candles := f.candles[market]
+	return candles[len(candles)-1], nil
+}
+
+func (f *feed) Since(ctx context.Context, market string, since time.Time) ([]paper.Candle, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for i, c := range f.candles[market] {
+		if c.Time.After(since) {
+			return append([]paper.Candle(nil), f.candles[market][i:]...), nil
+		}
+	}
+	return nil, nil
+}
+
+func TestRejectedEntryFailsTrade(t *testing.T) {
+	accounts, err := paper.NewBoltAccounts(filepath.Join(t.TempDir(), "paper.db"), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = accounts.Close() })
+	account, err := accounts.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prices := &feed{candles: make(map[string][]paper.Candle)}
+	prices.push("BTCUSDT", 100, 100)
+	prices.push("ETHUSDT", 10, 10)
+	engine := paper.NewPaperEngine(account, prices, paper.Fees{})
+
+	executor := strategy.NewExecutor(engine)
+	executor.PollInterval = 10 * time.Millisecond
+	trade := models.NewTrade(1, engine.Name(), models.Signal{
+		Market:   "BTCUSDT",
+		Side:     models.Long,
+		Entry:    models.EntryRange{From: models.MustDecimal("110"), To: models.MustDecimal("110")},
+		Targets:  []models.Decimal{models.MustDecimal("120")},
+		StopLoss: models.MustDecimal("105"),
+		Leverage: 10,
+	})
+	done := make(chan error)
+	go func() { done <- executor.Execute(context.Background(), trade) }()
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		orders, err := engine.OpenStopOrders(context.Background(), "BTCUSDT")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(orders) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("entry is never placed")
+		}
+	}
+
+	// Another trade takes the margin the entry needs before it triggers
+	_, err = engine.PlaceOrder(context.Background(), exchanges.OrderRequest{
+		Market: "ETHUSDT",
+		Side:   exchanges.Buy,
+		Type:   exchanges.MarketOrder,
+		Amount: "95",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prices.push("BTCUSDT", 100, 111)
+
+	if err := <-done; err == nil || err.Error() != "entry order is gone" {
+		t.Fatalf("got %v, want the trade failed", err)
+	}
+	if trade.State != models.TradeFailed {
+		t.Fatalf("trade is %s, want failed", trade.State)
+	}
+	positions, err := engine.Positions(context.Background(), "BTCUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(positions) != 0 {
+		t.Fatalf("rejected entry opened %+v", positions)
+	}
+}
@@ -0,0 +1,483 @@
+package strategy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/moneyscripter/teletrade/exchanges"
+	"github.com/moneyscripter/teletrade/models"
+)
+
+const (
+	defaultPollInterval = 500 * time.Millisecond
+	entryCheckPolls     = 10 // polls of a pending entry between two checks of its order
+	defaultRiskPercent  = 10 // percent of the available balance used as margin
+	defaultMinMargin    = 2  // USDT
+	quoteAsset          = "USDT"
+)
+
+// ErrSignalWithdrawn fails the trades whose signal is withdrawn by the channel before the entry
+var ErrSignalWithdrawn = errors.New("signal withdrawn by the channel")
+
+// Executor drives any exchange adapter from a signal: sizing, entry, TP/SL and monitoring
+type Executor struct {
+	exchange exchanges.Exchanges
+
+	PollInterval time.Duration
+	RiskPercent  float64
+	MinMargin    float64
+	MarginMode   exchanges.MarginMode
+}
+
+// NewExecutor is a constructor for Executor
+func NewExecutor(exchange exchanges.Exchanges) *Executor {
+	return &Executor{
+		exchange:     exchange,
+		PollInterval: defaultPollInterval,
+		RiskPercent:  defaultRiskPercent,
+		MinMargin:    defaultMinMargin,
+		MarginMode:   exchanges.Cross,
+	}
+}
+
+func (e *Executor) Exchange() exchanges.Exchanges {
+	return e.exchange
+}
+
+func (e *Executor) Execute(ctx context.Context, trade *models.Trade) error {
+	return e.follow(ctx, trade)
+}
+
+func (e *Executor) Resume(ctx context.Context, trade *models.Trade) error {
+	market := trade.Signal.Market
+	positions, err := e.exchange.Positions(ctx, market)
+	if err != nil {
+		return fmt.Errorf("failed to check position status: %v", err)
+	}
+
+	switch trade.State {
+	case models.TradePending:
+		// The entry order may have been placed right before the crash without being recorded
+		if len(positions) > 0 {
+			if err := trade.Transition(models.TradeOpen); err != nil {
+				return err
+			}
+			break
+		}
+		if err := e.cancelOrders(ctx, trade); err != nil {
+			return fmt.Errorf("failed to cancel unrecorded orders: %v", err)
+		}
+	case models.TradeEntryPlaced:
+		if len(positions) > 0 {
+			break
+		}
+		// Canceled by hand, or triggered and closed while we were down
+		if err := e.checkEntry(ctx, trade); err != nil {
+			return trade.Fail(err)
+		}
+	case models.TradeOpen, models.TradeProtected:
+		if len(positions) == 0 {
+			if err := trade.Transition(models.TradeClosing); err != nil {
+				return err
+			}
+		}
+	}
+
+	return e.follow(ctx, trade)
+}
+
+// follow drives the trade through its states until it's closed or failed
+func (e *Executor) follow(ctx context.Context, trade *models.Trade) error {
+	market := trade.Signal.Market
+	for !trade.State.IsFinal() {
+		var err error
+		switch trade.State {
+		case models.TradePending:
+			err = e.placeEntry(ctx, trade)
+		case models.TradeEntryPlaced:
+			err = e.waitPosition(ctx, trade, true)
+			if err == nil {
+				fmt.Printf("Position entered - exchange: %s, market: %s, position: %s\n", e.exchange.Name(), market, trade.Signal.Side)
+				err = trade.Transition(models.TradeOpen)
+			}
+		case models.TradeOpen:
+			err = e.protect(ctx, trade)
+		case models.TradeProtected:
+			err = e.waitPosition(ctx, trade, false)
+			if err == nil {
+				err = trade.Transition(models.TradeClosing)
+			}
+		case models.TradeClosing:
+			// Position is closed, cancel what is left of the orders of the trade
+			err = e.cancelOrders(ctx, trade)
+			if err != nil {
+				err = fmt.Errorf("failed to cancel orders: %v", err)
+			} else {
+				fmt.Printf("Position closed - exchange: %s, market: %s, position: %s\n", e.exchange.Name(), market, trade.Signal.Side)
+				err = trade.Transition(models.TradeClosed)
+			}
+		}
+		if err != nil {
+			return e.interrupted(ctx, trade, err)
+		}
+	}
+	return nil
+}
+
+// interrupted decides what happens to a trade that stopped with err. On shutdown the
+// trade is left as is to be resumed, when canceled on purpose the pending entry is dropped.
+func (e *Executor) interrupted(ctx context.Context, trade *models.Trade, err error) error {
+	if ctx.Err() == nil {
+		if failErr := trade.Fail(err); failErr != nil {
+			return failErr
+		}
+		return err
+	}
+	if !errors.Is(context.Cause(ctx), exchanges.ErrTradeCanceled) {
+		return err
+	}
+
+	// The trade context is done, cleanup has to outlive it
+	cleanupCtx := context.WithoutCancel(ctx)
+	switch trade.State {
+	case models.TradePending, models.TradeEntryPlaced:
+		if cancelErr := e.cancelOrders(cleanupCtx, trade); cancelErr != nil {
+			return fmt.Errorf("failed to cancel entry order: %v", cancelErr)
+		}
+		return trade.Fail(exchanges.ErrTradeCanceled)
+	case models.TradeOpen:
+		// Never leave an open position naked
+		return e.protect(cleanupCtx, trade)
+	}
+	return err
+}
+
+// cancelOrders cancels the open stop orders of the trade, other trades may have orders in
+// the same market. The entry of a pending trade isn't recorded yet, it's recognized by its
+// side and trigger price.
+func (e *Executor) cancelOrders(ctx context.Context, trade *models.Trade) error {
+	market := trade.Signal.Market
+	side, err := exchanges.SideFromPosition(trade.Signal.Side)
+	if err != nil {
+		return err
+	}
+	orders, err := e.exchange.OpenStopOrders(ctx, market)
+	if err != nil {
+		return err
+	}
+	for _, o := range orders {
+		own := o.ID == trade.EntryOrderID || slices.Contains(trade.TPSLOrderIDs, o.ID)
+		if trade.State == models.TradePending {
+			trigger, err := models.ParseDecimal(o.TriggerPrice)
+			own = err == nil && o.Side == side && trigger.Cmp(trade.Signal.Entry.From) == 0
+		}
+		if !own {
+			continue
+		}
+		if err := e.exchange.CancelStopOrder(ctx, market, o.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// placeEntry sizes the position and places the entry stop order where the entry range starts
+func (e *Executor) placeEntry(ctx context.Context, trade *models.Trade) error {
+	signal := trade.Signal
+	side, err := exchanges.SideFromPosition(signal.Side)
+	if err != nil {
+		return err
+	}
+	info, err := e.exchange.MarketInfo(ctx, signal.Market)
+	if err != nil {
+		return err
+	}
+	if err := signal.Validate(info.MaxLeverage); err != nil {
+		return err
+	}
+
+	marginMode := e.MarginMode
+	switch signal.MarginMode {
+	case models.Cross:
+		marginMode = exchanges.Cross
+	case models.Isolated:
+		marginMode = exchanges.Isolated
+	}
+	if err := e.exchange.SetLeverage(ctx, signal.Market, signal.Leverage, marginMode); err != nil {
+		return fmt.Errorf("failed to set leverage: %v", err)
+	}
+	amount, err := e.positionAmount(ctx, signal.Market, info, signal.Leverage)
+	if err != nil {
+		return err
+	}
+
+	entryPrice := signal.Entry.From.String()
+	order, err := e.exchange.PlaceStopOrder(ctx, exchanges.StopOrderRequest{
+		Market:       signal.Market,
+		Side:         side,
+		Type:         exchanges.MarketOrder,
+		Amount:       amount,
+		TriggerPrice: entryPrice,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to place initial order: %v", err)
+	}
+	fmt.Printf("Order placed [ exchange: %s, market: %s, position: %s, entry price: %s ]\n", e.exchange.Name(), signal.Market, signal.Side, entryPrice)
+
+	trade.Amount = amount
+	trade.EntryOrderID = order.ID
+	return trade.Transition(models.TradeEntryPlaced)
+}
+
+// protect sets the take profit on the first target and the stop loss of the open position
+func (e *Executor) protect(ctx context.Context, trade *models.Trade) error {
+	if err := e.setTPSL(ctx, trade, trade.Signal); err != nil {
+		return fmt.Errorf("failed to place TP/SL: %v", err)
+	}
+	return trade.Transition(models.TradeProtected)
+}
+
+// setTPSL sets the take profit on the first target and the stop loss of signal, and records
+// the stop orders the exchange places for them: the ones open after that were not before,
+// and the ones of the trade that are still open
+func (e *Executor) setTPSL(ctx context.Context, trade *models.Trade, signal models.Signal) error {
+	market := trade.Signal.Market
+	before, err := e.exchange.OpenStopOrders(ctx, market)
+	if err != nil {
+		return err
+	}
+	if err := e.exchange.SetPositionTPSL(ctx, market, signal.Targets[0].String(), signal.StopLoss.String()); err != nil {
+		return err
+	}
+	after, err := e.exchange.OpenStopOrders(ctx, market)
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	for _, o := range after {
+		placed := !slices.ContainsFunc(before, func(b exchanges.Order) bool { return b.ID == o.ID })
+		if placed || slices.Contains(trade.TPSLOrderIDs, o.ID) {
+			ids = append(ids, o.ID)
+		}
+	}
+	trade.TPSLOrderIDs = ids
+	return nil
+}
+
+// positionAmount uses RiskPercent of the available balance (at least MinMargin) as margin
+func (e *Executor) positionAmount(ctx context.Context, market string, info exchanges.MarketInfo, leverage int) (string, error) {
+	ticker, err := e.exchange.Ticker(ctx, market)
+	if err != nil {
+		return "", err
+	}
+	lastPrice, err := strconv.ParseFloat(ticker.LastPrice, 64)
+	if err != nil {
+		return "", err
+	}
+
+	balance, err := e.exchange.Balance(ctx, quoteAsset)
+	if err != nil {
+		return "", err
+	}
+	availableBalance, err := strconv.ParseFloat(balance.Available, 64)
+	if err != nil {
+		return "", err
+	}
+	if availableBalance < e.MinMargin {
+		return "", errors.New("not enough balance")
+	}
+
+	margin := e.RiskPercent / 100 * availableBalance
+	if margin < e.MinMargin {
+		margin = e.MinMargin
+	}
+
+	// Calculate the position amount based on the margin and the leverage,
+	// rounded down to the precision accepted by the market
+	positionAmount := (margin * float64(leverage)) / lastPrice
+	scale := math.Pow10(info.AmountPrecision)
+	positionAmount = math.Floor(positionAmount*scale) / scale
+
+	if info.MinAmount != "" {
+		minAmount, err := strconv.ParseFloat(info.MinAmount, 64)
+		if err != nil {
+			return "", err
+		}
+		if positionAmount < minAmount {
+			return "", fmt.Errorf("position amount %v is below the %s minimum of %s", positionAmount, info.MinAmount, market)
+		}
+	}
+	return strconv.FormatFloat(positionAmount, 'f', info.AmountPrecision, 64), nil
+}
+
+// waitPosition polls the positions of the market until one is open (or all are closed),
+// applying the amendments of the signal meanwhile. The entry order is looked for every
+// entryCheckPolls polls, to fail the trade once it's gone.
+func (e *Executor) waitPosition(ctx context.Context, trade *models.Trade, open bool) error {
+	polls := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case amendment := <-trade.Amendments:
+			if err := e.amend(ctx, trade, amendment); err != nil {
+				if errors.Is(err, ErrSignalWithdrawn) {
+					return err
+				}
+				// What is placed on the exchange still protects the trade, keep following it
+				fmt.Printf("Trade %s - amendment failed: %v\n", trade.ID, err)
+			}
+			continue
+		case <-time.After(e.PollInterval):
+		}
+
+		positions, err := e.exchange.Positions(ctx, trade.Signal.Market)
+		if err != nil {
+			return fmt.Errorf("failed to check position status: %v", err)
+		}
+		if (len(positions) > 0) == open {
+			return nil
+		}
+		if polls++; open && polls%entryCheckPolls == 0 {
+			if err := e.checkEntry(ctx, trade); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// checkEntry fails when the entry order of the trade left the open orders without opening
+// a position: it's canceled by hand, or rejected by the exchange once triggered
+func (e *Executor) checkEntry(ctx context.Context, trade *models.Trade) error {
+	market := trade.Signal.Market
+	stopOrders, err := e.exchange.OpenStopOrders(ctx, market)
+	if err != nil {
+		return fmt.Errorf("failed to check stop orders: %v", err)
+	}
+	for _, o := range stopOrders {
+		if o.ID == trade.EntryOrderID {
+			return nil
+		}
+	}
+	// The entry may have been filled since the positions were checked
+	positions, err := e.exchange.Positions(ctx, market)
+	if err != nil {
+		return fmt.Errorf("failed to check position status: %v", err)
+	}
+	if len(positions) > 0 {
+		return nil
+	}
+	return errors.New("entry order is gone")
+}
+
+// amend applies the changes of the signal to what is placed on the exchange: until it's
+// triggered the entry order is moved, or canceled when the signal is withdrawn or closed.
+// Then the TP/SL of the position are moved and the position is closed on demand, an open
+// position is kept when its signal is withdrawn.
+func (e *Executor) amend(ctx context.Context, trade *models.Trade, amendment models.Amendment) error {
+	market := trade.Signal.Market
+	fmt.Printf("Trade %s - %s\n", trade.ID, amendment)
+
+	if trade.State == models.TradeEntryPlaced && (amendment.Withdrawn || !amendment.Close.IsZero()) {
+		if err := e.exchange.CancelStopOrder(ctx, market, trade.EntryOrderID); err != nil {
+			// The entry may have been triggered meanwhile, the position is then followed as usual
+			if positions, posErr := e.exchange.Positions(ctx, market); posErr == nil && len(positions) > 0 {
+				return nil
+			}
+			return fmt.Errorf("failed to cancel entry order: %v", err)
+		}
+		return ErrSignalWithdrawn
+	}
+	if amendment.Withdrawn {
+		return nil
+	}
+
+	switch trade.State {
+	case models.TradeEntryPlaced:
+		if amendment.Entry != nil {
+			order, err := e.exchange.AmendStopOrder(ctx, market, trade.EntryOrderID, trade.Amount, amendment.Entry.From.String())
+			if err != nil {
+				return fmt.Errorf("failed to move entry order: %v", err)
+			}
+			if order.ID != "" {
+				trade.EntryOrderID = order.ID
+			}
+		}
+		return trade.Amend(amendment)
+	case models.TradeProtected:
+		if amendment.Breakeven {
+			positions, err := e.exchange.Positions(ctx, market)
+			if err != nil {
+				return fmt.Errorf("failed to check position status: %v", err)
+			}
+			if len(positions) == 0 {
+				return nil
+			}
+			entryPrice, err := models.ParseDecimal(positions[0].EntryPrice)
+			if err != nil {
+				return fmt.Errorf("invalid entry price of the position: %v", err)
+			}
+			amendment.StopLoss = &entryPrice
+		}
+		if amendment.Targets != nil || amendment.StopLoss != nil {
+			if err := e.setTPSL(ctx, trade, amendment.Apply(trade.Signal)); err != nil {
+				return fmt.Errorf("failed to move TP/SL: %v", err)
+			}
+		}
+		if err := trade.Amend(amendment); err != nil {
+			return err
+		}
+		if !amendment.Close.IsZero() {
+			return e.closePosition(ctx, market, amendment.Close)
+		}
+		return nil
+	}
+	return trade.Amend(amendment)
+}
+
+// closePosition closes percent of the open position at market price
+func (e *Executor) closePosition(ctx context.Context, market string, percent models.Decimal) error {
+	if percent.Cmp(models.MustDecimal("100")) >= 0 {
+		if err := e.exchange.ClosePosition(ctx, market, ""); err != nil {
+			return fmt.Errorf("failed to close position: %v", err)
+		}
+		return nil
+	}
+
+	positions, err := e.exchange.Positions(ctx, market)
+	if err != nil {
+		return fmt.Errorf("failed to check position status: %v", err)
+	}
+	if len(positions) == 0 {
+		return nil
+	}
+	info, err := e.exchange.MarketInfo(ctx, market)
+	if err != nil {
+		return err
+	}
+	positionAmount, err := strconv.ParseFloat(positions[0].Amount, 64)
+	if err != nil {
+		return err
+	}
+	scale := math.Pow10(info.AmountPrecision)
+	amount := math.Floor(math.Abs(positionAmount)*percent.Float64()/100*scale) / scale
+	if info.MinAmount != "" {
+		minAmount, err := strconv.ParseFloat(info.MinAmount, 64)
+		if err != nil {
+			return err
+		}
+		if amount < minAmount {
+			return fmt.Errorf("closing %s%% of the position is below the %s minimum of %s", percent, info.MinAmount, market)
+		}
+	}
+	if err := e.exchange.ClosePosition(ctx, market, strconv.FormatFloat(amount, 'f', info.AmountPrecision, 64)); err != nil {
+		return fmt.Errorf("failed to close position: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package strategy_test
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/moneyscripter/teletrade/exchanges"
+	"github.com/moneyscripter/teletrade/exchanges/binance"
+	"github.com/moneyscripter/teletrade/exchanges/binance/binancetest"
+	"github.com/moneyscripter/teletrade/exchanges/strategy"
+	"github.com/moneyscripter/teletrade/models"
+)
+
+func newExecutor(t *testing.T) (*strategy.Executor, *binancetest.Server) {
+	t.Helper()
+	server := binancetest.NewServer("key", "secret")
+	t.Cleanup(server.Close)
+	server.AddSymbol(binancetest.Symbol{
+		Symbol:      "BTCUSDT",
+		MinQty:      0.001,
+		StepSize:    0.001,
+		TickSize:    0.1,
+		MaxLeverage: 125,
+	})
+	server.SetPrice("BTCUSDT", 100)
+
+	executor := strategy.NewExecutor(binance.NewBinanceEngineWithBaseURL("key", "secret", server.URL))
+	executor.PollInterval = 10 * time.Millisecond
+	return executor, server
+}
+
+func newTrade() *models.Trade {
+	return models.NewTrade(1, "binance", models.Signal{
+		Market:   "BTCUSDT",
+		Side:     models.Long,
+		Entry:    models.EntryRange{From: models.MustDecimal("110"), To: models.MustDecimal("110")},
+		Targets:  []models.Decimal{models.MustDecimal("120")},
+		StopLoss: models.MustDecimal("105"),
+		Leverage: 10,
+	})
+}
+
+// placeOther places the entry of another trade in the same market
+func placeOther(t *testing.T, executor *strategy.Executor) exchanges.Order {
+	t.Helper()
+	order, err := executor.Exchange().PlaceStopOrder(context.Background(), exchanges.StopOrderRequest{
+		Market:       "BTCUSDT",
+		Side:         exchanges.Sell,
+		Type:         exchanges.MarketOrder,
+		Amount:       "0.01",
+		TriggerPrice: "90",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
+
+// waitOrders waits until n orders of the fake are open, and ids are not
+func waitOrders(t *testing.T, server *binancetest.Server, n int, ids ...string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		open := 0
+		for _, o := range server.Orders() {
+			if o.Status == "NEW" && !slices.Contains(ids, strconv.FormatInt(o.OrderID, 10)) {
+				open++
+			}
+		}
+		if open == n {
+			return
+		}
+	}
+	t.Fatalf("%d orders are never open", n)
+}
+
+// assertOpen checks the open orders of the fake are the ones of ids
+func assertOpen(t *testing.T, executor *strategy.Executor, ids ...string) {
+	t.Helper()
+	orders, err := executor.Exchange().OpenStopOrders(context.Background(), "BTCUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != len(ids) {
+		t.Fatalf("open orders are %+v, want %v", orders, ids)
+	}
+	for i, o := range orders {
+		if o.ID != ids[i] {
+			t.Fatalf("open orders are %+v, want %v", orders, ids)
+		}
+	}
+}
+
+func TestClosedTradeKeepsOtherOrders(t *testing.T) {
+	executor, server := newExecutor(t)
+	other := placeOther(t, executor)
+	trade := newTrade()
+
+	done := make(chan error)
+	go func() { done <- executor.Execute(context.Background(), trade) }()
+
+	waitOrders(t, server, 2)
+	server.SetPrice("BTCUSDT", 111)
+	// The entry is filled, the TP/SL replace it
+	waitOrders(t, server, 3)
+	server.SetPrice("BTCUSDT", 121)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if trade.State != models.TradeClosed {
+		t.Fatalf("trade is %s, want closed", trade.State)
+	}
+	if len(trade.TPSLOrderIDs) != 2 {
+		t.Fatalf("TP/SL orders are %v, want 2", trade.TPSLOrderIDs)
+	}
+	assertOpen(t, executor, other.ID)
+}
+
+func TestCanceledTradeKeepsOtherOrders(t *testing.T) {
+	executor, server := newExecutor(t)
+	other := placeOther(t, executor)
+	trade := newTrade()
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	done := make(chan error)
+	go func() { done <- executor.Execute(ctx, trade) }()
+
+	waitOrders(t, server, 2)
+	cancel(exchanges.ErrTradeCanceled)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if trade.State != models.TradeFailed || trade.Error != exchanges.ErrTradeCanceled.Error() {
+		t.Fatalf("trade is %s (%s), want failed", trade.State, trade.Error)
+	}
+	assertOpen(t, executor, other.ID)
+}
+
+func TestResumedPendingTradeCancelsUnrecordedEntry(t *testing.T) {
+	executor, server := newExecutor(t)
+	other := placeOther(t, executor)
+	trade := newTrade()
+
+	// The entry was placed right before a crash, without being recorded
+	unrecorded, err := executor.Exchange().PlaceStopOrder(context.Background(), exchanges.StopOrderRequest{
+		Market:       "BTCUSDT",
+		Side:         exchanges.Buy,
+		Type:         exchanges.MarketOrder,
+		Amount:       "0.5",
+		TriggerPrice: "110",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	done := make(chan error)
+	go func() { done <- executor.Resume(ctx, trade) }()
+
+	// The unrecorded entry is replaced by a new one
+	waitOrders(t, server, 2, unrecorded.ID)
+	cancel(exchanges.ErrTradeCanceled)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	assertOpen(t, executor, other.ID)
+}
@@ -1,483 +1,667 @@
 package toobit
 
 import (
-	"bytes"
 	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/moneyscripter/teletrade/exchanges"
-	"github.com/moneyscripter/teletrade/models"
-	"io/ioutil"
+	"io"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 const (
 	toobitBaseURL = "https://api.toobit.com"
+	recvWindow    = "5000"
 )
 
-type OrderRequest struct {
-	Market string `json:"market"`
-	Type   string `json:"type"` // "limit" or "market"
-	Side   string `json:"side"` // "buy" or "sell"
-	Amount string `json:"amount"`
-	Price  string `json:"price,omitempty"`
-}
-
 type engine struct {
 	ApiKey    string
 	SecretKey string
+	baseURL   string
+
+	mutex     *sync.Mutex
+	contracts map[string]contract // symbol -> contract, cached as they barely change
 }
 
 func NewToobitEngine(apiKey, secretKey string) exchanges.Exchanges {
+	return NewToobitEngineWithBaseURL(apiKey, secretKey, toobitBaseURL)
+}
+
+// NewToobitEngineWithBaseURL points the engine to another server, e.g. toobittest.Server
+func NewToobitEngineWithBaseURL(apiKey, secretKey, baseURL string) exchanges.Exchanges {
 	return &engine{
 		ApiKey:    apiKey,
 		SecretKey: secretKey,
+		baseURL:   baseURL,
+		mutex:     &sync.Mutex{},
+		contracts: make(map[string]contract),
 	}
 }
 
-func (c *engine) Execute(ctx context.Context, signal models.Signal) error {
-	leverage, err := strconv.Atoi(signal.Leverage)
-	if err != nil {
-		return err
-	}
-	positionAmount, err := c.calPositionAmount(signal.Market, leverage)
-	if err != nil {
-		return err
+func (c *engine) Name() string {
+	return "Toobit"
+}
+
+// Symbol converts a market (e.g. BTCUSDT) to the symbol of its USDT-M perpetual (BTC-SWAP-USDT)
+func Symbol(market string) string {
+	if strings.Contains(market, "-SWAP-") {
+		return market
 	}
+	return strings.TrimSuffix(market, "USDT") + "-SWAP-USDT"
+}
 
-	fmt.Printf("Position entered - market: %s, position: %s, entry price: %s\n", signal.Market, signal.Position, entryPrice)
-	return nil
+type markPrice struct {
+	ExchangeID int    `json:"exchangeId"`
+	SymbolID   string `json:"symbolId"`
+	Price      string `json:"price"`
+	Time       int64  `json:"time"`
 }
 
-func (c *engine) placeTakeProfitAndStopLossOrders(signal models.Signal, market, amount string) error {
-	// Step 2a: Place the stop-loss order (opposite side of the initial position)
-	oppositeSide := "sell"
-	if signal.Position == "sell" {
-		oppositeSide = "buy"
-	}
-	_, err := c.placeOrder(oppositeSide, market, amount, signal.StopLoss)
-	if err != nil {
-		return fmt.Errorf("failed to place stop-loss order: %v", err)
-	}
+type tickerPrice struct {
+	Symbol string `json:"s"`
+	Price  string `json:"p"`
+}
 
-	// Step 2b: Place take profit orders
-	m, err := strconv.Atoi(amount)
-	if err != nil {
-		return fmt.Errorf("failed to convert amount to int: %v", err)
-	}
+func (c *engine) Ticker(ctx context.Context, market string) (exchanges.Ticker, error) {
+	params := url.Values{"symbol": {Symbol(market)}}
 
-	x := 0
-	eachAmount := m / len(signal.Targets)
-	if eachAmount*len(signal.Targets) != m {
-		x = m - eachAmount*len(signal.Targets)
+	var mark markPrice
+	if err := c.call(ctx, "/quote/v1/markPrice", http.MethodGet, params, false, &mark); err != nil {
+		return exchanges.Ticker{}, err
 	}
-	for i, target := range signal.Targets {
-		newAmount := strconv.Itoa(eachAmount)
-		if i == 0 {
-			newAmount = strconv.Itoa(eachAmount + x)
-		}
-		_, err := c.placeStopOrder(oppositeSide, market, newAmount, target)
-		if err != nil {
-			return fmt.Errorf("failed to place take profit order at target %s: %v", target, err)
-		}
+	var last []tickerPrice
+	if err := c.call(ctx, "/quote/v1/ticker/price", http.MethodGet, params, false, &last); err != nil {
+		return exchanges.Ticker{}, err
+	}
+	if len(last) == 0 {
+		return exchanges.Ticker{}, fmt.Errorf("no ticker for market %s", market)
 	}
 
-	return nil
+	return exchanges.Ticker{
+		Market:    market,
+		LastPrice: last[0].Price,
+		MarkPrice: mark.Price,
+	}, nil
 }
 
-type placeOrderResponse struct {
-	OrderId          string `json:"order_id"`
-	Market           string `json:"market"`
-	MarketType       string `json:"market_type"`
-	Side             string `json:"side"`
-	Type             string `json:"type"`
-	Amount           string `json:"amount"`
-	Price            string `json:"price"`
-	UnfilledAmount   string `json:"unfilled_amount"`
-	FilledAmount     string `json:"filled_amount"`
-	FilledValue      string `json:"filled_value"`
-	ClientId         string `json:"client_id"`
-	Fee              string `json:"fee"`
-	FeeCcy           string `json:"fee_ccy"`
-	MakerFeeRate     string `json:"maker_fee_rate"`
-	TakerFeeRate     string `json:"taker_fee_rate"`
-	LastFilledAmount string `json:"last_filled_amount"`
-	LastFilledPrice  string `json:"last_filled_price"`
-	RealizedPnl      string `json:"realized_pnl"`
-	CreatedAt        int64  `json:"created_at"`
-	UpdatedAt        int64  `json:"updated_at"`
-}
-type CreateOrder struct {
-	Market     string `json:"market"`
-	MarketType string `json:"market_type"`
-	Side       string `json:"side"`
-	Type       string `json:"type"`
-	Amount     string `json:"amount"`
-	Price      string `json:"price"`
+type filter struct {
+	FilterType string `json:"filterType"`
+	TickSize   string `json:"tickSize"`
+	MinQty     string `json:"minQty"`
+	StepSize   string `json:"stepSize"`
+}
+
+type riskLimit struct {
+	RiskLimitID   string `json:"riskLimitId"`
+	Quantity      string `json:"quantity"`
+	InitialMargin string `json:"initialMargin"`
+	MaintMargin   string `json:"maintMargin"`
 }
 
-func (c *engine) placeOrder(side, market, amount, target string) (string, error) {
-	//params := fmt.Sprintf("?market=%s&market_type=%s&side=%s&type=%s&amount=%s&price=%s",
-	//market, "FUTURES", side, "limit", amount, target)
+type contract struct {
+	Symbol             string      `json:"symbol"`
+	Status             string      `json:"status"`
+	QuoteAsset         string      `json:"quoteAsset"`
+	ContractMultiplier string      `json:"contractMultiplier"`
+	Underlying         string      `json:"underlying"`
+	Filters            []filter    `json:"filters"`
+	RiskLimits         []riskLimit `json:"riskLimits"`
+}
+
+type exchangeInfo struct {
+	Contracts []contract `json:"contracts"`
+}
 
-	req := CreateOrder{
-		Market:     market,
-		MarketType: "FUTURES",
-		Side:       side,
-		Type:       "market",
-		Amount:     amount,
-		Price:      target,
+func (c *engine) contract(ctx context.Context, market string) (contract, error) {
+	symbol := Symbol(market)
+	c.mutex.Lock()
+	ct, ok := c.contracts[symbol]
+	c.mutex.Unlock()
+	if ok {
+		return ct, nil
 	}
-	rr, _ := json.Marshal(req)
-	response, err := c.call("/v2/futures/order", "POST", "", rr)
-	if err != nil {
-		return "", err
+
+	var info exchangeInfo
+	if err := c.call(ctx, "/api/v1/exchangeInfo", http.MethodGet, url.Values{}, false, &info); err != nil {
+		return contract{}, err
 	}
 
-	resp2, ok := response.(map[string]interface{})
-	if !ok {
-		return "", errors.New("response is not a map")
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, ct := range info.Contracts {
+		c.contracts[ct.Symbol] = ct
 	}
+	if ct, ok := c.contracts[symbol]; ok {
+		return ct, nil
+	}
+	return contract{}, fmt.Errorf("unknown market %s", market)
+}
 
-	var resp placeOrderResponse
-	err = MapJsonToStruct(resp2, &resp)
+// MarketInfo reports amounts in the base asset, orders are converted to contracts
+// with the contract multiplier of the market
+func (c *engine) MarketInfo(ctx context.Context, market string) (exchanges.MarketInfo, error) {
+	ct, err := c.contract(ctx, market)
 	if err != nil {
-		return "", err
+		return exchanges.MarketInfo{}, err
 	}
-
-	return resp.OrderId, nil
+	multiplier, err := strconv.ParseFloat(ct.ContractMultiplier, 64)
+	if err != nil {
+		return exchanges.MarketInfo{}, fmt.Errorf("invalid contract multiplier %q: %v", ct.ContractMultiplier, err)
+	}
+
+	info := exchanges.MarketInfo{Market: market}
+	for _, f := range ct.Filters {
+		switch f.FilterType {
+		case "PRICE_FILTER":
+			info.PricePrecision = precision(f.TickSize)
+		case "LOT_SIZE":
+			minQty, _ := strconv.ParseFloat(f.MinQty, 64)
+			stepSize, _ := strconv.ParseFloat(f.StepSize, 64)
+			info.AmountPrecision = precision(strconv.FormatFloat(stepSize*multiplier, 'f', -1, 64))
+			info.MinAmount = strconv.FormatFloat(minQty*multiplier, 'f', -1, 64)
+		}
+	}
+	// The first risk limit has the lowest initial margin, so the highest leverage
+	for _, r := range ct.RiskLimits {
+		initialMargin, err := strconv.ParseFloat(r.InitialMargin, 64)
+		if err != nil || initialMargin == 0 {
+			continue
+		}
+		if l := int(math.Round(1 / initialMargin)); l > info.MaxLeverage {
+			info.MaxLeverage = l
+		}
+	}
+	return info, nil
 }
 
-type placeStopOrderResponse struct {
-	StopId int64 `json:"stop_id"`
+type balance struct {
+	Asset              string `json:"asset"`
+	Balance            string `json:"balance"`
+	AvailableBalance   string `json:"availableBalance"`
+	PositionMargin     string `json:"positionMargin"`
+	OrderMargin        string `json:"orderMargin"`
+	CrossUnRealizedPnl string `json:"crossUnRealizedPnl"`
 }
 
-type placeTakeProfitRequest struct {
-	Market          string `json:"market"`
-	MarketType      string `json:"market_type"`
-	TakeProfitType  string `json:"take_profit_type"`
-	TakeProfitPrice string `json:"take_profit_price"`
+func (c *engine) Balance(ctx context.Context, asset string) (exchanges.Balance, error) {
+	var resp []balance
+	if err := c.call(ctx, "/api/v1/futures/balance", http.MethodGet, url.Values{}, true, &resp); err != nil {
+		return exchanges.Balance{}, err
+	}
+	for _, b := range resp {
+		if b.Asset == asset {
+			return exchanges.Balance{
+				Asset:     b.Asset,
+				Available: b.AvailableBalance,
+				Frozen:    b.OrderMargin,
+			}, nil
+		}
+	}
+	return exchanges.Balance{Asset: asset, Available: "0", Frozen: "0"}, nil
 }
 
-func (c *engine) placeTP(market, target string) error {
-	req := placeTakeProfitRequest{
-		Market:          market,
-		MarketType:      "FUTURES",
-		TakeProfitType:  "mark_price",
-		TakeProfitPrice: target,
+func (c *engine) SetLeverage(ctx context.Context, market string, leverage int, mode exchanges.MarginMode) error {
+	marginType := "CROSS"
+	if mode == exchanges.Isolated {
+		marginType = "ISOLATED"
 	}
-	rr, _ := json.Marshal(req)
-	response, err := c.call("/v2/futures/set-position-take-profit", "POST", "", rr)
+	err := c.call(ctx, "/api/v1/futures/marginType", http.MethodPost, url.Values{
+		"symbol":     {Symbol(market)},
+		"marginType": {marginType},
+	}, true, nil)
 	if err != nil {
-		return err
-	}
-
-	_, ok := response.(map[string]interface{})
-	if !ok {
-		return errors.New("response is not a map")
+		return fmt.Errorf("failed to set margin type: %v", err)
 	}
+	return c.call(ctx, "/api/v1/futures/leverage", http.MethodPost, url.Values{
+		"symbol":   {Symbol(market)},
+		"leverage": {strconv.Itoa(leverage)},
+	}, true, nil)
+}
 
-	return nil
+type order struct {
+	OrderID     string `json:"orderId"`
+	Symbol      string `json:"symbol"`
+	Price       string `json:"price"`
+	OrigQty     string `json:"origQty"`
+	ExecutedQty string `json:"executedQty"`
+	Status      string `json:"status"`
+	Type        string `json:"type"`      // LIMIT or STOP
+	Side        string `json:"side"`      // BUY_OPEN, SELL_OPEN, BUY_CLOSE, SELL_CLOSE
+	PriceType   string `json:"priceType"` // INPUT or MARKET
+	StopPrice   string `json:"stopPrice"`
 }
 
-type placeStopLossRequest struct {
-	Market        string `json:"market"`
-	MarketType    string `json:"market_type"`
-	StopLossType  string `json:"stop_loss_type"`
-	StopLossPrice string `json:"stop_loss_price"`
+func (c *engine) toOrder(ctx context.Context, market string, o order) (exchanges.Order, error) {
+	amount, err := c.toAmount(ctx, market, o.OrigQty)
+	if err != nil {
+		return exchanges.Order{}, err
+	}
+	side := exchanges.Sell
+	if strings.HasPrefix(o.Side, "BUY") {
+		side = exchanges.Buy
+	}
+	orderType := exchanges.LimitOrder
+	if o.PriceType == "MARKET" {
+		orderType = exchanges.MarketOrder
+	}
+	return exchanges.Order{
+		ID:           o.OrderID,
+		Market:       market,
+		Side:         side,
+		Type:         orderType,
+		Amount:       amount,
+		Price:        o.Price,
+		TriggerPrice: o.StopPrice,
+	}, nil
 }
 
-func (c *engine) placeSL(market, target string) error {
-	req := placeStopLossRequest{
-		Market:        market,
-		MarketType:    "FUTURES",
-		StopLossType:  "mark_price",
-		StopLossPrice: target,
+// orderSide opens the position with side, or closes the position opened by the opposite side when reduceOnly
+func orderSide(side exchanges.Side, reduceOnly bool) string {
+	action := "_OPEN"
+	if reduceOnly {
+		action = "_CLOSE"
 	}
-	rr, _ := json.Marshal(req)
-	response, err := c.call("/v2/futures/set-position-stop-loss", "POST", "", rr)
+	return strings.ToUpper(string(side)) + action
+}
+
+func (c *engine) PlaceOrder(ctx context.Context, r exchanges.OrderRequest) (exchanges.Order, error) {
+	quantity, err := c.toQuantity(ctx, r.Market, r.Amount)
 	if err != nil {
-		return err
+		return exchanges.Order{}, err
 	}
-
-	_, ok := response.(map[string]interface{})
-	if !ok {
-		return errors.New("response is not a map")
+	params := url.Values{
+		"symbol":           {Symbol(r.Market)},
+		"side":             {orderSide(r.Side, r.ReduceOnly)},
+		"type":             {"LIMIT"},
+		"quantity":         {quantity},
+		"newClientOrderId": {clientOrderID()},
+	}
+	if r.Type == exchanges.MarketOrder {
+		params.Set("priceType", "MARKET")
+	} else {
+		params.Set("priceType", "INPUT")
+		params.Set("price", r.Price)
+		params.Set("timeInForce", "GTC")
 	}
 
-	return nil
+	var resp order
+	if err := c.call(ctx, "/api/v1/futures/order", http.MethodPost, params, true, &resp); err != nil {
+		return exchanges.Order{}, err
+	}
+	return c.toOrder(ctx, r.Market, resp)
 }
 
-type Position struct {
-	PositionId             int    `json:"position_id"`
-	Market                 string `json:"market"`
-	MarketType             string `json:"market_type"`
-	Side                   string `json:"side"`
-	MarginMode             string `json:"margin_mode"`
-	OpenInterest           string `json:"open_interest"`
-	CloseAvbl              string `json:"close_avbl"`
-	AthPositionAmount      string `json:"ath_position_amount"`
-	UnrealizedPnl          string `json:"unrealized_pnl"`
-	RealizedPnl            string `json:"realized_pnl"`
-	AvgEntryPrice          string `json:"avg_entry_price"`
-	CmlPositionValue       string `json:"cml_position_value"`
-	MaxPositionValue       string `json:"max_position_value"`
-	TakeProfitPrice        string `json:"take_profit_price"`
-	StopLossPrice          string `json:"stop_loss_price"`
-	TakeProfitType         string `json:"take_profit_type"`
-	StopLossType           string `json:"stop_loss_type"`
-	Leverage               string `json:"leverage"`
-	MarginAvbl             string `json:"margin_avbl"`
-	AthMarginSize          string `json:"ath_margin_size"`
-	PositionMarginRate     string `json:"position_margin_rate"`
-	MaintenanceMarginRate  string `json:"maintenance_margin_rate"`
-	MaintenanceMarginValue string `json:"maintenance_margin_value"`
-	LiqPrice               string `json:"liq_price"`
-	BkrPrice               string `json:"bkr_price"`
-	AdlLevel               int    `json:"adl_level"`
-	SettlePrice            string `json:"settle_price"`
-	SettleValue            string `json:"settle_value"`
-	CreatedAt              int64  `json:"created_at"`
-	UpdatedAt              int64  `json:"updated_at"`
-}
-
-type openPositionResponse []Position
-
-func (c *engine) getOpenPosition(market string) ([]Position, error) {
-	response, err := c.call("/v2/futures/pending-position", "GET", fmt.Sprintf(
-		"?market=%s&market_type=%s",
-		market, "FUTURES"), nil)
+func (c *engine) PlaceStopOrder(ctx context.Context, r exchanges.StopOrderRequest) (exchanges.Order, error) {
+	quantity, err := c.toQuantity(ctx, r.Market, r.Amount)
 	if err != nil {
-		return nil, err
+		return exchanges.Order{}, err
 	}
-
-	resp2, ok := response.([]interface{})
-	if !ok {
-		return nil, errors.New("response is not a map")
+	params := url.Values{
+		"symbol":           {Symbol(r.Market)},
+		"side":             {orderSide(r.Side, r.ReduceOnly)},
+		"type":             {"STOP"},
+		"quantity":         {quantity},
+		"stopPrice":        {r.TriggerPrice},
+		"newClientOrderId": {clientOrderID()},
 	}
-
-	var resp openPositionResponse
-	for _, v := range resp2 {
-		var pos Position
-		err = MapJsonToStruct(v.(map[string]interface{}), &pos)
-		if err != nil {
-			return nil, err
-		}
-		resp = append(resp, pos)
+	if r.Type == exchanges.MarketOrder {
+		params.Set("priceType", "MARKET")
+	} else {
+		params.Set("priceType", "INPUT")
+		params.Set("price", r.Price)
 	}
 
-	return resp, nil
+	var resp order
+	if err := c.call(ctx, "/api/v1/futures/order", http.MethodPost, params, true, &resp); err != nil {
+		return exchanges.Order{}, err
+	}
+	return c.toOrder(ctx, r.Market, resp)
 }
 
-func (c *engine) calPositionAmount(market string, leverage int) (string, error) {
-	info, err := c.marketInfo(market)
+// AmendOrder cancels the order and places it again, Toobit can't modify orders in place
+func (c *engine) AmendOrder(ctx context.Context, market, orderID, amount, price string) (exchanges.Order, error) {
+	o, err := c.findOrder(ctx, market, orderID, "LIMIT")
 	if err != nil {
-		return "", err
+		return exchanges.Order{}, err
 	}
-	lastPrice, err := strconv.ParseFloat(info.Price, 64)
-	if err != nil {
-		return "", err
+	if err := c.CancelOrder(ctx, market, orderID); err != nil {
+		return exchanges.Order{}, err
 	}
+	if amount == "" {
+		amount = o.Amount
+	}
+	if price == "" {
+		price = o.Price
+	}
+	return c.PlaceOrder(ctx, exchanges.OrderRequest{
+		Market: market,
+		Side:   o.Side,
+		Type:   o.Type,
+		Amount: amount,
+		Price:  price,
+	})
+}
 
-	balances, err := c.balances()
+// AmendStopOrder cancels the stop order and places it again, Toobit can't modify orders in place
+func (c *engine) AmendStopOrder(ctx context.Context, market, orderID, amount, triggerPrice string) (exchanges.Order, error) {
+	o, err := c.findOrder(ctx, market, orderID, "STOP")
 	if err != nil {
-		return "", err
+		return exchanges.Order{}, err
 	}
-
-	availableBalance := 0.
-	for _, b := range balances {
-		if b.Asset == "USDT" {
-			availableBalance, err = strconv.ParseFloat(b.AvailableBalance, 64)
-			if err != nil {
-				return "", err
-			}
-		}
+	if err := c.CancelStopOrder(ctx, market, orderID); err != nil {
+		return exchanges.Order{}, err
 	}
-	if availableBalance < 2 {
-		return "", errors.New("not enough balance")
+	if amount == "" {
+		amount = o.Amount
 	}
+	if triggerPrice == "" {
+		triggerPrice = o.TriggerPrice
+	}
+	return c.PlaceStopOrder(ctx, exchanges.StopOrderRequest{
+		Market:       market,
+		Side:         o.Side,
+		Type:         o.Type,
+		Amount:       amount,
+		Price:        o.Price,
+		TriggerPrice: triggerPrice,
+	})
+}
 
-	availableBalance = (10 / 100) * availableBalance
-	if availableBalance < 2 {
-		availableBalance = 2
+func (c *engine) findOrder(ctx context.Context, market, orderID, orderType string) (exchanges.Order, error) {
+	orders, err := c.openOrders(ctx, market, orderType)
+	if err != nil {
+		return exchanges.Order{}, err
 	}
+	for _, o := range orders {
+		if o.ID == orderID {
+			return o, nil
+		}
+	}
+	return exchanges.Order{}, fmt.Errorf("order %s not found", orderID)
+}
 
-	// Calculate the position amount based on the available balance
-	// and the leverage
-	positionAmount := (availableBalance * float64(leverage)) / lastPrice
-	return strconv.FormatFloat(positionAmount, 'f', -1, 64), nil
+func (c *engine) CancelOrder(ctx context.Context, market, orderID string) error {
+	return c.call(ctx, "/api/v1/futures/order", http.MethodDelete, url.Values{
+		"orderId": {orderID},
+		"type":    {"LIMIT"},
+	}, true, nil)
 }
 
-func (c *engine) call(url, method, queryParams string, requestBody []byte) ([]byte, error) {
-	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
-	var bodyAsParam map[string]interface{}
-	json.Unmarshal(requestBody, &bodyAsParam)
+func (c *engine) CancelStopOrder(ctx context.Context, market, orderID string) error {
+	return c.call(ctx, "/api/v1/futures/order", http.MethodDelete, url.Values{
+		"orderId": {orderID},
+		"type":    {"STOP"},
+	}, true, nil)
+}
 
-	passingBody := ""
-	for key, value := range bodyAsParam {
-		passingBody += fmt.Sprintf("%s=%s&", key, value)
-	}
-	passingBody = passingBody + timestamp
-	// Step 1: Generate the timestamp and signature
-	preparedStr := queryParams + passingBody
-	signature := generateSignature(c.SecretKey, preparedStr)
+func (c *engine) CancelAllOrders(ctx context.Context, market string) error {
+	return c.call(ctx, "/api/v1/futures/batchOrders", http.MethodDelete, url.Values{
+		"symbol": {Symbol(market)},
+	}, true, nil)
+}
+
+func (c *engine) OpenOrders(ctx context.Context, market string) ([]exchanges.Order, error) {
+	return c.openOrders(ctx, market, "LIMIT")
+}
 
-	queryParams = queryParams + fmt.Sprintf("&timestamp=%s&signature=%s", timestamp, signature)
+func (c *engine) OpenStopOrders(ctx context.Context, market string) ([]exchanges.Order, error) {
+	return c.openOrders(ctx, market, "STOP")
+}
 
-	uri := fmt.Sprintf("%s%s?%s", toobitBaseURL, url, queryParams)
-	// Step 2: Create the HTTP request
-	req, err := http.NewRequest(method, uri, bytes.NewReader(requestBody))
+func (c *engine) openOrders(ctx context.Context, market, orderType string) ([]exchanges.Order, error) {
+	var resp []order
+	err := c.call(ctx, "/api/v1/futures/openOrders", http.MethodGet, url.Values{
+		"symbol": {Symbol(market)},
+		"type":   {orderType},
+	}, true, &resp)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
+		return nil, err
 	}
 
-	// Set headers
-	req.Header.Set("X-BB-APIKEY", c.ApiKey)
-	req.Header.Set("Content-Type", "application/json")
-
-	// Step 3: Send the request and handle the response
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send HTTP request: %v", err)
+	orders := make([]exchanges.Order, 0, len(resp))
+	for _, o := range resp {
+		converted, err := c.toOrder(ctx, market, o)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, converted)
 	}
-	defer resp.Body.Close()
+	return orders, nil
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
+type position struct {
+	Symbol        string `json:"symbol"`
+	Side          string `json:"side"` // LONG or SHORT
+	AvgPrice      string `json:"avgPrice"`
+	Position      string `json:"position"`
+	Available     string `json:"available"`
+	Leverage      string `json:"leverage"`
+	LastPrice     string `json:"lastPrice"`
+	UnrealizedPnL string `json:"unrealizedPnL"`
+	TakeProfit    string `json:"takeProfit"`
+	StopLoss      string `json:"stopLoss"`
+}
+
+func (c *engine) positions(ctx context.Context, market string) ([]position, error) {
+	var resp []position
+	err := c.call(ctx, "/api/v1/futures/positions", http.MethodGet, url.Values{
+		"symbol": {Symbol(market)},
+	}, true, &resp)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected response status: %d, body: %s", resp.StatusCode, string(body))
+	// Closed positions are reported with a zero quantity
+	var open []position
+	for _, p := range resp {
+		if quantity, err := strconv.ParseFloat(p.Position, 64); err == nil && quantity != 0 {
+			open = append(open, p)
+		}
 	}
-
-	return body, nil
+	return open, nil
 }
 
-func generateSignature(secret, preparedStr string) string {
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write([]byte(preparedStr))
-	return hex.EncodeToString(mac.Sum(nil))
+func (c *engine) Positions(ctx context.Context, market string) ([]exchanges.Position, error) {
+	resp, err := c.positions(ctx, market)
+	if err != nil {
+		return nil, err
+	}
+
+	positions := make([]exchanges.Position, 0, len(resp))
+	for _, p := range resp {
+		amount, err := c.toAmount(ctx, market, p.Position)
+		if err != nil {
+			return nil, err
+		}
+		side := exchanges.Buy
+		if p.Side == "SHORT" {
+			side = exchanges.Sell
+		}
+		positions = append(positions, exchanges.Position{
+			Market:        market,
+			Side:          side,
+			Amount:        amount,
+			EntryPrice:    p.AvgPrice,
+			TakeProfit:    p.TakeProfit,
+			StopLoss:      p.StopLoss,
+			Leverage:      p.Leverage,
+			UnrealizedPnl: p.UnrealizedPnL,
+		})
+	}
+	return positions, nil
 }
 
-func MapJsonToStruct(myMap map[string]interface{}, s interface{}) error {
-	// Marshal the map to JSON
-	jsonData, err := json.Marshal(myMap)
+func (c *engine) SetPositionTPSL(ctx context.Context, market, takeProfit, stopLoss string) error {
+	positions, err := c.positions(ctx, market)
 	if err != nil {
 		return err
 	}
+	if len(positions) == 0 {
+		return fmt.Errorf("no open position on %s", market)
+	}
 
-	// Unmarshal JSON data into the struct
-	if err := json.Unmarshal(jsonData, s); err != nil {
+	params := url.Values{
+		"symbol": {Symbol(market)},
+		"side":   {positions[0].Side},
+	}
+	if takeProfit != "" {
+		params.Set("takeProfit", takeProfit)
+		params.Set("tpTriggerBy", "MARK_PRICE")
+	}
+	if stopLoss != "" {
+		params.Set("stopLoss", stopLoss)
+		params.Set("slTriggerBy", "MARK_PRICE")
+	}
+	return c.call(ctx, "/api/v1/futures/position/trading-stop", http.MethodPost, params, true, nil)
+}
+
+func (c *engine) ClosePosition(ctx context.Context, market, amount string) error {
+	positions, err := c.positions(ctx, market)
+	if err != nil {
 		return err
 	}
+	if len(positions) == 0 {
+		return fmt.Errorf("no open position on %s", market)
+	}
 
-	return nil
+	quantity := positions[0].Position
+	if amount != "" {
+		if quantity, err = c.toQuantity(ctx, market, amount); err != nil {
+			return err
+		}
+	}
+	// A long position is closed by selling, a short one by buying
+	side := "SELL_CLOSE"
+	if positions[0].Side == "SHORT" {
+		side = "BUY_CLOSE"
+	}
+	return c.call(ctx, "/api/v1/futures/order", http.MethodPost, url.Values{
+		"symbol":           {Symbol(market)},
+		"side":             {side},
+		"type":             {"LIMIT"},
+		"priceType":        {"MARKET"},
+		"quantity":         {quantity},
+		"newClientOrderId": {clientOrderID()},
+	}, true, nil)
 }
 
-type marketInfo struct {
-	Price      string    `json:"price"`
-	ExchangeID int       `json:"exchangeId"`
-	SymbolID   string    `json:"symbolId"`
-	Time       time.Time `json:"time"`
+// toQuantity converts an amount of the base asset to a number of contracts
+func (c *engine) toQuantity(ctx context.Context, market, amount string) (string, error) {
+	multiplier, err := c.multiplier(ctx, market)
+	if err != nil {
+		return "", err
+	}
+	v, err := strconv.ParseFloat(amount, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid amount %q: %v", amount, err)
+	}
+	return strconv.FormatFloat(math.Floor(v/multiplier+1e-9), 'f', 0, 64), nil
 }
 
-func (c *engine) marketInfo(market string) (marketInfo, error) {
-	response, err := c.call("/quote/v1/markPrice", "GET",
-		fmt.Sprintf("symbol=%s", market), nil)
+// toAmount converts a number of contracts to an amount of the base asset
+func (c *engine) toAmount(ctx context.Context, market, quantity string) (string, error) {
+	multiplier, err := c.multiplier(ctx, market)
 	if err != nil {
-		return marketInfo{}, err
+		return "", err
 	}
-	var pos marketInfo
-	err = json.Unmarshal(response, &pos)
+	v, err := strconv.ParseFloat(quantity, 64)
 	if err != nil {
-		return marketInfo{}, err
+		return "", fmt.Errorf("invalid quantity %q: %v", quantity, err)
 	}
+	return strconv.FormatFloat(v*multiplier, 'f', -1, 64), nil
+}
 
-	return pos, nil
+func (c *engine) multiplier(ctx context.Context, market string) (float64, error) {
+	ct, err := c.contract(ctx, market)
+	if err != nil {
+		return 0, err
+	}
+	multiplier, err := strconv.ParseFloat(ct.ContractMultiplier, 64)
+	if err != nil || multiplier <= 0 {
+		return 0, fmt.Errorf("invalid contract multiplier %q", ct.ContractMultiplier)
+	}
+	return multiplier, nil
 }
 
-type balance struct {
-	Asset              string `json:"asset"`
-	Balance            string `json:"balance"`
-	AvailableBalance   string `json:"availableBalance"`
-	PositionMargin     string `json:"positionMargin"`
-	OrderMargin        string `json:"orderMargin"`
-	CrossUnRealizedPnl string `json:"crossUnRealizedPnl"`
+type apiError struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
 }
 
-func (c *engine) balances() ([]balance, error) {
-	response, err := c.call("/api/v1/futures/balance", "GET", "", nil)
+// call sends every parameter in the query string, signed ones get the timestamp and signature appended
+func (c *engine) call(ctx context.Context, path, method string, params url.Values, signed bool, out interface{}) error {
+	queryParams := params.Encode()
+	if signed {
+		params.Set("timestamp", strconv.FormatInt(time.Now().UnixMilli(), 10))
+		params.Set("recvWindow", recvWindow)
+		queryParams = params.Encode()
+		queryParams += "&signature=" + generateSignature(c.SecretKey, queryParams)
+	}
+
+	uri := fmt.Sprintf("%s%s", c.baseURL, path)
+	if queryParams != "" {
+		uri += "?" + queryParams
+	}
+	req, err := http.NewRequestWithContext(ctx, method, uri, nil)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to create HTTP request: %v", err)
 	}
+	req.Header.Set("X-BB-APIKEY", c.ApiKey)
 
-	var resp2 []interface{}
-	err = json.Unmarshal(response, &resp2)
+	client := &http.Client{}
+	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to send HTTP request: %v", err)
 	}
+	defer resp.Body.Close()
 
-	var resp []balance
-	for _, v := range resp2 {
-		var pos balance
-		err = MapJsonToStruct(v.(map[string]interface{}), &pos)
-		if err != nil {
-			return nil, err
-		}
-		resp = append(resp, pos)
-	}
-
-	return resp, nil
-}
-
-type CreateStopOrder struct {
-	Market           string  `json:"symbol"`
-	MarketType       string  `json:"side"` // BUY_OPEN、SELL_OPEN、BUY_CLOSE、SELL_CLOSE
-	Side             string  `json:"type"` // LIMIT or STOP
-	Type             float64 `json:"quantity"`
-	Amount           int     `json:"price"`
-	TriggerPriceType string  `json:"priceType"`
-	//TriggerPrice     string  `json:"takeProfit"`
-	//TriggerPrice     string  `json:"tpTriggerBy"`
-	//TriggerPrice     string  `json:"stopLoss"`
-	//TriggerPrice     string  `json:"takeProfit"`
-	//TriggerPrice     string  `json:"takeProfit"`
-}
-
-func (c *engine) placeStopOrder(side, market, amount, target string) (string, error) {
-	req := CreateStopOrder{
-		Market:           market,
-		MarketType:       "FUTURES",
-		Side:             side,
-		Type:             "market",
-		Amount:           amount,
-		TriggerPriceType: "mark_price",
-		TriggerPrice:     target,
-	}
-	rr, _ := json.Marshal(req)
-	response, err := c.call("/api/v1/futures/order", "POST", "", rr)
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("failed to read response body: %v", err)
 	}
 
-	resp2, ok := response.(map[string]interface{})
-	if !ok {
-		return "", errors.New("response is not a map")
+	// Errors come as {"code": -1121, "msg": "Invalid symbol."}, sometimes with a 200 status
+	var apiErr apiError
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Code != 0 && apiErr.Code != 200 {
+		return fmt.Errorf("got error on calling: %d %s", apiErr.Code, apiErr.Msg)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status: %d, body: %s", resp.StatusCode, string(body))
 	}
 
-	var resp placeStopOrderResponse
-	err = MapJsonToStruct(resp2, &resp)
-	if err != nil {
-		return "", err
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %v", err)
 	}
+	return nil
+}
+
+func generateSignature(secret, preparedStr string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(preparedStr))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// precision returns the number of decimal places of a step like 0.001
+func precision(step string) int {
+	step = strings.TrimRight(step, "0")
+	if i := strings.IndexByte(step, '.'); i >= 0 {
+		return len(step) - i - 1
+	}
+	return 0
+}
 
-	return strconv.FormatInt(resp.StopId, 10), nil
+func clientOrderID() string {
+	return "teletrade-" + strconv.FormatInt(time.Now().UnixNano(), 36)
 }
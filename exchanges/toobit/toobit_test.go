@@ -0,0 +1,224 @@
+package toobit_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/moneyscripter/teletrade/exchanges"
+	"github.com/moneyscripter/teletrade/exchanges/toobit"
+	"github.com/moneyscripter/teletrade/exchanges/toobit/toobittest"
+)
+
+const symbol = "BTC-SWAP-USDT"
+
+func newEngine(t *testing.T) (exchanges.Exchanges, *toobittest.Server) {
+	t.Helper()
+	server := toobittest.NewServer("key", "secret")
+	t.Cleanup(server.Close)
+	server.AddContract(toobittest.Contract{
+		Symbol:             symbol,
+		ContractMultiplier: 0.001,
+		MinQty:             1,
+		StepSize:           1,
+		TickSize:           0.1,
+		MaxLeverage:        50,
+	})
+	server.SetPrice(symbol, 100)
+	return toobit.NewToobitEngineWithBaseURL("key", "secret", server.URL), server
+}
+
+func TestMarketInfo(t *testing.T) {
+	engine, _ := newEngine(t)
+	info, err := engine.MarketInfo(context.Background(), "BTCUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.MinAmount != "0.001" || info.AmountPrecision != 3 || info.PricePrecision != 1 || info.MaxLeverage != 50 {
+		t.Fatalf("unexpected market info %+v", info)
+	}
+}
+
+func TestEntryAndTPSL(t *testing.T) {
+	ctx := context.Background()
+	engine, server := newEngine(t)
+
+	if err := engine.SetLeverage(ctx, "BTCUSDT", 10, exchanges.Isolated); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.Leverage(symbol); got != 10 {
+		t.Fatalf("leverage is %d, want 10", got)
+	}
+
+	// A long entering above the price waits for the price to rise
+	entry, err := engine.PlaceStopOrder(ctx, exchanges.StopOrderRequest{
+		Market:       "BTCUSDT",
+		Side:         exchanges.Buy,
+		Type:         exchanges.MarketOrder,
+		Amount:       "0.5",
+		TriggerPrice: "110",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Amount != "0.5" || entry.TriggerPrice != "110" || entry.Side != exchanges.Buy {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+	orders := server.Orders()
+	if len(orders) != 1 || orders[0].Side != "BUY_OPEN" || orders[0].Quantity != 500 {
+		t.Fatalf("unexpected orders %+v", orders)
+	}
+	if _, ok := server.Position(symbol); ok {
+		t.Fatal("position opened before the entry is reached")
+	}
+
+	server.SetPrice(symbol, 111)
+	positions, err := engine.Positions(ctx, "BTCUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(positions) != 1 || positions[0].Side != exchanges.Buy || positions[0].Amount != "0.5" {
+		t.Fatalf("unexpected positions %+v", positions)
+	}
+
+	if err := engine.SetPositionTPSL(ctx, "BTCUSDT", "130", "105"); err != nil {
+		t.Fatal(err)
+	}
+	p, _ := server.Position(symbol)
+	if p.TakeProfit != 130 || p.StopLoss != 105 {
+		t.Fatalf("unexpected TP/SL %+v", p)
+	}
+
+	// Moving the stop to the entry leaves the take profit untouched
+	if err := engine.SetPositionTPSL(ctx, "BTCUSDT", "", "111"); err != nil {
+		t.Fatal(err)
+	}
+	p, _ = server.Position(symbol)
+	if p.TakeProfit != 130 || p.StopLoss != 111 {
+		t.Fatalf("unexpected TP/SL %+v", p)
+	}
+
+	server.SetPrice(symbol, 110)
+	if _, ok := server.Position(symbol); ok {
+		t.Fatal("position is not closed by its stop loss")
+	}
+}
+
+func TestClosePosition(t *testing.T) {
+	ctx := context.Background()
+	engine, server := newEngine(t)
+
+	_, err := engine.PlaceOrder(ctx, exchanges.OrderRequest{
+		Market: "BTCUSDT",
+		Side:   exchanges.Sell,
+		Type:   exchanges.MarketOrder,
+		Amount: "1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := server.Position(symbol)
+	if !ok || p.Side != "SHORT" || p.Quantity != 1000 {
+		t.Fatalf("unexpected position %+v", p)
+	}
+
+	if err := engine.ClosePosition(ctx, "BTCUSDT", "0.4"); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := server.Position(symbol); p.Quantity != 600 {
+		t.Fatalf("position is %v contracts after a partial close, want 600", p.Quantity)
+	}
+	if err := engine.ClosePosition(ctx, "BTCUSDT", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Position(symbol); ok {
+		t.Fatal("position is still open")
+	}
+	if err := engine.ClosePosition(ctx, "BTCUSDT", ""); err == nil {
+		t.Fatal("closing no position succeeded")
+	}
+}
+
+func TestCancelAndAmend(t *testing.T) {
+	ctx := context.Background()
+	engine, _ := newEngine(t)
+
+	limit, err := engine.PlaceOrder(ctx, exchanges.OrderRequest{
+		Market: "BTCUSDT",
+		Side:   exchanges.Buy,
+		Type:   exchanges.LimitOrder,
+		Amount: "0.2",
+		Price:  "95",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop, err := engine.PlaceStopOrder(ctx, exchanges.StopOrderRequest{
+		Market:       "BTCUSDT",
+		Side:         exchanges.Sell,
+		Type:         exchanges.MarketOrder,
+		Amount:       "0.2",
+		TriggerPrice: "90",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	amended, err := engine.AmendOrder(ctx, "BTCUSDT", limit.ID, "", "96")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amended.ID == limit.ID || amended.Price != "96" || amended.Amount != "0.2" {
+		t.Fatalf("unexpected amended order %+v", amended)
+	}
+	amendedStop, err := engine.AmendStopOrder(ctx, "BTCUSDT", stop.ID, "0.1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amendedStop.TriggerPrice != "90" || amendedStop.Amount != "0.1" {
+		t.Fatalf("unexpected amended stop order %+v", amendedStop)
+	}
+
+	assertOpen := func(want, wantStop int) {
+		t.Helper()
+		orders, err := engine.OpenOrders(ctx, "BTCUSDT")
+		if err != nil {
+			t.Fatal(err)
+		}
+		stops, err := engine.OpenStopOrders(ctx, "BTCUSDT")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(orders) != want || len(stops) != wantStop {
+			t.Fatalf("%d orders and %d stop orders are open, want %d and %d", len(orders), len(stops), want, wantStop)
+		}
+	}
+	assertOpen(1, 1)
+
+	if err := engine.CancelOrder(ctx, "BTCUSDT", amended.ID); err != nil {
+		t.Fatal(err)
+	}
+	assertOpen(0, 1)
+	// A stop order is not canceled as a limit one
+	if err := engine.CancelOrder(ctx, "BTCUSDT", amendedStop.ID); err == nil {
+		t.Fatal("stop order canceled as a limit order")
+	}
+	if err := engine.CancelStopOrder(ctx, "BTCUSDT", amendedStop.ID); err != nil {
+		t.Fatal(err)
+	}
+	assertOpen(0, 0)
+}
+
+func TestSignatureRejected(t *testing.T) {
+	_, server := newEngine(t)
+	engine := toobit.NewToobitEngineWithBaseURL("key", "wrong secret", server.URL)
+
+	_, err := engine.Balance(context.Background(), "USDT")
+	if err == nil || !strings.Contains(err.Error(), "-1022") {
+		t.Fatalf("got %v, want a signature error", err)
+	}
+	// Public endpoints need no signature
+	if _, err := engine.Ticker(context.Background(), "BTCUSDT"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,525 @@
+// Package toobittest provides an in-memory fake of the Toobit USDT-M futures REST API,
+// to run the Toobit engine offline. Stop orders, position TP/SL and market orders
+// are filled against the prices set with SetPrice.
+package toobittest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Contract struct {
+	Symbol             string // e.g. BTC-SWAP-USDT
+	ContractMultiplier float64
+	MinQty             float64
+	StepSize           float64
+	TickSize           float64
+	MaxLeverage        int
+}
+
+type Order struct {
+	OrderID   string
+	Symbol    string
+	Side      string // BUY_OPEN, SELL_OPEN, BUY_CLOSE, SELL_CLOSE
+	Type      string // LIMIT or STOP
+	PriceType string // INPUT or MARKET
+	Quantity  float64
+	Price     float64
+	StopPrice float64
+	triggerUp bool // stop triggers when the price rises to StopPrice
+	ClientID  string
+	Status    string
+}
+
+type Position struct {
+	Symbol     string
+	Side       string // LONG or SHORT
+	Quantity   float64
+	AvgPrice   float64
+	Leverage   int
+	TakeProfit float64
+	StopLoss   float64
+}
+
+// Server is a fake Toobit exchange, requests must be signed with APIKey and SecretKey
+type Server struct {
+	*httptest.Server
+
+	APIKey    string
+	SecretKey string
+
+	mutex      *sync.Mutex
+	contracts  map[string]Contract
+	prices     map[string]float64
+	balance    float64
+	leverage   map[string]int
+	marginType map[string]string
+	orders     map[string]*Order
+	positions  map[string]*Position
+	nextID     int64
+}
+
+// NewServer starts a fake exchange with 1000 USDT of available balance
+func NewServer(apiKey, secretKey string) *Server {
+	s := &Server{
+		APIKey:     apiKey,
+		SecretKey:  secretKey,
+		mutex:      &sync.Mutex{},
+		contracts:  make(map[string]Contract),
+		prices:     make(map[string]float64),
+		balance:    1000,
+		leverage:   make(map[string]int),
+		marginType: make(map[string]string),
+		orders:     make(map[string]*Order),
+		positions:  make(map[string]*Position),
+		nextID:     1000,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/quote/v1/markPrice", s.public(s.markPrice))
+	mux.HandleFunc("/quote/v1/ticker/price", s.public(s.tickerPrice))
+	mux.HandleFunc("/api/v1/exchangeInfo", s.public(s.exchangeInfo))
+	mux.HandleFunc("/api/v1/futures/balance", s.signed(s.balances))
+	mux.HandleFunc("/api/v1/futures/marginType", s.signed(s.setMarginType))
+	mux.HandleFunc("/api/v1/futures/leverage", s.signed(s.setLeverage))
+	mux.HandleFunc("/api/v1/futures/order", s.signed(s.order))
+	mux.HandleFunc("/api/v1/futures/batchOrders", s.signed(s.cancelAll))
+	mux.HandleFunc("/api/v1/futures/openOrders", s.signed(s.openOrders))
+	mux.HandleFunc("/api/v1/futures/positions", s.signed(s.positionList))
+	mux.HandleFunc("/api/v1/futures/position/trading-stop", s.signed(s.tradingStop))
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *Server) AddContract(c Contract) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.contracts[c.Symbol] = c
+}
+
+func (s *Server) SetBalance(available float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.balance = available
+}
+
+// SetPrice moves the mark and last price of symbol, filling every stop order and TP/SL it crosses
+func (s *Server) SetPrice(symbol string, price float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.prices[symbol] = price
+	for _, o := range s.sortedOrders() {
+		if o.Symbol != symbol || o.Type != "STOP" || o.Status != "NEW" {
+			continue
+		}
+		if (o.triggerUp && price >= o.StopPrice) || (!o.triggerUp && price <= o.StopPrice) {
+			o.Status = "FILLED"
+			s.fill(o.Symbol, o.Side, o.Quantity, price)
+		}
+	}
+
+	p, ok := s.positions[symbol]
+	if !ok {
+		return
+	}
+	long := p.Side == "LONG"
+	hitTP := p.TakeProfit > 0 && ((long && price >= p.TakeProfit) || (!long && price <= p.TakeProfit))
+	hitSL := p.StopLoss > 0 && ((long && price <= p.StopLoss) || (!long && price >= p.StopLoss))
+	if hitTP || hitSL {
+		side := "SELL_CLOSE"
+		if !long {
+			side = "BUY_CLOSE"
+		}
+		s.fill(symbol, side, p.Quantity, price)
+	}
+}
+
+func (s *Server) Balance() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.balance
+}
+
+// Position returns a copy of the open position of symbol
+func (s *Server) Position(symbol string) (Position, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, ok := s.positions[symbol]
+	if !ok {
+		return Position{}, false
+	}
+	return *p, true
+}
+
+// Orders returns a copy of every order ever placed
+func (s *Server) Orders() []Order {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var orders []Order
+	for _, o := range s.sortedOrders() {
+		orders = append(orders, *o)
+	}
+	return orders
+}
+
+func (s *Server) Leverage(symbol string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.leverage[symbol]
+}
+
+func (s *Server) sortedOrders() []*Order {
+	orders := make([]*Order, 0, len(s.orders))
+	for id := int64(1000); id <= s.nextID; id++ {
+		if o, ok := s.orders[strconv.FormatInt(id, 10)]; ok {
+			orders = append(orders, o)
+		}
+	}
+	return orders
+}
+
+// fill applies an executed order to the position of symbol and the balance
+func (s *Server) fill(symbol, side string, quantity, price float64) {
+	c := s.contracts[symbol]
+	leverage := s.leverage[symbol]
+	if leverage == 0 {
+		leverage = 1
+	}
+	positionSide := "LONG"
+	if side == "SELL_OPEN" || side == "BUY_CLOSE" {
+		positionSide = "SHORT"
+	}
+
+	p, ok := s.positions[symbol]
+	if strings.HasSuffix(side, "_OPEN") {
+		s.balance -= quantity * c.ContractMultiplier * price / float64(leverage)
+		if !ok {
+			s.positions[symbol] = &Position{
+				Symbol:   symbol,
+				Side:     positionSide,
+				Quantity: quantity,
+				AvgPrice: price,
+				Leverage: leverage,
+			}
+			return
+		}
+		p.AvgPrice = (p.AvgPrice*p.Quantity + price*quantity) / (p.Quantity + quantity)
+		p.Quantity += quantity
+		return
+	}
+
+	if !ok || p.Side != positionSide {
+		return
+	}
+	quantity = math.Min(quantity, p.Quantity)
+	pnl := (price - p.AvgPrice) * quantity * c.ContractMultiplier
+	if p.Side == "SHORT" {
+		pnl = -pnl
+	}
+	s.balance += quantity*c.ContractMultiplier*p.AvgPrice/float64(p.Leverage) + pnl
+	p.Quantity -= quantity
+	if p.Quantity <= 0 {
+		delete(s.positions, symbol)
+	}
+}
+
+type handler func(w http.ResponseWriter, r *http.Request)
+
+func (s *Server) public(h handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		h(w, r)
+	}
+}
+
+// signed checks the api key and the signature of the query string before calling h
+func (s *Server) signed(h handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-BB-APIKEY") != s.APIKey {
+			writeError(w, -2015, "Invalid API-key")
+			return
+		}
+		query, signature, ok := strings.Cut(r.URL.RawQuery, "&signature=")
+		mac := hmac.New(sha256.New, []byte(s.SecretKey))
+		mac.Write([]byte(query))
+		if !ok || !hmac.Equal([]byte(signature), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+			writeError(w, -1022, "Signature for this request is not valid.")
+			return
+		}
+		if r.URL.Query().Get("timestamp") == "" {
+			writeError(w, -1102, "Mandatory parameter 'timestamp' was not sent")
+			return
+		}
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		h(w, r)
+	}
+}
+
+func (s *Server) markPrice(w http.ResponseWriter, r *http.Request) {
+	symbol := r.URL.Query().Get("symbol")
+	price, ok := s.prices[symbol]
+	if !ok {
+		writeError(w, -1121, "Invalid symbol.")
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"exchangeId": 301,
+		"symbolId":   symbol,
+		"price":      formatFloat(price),
+		"time":       0,
+	})
+}
+
+func (s *Server) tickerPrice(w http.ResponseWriter, r *http.Request) {
+	symbol := r.URL.Query().Get("symbol")
+	price, ok := s.prices[symbol]
+	if !ok {
+		writeError(w, -1121, "Invalid symbol.")
+		return
+	}
+	writeJSON(w, []map[string]string{{"s": symbol, "p": formatFloat(price)}})
+}
+
+func (s *Server) exchangeInfo(w http.ResponseWriter, r *http.Request) {
+	var contracts []map[string]interface{}
+	for _, c := range s.contracts {
+		maxLeverage := c.MaxLeverage
+		if maxLeverage == 0 {
+			maxLeverage = 20
+		}
+		contracts = append(contracts, map[string]interface{}{
+			"symbol":             c.Symbol,
+			"status":             "TRADING",
+			"quoteAsset":         "USDT",
+			"contractMultiplier": formatFloat(c.ContractMultiplier),
+			"underlying":         strings.Split(c.Symbol, "-")[0],
+			"filters": []map[string]string{
+				{"filterType": "PRICE_FILTER", "tickSize": formatFloat(c.TickSize)},
+				{"filterType": "LOT_SIZE", "minQty": formatFloat(c.MinQty), "stepSize": formatFloat(c.StepSize)},
+			},
+			"riskLimits": []map[string]string{
+				{"riskLimitId": "1", "quantity": "1000", "initialMargin": formatFloat(1 / float64(maxLeverage)), "maintMargin": "0.005"},
+			},
+		})
+	}
+	writeJSON(w, map[string]interface{}{"contracts": contracts})
+}
+
+func (s *Server) balances(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, []map[string]string{{
+		"asset":              "USDT",
+		"balance":            formatFloat(s.balance),
+		"availableBalance":   formatFloat(s.balance),
+		"positionMargin":     "0",
+		"orderMargin":        "0",
+		"crossUnRealizedPnl": "0",
+	}})
+}
+
+func (s *Server) setMarginType(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if _, ok := s.contracts[q.Get("symbol")]; !ok {
+		writeError(w, -1121, "Invalid symbol.")
+		return
+	}
+	s.marginType[q.Get("symbol")] = q.Get("marginType")
+	writeJSON(w, map[string]interface{}{"code": 200, "symbolId": q.Get("symbol"), "marginType": q.Get("marginType")})
+}
+
+func (s *Server) setLeverage(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	c, ok := s.contracts[q.Get("symbol")]
+	if !ok {
+		writeError(w, -1121, "Invalid symbol.")
+		return
+	}
+	leverage, err := strconv.Atoi(q.Get("leverage"))
+	if err != nil || leverage < 1 || (c.MaxLeverage > 0 && leverage > c.MaxLeverage) {
+		writeError(w, -2013, "Invalid leverage.")
+		return
+	}
+	s.leverage[q.Get("symbol")] = leverage
+	writeJSON(w, map[string]interface{}{"code": 200, "symbolId": q.Get("symbol"), "leverage": q.Get("leverage")})
+}
+
+func (s *Server) order(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.newOrder(w, r)
+	case http.MethodDelete:
+		o, ok := s.orders[r.URL.Query().Get("orderId")]
+		if !ok || o.Status != "NEW" || o.Type != r.URL.Query().Get("type") {
+			writeError(w, -2013, "Order does not exist.")
+			return
+		}
+		o.Status = "CANCELED"
+		writeJSON(w, orderJSON(o))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) newOrder(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	symbol := q.Get("symbol")
+	c, ok := s.contracts[symbol]
+	if !ok {
+		writeError(w, -1121, "Invalid symbol.")
+		return
+	}
+	quantity, err := strconv.ParseFloat(q.Get("quantity"), 64)
+	if err != nil || quantity < c.MinQty {
+		writeError(w, -1013, "Invalid quantity.")
+		return
+	}
+	side := q.Get("side")
+	switch side {
+	case "BUY_OPEN", "SELL_OPEN", "BUY_CLOSE", "SELL_CLOSE":
+	default:
+		writeError(w, -1117, "Invalid side.")
+		return
+	}
+
+	s.nextID++
+	o := &Order{
+		OrderID:   strconv.FormatInt(s.nextID, 10),
+		Symbol:    symbol,
+		Side:      side,
+		Type:      q.Get("type"),
+		PriceType: q.Get("priceType"),
+		Quantity:  quantity,
+		ClientID:  q.Get("newClientOrderId"),
+		Status:    "NEW",
+	}
+	o.Price, _ = strconv.ParseFloat(q.Get("price"), 64)
+
+	switch o.Type {
+	case "STOP":
+		o.StopPrice, err = strconv.ParseFloat(q.Get("stopPrice"), 64)
+		if err != nil {
+			writeError(w, -1102, "Mandatory parameter 'stopPrice' was not sent")
+			return
+		}
+		o.triggerUp = o.StopPrice >= s.prices[symbol]
+	case "LIMIT":
+		if o.PriceType == "MARKET" {
+			o.Status = "FILLED"
+			s.fill(symbol, side, quantity, s.prices[symbol])
+		}
+	default:
+		writeError(w, -1116, "Invalid orderType.")
+		return
+	}
+	s.orders[o.OrderID] = o
+	writeJSON(w, orderJSON(o))
+}
+
+func (s *Server) cancelAll(w http.ResponseWriter, r *http.Request) {
+	symbol := r.URL.Query().Get("symbol")
+	for _, o := range s.orders {
+		if o.Symbol == symbol && o.Status == "NEW" {
+			o.Status = "CANCELED"
+		}
+	}
+	writeJSON(w, map[string]interface{}{"code": 200, "message": "success"})
+}
+
+func (s *Server) openOrders(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	orders := []map[string]interface{}{}
+	for _, o := range s.sortedOrders() {
+		if o.Symbol == q.Get("symbol") && o.Type == q.Get("type") && o.Status == "NEW" {
+			orders = append(orders, orderJSON(o))
+		}
+	}
+	writeJSON(w, orders)
+}
+
+func (s *Server) positionList(w http.ResponseWriter, r *http.Request) {
+	positions := []map[string]string{}
+	for symbol, p := range s.positions {
+		if q := r.URL.Query().Get("symbol"); q != "" && q != symbol {
+			continue
+		}
+		positions = append(positions, map[string]string{
+			"symbol":        p.Symbol,
+			"side":          p.Side,
+			"avgPrice":      formatFloat(p.AvgPrice),
+			"position":      formatFloat(p.Quantity),
+			"available":     formatFloat(p.Quantity),
+			"leverage":      strconv.Itoa(p.Leverage),
+			"lastPrice":     formatFloat(s.prices[symbol]),
+			"unrealizedPnL": "0",
+			"takeProfit":    formatFloat(p.TakeProfit),
+			"stopLoss":      formatFloat(p.StopLoss),
+		})
+	}
+	writeJSON(w, positions)
+}
+
+func (s *Server) tradingStop(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	p, ok := s.positions[q.Get("symbol")]
+	if !ok || p.Side != q.Get("side") {
+		writeError(w, -2013, "Position does not exist.")
+		return
+	}
+	if v := q.Get("takeProfit"); v != "" {
+		p.TakeProfit, _ = strconv.ParseFloat(v, 64)
+	}
+	if v := q.Get("stopLoss"); v != "" {
+		p.StopLoss, _ = strconv.ParseFloat(v, 64)
+	}
+	writeJSON(w, map[string]interface{}{"code": 200, "symbol": p.Symbol})
+}
+
+func orderJSON(o *Order) map[string]interface{} {
+	return map[string]interface{}{
+		"orderId":     o.OrderID,
+		"symbol":      o.Symbol,
+		"price":       formatFloat(o.Price),
+		"origQty":     formatFloat(o.Quantity),
+		"executedQty": "0",
+		"status":      o.Status,
+		"type":        o.Type,
+		"side":        o.Side,
+		"priceType":   o.PriceType,
+		"stopPrice":   formatFloat(o.StopPrice),
+	}
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_, _ = fmt.Fprintf(w, `{"code":%d,"msg":%q}`, code, msg)
+}
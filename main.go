@@ -3,79 +3,360 @@ package main
 import (
 	"context"
 	"fmt"
-	"github.com/moneyscripter/teletrade/channels/CryptoTrade066"
+	"github.com/moneyscripter/teletrade/backfill"
+	"github.com/moneyscripter/teletrade/channels"
+	_ "github.com/moneyscripter/teletrade/channels/CryptoTrade066"
+	"github.com/moneyscripter/teletrade/channels/template"
 	"github.com/moneyscripter/teletrade/config"
+	"github.com/moneyscripter/teletrade/dedup"
 	"github.com/moneyscripter/teletrade/exchanges"
+	"github.com/moneyscripter/teletrade/exchanges/binance"
+	"github.com/moneyscripter/teletrade/exchanges/bybit"
 	"github.com/moneyscripter/teletrade/exchanges/coinex"
+	"github.com/moneyscripter/teletrade/exchanges/paper"
+	"github.com/moneyscripter/teletrade/exchanges/strategy"
+	"github.com/moneyscripter/teletrade/exchanges/toobit"
+	"github.com/moneyscripter/teletrade/models"
+	"github.com/moneyscripter/teletrade/router"
+	"github.com/moneyscripter/teletrade/scheduler"
+	"github.com/moneyscripter/teletrade/secrets"
 	"github.com/moneyscripter/teletrade/telegram_engine/bot"
 	"github.com/moneyscripter/teletrade/telegram_engine/client"
+	"github.com/moneyscripter/teletrade/trades"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"time"
 )
 
-func main() {
-	configPath := os.Getenv("CONFIG_PATH")
-	config.LoadConfig(configPath)
+// run trades the signals of the channels for the subscribed users until interrupted
+func run(cli cliOptions) {
+	// Master key used to seal exchange credentials
+	masterKey, err := secrets.LoadMasterKey(config.AppConfig.Secrets.MasterKey, config.AppConfig.Secrets.MasterKeyFile)
+	if err != nil {
+		panic(fmt.Errorf("fatal error master key: %w", err))
+	}
+	sealer, err := secrets.NewSealer(masterKey)
+	if err != nil {
+		panic(fmt.Errorf("fatal error master key: %w", err))
+	}
+
+	// Subscribed users storage
+	dbPath := config.AppConfig.TelegramBot.DBPath
+	if dbPath == "" {
+		dbPath = "users.bolt.db"
+	}
+	userStore, err := bot.NewBoltUserStore(dbPath)
+	if err != nil {
+		panic(fmt.Errorf("fatal error user store: %w", err))
+	}
+	defer userStore.Close()
+	if err = bot.UseStore(userStore, sealer); err != nil {
+		panic(fmt.Errorf("fatal error loading users: %w", err))
+	}
+
+	// Channels parsed from the templates of the config are registered along the built-in ones
+	if templatesDir := config.AppConfig.Channels.TemplatesDir; templatesDir != "" {
+		channelTemplates, err := template.LoadDir(templatesDir)
+		if err != nil {
+			panic(fmt.Errorf("fatal error channel templates: %w", err))
+		}
+		for _, channelTemplate := range channelTemplates {
+			if err := template.Register(channelTemplate); err != nil {
+				panic(fmt.Errorf("fatal error channel templates: %w", err))
+			}
+		}
+	}
 
 	// Telegram Bot
-	bot.Run(config.AppConfig.TelegramBot.Token)
+	go func() {
+		if err := bot.Run(config.AppConfig.TelegramBot.Token); err != nil {
+			fmt.Printf("bot error: %v\n", err)
+		}
+	}()
+
+	// Subscription router, decides which users receive the signals of each channel
+	signalRouter := router.NewRouter()
+
 	var receivingChannels []client.ReceivingChannel
-	cryptoTrade006Channel, cryptoTrade006ChannelID := CryptoTrade066.NewCryptoTrade0066()
-	receivingChannels = append(receivingChannels, client.ReceivingChannel{
-		Chan:      make(chan string, 1000),
-		ChannelID: cryptoTrade006ChannelID,
-		Parser:    cryptoTrade006Channel,
-	})
+	for _, registered := range channels.Registered() {
+		parser, err := registered.New()
+		if err != nil {
+			panic(fmt.Errorf("fatal error channel %s: %w", registered.Name, err))
+		}
+		receivingChannels = append(receivingChannels, client.ReceivingChannel{
+			Name:      registered.Name,
+			Chan:      make(chan client.Message, 1000),
+			ChannelID: registered.ChannelID,
+			Username:  registered.Username,
+			Link:      registered.URL,
+			Parser:    parser,
+			Source:    client.SourceKind(registered.Source),
+			TopicID:   registered.TopicID,
+			Authors:   registered.Authors,
+		})
+	}
 
-	phone := config.AppConfig.TelegramClient.Phone
-	appID := config.AppConfig.TelegramClient.AppID
-	appHash := config.AppConfig.TelegramClient.AppHash
+	// Listening accounts, each channel is received once whatever the number of accounts seeing it
+	telegramClient := config.AppConfig.TelegramClient
+	accounts := telegramAccounts(cli)
+	telegramPool, err := client.NewPool(accounts, receivingChannels)
+	if err != nil {
+		panic(fmt.Errorf("fatal error telegram accounts: %w", err))
+	}
+	// Headless login, the code and password are asked to the admins of the bot or over HTTP
+	loginConfig := telegramClient.Login
+	var loginBroker *client.LoginBroker
+	if admins := config.AppConfig.TelegramBot.Admins; len(admins) > 0 || loginConfig.HTTPAddr != "" {
+		loginBroker = client.NewLoginBroker()
+		loginBroker.Notify = func(request client.LoginRequest) {
+			fmt.Printf("login %s of %s is waiting for an answer\n", request.Question, request.Phone)
+			bot.AskAdmins(request.Phone, string(request.Question), request.Attempt)
+		}
+		loginBroker.Done = func(request client.LoginRequest) {
+			bot.LoginDone(request.Phone)
+		}
+		bot.UseLogin(admins, loginBroker.Answer)
+	}
+	if loginBroker != nil && loginConfig.HTTPAddr != "" {
+		if loginConfig.HTTPToken == "" {
+			panic(fmt.Errorf("fatal error login endpoint: telegram_client.login.http_token is missing"))
+		}
+		go func() {
+			if err := http.ListenAndServe(loginConfig.HTTPAddr, loginBroker.Handler(loginConfig.HTTPToken)); err != nil {
+				fmt.Printf("login endpoint error: %v\n", err)
+			}
+		}()
+	}
 
-	telegramEngine := client.Engine{
-		Phone:             phone,
-		AppID:             appID,
-		AppHash:           appHash,
-		ReceivingChannels: receivingChannels,
+	// Messages missed while the accounts were down are read from the history of the channels
+	backfillConfig := config.AppConfig.Backfill
+	backfillMode, err := backfill.ParseMode(backfillConfig.Mode)
+	if err != nil {
+		panic(fmt.Errorf("fatal error backfill: %w", err))
+	}
+	var backfillStore *backfill.Store
+	if backfillMode != backfill.Off {
+		backfillDBPath := backfillConfig.DBPath
+		if backfillDBPath == "" {
+			backfillDBPath = "backfill.bolt.db"
+		}
+		backfillStore, err = backfill.NewBoltStore(backfillDBPath)
+		if err != nil {
+			panic(fmt.Errorf("fatal error backfill store: %w", err))
+		}
+		defer backfillStore.Close()
+	}
+
+	for _, engine := range telegramPool.Engines() {
+		if backfillStore != nil {
+			engine.LastMessageID = func(channelKey string) int {
+				lastID, err := backfillStore.LastID(channelKey)
+				if err != nil {
+					fmt.Printf("failed to read the last message of channel %s: %v\n", channelKey, err)
+				}
+				return lastID
+			}
+		}
+		engine.LoginRetries = loginConfig.Retries
+		if loginBroker != nil {
+			engine.Authenticator = &client.RemoteAuth{
+				PhoneNumber: engine.Phone,
+				Broker:      loginBroker,
+				Timeout:     loginConfig.Timeout,
+			}
+		}
 	}
 
 	ct := context.Background()
 	ctx, cancelFunc := context.WithCancel(ct)
-	go func() {
-		for {
-			err := telegramEngine.Run(ctx)
-			if err != nil {
-				fmt.Printf("error: %v", err)
-			}
-			time.Sleep(2 * time.Second)
+	go telegramPool.Run(ctx)
+
+	// Every (user, signal) trade runs as its own job, so an open trade never stalls the others
+	executionConfig := config.AppConfig.Execution
+	executor := scheduler.NewScheduler(executionConfig.Workers, executionConfig.PerUser, executionConfig.QueueSize)
+	executor.OnDone = func(job *scheduler.Job, err error) {
+		if err != nil {
+			fmt.Printf("trade %d of chat id %d failed: %v\n", job.ID, job.ChatID, err)
+			return
 		}
-	}()
+		fmt.Printf("trade %d of chat id %d is done\n", job.ID, job.ChatID)
+	}
+	executor.Start(ctx)
+
+	// Trades journal, unfinished trades of the previous run are resumed once their exchange is ready
+	tradesDBPath := executionConfig.DBPath
+	if tradesDBPath == "" {
+		tradesDBPath = "trades.bolt.db"
+	}
+	tradeStore, err := trades.NewBoltStore(tradesDBPath)
+	if err != nil {
+		panic(fmt.Errorf("fatal error trade store: %w", err))
+	}
+	defer tradeStore.Close()
+	reconciler, err := trades.NewReconciler(tradeStore)
+	if err != nil {
+		panic(fmt.Errorf("fatal error loading trades: %w", err))
+	}
 
-	var contextMap map[int64][]context.CancelFunc
-	var exchangeMap map[int64]exchanges.Exchanges
+	// Simulated wallets of the users trading on the Paper exchange
+	paperConfig := config.AppConfig.Paper
+	paperDBPath := paperConfig.DBPath
+	if paperDBPath == "" {
+		paperDBPath = "paper.bolt.db"
+	}
+	initialBalance := paperConfig.InitialBalance
+	if initialBalance <= 0 {
+		initialBalance = 1000
+	}
+	paperAccounts, err := paper.NewBoltAccounts(paperDBPath, initialBalance)
+	if err != nil {
+		panic(fmt.Errorf("fatal error paper accounts: %w", err))
+	}
+	defer paperAccounts.Close()
+	var priceFeed paper.PriceFeed = paper.NewTickerFeed(binance.NewBinanceEngine("", ""))
+	if paperConfig.CandlesDir != "" {
+		priceFeed = paper.NewCandleFeed(paperConfig.CandlesDir, paperConfig.CandleStep)
+	}
+	paperTrading := &paperTrading{
+		accounts: paperAccounts,
+		feed:     priceFeed,
+		fees: paper.Fees{
+			Taker:           paperConfig.TakerFee,
+			Maker:           paperConfig.MakerFee,
+			Slippage:        paperConfig.Slippage,
+			FundingRate:     paperConfig.FundingRate,
+			FundingInterval: paperConfig.FundingInterval,
+		},
+	}
+
+	// Signals already admitted, reposts and edits of a call must not open a second trade
+	dedupConfig := config.AppConfig.Dedup
+	dedupDBPath := dedupConfig.DBPath
+	if dedupDBPath == "" {
+		dedupDBPath = "signals.bolt.db"
+	}
+	signalStore, err := dedup.NewBoltStore(dedupDBPath, dedupConfig.Window)
+	if err != nil {
+		panic(fmt.Errorf("fatal error signal store: %w", err))
+	}
+	defer signalStore.Close()
+
+	mutex := &sync.RWMutex{}
+	exchangeMap := make(map[int64]exchanges.Trader)
 	for _, receivingChannel := range receivingChannels {
 		go func(receivingChannel client.ReceivingChannel) {
 			for {
 				select {
 				case msg := <-receivingChannel.Chan:
-					fmt.Println("Received Signal on channel id: ", receivingChannel.ChannelID)
-					sig, ok := receivingChannel.Parser.ParsSignal(msg)
+					// Messages already processed, e.g. read again from the history, are skipped
+					if backfillStore != nil && msg.Kind == client.MessageNew && msg.SharedIDs() {
+						advanced, err := backfillStore.Advance(receivingChannel.Name, msg.ID)
+						if err != nil {
+							fmt.Printf("failed to record message %d of channel %s: %v\n", msg.ID, receivingChannel.Name, err)
+						} else if !advanced {
+							continue
+						}
+					}
+
+					signalID := models.SignalID(msg.ChannelID, msg.ID)
+					if msg.Kind == client.MessageDeleted {
+						if n := executor.Amend(models.Withdrawal(signalID)); n > 0 {
+							fmt.Printf("Signal %s is deleted, %d trades are notified\n", signalID, n)
+						}
+						continue
+					}
+
+					fmt.Println("Received Signal on channel id: ", msg.ChannelID)
+					sig, ok := receivingChannel.Parser.ParsSignal(msg.Text)
+					if ok {
+						if err := sig.Validate(0); err != nil {
+							fmt.Printf("Signal of channel id %d is rejected: %v\n", msg.ChannelID, err)
+							ok = false
+						}
+					}
+
+					// An edit amends the trades of the original signal, if any is still running
+					if msg.Kind == client.MessageEdited {
+						if original, running := executor.Signal(signalID); running {
+							amendment := models.Withdrawal(signalID)
+							if ok {
+								amendment = models.DiffSignal(original, sig)
+							}
+							if !amendment.IsEmpty() {
+								n := executor.Amend(amendment)
+								fmt.Printf("Signal %s is edited, %d trades are notified\n", signalID, n)
+							}
+							continue
+						}
+					}
+					// A reply that isn't a signal may manage the signal it replies to
+					if !ok && msg.Kind == client.MessageNew && msg.ReplyTo != 0 {
+						replyTo := models.SignalID(msg.ChannelID, msg.ReplyTo)
+						for _, action := range receivingChannel.Parser.ParsAction(msg.Text) {
+							amendment, err := action.Amendment(replyTo)
+							if err != nil {
+								fmt.Printf("Action on signal %s is rejected: %v\n", replyTo, err)
+								continue
+							}
+							n := executor.Amend(amendment)
+							fmt.Printf("Action %s on signal %s, %d trades are notified\n", action.Kind, replyTo, n)
+						}
+					}
 					if !ok {
 						continue
 					}
+					sig.Attribute(msg.ChannelID, msg.ID, msg.Date, msg.Text)
+					// A signal missed during a downtime is traded only while its entry is still ahead
+					if msg.Backfill {
+						entry := backfill.Entry{Signal: sig, Reason: "recorded only"}
+						if backfillMode == backfill.Replay {
+							entry.Replayed, entry.Reason = backfill.StillValid(ctx, priceFeed, sig, backfillConfig.MaxAge, time.Now())
+						}
+						if err := backfillStore.Record(entry); err != nil {
+							fmt.Printf("failed to record missed signal %s: %v\n", sig.ID, err)
+						}
+						if !entry.Replayed {
+							fmt.Printf("Missed signal %s is not traded: %s\n", sig.ID, entry.Reason)
+							continue
+						}
+						fmt.Printf("Missed signal %s is replayed\n", sig.ID)
+					}
+					drop, err := signalStore.Admit(sig)
+					if err != nil {
+						fmt.Printf("Signal %s is dropped: %v\n", sig.ID, err)
+						continue
+					}
+					if drop != nil {
+						fmt.Printf("Signal %s is dropped as a duplicate of %s (%s)\n", sig.ID, drop.DuplicateOf, drop.Reason)
+						continue
+					}
 
-					for chatID, exchange := range exchangeMap {
-						fmt.Println("Signal is shipped to chat id: ", chatID)
-						newContext, cf := context.WithCancel(ctx)
-						err := exchange.Execute(newContext, sig)
-						if err != nil {
-							fmt.Println(err)
+					for _, chatID := range signalRouter.Route(receivingChannel.Name) {
+						mutex.RLock()
+						exchange, exists := exchangeMap[chatID]
+						mutex.RUnlock()
+						if !exists {
+							continue
 						}
-						if _, exists := contextMap[chatID]; !exists {
-							contextMap[chatID] = []context.CancelFunc{cf}
-						} else {
-							contextMap[chatID] = append(contextMap[chatID], cf)
+
+						info, ok := bot.ActiveUsers()[chatID]
+						if !ok {
+							continue
+						}
+						trade := models.NewTrade(chatID, info.Exchange, sig)
+						if err := trades.Track(tradeStore, trade); err != nil {
+							fmt.Printf("Signal is dropped for chat id %d: %v\n", chatID, err)
+							continue
 						}
+						job, err := executor.Submit(chatID, exchange, trade)
+						if err != nil {
+							fmt.Printf("Signal is dropped for chat id %d: %v\n", chatID, err)
+							_ = trade.Fail(err)
+							continue
+						}
+						fmt.Printf("Signal is shipped to chat id: %d (trade %s, job %d)\n", chatID, trade.ID, job.ID)
 					}
 				}
 			}
@@ -86,30 +367,40 @@ func main() {
 		for {
 			time.Sleep(1 * time.Second)
 			activeUsers := bot.ActiveUsers()
+			mutex.Lock()
 			for chatID, info := range activeUsers {
-				if info.IsRunning {
-					_, exists := exchangeMap[chatID]
-					if !exists {
-						if info.APIKey == "" || info.SecretKey == "" {
-							continue
-						}
-						switch info.Exchange {
-						case "Coinex":
-							coinexEngine := coinex.NewCoinexEngine(info.APIKey, info.SecretKey)
-							exchangeMap[chatID] = coinexEngine
-						default:
-						}
-					} else {
-						delete(exchangeMap, chatID)
-						if value, ok := contextMap[chatID]; ok {
-							for _, v := range value {
-								v()
-							}
-							delete(contextMap, chatID)
-						}
+				channelIDs, paused := info.Subscriptions()
+				signalRouter.Sync(chatID, channelIDs, paused)
+
+				exchange, exists := exchangeMap[chatID]
+				if info.IsRunning && !exists {
+					exchange, exists = newExchange(sealer, paperTrading, chatID, info)
+					if exists {
+						exchangeMap[chatID] = exchange
 					}
+				} else if !info.IsRunning && exists {
+					delete(exchangeMap, chatID)
+					executor.CancelUser(chatID)
+				}
+
+				// Resume the trades left unfinished by the previous run, even if the user stopped since
+				if !reconciler.HasPending(chatID) {
+					continue
+				}
+				if !exists {
+					if exchange, exists = newExchange(sealer, paperTrading, chatID, info); !exists {
+						continue
+					}
+				}
+				for _, trade := range reconciler.Take(chatID) {
+					if _, err := executor.Resume(chatID, exchange, trade); err != nil {
+						fmt.Printf("failed to resume trade %s: %v\n", trade.ID, err)
+						continue
+					}
+					fmt.Printf("Trade %s of chat id %d is resumed from %s\n", trade.ID, chatID, trade.State)
 				}
 			}
+			mutex.Unlock()
 		}
 	}()
 
@@ -118,4 +409,52 @@ func main() {
 	signal.Notify(quit, os.Interrupt)
 	<-quit
 	cancelFunc()
+	executor.Wait()
+}
+
+// paperTrading holds what the simulated exchanges of the users share
+type paperTrading struct {
+	accounts *paper.Accounts
+	feed     paper.PriceFeed
+	fees     paper.Fees
+}
+
+// newExchange builds the trader of the user on its exchange from its sealed credentials
+func newExchange(sealer *secrets.Sealer, paperTrading *paperTrading, chatID int64, info *bot.Info) (exchanges.Trader, bool) {
+	if info.Exchange == exchanges.PaperExchange {
+		account, err := paperTrading.accounts.Get(chatID)
+		if err != nil {
+			fmt.Printf("failed to load paper account of %d: %v\n", chatID, err)
+			return nil, false
+		}
+		return strategy.NewExecutor(paper.NewPaperEngine(account, paperTrading.feed, paperTrading.fees)), true
+	}
+
+	sealedApiKey, sealedSecretKey := info.Credentials()
+	if sealedApiKey == "" || sealedSecretKey == "" {
+		return nil, false
+	}
+	apiKey, err := sealer.Open(sealedApiKey)
+	if err != nil {
+		fmt.Printf("failed to open api key of %d: %v\n", chatID, err)
+		return nil, false
+	}
+	secretKey, err := sealer.Open(sealedSecretKey)
+	if err != nil {
+		fmt.Printf("failed to open secret key of %d: %v\n", chatID, err)
+		return nil, false
+	}
+
+	switch info.Exchange {
+	case "Binance":
+		return strategy.NewExecutor(binance.NewBinanceEngine(apiKey, secretKey)), true
+	case "Bybit":
+		return strategy.NewExecutor(bybit.NewBybitEngine(apiKey, secretKey)), true
+	case "Coinex":
+		return strategy.NewExecutor(coinex.NewCoinexEngine(apiKey, secretKey)), true
+	case "Toobit":
+		return strategy.NewExecutor(toobit.NewToobitEngine(apiKey, secretKey)), true
+	default:
+		return nil, false
+	}
 }
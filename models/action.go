@@ -0,0 +1,49 @@
+package models
+
+import "fmt"
+
+type ActionKind string
+
+const (
+	ActionClose        ActionKind = "close"          // closes Percent of the position, all of it when Percent is zero
+	ActionMoveStopLoss ActionKind = "move_stop_loss" // moves the stop loss to Price, to the entry when Price is zero
+	ActionCancelEntry  ActionKind = "cancel_entry"   // cancels the entry not triggered yet
+)
+
+// Action is a follow-up of a channel managing one of its signals, e.g. "close 50%" or
+// "move the stop to entry", posted as a reply to the signal
+type Action struct {
+	Kind    ActionKind
+	Percent Decimal
+	Price   Decimal
+}
+
+// Amendment returns what the action changes in the trades of the signal
+func (a Action) Amendment(signalID string) (Amendment, error) {
+	amendment := Amendment{SignalID: signalID}
+	switch a.Kind {
+	case ActionClose:
+		amendment.Close = a.Percent
+		if a.Percent.IsZero() {
+			amendment.Close = MustDecimal("100")
+		}
+		if amendment.Close.Sign() < 0 || amendment.Close.Cmp(MustDecimal("100")) > 0 {
+			return Amendment{}, fmt.Errorf("invalid close percent %s", a.Percent)
+		}
+	case ActionMoveStopLoss:
+		if a.Price.IsZero() {
+			amendment.Breakeven = true
+			break
+		}
+		if a.Price.Sign() < 0 {
+			return Amendment{}, fmt.Errorf("invalid stop loss %s", a.Price)
+		}
+		price := a.Price
+		amendment.StopLoss = &price
+	case ActionCancelEntry:
+		amendment.Withdrawn = true
+	default:
+		return Amendment{}, fmt.Errorf("unknown action %q", a.Kind)
+	}
+	return amendment, nil
+}
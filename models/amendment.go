@@ -0,0 +1,96 @@
+package models
+
+import "fmt"
+
+// Amendment is what changed in a signal after the channel edited or deleted its message,
+// nil fields are left untouched.
+type Amendment struct {
+	SignalID  string
+	Withdrawn bool // the message is deleted, or is no longer the same valid call
+	Entry     *EntryRange
+	Targets   []Decimal
+	StopLoss  *Decimal
+	Breakeven bool    // the stop loss moves to the entry price of the position
+	Close     Decimal // percent of the position closed at market, 100 closes all of it
+}
+
+// Withdrawal is the amendment of a deleted message
+func Withdrawal(signalID string) Amendment {
+	return Amendment{SignalID: signalID, Withdrawn: true}
+}
+
+// DiffSignal returns how edited amends original. Another market, side or leverage is a
+// different call altogether, so original is withdrawn.
+func DiffSignal(original, edited Signal) Amendment {
+	amendment := Amendment{SignalID: original.ID}
+	if original.Market != edited.Market || original.Side != edited.Side || original.Leverage != edited.Leverage {
+		amendment.Withdrawn = true
+		return amendment
+	}
+	if original.Entry.From.Cmp(edited.Entry.From) != 0 || original.Entry.To.Cmp(edited.Entry.To) != 0 {
+		entry := edited.Entry
+		amendment.Entry = &entry
+	}
+	if !sameDecimals(original.Targets, edited.Targets) {
+		amendment.Targets = edited.Targets
+	}
+	if original.StopLoss.Cmp(edited.StopLoss) != 0 {
+		stopLoss := edited.StopLoss
+		amendment.StopLoss = &stopLoss
+	}
+	return amendment
+}
+
+// IsEmpty reports whether nothing changed
+func (a Amendment) IsEmpty() bool {
+	return !a.Withdrawn && a.Entry == nil && a.Targets == nil && a.StopLoss == nil && !a.Breakeven && a.Close.IsZero()
+}
+
+// Apply returns the signal with the changes of the amendment
+func (a Amendment) Apply(signal Signal) Signal {
+	if a.Entry != nil {
+		signal.Entry = *a.Entry
+	}
+	if a.Targets != nil {
+		signal.Targets = a.Targets
+	}
+	if a.StopLoss != nil {
+		signal.StopLoss = *a.StopLoss
+	}
+	return signal
+}
+
+func (a Amendment) String() string {
+	if a.Withdrawn {
+		return fmt.Sprintf("signal %s withdrawn", a.SignalID)
+	}
+	s := fmt.Sprintf("signal %s amended:", a.SignalID)
+	if a.Entry != nil {
+		s += fmt.Sprintf(" entry %s-%s", a.Entry.From, a.Entry.To)
+	}
+	if a.Targets != nil {
+		s += fmt.Sprintf(" targets %v", a.Targets)
+	}
+	if a.StopLoss != nil {
+		s += fmt.Sprintf(" stop loss %s", a.StopLoss)
+	}
+	if a.Breakeven {
+		s += " stop loss to entry"
+	}
+	if !a.Close.IsZero() {
+		s += fmt.Sprintf(" close %s%%", a.Close)
+	}
+	return s
+}
+
+func sameDecimals(a, b []Decimal) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Cmp(b[i]) != 0 {
+			return false
+		}
+	}
+	return true
+}
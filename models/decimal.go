@@ -0,0 +1,130 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/moneyscripter/teletrade/normalize"
+)
+
+// Decimal is an exact decimal number, prices keep the digits they were posted with.
+// The zero value is 0.
+type Decimal struct {
+	text string // canonical form, e.g. "-0.015", empty for zero
+}
+
+// ParseDecimal accepts plain decimals with Persian or Arabic-Indic digits, thousands
+// separators, direction marks and surrounding spaces, e.g. "1,250.5" or "۱۲۵۰٫۵"
+func ParseDecimal(value string) (Decimal, error) {
+	text := strings.Map(func(r rune) rune {
+		switch r {
+		case ',', ' ', '_':
+			return -1
+		}
+		return r
+	}, normalize.Digits(normalize.StripBidi(strings.TrimSpace(value))))
+
+	digits := strings.TrimPrefix(strings.TrimPrefix(text, "-"), "+")
+	integer, fraction, _ := strings.Cut(digits, ".")
+	if integer == "" && fraction == "" || !isDigits(integer) || !isDigits(fraction) {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", value)
+	}
+
+	integer = strings.TrimLeft(integer, "0")
+	if integer == "" {
+		integer = "0"
+	}
+	fraction = strings.TrimRight(fraction, "0")
+	canonical := integer
+	if fraction != "" {
+		canonical += "." + fraction
+	}
+	if canonical == "0" {
+		return Decimal{}, nil
+	}
+	if strings.HasPrefix(text, "-") {
+		canonical = "-" + canonical
+	}
+	return Decimal{text: canonical}, nil
+}
+
+// MustDecimal is ParseDecimal for constants, it panics on invalid values
+func MustDecimal(value string) Decimal {
+	d, err := ParseDecimal(value)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (d Decimal) String() string {
+	if d.text == "" {
+		return "0"
+	}
+	return d.text
+}
+
+func (d Decimal) IsZero() bool {
+	return d.text == ""
+}
+
+// Sign returns -1, 0 or +1
+func (d Decimal) Sign() int {
+	switch {
+	case d.text == "":
+		return 0
+	case strings.HasPrefix(d.text, "-"):
+		return -1
+	default:
+		return 1
+	}
+}
+
+func (d Decimal) rat() *big.Rat {
+	r, _ := new(big.Rat).SetString(d.String())
+	return r
+}
+
+// Cmp returns -1, 0 or +1 when d is lower, equal or greater than other
+func (d Decimal) Cmp(other Decimal) int {
+	return d.rat().Cmp(other.rat())
+}
+
+// Float64 is the nearest float of d, for sizing and statistics
+func (d Decimal) Float64() float64 {
+	f, _ := d.rat().Float64()
+	return f
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON accepts quoted and bare numbers, an empty string is zero
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		text = string(data)
+	}
+	if text == "" {
+		*d = Decimal{}
+		return nil
+	}
+	parsed, err := ParseDecimal(text)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
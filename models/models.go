@@ -1,10 +1,273 @@
 package models
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/moneyscripter/teletrade/normalize"
+)
+
+type Side string
+
+const (
+	Long  Side = "long"
+	Short Side = "short"
+)
+
+// ParseSide accepts the usual English and Persian spellings of a position, e.g. long, buy, لانگ or خرید
+func ParseSide(value string) (Side, error) {
+	side, ok := normalize.Side(value)
+	if !ok {
+		return "", fmt.Errorf("unknown position %q", value)
+	}
+	return Side(side), nil
+}
+
+type MarginMode string
+
+const (
+	Cross    MarginMode = "cross"
+	Isolated MarginMode = "isolated"
+)
+
+// EntryRange is where the signal enters, From is the first price posted and is used to
+// trigger the entry. A single entry point has To equal to From.
+type EntryRange struct {
+	From Decimal
+	To   Decimal
+}
+
+// Low returns the lowest end of the range
+func (r EntryRange) Low() Decimal {
+	if r.To.Cmp(r.From) < 0 {
+		return r.To
+	}
+	return r.From
+}
+
+// High returns the highest end of the range
+func (r EntryRange) High() Decimal {
+	if r.To.Cmp(r.From) > 0 {
+		return r.To
+	}
+	return r.From
+}
+
 type Signal struct {
-	Market      string
-	Position    string
-	EntryPoints []string
-	Targets     []string
-	StopLoss    string
-	Leverage    string
+	ID         string // stable across restarts, see Signal.Attribute
+	Source     Source
+	Market     string
+	Side       Side
+	Entry      EntryRange
+	Targets    []Decimal // from the nearest to the farthest
+	StopLoss   Decimal
+	Leverage   int
+	MarginMode MarginMode // empty leaves the choice to the executor
+}
+
+// ParseSignal builds a signal from the texts found by a channel parser, the second entry
+// point (if any) ends the entry range. The signal still has to be validated.
+func ParseSignal(market, position string, entryPoints, targets []string, stopLoss, leverage string) (Signal, error) {
+	signal := Signal{Market: NormalizeMarket(market)}
+	var err error
+	if signal.Side, err = ParseSide(position); err != nil {
+		return Signal{}, err
+	}
+	if len(entryPoints) == 0 {
+		return Signal{}, errors.New("no entry point")
+	}
+	if signal.Entry.From, err = ParseDecimal(entryPoints[0]); err != nil {
+		return Signal{}, fmt.Errorf("entry point: %v", err)
+	}
+	signal.Entry.To = signal.Entry.From
+	if len(entryPoints) > 1 {
+		if signal.Entry.To, err = ParseDecimal(entryPoints[1]); err != nil {
+			return Signal{}, fmt.Errorf("entry point: %v", err)
+		}
+	}
+	for _, t := range targets {
+		target, err := ParseDecimal(t)
+		if err != nil {
+			return Signal{}, fmt.Errorf("target: %v", err)
+		}
+		signal.Targets = append(signal.Targets, target)
+	}
+	if signal.StopLoss, err = ParseDecimal(stopLoss); err != nil {
+		return Signal{}, fmt.Errorf("stop loss: %v", err)
+	}
+	if signal.Leverage, err = ParseLeverage(leverage); err != nil {
+		return Signal{}, err
+	}
+	return signal, nil
+}
+
+// NormalizeMarket turns the usual notations of a pair (BTC/USDT, btc-usdt) into BTCUSDT
+func NormalizeMarket(market string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '-' || r == '_' || unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToUpper(r)
+	}, market)
+}
+
+// ParseLeverage accepts the usual notations, e.g. 10, 10x, x10 or ۱۰x
+func ParseLeverage(value string) (int, error) {
+	d, err := ParseDecimal(strings.Trim(strings.ToLower(strings.TrimSpace(value)), "x×"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid leverage %q", value)
+	}
+	leverage, err := strconv.Atoi(d.String())
+	if err != nil {
+		return 0, fmt.Errorf("invalid leverage %q", value)
+	}
+	return leverage, nil
+}
+
+// MaxLeverage is the highest leverage accepted without knowing the limits of the market
+const MaxLeverage = 125
+
+// ValidationError tells why a field of a signal is rejected
+type ValidationError struct {
+	Field  string // Market, Side, Entry, Targets, StopLoss, Leverage or MarginMode
+	Value  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Field, e.Value, e.Reason)
+}
+
+// ValidationErrors are all the problems found in a signal
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return "invalid signal: " + strings.Join(messages, "; ")
+}
+
+// Validate checks the signal is consistent: the stop loss is on the losing side of the whole
+// entry range, targets move away from the entry on the winning side, and the leverage is
+// within maxLeverage (MaxLeverage when the limit of the market is unknown, i.e. zero).
+// It returns ValidationErrors, or nil.
+func (s Signal) Validate(maxLeverage int) error {
+	var errs ValidationErrors
+	invalid := func(field, value, reason string) {
+		errs = append(errs, &ValidationError{Field: field, Value: value, Reason: reason})
+	}
+
+	if s.Market == "" {
+		invalid("Market", "", "is empty")
+	}
+	if s.Side != Long && s.Side != Short {
+		invalid("Side", string(s.Side), "must be long or short")
+	}
+	if s.MarginMode != "" && s.MarginMode != Cross && s.MarginMode != Isolated {
+		invalid("MarginMode", string(s.MarginMode), "must be cross or isolated")
+	}
+	if s.Entry.Low().Sign() <= 0 {
+		invalid("Entry", s.Entry.Low().String(), "must be positive")
+	}
+	if s.StopLoss.Sign() <= 0 {
+		invalid("StopLoss", s.StopLoss.String(), "must be positive")
+	}
+
+	// direction is +1 when prices have to rise to win
+	direction := 1
+	if s.Side == Short {
+		direction = -1
+	}
+	switch {
+	case s.Side == Long && s.StopLoss.Cmp(s.Entry.Low()) >= 0:
+		invalid("StopLoss", s.StopLoss.String(), "must be below the entry of a long")
+	case s.Side == Short && s.StopLoss.Cmp(s.Entry.High()) <= 0:
+		invalid("StopLoss", s.StopLoss.String(), "must be above the entry of a short")
+	}
+
+	if len(s.Targets) == 0 {
+		invalid("Targets", "", "is empty")
+	}
+	for i, target := range s.Targets {
+		previous := s.Entry.High()
+		if s.Side == Short {
+			previous = s.Entry.Low()
+		}
+		if i > 0 {
+			previous = s.Targets[i-1]
+		}
+		if target.Cmp(previous)*direction <= 0 {
+			reason := "must be beyond the entry"
+			if i > 0 {
+				reason = "must be beyond the previous target"
+			}
+			invalid(fmt.Sprintf("Targets[%d]", i), target.String(), reason)
+		}
+	}
+
+	if maxLeverage <= 0 {
+		maxLeverage = MaxLeverage
+	}
+	if s.Leverage < 1 || s.Leverage > maxLeverage {
+		invalid("Leverage", strconv.Itoa(s.Leverage), fmt.Sprintf("must be between 1 and %d", maxLeverage))
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// UnmarshalJSON also reads the untyped signals journaled by older versions, whose
+// position, entry points and leverage were plain strings
+func (s *Signal) UnmarshalJSON(data []byte) error {
+	type signal Signal
+	var typed struct {
+		signal
+		Leverage json.RawMessage
+	}
+	if err := json.Unmarshal(data, &typed); err != nil {
+		return err
+	}
+	decoded := Signal(typed.signal)
+
+	if len(typed.Leverage) > 0 && string(typed.Leverage) != "null" {
+		var leverage interface{}
+		if err := json.Unmarshal(typed.Leverage, &leverage); err != nil {
+			return err
+		}
+		var err error
+		switch v := leverage.(type) {
+		case float64:
+			decoded.Leverage = int(v)
+		case string:
+			if decoded.Leverage, err = ParseLeverage(v); err != nil {
+				return err
+			}
+		}
+	}
+
+	var legacy struct {
+		Position    string
+		EntryPoints []string
+	}
+	if err := json.Unmarshal(data, &legacy); err == nil && legacy.Position != "" && decoded.Side == "" {
+		if decoded.Side, err = ParseSide(legacy.Position); err != nil {
+			return err
+		}
+		if len(legacy.EntryPoints) > 0 {
+			if decoded.Entry.From, err = ParseDecimal(legacy.EntryPoints[0]); err != nil {
+				return err
+			}
+			decoded.Entry.To = decoded.Entry.From
+		}
+	}
+	*s = decoded
+	return nil
 }
@@ -0,0 +1,162 @@
+package models_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/moneyscripter/teletrade/models"
+)
+
+func TestValidate(t *testing.T) {
+	valid := func() models.Signal {
+		return models.Signal{
+			Market:   "BTCUSDT",
+			Side:     models.Long,
+			Entry:    models.EntryRange{From: models.MustDecimal("100"), To: models.MustDecimal("105")},
+			Targets:  []models.Decimal{models.MustDecimal("110"), models.MustDecimal("120")},
+			StopLoss: models.MustDecimal("90"),
+			Leverage: 10,
+		}
+	}
+	short := func() models.Signal {
+		s := valid()
+		s.Side = models.Short
+		s.Targets = []models.Decimal{models.MustDecimal("95"), models.MustDecimal("92")}
+		s.StopLoss = models.MustDecimal("110")
+		return s
+	}
+
+	tests := []struct {
+		name        string
+		signal      func() models.Signal
+		maxLeverage int
+		fields      []string // rejected, none when valid
+	}{
+		{"valid long", valid, 0, nil},
+		{"valid short", short, 0, nil},
+		{"inverted entry range", func() models.Signal {
+			s := valid()
+			s.Entry.From, s.Entry.To = s.Entry.To, s.Entry.From
+			return s
+		}, 0, nil},
+		{"stop inside an inverted entry range", func() models.Signal {
+			s := valid()
+			s.Entry.From, s.Entry.To = s.Entry.To, s.Entry.From
+			s.StopLoss = models.MustDecimal("102")
+			return s
+		}, 0, []string{"StopLoss"}},
+		{"long stop above the entry", func() models.Signal {
+			s := valid()
+			s.StopLoss = models.MustDecimal("106")
+			return s
+		}, 0, []string{"StopLoss"}},
+		{"short stop below the entry", func() models.Signal {
+			s := short()
+			s.StopLoss = models.MustDecimal("99")
+			return s
+		}, 0, []string{"StopLoss"}},
+		{"long target below the entry", func() models.Signal {
+			s := valid()
+			s.Targets[0] = models.MustDecimal("104")
+			return s
+		}, 0, []string{"Targets[0]"}},
+		{"long targets in the wrong order", func() models.Signal {
+			s := valid()
+			s.Targets[0], s.Targets[1] = s.Targets[1], s.Targets[0]
+			return s
+		}, 0, []string{"Targets[1]"}},
+		{"short targets in the wrong order", func() models.Signal {
+			s := short()
+			s.Targets[0], s.Targets[1] = s.Targets[1], s.Targets[0]
+			return s
+		}, 0, []string{"Targets[1]"}},
+		{"leverage above the market max", valid, 5, []string{"Leverage"}},
+		{"leverage above the default max", func() models.Signal {
+			s := valid()
+			s.Leverage = models.MaxLeverage + 1
+			return s
+		}, 0, []string{"Leverage"}},
+		{"leverage at the market max", valid, 10, nil},
+		{"empty", func() models.Signal { return models.Signal{} }, 0,
+			[]string{"Market", "Side", "Entry", "StopLoss", "Targets", "Leverage"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.signal().Validate(tt.maxLeverage)
+			var fields []string
+			var errs models.ValidationErrors
+			if errors.As(err, &errs) {
+				for _, e := range errs {
+					fields = append(fields, e.Field)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(fields, tt.fields) {
+				t.Fatalf("rejected %v (%v), want %v", fields, err, tt.fields)
+			}
+		})
+	}
+}
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string // empty when invalid
+	}{
+		{"0.52", "0.52"},
+		{" 1,250.50 ", "1250.5"},
+		{"۱۲۵۰٫۵", "1250.5"},
+		{"٠٫٥٢", "0.52"},
+		{"۶۲٬۵۰۰", "62500"},
+		{"‏0.55", "0.55"},
+		{"-0.0150", "-0.015"},
+		{"007", "7"},
+		{".5", "0.5"},
+		{"0.000", "0"},
+		{"", ""},
+		{"abc", ""},
+		{"1.2.3", ""},
+		{"12a", ""},
+		{"--1", ""},
+		{".", ""},
+	}
+	for _, tt := range tests {
+		d, err := models.ParseDecimal(tt.value)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("ParseDecimal(%q) = %s, want an error", tt.value, d)
+		case tt.want != "" && err != nil:
+			t.Errorf("ParseDecimal(%q): %v", tt.value, err)
+		case tt.want != "" && d.String() != tt.want:
+			t.Errorf("ParseDecimal(%q) = %s, want %s", tt.value, d, tt.want)
+		}
+	}
+}
+
+func TestParseLeverage(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int // zero when invalid
+	}{
+		{"10", 10},
+		{"10x", 10},
+		{"X20", 20},
+		{"۱۰x", 10},
+		{"25×", 25},
+		{" 5 ", 5},
+		{"10.5", 0},
+		{"x", 0},
+		{"ten", 0},
+	}
+	for _, tt := range tests {
+		leverage, err := models.ParseLeverage(tt.value)
+		switch {
+		case tt.want == 0 && err == nil:
+			t.Errorf("ParseLeverage(%q) = %d, want an error", tt.value, leverage)
+		case tt.want != 0 && (err != nil || leverage != tt.want):
+			t.Errorf("ParseLeverage(%q) = %d, %v, want %d", tt.value, leverage, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Source is the telegram message a signal was parsed from
+type Source struct {
+	ChannelID int64
+	MessageID int
+	PostedAt  time.Time
+	TextHash  string // see HashText
+}
+
+// HashText hashes the raw text of a message, ignoring the spaces around it
+func HashText(text string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(text)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Attribute records the message the signal was parsed from, the ID of the signal is derived
+// from it so the same message always gives the same ID.
+func (s *Signal) Attribute(channelID int64, messageID int, postedAt time.Time, text string) {
+	s.Source = Source{
+		ChannelID: channelID,
+		MessageID: messageID,
+		PostedAt:  postedAt,
+		TextHash:  HashText(text),
+	}
+	s.ID = SignalID(channelID, messageID)
+}
+
+// SignalID is the ID of the signal parsed from the message of the channel
+func SignalID(channelID int64, messageID int) string {
+	return fmt.Sprintf("%d-%d", channelID, messageID)
+}
+
+// Fingerprint identifies the call itself whatever the wording of the message, two signals
+// with the same market, side, entry, targets, stop loss and leverage share it.
+func (s Signal) Fingerprint() string {
+	targets := make([]string, len(s.Targets))
+	for i, target := range s.Targets {
+		targets[i] = target.String()
+	}
+	call := fmt.Sprintf("%s|%s|%s|%s|%s|%s|%d", s.Market, s.Side, s.Entry.From, s.Entry.To,
+		strings.Join(targets, ","), s.StopLoss, s.Leverage)
+	sum := sha256.Sum256([]byte(call))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+type TradeState string
+
+const (
+	TradePending     TradeState = "pending"      // nothing placed on the exchange yet
+	TradeEntryPlaced TradeState = "entry_placed" // entry stop order is waiting to be triggered
+	TradeOpen        TradeState = "open"         // position is open without TP/SL
+	TradeProtected   TradeState = "protected"    // position is open with TP/SL
+	TradeClosing     TradeState = "closing"      // position is gone, leftover orders are being canceled
+	TradeClosed      TradeState = "closed"
+	TradeFailed      TradeState = "failed"
+)
+
+// tradeTransitions lists the states every state can move to
+var tradeTransitions = map[TradeState][]TradeState{
+	TradePending:     {TradeEntryPlaced, TradeOpen, TradeFailed},
+	TradeEntryPlaced: {TradeOpen, TradeFailed},
+	TradeOpen:        {TradeProtected, TradeClosing, TradeFailed},
+	TradeProtected:   {TradeClosing, TradeFailed},
+	TradeClosing:     {TradeClosed, TradeFailed},
+}
+
+// IsFinal reports whether the trade can't move anymore
+func (s TradeState) IsFinal() bool {
+	return s == TradeClosed || s == TradeFailed
+}
+
+func (s TradeState) CanTransition(to TradeState) bool {
+	for _, state := range tradeTransitions[s] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Trade is the lifecycle of a single signal executed for a single user
+type Trade struct {
+	ID           string
+	ChatID       int64
+	Exchange     string
+	Signal       Signal
+	State        TradeState
+	Amount       string
+	EntryOrderID string
+	TPSLOrderIDs []string // the stop orders placed by the exchange for the TP/SL, if it places any
+	Error        string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+
+	// OnChange is called after every transition, it's used to persist the trade
+	OnChange func(trade *Trade) error `json:"-"`
+	// Amendments receives the changes of the signal made by the channel while the trade runs
+	Amendments chan Amendment `json:"-"`
+}
+
+func NewTrade(chatID int64, exchange string, signal Signal) *Trade {
+	now := time.Now()
+	id := fmt.Sprintf("%d-%d", chatID, now.UnixNano())
+	if signal.ID != "" {
+		// a single trade per user and signal
+		id = fmt.Sprintf("%d-%s", chatID, signal.ID)
+	}
+	return &Trade{
+		ID:        id,
+		ChatID:    chatID,
+		Exchange:  exchange,
+		Signal:    signal,
+		State:     TradePending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// Transition moves the trade to the given state and persists it
+func (t *Trade) Transition(to TradeState) error {
+	if !t.State.CanTransition(to) {
+		return fmt.Errorf("trade %s: invalid transition from %s to %s", t.ID, t.State, to)
+	}
+	t.State = to
+	t.UpdatedAt = time.Now()
+	if t.OnChange != nil {
+		return t.OnChange(t)
+	}
+	return nil
+}
+
+// Fail moves the trade to the failed state, keeping the reason
+func (t *Trade) Fail(reason error) error {
+	if t.State.IsFinal() {
+		return nil
+	}
+	t.Error = reason.Error()
+	return t.Transition(TradeFailed)
+}
+
+// Amend applies the changes of the signal and persists the trade
+func (t *Trade) Amend(amendment Amendment) error {
+	t.Signal = amendment.Apply(t.Signal)
+	t.UpdatedAt = time.Now()
+	if t.OnChange != nil {
+		return t.OnChange(t)
+	}
+	return nil
+}
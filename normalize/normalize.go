@@ -0,0 +1,111 @@
+// Package normalize turns the Persian and Arabic flavored texts of the channels into what the
+// parsers expect: ASCII digits, no bidi marks nor emoji, Persian letters and canonical side words.
+package normalize
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	Long  = "long"
+	Short = "short"
+)
+
+// sides maps the side words to their canonical value, keys are normalized with Letters
+var sides = map[string]string{
+	"long":  Long,
+	"buy":   Long,
+	"لانگ":  Long,
+	"خرید":  Long,
+	"short": Short,
+	"sell":  Short,
+	"شورت":  Short,
+	"شرت":   Short,
+	"فروش":  Short,
+}
+
+// Text applies every normalization of the package, line breaks are kept
+func Text(s string) string {
+	return Letters(Digits(StripEmoji(StripBidi(s))))
+}
+
+// Digits converts Persian and Arabic-Indic digits to ASCII, the Arabic decimal separator
+// to a dot and the Arabic thousands separator and Persian comma to a comma,
+// e.g. "٠٫٥٢" is "0.52" and "۱۲۰، ۱۳۰" is "120, 130"
+func Digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= '۰' && r <= '۹':
+			return '0' + r - '۰'
+		case r >= '٠' && r <= '٩':
+			return '0' + r - '٠'
+		case r == '٫':
+			return '.'
+		case r == '٬' || r == '،':
+			return ','
+		}
+		return r
+	}, s)
+}
+
+// StripBidi removes the direction marks and other invisible formatting characters, the
+// zero width non-joiner written inside Persian words becomes a space
+func StripBidi(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\u200c':
+			return ' '
+		case unicode.Is(unicode.Cf, r):
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// StripEmoji removes emoji and pictographs, with their variation selectors, keycaps and skin tones
+func StripEmoji(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 0xfe00 && r <= 0xfe0f, r == 0x20e3:
+			return -1
+		case r >= 0x2000 && (unicode.Is(unicode.So, r) || unicode.Is(unicode.Sk, r)):
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// Letters writes the Arabic spellings of Persian letters the Persian way, e.g. ي and ك
+func Letters(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case 'ي', 'ى':
+			return 'ی'
+		case 'ك':
+			return 'ک'
+		case 'ة':
+			return 'ه'
+		}
+		return r
+	}, s)
+}
+
+// Side returns the canonical side (Long or Short) of a side word in any language and case,
+// e.g. "Buy", "لانگ" or "فروش"
+func Side(word string) (string, bool) {
+	side, ok := sides[strings.ToLower(strings.TrimSpace(Text(word)))]
+	return side, ok
+}
+
+// FindSide returns the side of the first side word of text
+func FindSide(text string) (string, bool) {
+	for _, word := range strings.FieldsFunc(Text(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		if side, ok := Side(word); ok {
+			return side, true
+		}
+	}
+	return "", false
+}
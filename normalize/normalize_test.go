@@ -0,0 +1,58 @@
+package normalize_test
+
+import (
+	"testing"
+
+	"github.com/moneyscripter/teletrade/normalize"
+)
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"۰٫۵۲ - ۰٫۵۵", "0.52 - 0.55"},
+		{"٠٫٥٢", "0.52"},
+		{"۱۲۰، ۱۳۰", "120, 130"},
+		{"۶۲٬۵۰۰", "62,500"},
+		{"‏نقطه ورود‎ : ۱۱۰", "نقطه ورود : 110"},
+		{"ریسک‌فری", "ریسک فری"},
+		{"🎯 Target 1️⃣", " Target 1"},
+		{"كيف", "کیف"},
+		{"line\nbreak", "line\nbreak"},
+	}
+	for _, tt := range tests {
+		if got := normalize.Text(tt.text); got != tt.want {
+			t.Errorf("Text(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSide(t *testing.T) {
+	tests := []struct {
+		text string
+		want string // empty when there's no side
+	}{
+		{"Long", normalize.Long},
+		{" BUY ", normalize.Long},
+		{"لانگ", normalize.Long},
+		{"خريد", normalize.Long},
+		{"Sell", normalize.Short},
+		{"شرت", normalize.Short},
+		{"فروش", normalize.Short},
+		{"hold", ""},
+	}
+	for _, tt := range tests {
+		side, ok := normalize.Side(tt.text)
+		if side != tt.want || ok != (tt.want != "") {
+			t.Errorf("Side(%q) = %q, %t, want %q", tt.text, side, ok, tt.want)
+		}
+	}
+
+	if side, ok := normalize.FindSide("🔴 پوزیشن: شورت"); !ok || side != normalize.Short {
+		t.Errorf("FindSide found %q, %t, want short", side, ok)
+	}
+	if _, ok := normalize.FindSide("no position here"); ok {
+		t.Error("FindSide found a side in a text without one")
+	}
+}
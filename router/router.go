@@ -0,0 +1,76 @@
+package router
+
+import (
+	"sort"
+	"sync"
+)
+
+// Router keeps track of which users are subscribed to which signal channels
+// and resolves the set of chat ids a parsed signal has to be delivered to.
+// Channels are known by their key only, the Telegram chat id of a channel isn't
+// tracked: the dispatcher knows which receiving channel a message came from.
+type Router struct {
+	mutex *sync.RWMutex
+
+	// channel key (e.g. CryptoTrade066) -> chat id -> enabled
+	subscriptions map[string]map[int64]bool
+}
+
+// NewRouter is a constructor for Router
+func NewRouter() *Router {
+	return &Router{
+		mutex:         &sync.RWMutex{},
+		subscriptions: make(map[string]map[int64]bool),
+	}
+}
+
+// Sync replaces every subscription of chatID with the given channel keys.
+// Channels listed in disabled stay subscribed but receive no signals.
+func (r *Router) Sync(chatID int64, channelKeys []string, disabled []string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.remove(chatID)
+	for _, key := range channelKeys {
+		r.subscribe(key, chatID, !contains(disabled, key))
+	}
+}
+
+// Route returns the chat ids that opted into the channel the signal came from
+// and have it enabled. Channels sharing a chat, e.g. the topics of a forum, are
+// told apart by their key.
+func (r *Router) Route(channelKey string) []int64 {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var chatIDs []int64
+	for chatID, enabled := range r.subscriptions[channelKey] {
+		if enabled {
+			chatIDs = append(chatIDs, chatID)
+		}
+	}
+	sort.Slice(chatIDs, func(i, j int) bool { return chatIDs[i] < chatIDs[j] })
+	return chatIDs
+}
+
+func (r *Router) subscribe(channelKey string, chatID int64, enabled bool) {
+	if _, ok := r.subscriptions[channelKey]; !ok {
+		r.subscriptions[channelKey] = make(map[int64]bool)
+	}
+	r.subscriptions[channelKey][chatID] = enabled
+}
+
+func (r *Router) remove(chatID int64) {
+	for _, subscribers := range r.subscriptions {
+		delete(subscribers, chatID)
+	}
+}
+
+func contains(arr []string, item string) bool {
+	for _, i := range arr {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package router_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/moneyscripter/teletrade/router"
+)
+
+func TestSyncRoute(t *testing.T) {
+	r := router.NewRouter()
+	route := func(key string, want ...int64) {
+		t.Helper()
+		if got := r.Route(key); !slices.Equal(got, want) {
+			t.Fatalf("%s routes to %v, want %v", key, got, want)
+		}
+	}
+
+	// Subscribe
+	r.Sync(2, []string{"A", "B"}, nil)
+	r.Sync(1, []string{"A"}, nil)
+	route("A", 1, 2)
+	route("B", 2)
+	route("C")
+
+	// Disable
+	r.Sync(2, []string{"A", "B"}, []string{"A"})
+	route("A", 1)
+	route("B", 2)
+
+	// Re-sync drops the channels no longer listed, and enables them again
+	r.Sync(2, []string{"A"}, nil)
+	route("A", 1, 2)
+	route("B")
+	r.Sync(1, nil, nil)
+	route("A", 2)
+}
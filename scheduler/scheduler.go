@@ -0,0 +1,257 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/moneyscripter/teletrade/exchanges"
+	"github.com/moneyscripter/teletrade/models"
+)
+
+const (
+	defaultWorkers   = 64
+	defaultPerUser   = 4
+	defaultQueueSize = 1000
+	amendmentsSize   = 16 // amendments of a trade waiting to be applied
+)
+
+var (
+	ErrQueueFull  = errors.New("execution queue is full")
+	ErrUserLimit  = errors.New("user reached the concurrent trades limit")
+	ErrNotStarted = errors.New("scheduler is not started")
+)
+
+// Job is a single trade executed on the exchange of a single user
+type Job struct {
+	ID       uint64
+	ChatID   int64
+	Trade    *models.Trade
+	Exchange exchanges.Trader
+	Resume   bool // trade is left unfinished by a previous run
+
+	signal models.Signal // latest version of the signal of the trade, amendments included
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+}
+
+// Scheduler runs every (user, signal) trade as its own job on a bounded pool of workers,
+// so a long-lived trade never blocks the delivery of other signals.
+type Scheduler struct {
+	workers int
+	perUser int
+
+	mutex   *sync.Mutex
+	ctx     context.Context
+	queue   chan *Job
+	nextID  uint64
+	running map[int64]map[uint64]*Job // chat id -> job id -> queued or running job
+	wg      *sync.WaitGroup
+
+	// OnDone is called after every job with the error returned by the exchange, if any
+	OnDone func(job *Job, err error)
+}
+
+// NewScheduler is a constructor for Scheduler, zero values fall back to the defaults
+func NewScheduler(workers, perUser, queueSize int) *Scheduler {
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	if perUser <= 0 {
+		perUser = defaultPerUser
+	}
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	return &Scheduler{
+		workers: workers,
+		perUser: perUser,
+		mutex:   &sync.Mutex{},
+		queue:   make(chan *Job, queueSize),
+		running: make(map[int64]map[uint64]*Job),
+		wg:      &sync.WaitGroup{},
+	}
+}
+
+// Start spawns the workers, every job is canceled when ctx is done
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mutex.Lock()
+	s.ctx = ctx
+	s.mutex.Unlock()
+
+	for i := 0; i < s.workers; i++ {
+		s.wg.Add(1)
+		go s.worker(ctx)
+	}
+}
+
+// Wait blocks until every worker has returned
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// Submit queues the trade for the user without blocking
+func (s *Scheduler) Submit(chatID int64, exchange exchanges.Trader, trade *models.Trade) (*Job, error) {
+	return s.submit(chatID, exchange, trade, false)
+}
+
+// Resume queues a trade left unfinished by a previous run, it's never rejected by the per-user limit
+func (s *Scheduler) Resume(chatID int64, exchange exchanges.Trader, trade *models.Trade) (*Job, error) {
+	return s.submit(chatID, exchange, trade, true)
+}
+
+func (s *Scheduler) submit(chatID int64, exchange exchanges.Trader, trade *models.Trade, resume bool) (*Job, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.ctx == nil {
+		return nil, ErrNotStarted
+	}
+	if !resume && len(s.running[chatID]) >= s.perUser {
+		return nil, ErrUserLimit
+	}
+
+	if trade.Amendments == nil {
+		trade.Amendments = make(chan models.Amendment, amendmentsSize)
+	}
+
+	s.nextID++
+	ctx, cancel := context.WithCancelCause(s.ctx)
+	job := &Job{
+		ID:       s.nextID,
+		ChatID:   chatID,
+		Trade:    trade,
+		Exchange: exchange,
+		Resume:   resume,
+		signal:   trade.Signal,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	select {
+	case s.queue <- job:
+	default:
+		cancel(ErrQueueFull)
+		return nil, ErrQueueFull
+	}
+
+	if _, ok := s.running[chatID]; !ok {
+		s.running[chatID] = make(map[uint64]*Job)
+	}
+	s.running[chatID][job.ID] = job
+	return job, nil
+}
+
+// CancelUser cancels every queued and running job of the user
+func (s *Scheduler) CancelUser(chatID int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, job := range s.running[chatID] {
+		job.cancel(exchanges.ErrTradeCanceled)
+	}
+}
+
+// Running returns the number of queued and running jobs of the user
+func (s *Scheduler) Running(chatID int64) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.running[chatID])
+}
+
+// Signal returns the signal of the queued or running trades parsed from signalID
+func (s *Scheduler) Signal(signalID string) (models.Signal, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, jobs := range s.running {
+		for _, job := range jobs {
+			if job.signal.ID == signalID {
+				return job.signal, true
+			}
+		}
+	}
+	return models.Signal{}, false
+}
+
+// Amend hands the amendment to every queued and running trade of its signal without
+// blocking, and returns the number of trades it reached
+func (s *Scheduler) Amend(amendment models.Amendment) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	reached := 0
+	for _, jobs := range s.running {
+		for _, job := range jobs {
+			if job.signal.ID != amendment.SignalID {
+				continue
+			}
+			select {
+			case job.Trade.Amendments <- amendment:
+				job.signal = amendment.Apply(job.signal)
+				reached++
+			default:
+				fmt.Printf("amendment of trade %s is dropped, too many are waiting\n", job.Trade.ID)
+			}
+		}
+	}
+	return reached
+}
+
+func (s *Scheduler) worker(ctx context.Context) {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-s.queue:
+			s.run(job)
+		}
+	}
+}
+
+// run executes the job and recovers from a panicking exchange, so a single broken
+// trade can't take the worker (or the process) down with it. The trade of a panicking
+// job is failed like on any other error.
+func (s *Scheduler) run(job *Job) {
+	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job %d panicked: %v", job.ID, r)
+			if failErr := job.Trade.Fail(err); failErr != nil {
+				err = failErr
+			}
+		}
+		job.cancel(nil)
+
+		s.mutex.Lock()
+		delete(s.running[job.ChatID], job.ID)
+		if len(s.running[job.ChatID]) == 0 {
+			delete(s.running, job.ChatID)
+		}
+		s.mutex.Unlock()
+
+		if s.OnDone != nil {
+			s.OnDone(job, err)
+		}
+	}()
+
+	if job.ctx.Err() != nil {
+		// Canceled while queued, nothing is placed yet
+		err = context.Cause(job.ctx)
+		if errors.Is(err, exchanges.ErrTradeCanceled) && !job.Resume {
+			if failErr := job.Trade.Fail(err); failErr != nil {
+				err = failErr
+			}
+		}
+		return
+	}
+	if job.Resume {
+		err = job.Exchange.Resume(job.ctx, job.Trade)
+		return
+	}
+	err = job.Exchange.Execute(job.ctx, job.Trade)
+}
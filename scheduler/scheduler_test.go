@@ -0,0 +1,192 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moneyscripter/teletrade/exchanges"
+	"github.com/moneyscripter/teletrade/models"
+	"github.com/moneyscripter/teletrade/scheduler"
+)
+
+// trader runs execute for every trade, and follows the trade until it's canceled when nil
+type trader struct {
+	execute func(ctx context.Context, trade *models.Trade) error
+}
+
+func (t trader) Execute(ctx context.Context, trade *models.Trade) error {
+	if t.execute != nil {
+		return t.execute(ctx, trade)
+	}
+	<-ctx.Done()
+	return context.Cause(ctx)
+}
+
+func (t trader) Resume(ctx context.Context, trade *models.Trade) error {
+	return t.Execute(ctx, trade)
+}
+
+type done struct {
+	job *scheduler.Job
+	err error
+}
+
+// newScheduler starts a scheduler reporting its finished jobs on the returned channel
+func newScheduler(t *testing.T, workers, perUser int) (*scheduler.Scheduler, chan done) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	s := scheduler.NewScheduler(workers, perUser, 10)
+	finished := make(chan done, 10)
+	s.OnDone = func(job *scheduler.Job, err error) { finished <- done{job, err} }
+	s.Start(ctx)
+	t.Cleanup(func() {
+		cancel()
+		s.Wait()
+	})
+	return s, finished
+}
+
+func trade(chatID int64, signalID string) *models.Trade {
+	return models.NewTrade(chatID, "paper", models.Signal{ID: signalID, Market: "BTCUSDT", Side: models.Long})
+}
+
+func wait(t *testing.T, finished chan done) done {
+	t.Helper()
+	select {
+	case d := <-finished:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("no job finished")
+	}
+	return done{}
+}
+
+func TestPerUserLimit(t *testing.T) {
+	s, finished := newScheduler(t, 4, 2)
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.Submit(1, trader{}, trade(1, "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Submit(1, trader{}, trade(1, "")); !errors.Is(err, scheduler.ErrUserLimit) {
+		t.Fatalf("got %v, want the user limit", err)
+	}
+	if _, err := s.Submit(2, trader{}, trade(2, "")); err != nil {
+		t.Fatalf("other user rejected: %v", err)
+	}
+	if _, err := s.Resume(1, trader{}, trade(1, "")); err != nil {
+		t.Fatalf("resumed trade rejected: %v", err)
+	}
+	if running := s.Running(1); running != 3 {
+		t.Fatalf("%d trades running, want 3", running)
+	}
+
+	s.CancelUser(1)
+	for i := 0; i < 3; i++ {
+		if d := wait(t, finished); d.job.ChatID != 1 {
+			t.Fatalf("job of user %d finished", d.job.ChatID)
+		}
+	}
+	if running := s.Running(1); running != 0 {
+		t.Fatalf("%d trades still running", running)
+	}
+	if _, err := s.Submit(1, trader{}, trade(1, "")); err != nil {
+		t.Fatalf("submit after the jobs finished: %v", err)
+	}
+}
+
+func TestCancelUser(t *testing.T) {
+	// A single worker, the second trade stays queued behind the first
+	s, finished := newScheduler(t, 1, 2)
+	started := make(chan struct{})
+	running := trader{execute: func(ctx context.Context, trade *models.Trade) error {
+		close(started)
+		<-ctx.Done()
+		return context.Cause(ctx)
+	}}
+	if _, err := s.Submit(1, running, trade(1, "a")); err != nil {
+		t.Fatal(err)
+	}
+	queued := trade(1, "b")
+	if _, err := s.Submit(1, trader{}, queued); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	s.CancelUser(1)
+	for i := 0; i < 2; i++ {
+		if d := wait(t, finished); !errors.Is(d.err, exchanges.ErrTradeCanceled) {
+			t.Fatalf("job %d finished with %v, want it canceled", d.job.ID, d.err)
+		}
+	}
+	if queued.State != models.TradeFailed || queued.Error != exchanges.ErrTradeCanceled.Error() {
+		t.Fatalf("queued trade is %s (%s), want it failed as canceled", queued.State, queued.Error)
+	}
+}
+
+func TestAmend(t *testing.T) {
+	s, finished := newScheduler(t, 2, 2)
+	amended := make(chan models.Amendment, 1)
+	follow := trader{execute: func(ctx context.Context, trade *models.Trade) error {
+		select {
+		case amendment := <-trade.Amendments:
+			amended <- amendment
+		case <-ctx.Done():
+		}
+		return nil
+	}}
+	a, b := trade(1, "a"), trade(1, "b")
+	for _, trade := range []*models.Trade{a, b} {
+		if _, err := s.Submit(1, follow, trade); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stopLoss := models.MustDecimal("95")
+	if reached := s.Amend(models.Amendment{SignalID: "b", StopLoss: &stopLoss}); reached != 1 {
+		t.Fatalf("amendment reached %d trades, want 1", reached)
+	}
+	if signal, ok := s.Signal("b"); !ok || signal.StopLoss.Cmp(stopLoss) != 0 {
+		t.Fatalf("signal b is %+v, want the stop loss amended", signal)
+	}
+	if signal, ok := s.Signal("a"); !ok || !signal.StopLoss.IsZero() {
+		t.Fatalf("signal a is %+v, want it untouched", signal)
+	}
+	if d := wait(t, finished); d.job.Trade != b {
+		t.Fatalf("trade %s finished, want b", d.job.Trade.ID)
+	}
+	if amendment := <-amended; amendment.SignalID != "b" {
+		t.Fatalf("trade got the amendment of %s", amendment.SignalID)
+	}
+	if reached := s.Amend(models.Amendment{SignalID: "c"}); reached != 0 {
+		t.Fatalf("amendment of an unknown signal reached %d trades", reached)
+	}
+}
+
+func TestPanicFailsTrade(t *testing.T) {
+	s, finished := newScheduler(t, 1, 2)
+	panicking := trade(1, "a")
+	if _, err := s.Submit(1, trader{execute: func(ctx context.Context, trade *models.Trade) error {
+		panic("broken exchange")
+	}}, panicking); err != nil {
+		t.Fatal(err)
+	}
+	if d := wait(t, finished); d.err == nil || !strings.Contains(d.err.Error(), "panicked") {
+		t.Fatalf("got %v, want the panic", d.err)
+	}
+	if panicking.State != models.TradeFailed || !strings.Contains(panicking.Error, "broken exchange") {
+		t.Fatalf("trade is %s (%s), want it failed", panicking.State, panicking.Error)
+	}
+
+	// The worker survives the panic
+	if _, err := s.Submit(1, trader{execute: func(context.Context, *models.Trade) error { return nil }}, trade(1, "b")); err != nil {
+		t.Fatal(err)
+	}
+	if d := wait(t, finished); d.err != nil {
+		t.Fatal(d.err)
+	}
+}
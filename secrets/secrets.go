@@ -0,0 +1,170 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	keySize      = 32 // AES-256
+	sealedPrefix = "v1:"
+)
+
+var ErrInvalidSealed = errors.New("invalid sealed value")
+
+// Sealer encrypts credentials with envelope encryption: every value gets its own
+// random data key, and only the data key is encrypted with the master key.
+type Sealer struct {
+	master cipher.AEAD
+}
+
+// NewSealer is a constructor for Sealer, masterKey must be 32 bytes long
+func NewSealer(masterKey []byte) (*Sealer, error) {
+	if len(masterKey) != keySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", keySize, len(masterKey))
+	}
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{master: aead}, nil
+}
+
+// LoadMasterKey returns the master key from a base64 encoded value (config or env),
+// falling back to the key file, which can hold either the raw or the base64 encoded key.
+func LoadMasterKey(value, file string) ([]byte, error) {
+	if value != "" {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("decode master key: %w", err)
+		}
+		return key, nil
+	}
+	if file == "" {
+		return nil, errors.New("no master key configured")
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read master key file: %w", err)
+	}
+	if len(data) == keySize {
+		return data, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("decode master key file: %w", err)
+	}
+	return key, nil
+}
+
+// Seal encrypts plaintext and returns it in the "v1:<wrapped data key>:<ciphertext>" format
+func (s *Sealer) Seal(plaintext string) (string, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := seal(s.master, dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(data, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+
+	return sealedPrefix +
+		base64.RawStdEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Open decrypts a value produced by Seal
+func (s *Sealer) Open(sealed string) (string, error) {
+	if !IsSealed(sealed) {
+		return "", ErrInvalidSealed
+	}
+	parts := strings.Split(strings.TrimPrefix(sealed, sealedPrefix), ":")
+	if len(parts) != 2 {
+		return "", ErrInvalidSealed
+	}
+	wrappedKey, err := base64.RawStdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalidSealed
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrInvalidSealed
+	}
+
+	dataKey, err := open(s.master, wrappedKey)
+	if err != nil {
+		return "", err
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(data, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// IsSealed reports whether value looks like the output of Seal
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+// Mask hides all but the first 4 and the last 2 characters of a secret, e.g. AB12…F9
+func Mask(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if utf8.RuneCountInString(secret) <= 8 {
+		return "…"
+	}
+	r := []rune(secret)
+	return string(r[:4]) + "…" + string(r[len(r)-2:])
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrInvalidSealed
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrInvalidSealed
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,105 @@
+package secrets_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/moneyscripter/teletrade/secrets"
+)
+
+func newSealer(t *testing.T, b byte) *secrets.Sealer {
+	t.Helper()
+	sealer, err := secrets.NewSealer(bytes.Repeat([]byte{b}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealer
+}
+
+func TestSealOpen(t *testing.T) {
+	sealer := newSealer(t, 1)
+	for _, plaintext := range []string{"", "api-key-1234", "کلید"} {
+		sealed, err := sealer.Seal(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !secrets.IsSealed(sealed) {
+			t.Fatalf("sealed %q as %q", plaintext, sealed)
+		}
+		opened, err := sealer.Open(sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opened != plaintext {
+			t.Fatalf("opened %q, want %q", opened, plaintext)
+		}
+	}
+
+	first, _ := sealer.Seal("secret")
+	second, _ := sealer.Seal("secret")
+	if first == second {
+		t.Fatal("the same value is sealed twice the same way")
+	}
+
+	if _, err := secrets.NewSealer(make([]byte, 16)); err == nil {
+		t.Fatal("short master key accepted")
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	sealer := newSealer(t, 1)
+	sealed, err := sealer.Seal("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flip a bit of the ciphertext
+	wrappedKey, encoded, _ := strings.Cut(strings.TrimPrefix(sealed, "v1:"), ":")
+	ciphertext, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext[len(ciphertext)-1] ^= 1
+	tampered := "v1:" + wrappedKey + ":" + base64.RawStdEncoding.EncodeToString(ciphertext)
+
+	tests := []struct {
+		name   string
+		sealer *secrets.Sealer
+		sealed string
+	}{
+		{"tampered ciphertext", sealer, tampered},
+		{"wrong key", newSealer(t, 2), sealed},
+		{"plaintext", sealer, "secret"},
+		{"missing ciphertext", sealer, "v1:" + wrappedKey},
+		{"not base64", sealer, "v1:" + wrappedKey + ":!!"},
+		{"truncated", sealer, "v1:" + wrappedKey + ":AAAA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if opened, err := tt.sealer.Open(tt.sealed); !errors.Is(err, secrets.ErrInvalidSealed) {
+				t.Fatalf("opened %q with %v, want it rejected", opened, err)
+			}
+		})
+	}
+}
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		secret string
+		want   string
+	}{
+		{"", ""},
+		{"short", "…"},
+		{"12345678", "…"},
+		{"AB12CDEF9", "AB12…F9"},
+		{"کلیدهای-مخفی", "کلید…فی"},
+	}
+	for _, tt := range tests {
+		if got := secrets.Mask(tt.secret); got != tt.want {
+			t.Errorf("Mask(%q) = %q, want %q", tt.secret, got, tt.want)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/moneyscripter/teletrade/channels"
 	"github.com/moneyscripter/teletrade/exchanges"
+	"github.com/moneyscripter/teletrade/secrets"
 	"os"
 	"os/signal"
 	"strings"
@@ -17,11 +18,95 @@ import (
 
 var updateChan = make(chan *models.Update, 1000)
 
-// Mock Database for Subscribed Users
-var userInfo = make(map[int64]*Info)
+// Subscribed users, loaded from and persisted to store
+var (
+	usersMutex = &sync.RWMutex{}
+	userInfo   = make(map[int64]*Info)
+	store      UserStore
+	sealer     *secrets.Sealer
+)
+
+// UseStore loads the persisted users from s and persists every later change to it.
+// Credentials are sealed with ss before they are kept in memory or storage.
+func UseStore(s UserStore, ss *secrets.Sealer) error {
+	users, err := s.Load()
+	if err != nil {
+		return err
+	}
+
+	usersMutex.Lock()
+	defer usersMutex.Unlock()
+
+	for chatID, info := range users {
+		// Pending inputs don't survive a restart, the prompt is long gone
+		info.WaitingApiKey = false
+		info.WaitingSecretKey = false
+
+		// Seal credentials stored in plaintext by older versions
+		resealed := false
+		if info.APIKey != "" && !secrets.IsSealed(info.APIKey) {
+			if info.APIKey, err = ss.Seal(info.APIKey); err != nil {
+				return err
+			}
+			info.APIKeyMask = "…"
+			resealed = true
+		}
+		if info.SecretKey != "" && !secrets.IsSealed(info.SecretKey) {
+			if info.SecretKey, err = ss.Seal(info.SecretKey); err != nil {
+				return err
+			}
+			info.SecretKeyMask = "…"
+			resealed = true
+		}
+		if resealed {
+			if err = s.Save(chatID, info); err != nil {
+				return err
+			}
+		}
+
+		userInfo[chatID] = info
+	}
+	store = s
+	sealer = ss
+	return nil
+}
 
 func ActiveUsers() map[int64]*Info {
-	return userInfo
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+
+	users := make(map[int64]*Info, len(userInfo))
+	for chatID, info := range userInfo {
+		users[chatID] = info
+	}
+	return users
+}
+
+func getUser(chatID int64) (*Info, bool) {
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+
+	info, ok := userInfo[chatID]
+	return info, ok
+}
+
+func addUser(chatID int64, info *Info) {
+	usersMutex.Lock()
+	userInfo[chatID] = info
+	usersMutex.Unlock()
+
+	saveUser(chatID)
+}
+
+// saveUser persists the current state of the user, if a store is configured
+func saveUser(chatID int64) {
+	info, ok := getUser(chatID)
+	if !ok || store == nil {
+		return
+	}
+	if err := store.Save(chatID, info); err != nil {
+		fmt.Printf("failed to save user %d: %v\n", chatID, err)
+	}
 }
 
 var botMessageIDs = make(map[int64][]int)
@@ -35,10 +120,13 @@ type Info struct {
 	mutex            *sync.RWMutex
 	IsRunning        bool
 	ChannelIDs       []string
+	PausedChannelIDs []string
 	Exchange         string
-	APIKey           string
+	APIKey           string // sealed, see secrets.Sealer
+	APIKeyMask       string
 	WaitingApiKey    bool
-	SecretKey        string
+	SecretKey        string // sealed, see secrets.Sealer
+	SecretKeyMask    string
 	WaitingSecretKey bool
 }
 
@@ -64,6 +152,48 @@ func (i *Info) RemoveChannelID(channelID string) {
 			break
 		}
 	}
+	for index := range i.PausedChannelIDs {
+		if i.PausedChannelIDs[index] == channelID {
+			i.PausedChannelIDs = append(i.PausedChannelIDs[:index], i.PausedChannelIDs[index+1:]...)
+			break
+		}
+	}
+}
+
+// ToggleChannelID pauses signals of a subscribed channel or resumes them if already paused
+func (i *Info) ToggleChannelID(channelID string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	for index := range i.PausedChannelIDs {
+		if i.PausedChannelIDs[index] == channelID {
+			i.PausedChannelIDs = append(i.PausedChannelIDs[:index], i.PausedChannelIDs[index+1:]...)
+			return
+		}
+	}
+	i.PausedChannelIDs = append(i.PausedChannelIDs, channelID)
+}
+
+func (i *Info) IsChannelPaused(channelID string) bool {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	for _, channel := range i.PausedChannelIDs {
+		if channel == channelID {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscriptions returns a copy of the subscribed and paused channel ids
+func (i *Info) Subscriptions() ([]string, []string) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	channelIDs := append([]string(nil), i.ChannelIDs...)
+	paused := append([]string(nil), i.PausedChannelIDs...)
+	return channelIDs, paused
 }
 
 func (i *Info) UpdateExchange(exchange string) {
@@ -73,11 +203,12 @@ func (i *Info) UpdateExchange(exchange string) {
 	i.Exchange = exchange
 }
 
-func (i *Info) UpdateApiKey(apiKey string) {
+func (i *Info) UpdateApiKey(sealedApiKey, mask string) {
 	i.mutex.Lock()
 	defer i.mutex.Unlock()
 
-	i.APIKey = apiKey
+	i.APIKey = sealedApiKey
+	i.APIKeyMask = mask
 }
 
 func (i *Info) ApiKeyWaiting(b bool) {
@@ -87,11 +218,20 @@ func (i *Info) ApiKeyWaiting(b bool) {
 	i.WaitingApiKey = b
 }
 
-func (i *Info) UpdateSecret(secretKey string) {
+func (i *Info) UpdateSecret(sealedSecretKey, mask string) {
 	i.mutex.Lock()
 	defer i.mutex.Unlock()
 
-	i.SecretKey = secretKey
+	i.SecretKey = sealedSecretKey
+	i.SecretKeyMask = mask
+}
+
+// Credentials returns the sealed api key and secret key
+func (i *Info) Credentials() (string, string) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	return i.APIKey, i.SecretKey
 }
 
 func (i *Info) SecretKeyWaiting(b bool) {
@@ -151,6 +291,7 @@ func Run(token string) error {
 	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, "", bot.MatchTypePrefix, callbackQueryHandler)
 	b.RegisterHandler(bot.HandlerTypeMessageText, "", bot.MatchTypeExact, userInputHandler)
 
+	startLogin(ctx, b)
 	b.Start(ctx)
 
 	return nil
@@ -183,18 +324,20 @@ func helloHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
 func channelSelection(ctx context.Context, b *bot.Bot, chatID int64) {
 	// Create an inline keyboard for the available channels
 	var buttons [][]models.InlineKeyboardButton
-	for channelID, _ := range channels.AvailableChannels {
+	for _, parser := range channels.Registered() {
 		var row []models.InlineKeyboardButton
 		channelsButton := models.InlineKeyboardButton{
-			Text:         channelID,
-			CallbackData: "channel_" + channelID,
+			Text:         parser.Name,
+			CallbackData: "channel_" + parser.Name,
 		}
 		row = append(row, channelsButton)
-		redirectButton := models.InlineKeyboardButton{
-			Text: "Redirect",
-			URL:  channels.AvailableChannels[channelID],
+		if parser.URL != "" {
+			redirectButton := models.InlineKeyboardButton{
+				Text: "Redirect",
+				URL:  parser.URL,
+			}
+			row = append(row, redirectButton)
 		}
-		row = append(row, redirectButton)
 		buttons = append(buttons, row)
 	}
 
@@ -223,11 +366,13 @@ func exchangeSelection(ctx context.Context, b *bot.Bot, chatID int64) {
 			CallbackData: "exchange_" + exchange,
 		}
 		row = append(row, button)
-		redirectButton := models.InlineKeyboardButton{
-			Text: "Redirect",
-			URL:  exchanges.AvailableExchanges[exchange],
+		if url := exchanges.AvailableExchanges[exchange]; url != "" {
+			redirectButton := models.InlineKeyboardButton{
+				Text: "Redirect",
+				URL:  url,
+			}
+			row = append(row, redirectButton)
 		}
-		row = append(row, redirectButton)
 		buttons = append(buttons, row)
 	}
 
@@ -298,19 +443,35 @@ func userState(ctx context.Context, b *bot.Bot, chatID int64) {
 }
 
 func channelState(ctx context.Context, b *bot.Bot, chatID int64) {
+	info, ok := getUser(chatID)
+	if !ok {
+		return
+	}
+
 	buttons := [][]models.InlineKeyboardButton{}
-	for _, channelID := range userInfo[chatID].ChannelIDs {
+	for _, channelID := range info.ChannelIDs {
 		var row []models.InlineKeyboardButton
 		channelsButton := models.InlineKeyboardButton{
 			Text:         channelID,
 			CallbackData: "channels",
 		}
 		row = append(row, channelsButton)
-		redirectButton := models.InlineKeyboardButton{
-			Text: "Redirect",
-			URL:  channels.AvailableChannels[channelID],
+		if parser, ok := channels.Lookup(channelID); ok && parser.URL != "" {
+			redirectButton := models.InlineKeyboardButton{
+				Text: "Redirect",
+				URL:  parser.URL,
+			}
+			row = append(row, redirectButton)
+		}
+		toggleText := "⏸"
+		if info.IsChannelPaused(channelID) {
+			toggleText = "▶️"
+		}
+		toggleButton := models.InlineKeyboardButton{
+			Text:         toggleText,
+			CallbackData: "toggle_channel_" + channelID,
 		}
-		row = append(row, redirectButton)
+		row = append(row, toggleButton)
 		removeButton := models.InlineKeyboardButton{
 			Text:         "❌",
 			CallbackData: "remove_channel_" + channelID,
@@ -340,30 +501,54 @@ func channelState(ctx context.Context, b *bot.Bot, chatID int64) {
 }
 
 func exchangeState(ctx context.Context, b *bot.Bot, chatID int64) {
+	info, ok := getUser(chatID)
+	if !ok {
+		return
+	}
+
 	buttons := [][]models.InlineKeyboardButton{}
 	row1 := []models.InlineKeyboardButton{}
 	flag := true
-	if userInfo[chatID].Exchange == "" {
-		userInfo[chatID].Exchange = "Not Selected"
+	exchangeText := info.Exchange
+	if exchangeText == "" {
+		exchangeText = "Not Selected"
 		flag = false
 	}
 	exchangeButton := models.InlineKeyboardButton{
-		Text:         userInfo[chatID].Exchange,
+		Text:         exchangeText,
 		CallbackData: "set_exchange",
 	}
 	row1 = append(row1, exchangeButton)
-	if flag {
+	if flag && exchanges.AvailableExchanges[info.Exchange] != "" {
 		redirectButton := models.InlineKeyboardButton{
 			Text: "Redirect",
-			URL:  exchanges.AvailableExchanges[userInfo[chatID].Exchange],
+			URL:  exchanges.AvailableExchanges[info.Exchange],
 		}
 		row1 = append(row1, redirectButton)
 	}
 	buttons = append(buttons, row1)
 
+	// Paper trading is simulated, there are no keys to set
+	if info.Exchange == exchanges.PaperExchange {
+		backButton := models.InlineKeyboardButton{
+			Text:         "Back",
+			CallbackData: "home",
+		}
+		buttons = append(buttons, []models.InlineKeyboardButton{backButton})
+
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   "Exchange (simulated, no keys needed):",
+			ReplyMarkup: &models.InlineKeyboardMarkup{
+				InlineKeyboard: buttons,
+			},
+		})
+		return
+	}
+
 	row2 := []models.InlineKeyboardButton{}
-	apiKey := userInfo[chatID].APIKey
-	if apiKey == "" {
+	apiKey := "API KEY: " + info.APIKeyMask
+	if info.APIKey == "" {
 		apiKey = "API KEY: EMPTY"
 	}
 	apiKeyButton := models.InlineKeyboardButton{
@@ -371,9 +556,9 @@ func exchangeState(ctx context.Context, b *bot.Bot, chatID int64) {
 		CallbackData: "set_api_key",
 	}
 	row2 = append(row2, apiKeyButton)
-	secretKey := userInfo[chatID].SecretKey
-	if secretKey == "" {
-		secretKey = "API KEY: EMPTY"
+	secretKey := "SECRET KEY: " + info.SecretKeyMask
+	if info.SecretKey == "" {
+		secretKey = "SECRET KEY: EMPTY"
 	}
 	secretKeyButton := models.InlineKeyboardButton{
 		Text:         secretKey,
@@ -403,7 +588,7 @@ func callbackQueryHandler(ctx context.Context, b *bot.Bot, update *models.Update
 	data := query.Data
 	chatID := query.Message.Message.Chat.ID
 
-	_, ok := subscription(ctx, b, chatID)
+	info, ok := subscription(ctx, b, chatID)
 	if !ok && data != "subscribe" {
 		return
 	}
@@ -416,16 +601,19 @@ func callbackQueryHandler(ctx context.Context, b *bot.Bot, update *models.Update
 	case "home":
 		userState(ctx, b, chatID)
 	case "subscribe":
-		userInfo[chatID] = &Info{
+		addUser(chatID, &Info{
 			mutex:            &sync.RWMutex{},
 			IsRunning:        false,
 			ChannelIDs:       nil,
+			PausedChannelIDs: nil,
 			Exchange:         "",
 			APIKey:           "",
+			APIKeyMask:       "",
 			WaitingApiKey:    false,
 			SecretKey:        "",
+			SecretKeyMask:    "",
 			WaitingSecretKey: false,
-		}
+		})
 		userState(ctx, b, chatID)
 	case "channels":
 		channelState(ctx, b, chatID)
@@ -436,42 +624,49 @@ func callbackQueryHandler(ctx context.Context, b *bot.Bot, update *models.Update
 	case "set_exchange":
 		exchangeSelection(ctx, b, chatID)
 	case "set_api_key":
-		userInfo[chatID].ApiKeyWaiting(true)
+		info.ApiKeyWaiting(true)
 		b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: chatID,
 			Text:   "Please enter your access key:",
 		})
 	case "set_secret_key":
-		userInfo[chatID].SecretKeyWaiting(true)
+		info.SecretKeyWaiting(true)
 		b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: chatID,
 			Text:   "Please enter your secret:",
 		})
 	case "start":
-		userInfo[chatID].Start()
+		info.Start()
 	case "stop":
-		userInfo[chatID].Stop()
+		info.Stop()
 	default:
 		if strings.HasPrefix(data, "channel_") {
 			selectedChannel := strings.TrimPrefix(data, "channel_")
-			userInfo[chatID].AddChannelID(selectedChannel)
+			info.AddChannelID(selectedChannel)
 
 			channelState(ctx, b, chatID)
 		}
 		if strings.HasPrefix(data, "remove_channel_") {
 			selectedChannel := strings.TrimPrefix(data, "remove_channel_")
-			userInfo[chatID].RemoveChannelID(selectedChannel)
+			info.RemoveChannelID(selectedChannel)
+
+			channelState(ctx, b, chatID)
+		}
+		if strings.HasPrefix(data, "toggle_channel_") {
+			selectedChannel := strings.TrimPrefix(data, "toggle_channel_")
+			info.ToggleChannelID(selectedChannel)
 
 			channelState(ctx, b, chatID)
 		}
 
 		if strings.HasPrefix(data, "exchange_") {
 			selectedExchange := strings.TrimPrefix(data, "exchange_")
-			userInfo[chatID].UpdateExchange(selectedExchange)
+			info.UpdateExchange(selectedExchange)
 
 			exchangeState(ctx, b, chatID)
 		}
 	}
+	saveUser(chatID)
 
 	// Acknowledge the callback query
 	b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
@@ -486,34 +681,58 @@ func userInputHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
 	//		Text:   fmt.Sprintf("%d: %s", update.ChannelPost.Chat.ID, update.ChannelPost.Text),
 	//	})
 	//}
-	updateChan <- update
 	if update.Message == nil {
+		updateChan <- update
 		return
 	}
 	chatID := update.Message.Chat.ID
 	message := update.Message.Text
 
-	_, ok := subscription(ctx, b, chatID)
+	if loginAnswer(ctx, b, update.Message) {
+		return
+	}
+
+	info, ok := subscription(ctx, b, chatID)
 	if !ok {
+		updateChan <- update
 		return
 	}
 
 	// Check if access key is already provided
-	if userInfo[chatID].WaitingApiKey {
-		userInfo[chatID].UpdateApiKey(message)
-		userInfo[chatID].ApiKeyWaiting(false)
-		exchangeState(ctx, b, chatID)
-	} else if userInfo[chatID].WaitingSecretKey {
-		userInfo[chatID].UpdateSecret(message)
-		userInfo[chatID].SecretKeyWaiting(false)
+	if info.WaitingApiKey || info.WaitingSecretKey {
+		// Credentials are never forwarded nor left in the chat history
+		b.DeleteMessage(ctx, &bot.DeleteMessageParams{
+			ChatID:    chatID,
+			MessageID: update.Message.ID,
+		})
+
+		sealed, err := sealer.Seal(message)
+		if err != nil {
+			fmt.Printf("failed to seal credentials of %d: %v\n", chatID, err)
+			b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: chatID,
+				Text:   "Failed to store your key, please try again later.",
+			})
+			return
+		}
+
+		if info.WaitingApiKey {
+			info.UpdateApiKey(sealed, secrets.Mask(message))
+			info.ApiKeyWaiting(false)
+		} else {
+			info.UpdateSecret(sealed, secrets.Mask(message))
+			info.SecretKeyWaiting(false)
+		}
+		saveUser(chatID)
 		exchangeState(ctx, b, chatID)
 	} else {
+		updateChan <- update
 		userState(ctx, b, chatID)
 	}
 }
 
 func subscription(ctx context.Context, b *bot.Bot, chatID int64) (*Info, bool) {
-	info, ok := userInfo[chatID]
+	info, ok := getUser(chatID)
 	if !ok {
 		var buttons [][]models.InlineKeyboardButton
 		button := models.InlineKeyboardButton{
@@ -0,0 +1,166 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// Login questions of the listening telegram accounts, answered by the admins
+var (
+	loginMutex   = &sync.Mutex{}
+	admins       []int64
+	answerLogin  func(phone, answer string) error
+	loginPrompts = make(map[int64]map[int]string) // admin chat id -> prompt message id -> phone
+	queuedLogins []loginQuestion                  // asked before the bot started
+	controlBot   *bot.Bot
+)
+
+type loginQuestion struct {
+	phone    string
+	question string
+	attempt  int
+}
+
+// UseLogin lets the admins answer the login questions of the telegram accounts, their
+// answers are handed to answer
+func UseLogin(adminChatIDs []int64, answer func(phone, answer string) error) {
+	loginMutex.Lock()
+	defer loginMutex.Unlock()
+
+	admins = adminChatIDs
+	answerLogin = answer
+}
+
+// AskAdmins sends a login question (code or password) of the account of phone to every
+// admin, the reply to it is the answer
+func AskAdmins(phone, question string, attempt int) {
+	loginMutex.Lock()
+	b := controlBot
+	if b == nil {
+		queuedLogins = append(queuedLogins, loginQuestion{phone: phone, question: question, attempt: attempt})
+	}
+	loginMutex.Unlock()
+
+	if b != nil {
+		askAdmins(context.Background(), b, loginQuestion{phone: phone, question: question, attempt: attempt})
+	}
+}
+
+// LoginDone withdraws the question of the account of phone, it no longer waits for an answer
+func LoginDone(phone string) {
+	loginMutex.Lock()
+	defer loginMutex.Unlock()
+
+	dropLoginPrompts(phone)
+	var queued []loginQuestion
+	for _, q := range queuedLogins {
+		if q.phone != phone {
+			queued = append(queued, q)
+		}
+	}
+	queuedLogins = queued
+}
+
+// dropLoginPrompts forgets the prompts of phone sent to every admin, the caller holds loginMutex
+func dropLoginPrompts(phone string) {
+	for _, adminPrompts := range loginPrompts {
+		for id, p := range adminPrompts {
+			if p == phone {
+				delete(adminPrompts, id)
+			}
+		}
+	}
+}
+
+// startLogin sends the questions asked before the bot started
+func startLogin(ctx context.Context, b *bot.Bot) {
+	loginMutex.Lock()
+	controlBot = b
+	queued := queuedLogins
+	queuedLogins = nil
+	loginMutex.Unlock()
+
+	for _, q := range queued {
+		askAdmins(ctx, b, q)
+	}
+}
+
+func askAdmins(ctx context.Context, b *bot.Bot, q loginQuestion) {
+	text := fmt.Sprintf("Telegram account %s needs its login %s", q.phone, q.question)
+	if q.attempt > 1 {
+		text += fmt.Sprintf(" (attempt %d, the previous one was wrong or late)", q.attempt)
+	}
+	if q.question == "code" {
+		text += ".\nReply to this message with the digits spaced out, e.g. 1 2 3 4 5, Telegram expires the codes shared as is."
+	} else {
+		text += ".\nReply to this message with it, your message is deleted right away."
+	}
+
+	loginMutex.Lock()
+	chatIDs := admins
+	loginMutex.Unlock()
+	if len(chatIDs) == 0 {
+		fmt.Printf("login %s of %s is asked but no admin is configured\n", q.question, q.phone)
+		return
+	}
+
+	for _, chatID := range chatIDs {
+		message, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:      chatID,
+			Text:        text,
+			ReplyMarkup: &models.ForceReply{ForceReply: true},
+		})
+		if err != nil {
+			fmt.Printf("failed to ask admin %d for the login %s of %s: %v\n", chatID, q.question, q.phone, err)
+			continue
+		}
+
+		loginMutex.Lock()
+		if loginPrompts[chatID] == nil {
+			loginPrompts[chatID] = make(map[int]string)
+		}
+		loginPrompts[chatID][message.ID] = q.phone
+		loginMutex.Unlock()
+	}
+}
+
+// loginAnswer hands a message of an admin to the login waiting for it, it tells whether the
+// message was an answer. Only the replies to a question still waiting are answers.
+func loginAnswer(ctx context.Context, b *bot.Bot, message *models.Message) bool {
+	if message.ReplyToMessage == nil {
+		return false
+	}
+	chatID := message.Chat.ID
+
+	loginMutex.Lock()
+	phone := loginPrompts[chatID][message.ReplyToMessage.ID]
+	answer := answerLogin
+	if phone != "" {
+		// The question is answered for every admin
+		dropLoginPrompts(phone)
+	}
+	loginMutex.Unlock()
+	if phone == "" || answer == nil {
+		return false
+	}
+
+	// Codes and passwords are never left in the chat history
+	b.DeleteMessage(ctx, &bot.DeleteMessageParams{
+		ChatID:    chatID,
+		MessageID: message.ID,
+	})
+
+	text := fmt.Sprintf("Answer sent to account %s.", phone)
+	if err := answer(phone, message.Text); err != nil {
+		text = fmt.Sprintf("Answer of account %s is not sent: %v", phone, err)
+	}
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   text,
+	})
+	return true
+}
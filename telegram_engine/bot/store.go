@@ -0,0 +1,125 @@
+package bot
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"go.etcd.io/bbolt"
+)
+
+// UserStore persists the subscribed users, so they survive restarts and deploys.
+type UserStore interface {
+	Load() (map[int64]*Info, error)
+	Save(chatID int64, info *Info) error
+	Delete(chatID int64) error
+	Close() error
+}
+
+var (
+	usersBucket = []byte("users")
+	metaBucket  = []byte("meta")
+	versionKey  = []byte("schema_version")
+)
+
+// migrations are applied in order, migrations[i] upgrades the schema from version i to i+1.
+// Never edit or reorder an existing migration, append a new one instead.
+var migrations = []func(tx *bbolt.Tx) error{
+	// 1: users bucket, chat id (big endian) -> json encoded Info
+	func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(usersBucket)
+		return err
+	},
+}
+
+type boltUserStore struct {
+	db *bbolt.DB
+}
+
+// NewBoltUserStore opens (or creates) the bbolt database at path and migrates it to the latest schema
+func NewBoltUserStore(path string) (UserStore, error) {
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("open user store: %w", err)
+	}
+	s := &boltUserStore{db: db}
+	if err := s.migrate(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *boltUserStore) migrate() error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		version := 0
+		if v := meta.Get(versionKey); v != nil {
+			version = int(binary.BigEndian.Uint64(v))
+		}
+		if version > len(migrations) {
+			return fmt.Errorf("user store schema version %d is newer than supported version %d", version, len(migrations))
+		}
+		for ; version < len(migrations); version++ {
+			if err := migrations[version](tx); err != nil {
+				return fmt.Errorf("migrate user store to version %d: %w", version+1, err)
+			}
+		}
+		return meta.Put(versionKey, itob(int64(version)))
+	})
+}
+
+func (s *boltUserStore) Load() (map[int64]*Info, error) {
+	users := make(map[int64]*Info)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(usersBucket).ForEach(func(k, v []byte) error {
+			info := &Info{}
+			if err := json.Unmarshal(v, info); err != nil {
+				return fmt.Errorf("decode user %d: %w", btoi(k), err)
+			}
+			info.mutex = &sync.RWMutex{}
+			users[btoi(k)] = info
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (s *boltUserStore) Save(chatID int64, info *Info) error {
+	info.mutex.RLock()
+	data, err := json.Marshal(info)
+	info.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(usersBucket).Put(itob(chatID), data)
+	})
+}
+
+func (s *boltUserStore) Delete(chatID int64) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(usersBucket).Delete(itob(chatID))
+	})
+}
+
+func (s *boltUserStore) Close() error {
+	return s.db.Close()
+}
+
+func itob(v int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+func btoi(b []byte) int64 {
+	return int64(binary.BigEndian.Uint64(b))
+}
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/gotd/contrib/storage"
+	"github.com/gotd/td/telegram/query/dialogs"
+	"github.com/gotd/td/tg"
+)
+
+const (
+	defaultBackfillLimit = 100
+	historyPageSize      = 100 // maximum of messages.getHistory
+)
+
+// backfillChannels reads the messages the receiving channels posted while the account was down
+func (t *Engine) backfillChannels(ctx context.Context, resolver channelResolver) {
+	if t.LastMessageID == nil {
+		return
+	}
+	t.mutex.RLock()
+	n := len(t.ReceivingChannels)
+	t.mutex.RUnlock()
+
+	for i := 0; i < n; i++ {
+		t.backfill(ctx, resolver, i)
+	}
+}
+
+// backfill delivers the messages posted after the last one processed, oldest first. A channel
+// never processed, e.g. just added, gets its latest messages.
+func (t *Engine) backfill(ctx context.Context, resolver channelResolver, i int) {
+	if t.LastMessageID == nil {
+		return
+	}
+	t.mutex.RLock()
+	channel := t.ReceivingChannels[i]
+	t.mutex.RUnlock()
+	if channel.ChannelID == 0 {
+		return
+	}
+
+	since := t.LastMessageID(channel.Name)
+	messages, complete, err := history(ctx, resolver, channel, since, t.backfillLimit())
+	if err != nil {
+		fmt.Printf("channel %s is not backfilled: %v\n", channel.Name, err)
+		return
+	}
+	if len(messages) == 0 {
+		return
+	}
+	if !complete && since != 0 {
+		fmt.Printf("channel %s posted more than %d messages since %d, the oldest are skipped\n", channel.Name, len(messages), since)
+	}
+	fmt.Printf("channel %s is backfilled with %d messages\n", channel.Name, len(messages))
+	for _, message := range messages {
+		t.deliver(message)
+	}
+}
+
+func (t *Engine) backfillLimit() int {
+	if t.Options.BackfillLimit > 0 {
+		return t.Options.BackfillLimit
+	}
+	return defaultBackfillLimit
+}
+
+// history returns the messages of the channel after since, at most limit of the latest ones
+// sorted by id, and whether none was left out. The messages the channel doesn't accept, e.g.
+// out of its topic, are left out too.
+func history(ctx context.Context, resolver channelResolver, channel ReceivingChannel, since, limit int) ([]Message, bool, error) {
+	channelID := channel.ChannelID
+	p, err := findPeer(ctx, resolver.peers, channel)
+	if err != nil {
+		return nil, false, fmt.Errorf("find chat %d: %w", channelID, err)
+	}
+	input := p.AsInputPeer()
+	from := sourceOf(p)
+	if !from.sharedIDs {
+		// The last message processed may be numbered by another account
+		return nil, true, nil
+	}
+
+	var messages []Message
+	offsetID := 0
+	for len(messages) < limit {
+		pageSize := limit - len(messages)
+		if pageSize > historyPageSize {
+			pageSize = historyPageSize
+		}
+		result, err := resolver.api.MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{
+			Peer:     input,
+			OffsetID: offsetID,
+			Limit:    pageSize,
+			MinID:    since,
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("get history of %d: %w", channelID, err)
+		}
+		modified, ok := result.AsModified()
+		if !ok {
+			break
+		}
+		page := modified.GetMessages()
+		previousOffsetID := offsetID
+		for _, m := range page {
+			// Service messages move the offset along, they are never delivered
+			if offsetID == 0 || m.GetID() < offsetID {
+				offsetID = m.GetID()
+			}
+			msg, ok := m.(*tg.Message)
+			if !ok || msg.ID <= since {
+				continue
+			}
+			message := newMessage(MessageNew, from, msg)
+			message.Backfill = true
+			if channel.accepts(message) {
+				messages = append(messages, message)
+			}
+		}
+		if len(page) < pageSize || offsetID == previousOffsetID {
+			sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+			return messages, true, nil
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	return messages, false, nil
+}
+
+// findPeer finds the chat of the channel in the peer storage, a group is either a supergroup
+// or a basic one
+func findPeer(ctx context.Context, peers storage.PeerStorage, channel ReceivingChannel) (storage.Peer, error) {
+	var kinds []dialogs.PeerKind
+	switch channel.Source {
+	case SourceGroup:
+		kinds = []dialogs.PeerKind{dialogs.Channel, dialogs.Chat}
+	case SourcePrivate:
+		kinds = []dialogs.PeerKind{dialogs.User}
+	default:
+		kinds = []dialogs.PeerKind{dialogs.Channel}
+	}
+	var err error
+	for _, kind := range kinds {
+		var p storage.Peer
+		if p, err = peers.Find(ctx, storage.PeerKey{Kind: kind, ID: channel.ChannelID}); err == nil {
+			return p, nil
+		}
+	}
+	return storage.Peer{}, err
+}
@@ -0,0 +1,244 @@
+package client
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/telegram/auth"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+const (
+	// LoginTimeout is the default wait for an answer to a login question
+	LoginTimeout = 10 * time.Minute
+	// LoginRetries is the default number of login attempts after a wrong or missing answer
+	LoginRetries = 3
+)
+
+var ErrLoginTimeout = errors.New("no answer to the login question in time")
+
+type LoginQuestion string
+
+const (
+	LoginCode     LoginQuestion = "code"     // the code Telegram sent to the account
+	LoginPassword LoginQuestion = "password" // the 2FA password of the account
+)
+
+// LoginRequest is a question of the login of an account waiting for an answer
+type LoginRequest struct {
+	Phone    string        `json:"phone"`
+	Question LoginQuestion `json:"question"`
+	Attempt  int           `json:"attempt"` // 1 for the first attempt, more after wrong answers
+	Since    time.Time     `json:"since"`
+}
+
+type loginWaiter struct {
+	request LoginRequest
+	answer  chan string
+}
+
+// LoginBroker hands the login questions of the accounts to whoever answers them, e.g. an
+// admin through the control bot or the HTTP admin endpoint, so a headless deploy can log in
+type LoginBroker struct {
+	mutex   *sync.Mutex
+	pending map[string]*loginWaiter // phone -> question waiting for an answer
+
+	// Notify tells the admins a question is waiting for them
+	Notify func(request LoginRequest)
+	// Done tells the question no longer waits: it's answered, timed out or abandoned
+	Done func(request LoginRequest)
+}
+
+// NewLoginBroker is a constructor for LoginBroker
+func NewLoginBroker() *LoginBroker {
+	return &LoginBroker{
+		mutex:   &sync.Mutex{},
+		pending: make(map[string]*loginWaiter),
+	}
+}
+
+// Ask waits for the answer to request until ctx is done
+func (b *LoginBroker) Ask(ctx context.Context, request LoginRequest) (string, error) {
+	request.Since = time.Now()
+	waiter := &loginWaiter{request: request, answer: make(chan string, 1)}
+
+	b.mutex.Lock()
+	b.pending[request.Phone] = waiter
+	b.mutex.Unlock()
+	defer func() {
+		b.mutex.Lock()
+		if b.pending[request.Phone] == waiter {
+			delete(b.pending, request.Phone)
+		}
+		b.mutex.Unlock()
+		if b.Done != nil {
+			b.Done(request)
+		}
+	}()
+
+	if b.Notify != nil {
+		b.Notify(request)
+	}
+	select {
+	case answer := <-waiter.answer:
+		return answer, nil
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", ErrLoginTimeout
+		}
+		return "", ctx.Err()
+	}
+}
+
+// Answer gives the answer to the pending question of the account of phone
+func (b *LoginBroker) Answer(phone, answer string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	waiter, ok := b.pending[phone]
+	if !ok {
+		return fmt.Errorf("no login question is waiting for %s", phone)
+	}
+	select {
+	case waiter.answer <- answer:
+		delete(b.pending, phone)
+		return nil
+	default:
+		return fmt.Errorf("login question of %s is already answered", phone)
+	}
+}
+
+// Pending returns the questions waiting for an answer, sorted by phone
+func (b *LoginBroker) Pending() []LoginRequest {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	requests := make([]LoginRequest, 0, len(b.pending))
+	for _, waiter := range b.pending {
+		requests = append(requests, waiter.request)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].Phone < requests[j].Phone
+	})
+	return requests
+}
+
+// RemoteAuth is an auth.UserAuthenticator asking the login code and password through a LoginBroker
+type RemoteAuth struct {
+	PhoneNumber string
+	Broker      *LoginBroker
+	Timeout     time.Duration // wait for each answer, LoginTimeout when zero
+
+	attempts map[LoginQuestion]int
+}
+
+// Phone implements auth.UserAuthenticator
+func (a *RemoteAuth) Phone(_ context.Context) (string, error) {
+	return a.PhoneNumber, nil
+}
+
+// Code implements auth.CodeAuthenticator, the digits are kept alone since Telegram expires
+// the codes shared as is in a chat, so the admins are asked to space them out
+func (a *RemoteAuth) Code(ctx context.Context, _ *tg.AuthSentCode) (string, error) {
+	answer, err := a.ask(ctx, LoginCode)
+	if err != nil {
+		return "", err
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, answer), nil
+}
+
+// Password implements auth.UserAuthenticator
+func (a *RemoteAuth) Password(ctx context.Context) (string, error) {
+	answer, err := a.ask(ctx, LoginPassword)
+	return strings.TrimSpace(answer), err
+}
+
+// AcceptTermsOfService implements auth.UserAuthenticator, only new accounts are asked to
+func (a *RemoteAuth) AcceptTermsOfService(_ context.Context, _ tg.HelpTermsOfService) error {
+	return fmt.Errorf("account %s is not signed up, sign it up with an official app first", a.PhoneNumber)
+}
+
+// SignUp implements auth.UserAuthenticator, accounts are never signed up by the bot
+func (a *RemoteAuth) SignUp(_ context.Context) (auth.UserInfo, error) {
+	return auth.UserInfo{}, fmt.Errorf("account %s is not signed up, sign it up with an official app first", a.PhoneNumber)
+}
+
+func (a *RemoteAuth) ask(ctx context.Context, question LoginQuestion) (string, error) {
+	timeout := a.Timeout
+	if timeout == 0 {
+		timeout = LoginTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if a.attempts == nil {
+		a.attempts = make(map[LoginQuestion]int)
+	}
+	a.attempts[question]++
+	return a.Broker.Ask(ctx, LoginRequest{
+		Phone:    a.PhoneNumber,
+		Question: question,
+		Attempt:  a.attempts[question],
+	})
+}
+
+// retryLogin tells whether a failed login is worth another attempt, i.e. it failed on a
+// wrong, expired or missing answer
+func retryLogin(err error) bool {
+	return errors.Is(err, ErrLoginTimeout) ||
+		errors.Is(err, auth.ErrPasswordInvalid) ||
+		tgerr.Is(err, "PHONE_CODE_INVALID", "PHONE_CODE_EXPIRED", "PHONE_CODE_EMPTY")
+}
+
+// Handler serves the login questions over HTTP to the requests bearing token:
+//
+//	GET  /login                                  pending questions
+//	POST /login {"phone": "+98...", "answer": "1 2 3 4 5"}
+func (b *LoginBroker) Handler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(b.Pending())
+		case http.MethodPost:
+			var body struct {
+				Phone  string `json:"phone"`
+				Answer string `json:"answer"`
+			}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&body); err != nil {
+				http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+				return
+			}
+			if err := b.Answer(body.Phone, body.Answer); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	return mux
+}
@@ -0,0 +1,50 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/moneyscripter/teletrade/telegram_engine/client"
+)
+
+func TestLoginBrokerDone(t *testing.T) {
+	broker := client.NewLoginBroker()
+	asked := make(chan client.LoginRequest, 1)
+	var done []string
+	broker.Notify = func(request client.LoginRequest) { asked <- request }
+	broker.Done = func(request client.LoginRequest) { done = append(done, request.Phone) }
+
+	// Answered
+	answered := make(chan string)
+	go func() {
+		answer, _ := broker.Ask(context.Background(), client.LoginRequest{Phone: "+1", Question: client.LoginCode, Attempt: 1})
+		answered <- answer
+	}()
+	<-asked
+	if err := broker.Answer("+1", "12345"); err != nil {
+		t.Fatal(err)
+	}
+	if answer := <-answered; answer != "12345" {
+		t.Fatalf("got answer %q", answer)
+	}
+
+	// Timed out
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := broker.Ask(ctx, client.LoginRequest{Phone: "+2", Question: client.LoginPassword, Attempt: 1}); !errors.Is(err, client.ErrLoginTimeout) {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+	<-asked
+
+	if len(done) != 2 || done[0] != "+1" || done[1] != "+2" {
+		t.Fatalf("done with %v, want +1 and +2", done)
+	}
+	if pending := broker.Pending(); len(pending) != 0 {
+		t.Fatalf("questions still pending %+v", pending)
+	}
+	if err := broker.Answer("+2", "late"); err == nil {
+		t.Fatal("late answer is accepted")
+	}
+}
@@ -0,0 +1,264 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// seenWindow is how long a delivered message is remembered to drop the copies of the other accounts
+	seenWindow = 10 * time.Minute
+	// restartDelay is the first wait before restarting a stopped account, doubled up to maxRestartDelay
+	restartDelay    = 2 * time.Second
+	maxRestartDelay = 5 * time.Minute
+)
+
+// Account is a Telegram user account listening to the channels, its session is kept
+// under session/phone-<digits>
+type Account struct {
+	Phone    string
+	AppID    int
+	AppHash  string
+	Channels []string // names of the channels it listens to, every channel when empty
+	Options  Options
+}
+
+// Pool runs several accounts at once. A message seen by more than one account is delivered
+// once, and the channels of an account going down are taken over by a healthy one.
+type Pool struct {
+	mutex         *sync.Mutex
+	failoverMutex *sync.Mutex // a single failover at once, so a channel is taken over once
+	engines       []*Engine
+	healthy       map[*Engine]bool
+	seen          map[uint64]time.Time
+	pruned        time.Time
+
+	channels []ReceivingChannel
+}
+
+// NewPool is a constructor for Pool, every channel has to be listened to by an account
+func NewPool(accounts []Account, receivingChannels []ReceivingChannel) (*Pool, error) {
+	if len(accounts) == 0 {
+		return nil, fmt.Errorf("no telegram account")
+	}
+	p := &Pool{
+		mutex:         &sync.Mutex{},
+		failoverMutex: &sync.Mutex{},
+		healthy:       make(map[*Engine]bool),
+		seen:          make(map[uint64]time.Time),
+		channels:      receivingChannels,
+	}
+
+	sessionDirs := make(map[string]bool)
+	covered := make(map[string]bool)
+	for _, account := range accounts {
+		engine := &Engine{
+			Phone:   account.Phone,
+			AppID:   account.AppID,
+			AppHash: account.AppHash,
+			Options: account.Options,
+			Filter:  p.firstSeen,
+		}
+		// Accounts sharing a session would log each other out
+		sessionDir := filepath.Clean(engine.SessionDir())
+		if sessionDirs[sessionDir] {
+			return nil, fmt.Errorf("account %s shares the session %s of another account", account.Phone, sessionDir)
+		}
+		sessionDirs[sessionDir] = true
+
+		for _, name := range account.Channels {
+			channel, ok := p.channel(name)
+			if !ok {
+				return nil, fmt.Errorf("account %s listens to unknown channel %s", account.Phone, name)
+			}
+			engine.ReceivingChannels = append(engine.ReceivingChannels, channel)
+		}
+		if len(account.Channels) == 0 {
+			engine.ReceivingChannels = append(engine.ReceivingChannels, receivingChannels...)
+		}
+		for _, channel := range engine.ReceivingChannels {
+			covered[channel.Name] = true
+		}
+		p.engines = append(p.engines, engine)
+	}
+	for _, channel := range receivingChannels {
+		if !covered[channel.Name] {
+			return nil, fmt.Errorf("channel %s is listened to by no account", channel.Name)
+		}
+	}
+	return p, nil
+}
+
+// Engines returns the engine of every account, to be configured before Run
+func (p *Pool) Engines() []*Engine {
+	return p.engines
+}
+
+// Run runs every account until ctx is done, an account stopping on an error is restarted
+// with an increasing delay while its channels are covered by the others
+func (p *Pool) Run(ctx context.Context) {
+	wg := &sync.WaitGroup{}
+	for _, engine := range p.engines {
+		onHealth := engine.OnHealth
+		engine.OnHealth = func(engine *Engine) func(bool) {
+			return func(healthy bool) {
+				if onHealth != nil {
+					onHealth(healthy)
+				}
+				p.setHealth(ctx, engine, healthy)
+			}
+		}(engine)
+
+		wg.Add(1)
+		go func(engine *Engine) {
+			defer wg.Done()
+			p.runEngine(ctx, engine)
+		}(engine)
+	}
+	wg.Wait()
+}
+
+func (p *Pool) runEngine(ctx context.Context, engine *Engine) {
+	delay := restartDelay
+	for {
+		started := time.Now()
+		if err := engine.Run(ctx); err != nil {
+			fmt.Printf("account %s stopped: %v\n", engine.Phone, err)
+		}
+		// Run may return before the account listened, e.g. on auth errors
+		p.setHealth(ctx, engine, false)
+
+		// An account that ran for a while is restarted quickly again
+		if time.Since(started) > maxRestartDelay {
+			delay = restartDelay
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+	}
+}
+
+// Healthy returns the phones of the accounts currently listening
+func (p *Pool) Healthy() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var phones []string
+	for engine, healthy := range p.healthy {
+		if healthy {
+			phones = append(phones, engine.Phone)
+		}
+	}
+	sort.Strings(phones)
+	return phones
+}
+
+func (p *Pool) setHealth(ctx context.Context, engine *Engine, healthy bool) {
+	p.mutex.Lock()
+	changed := p.healthy[engine] != healthy
+	p.healthy[engine] = healthy
+	p.mutex.Unlock()
+
+	if !changed {
+		return
+	}
+	if healthy {
+		fmt.Printf("account %s is listening\n", engine.Phone)
+	} else {
+		fmt.Printf("account %s is down\n", engine.Phone)
+	}
+	// Resolving and joining the channels taken over calls the API, out of the update handlers
+	go p.failover(ctx)
+}
+
+// failover hands every channel no healthy account listens to over to the healthy account
+// listening to the fewest channels. Channels taken over stay with their new account.
+func (p *Pool) failover(ctx context.Context) {
+	p.failoverMutex.Lock()
+	defer p.failoverMutex.Unlock()
+
+	p.mutex.Lock()
+	var healthy []*Engine
+	for _, engine := range p.engines {
+		if p.healthy[engine] {
+			healthy = append(healthy, engine)
+		}
+	}
+	p.mutex.Unlock()
+	if len(healthy) == 0 {
+		fmt.Println("no telegram account is listening, signals are missed")
+		return
+	}
+
+	for _, channel := range p.channels {
+		covered := false
+		for _, engine := range healthy {
+			if engine.Listens(channel.Name) {
+				covered = true
+				break
+			}
+		}
+		if covered {
+			continue
+		}
+		sort.SliceStable(healthy, func(i, j int) bool {
+			return healthy[i].channelCount() < healthy[j].channelCount()
+		})
+		fmt.Printf("channel %s is taken over by account %s\n", channel.Name, healthy[0].Phone)
+		healthy[0].AddChannel(ctx, channel)
+	}
+}
+
+// firstSeen tells whether message is delivered for the first time, edits are told apart by their
+// text. Basic groups and private chats number their messages per account, so their messages
+// are told apart by their author, date and text instead.
+func (p *Pool) firstSeen(message Message) bool {
+	h := fnv.New64a()
+	if message.sharedIDs || message.Kind == MessageDeleted {
+		fmt.Fprintf(h, "%s|%d|%d", message.Kind, message.ChannelID, message.ID)
+		if message.Kind == MessageEdited {
+			h.Write([]byte(message.Text))
+		}
+	} else {
+		fmt.Fprintf(h, "%s|%s|%d|%d|%d|", message.Kind, message.Source, message.ChannelID, message.AuthorID, message.Date.Unix())
+		h.Write([]byte(message.Text))
+	}
+	key := h.Sum64()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(p.pruned) > time.Minute {
+		for k, at := range p.seen {
+			if now.Sub(at) > seenWindow {
+				delete(p.seen, k)
+			}
+		}
+		p.pruned = now
+	}
+	if at, ok := p.seen[key]; ok && now.Sub(at) <= seenWindow {
+		return false
+	}
+	p.seen[key] = now
+	return true
+}
+
+func (p *Pool) channel(name string) (ReceivingChannel, bool) {
+	for _, channel := range p.channels {
+		if channel.Name == name {
+			return channel, true
+		}
+	}
+	return ReceivingChannel{}, false
+}
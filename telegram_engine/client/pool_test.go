@@ -0,0 +1,33 @@
+package client
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPoolDeliversOnceToAssignedAccount(t *testing.T) {
+	a := ReceivingChannel{Name: "A", ChannelID: 100, Chan: make(chan Message, 10)}
+	b := ReceivingChannel{Name: "B", ChannelID: 200, Chan: make(chan Message, 10)}
+	dir := t.TempDir()
+	pool, err := NewPool([]Account{
+		{Phone: "+1", Channels: []string{"B"}, Options: Options{SessionDir: filepath.Join(dir, "1")}},
+		{Phone: "+2", Channels: []string{"A"}, Options: Options{SessionDir: filepath.Join(dir, "2")}},
+	}, []ReceivingChannel{a, b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	engines := pool.Engines()
+
+	// Both accounts read the post of A, the one not assigned to it doesn't hide it
+	message := Message{Kind: MessageNew, ID: 1, ChannelID: 100, Source: SourceChannel, Text: "BTC long", sharedIDs: true}
+	engines[0].deliver(message)
+	engines[1].deliver(message)
+	engines[1].deliver(message)
+
+	if len(a.Chan) != 1 || len(b.Chan) != 0 {
+		t.Fatalf("A got %d messages and B %d, want 1 and 0", len(a.Chan), len(b.Chan))
+	}
+	if len(pool.seen) != 1 {
+		t.Fatalf("%d messages remembered, want 1", len(pool.seen))
+	}
+}
@@ -0,0 +1,218 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/contrib/storage"
+	"github.com/gotd/td/telegram/message/peer"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// ParseChannelLink tells the public username or the invite hash of a channel link, e.g.
+// "@CryptoTrade066", "https://t.me/CryptoTrade066", "https://t.me/+AbCd" or "t.me/joinchat/AbCd"
+func ParseChannelLink(link string) (username, inviteHash string) {
+	link = strings.TrimSpace(link)
+	if strings.HasPrefix(link, "@") {
+		return strings.TrimPrefix(link, "@"), ""
+	}
+	if !strings.Contains(link, "://") {
+		link = "https://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", ""
+	}
+	switch strings.TrimPrefix(strings.ToLower(u.Host), "www.") {
+	case "t.me", "telegram.me", "telegram.dog":
+	default:
+		return "", ""
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch {
+	case parts[0] == "":
+		return "", ""
+	case strings.HasPrefix(parts[0], "+"):
+		return "", strings.TrimPrefix(parts[0], "+")
+	case parts[0] == "joinchat" && len(parts) > 1:
+		return "", parts[1]
+	case parts[0] == "s" && len(parts) > 1:
+		return parts[1], ""
+	}
+	return parts[0], ""
+}
+
+// channelResolver finds the id of the receiving channels and joins them when needed, the
+// resolved peers are cached in the peer storage so a restart doesn't resolve them again
+type channelResolver struct {
+	api      *tg.Client
+	peers    storage.PeerStorage
+	cache    storage.ResolverCache
+	resolver peer.Resolver
+}
+
+func newChannelResolver(api *tg.Client, peers storage.PeerStorage) channelResolver {
+	resolver := peer.Plain(api)
+	return channelResolver{
+		api:      api,
+		peers:    peers,
+		cache:    storage.NewResolverCache(resolver, peers),
+		resolver: resolver,
+	}
+}
+
+// Resolve returns the id of the channel, looked up by its username or invite link, falling
+// back to the configured id for channels having neither. The id of a private chat is the
+// id of the user.
+func (r channelResolver) Resolve(ctx context.Context, channel ReceivingChannel) (int64, error) {
+	username, inviteHash := strings.TrimPrefix(channel.Username, "@"), ""
+	if username == "" {
+		username, inviteHash = ParseChannelLink(channel.Link)
+	}
+	switch {
+	case username != "" && channel.Source == SourcePrivate:
+		return r.resolveUser(ctx, username)
+	case username != "":
+		return r.resolveUsername(ctx, username)
+	case inviteHash != "":
+		return r.resolveInvite(ctx, inviteHash)
+	case channel.ChannelID != 0:
+		return channel.ChannelID, nil
+	}
+	return 0, errors.New("neither username, invite link nor channel id")
+}
+
+func (r channelResolver) resolveUsername(ctx context.Context, username string) (int64, error) {
+	input, err := r.cache.ResolveDomain(ctx, username)
+	if err != nil {
+		return 0, fmt.Errorf("resolve @%s: %w", username, err)
+	}
+	channel, err := r.channel(ctx, input)
+	if err != nil || !hasUsername(channel, username) {
+		// The cached peer is stale, e.g. the username moved to another channel
+		if input, err = r.resolver.ResolveDomain(ctx, username); err != nil {
+			return 0, fmt.Errorf("resolve @%s: %w", username, err)
+		}
+		if channel, err = r.channel(ctx, input); err != nil {
+			return 0, fmt.Errorf("resolve @%s: %w", username, err)
+		}
+		var p storage.Peer
+		if p.FromChat(channel) {
+			if err := r.peers.Assign(ctx, username, p); err != nil {
+				return 0, fmt.Errorf("cache @%s: %w", username, err)
+			}
+		}
+	}
+	if channel.Left {
+		if _, err := r.api.ChannelsJoinChannel(ctx, channel.AsInput()); err != nil {
+			return 0, fmt.Errorf("join @%s: %w", username, err)
+		}
+		fmt.Printf("Joined channel @%s\n", username)
+	}
+	return channel.ID, nil
+}
+
+func (r channelResolver) resolveUser(ctx context.Context, username string) (int64, error) {
+	input, err := r.cache.ResolveDomain(ctx, username)
+	if err != nil {
+		return 0, fmt.Errorf("resolve @%s: %w", username, err)
+	}
+	user, ok := input.(*tg.InputPeerUser)
+	if !ok {
+		return 0, fmt.Errorf("@%s is not a user", username)
+	}
+	return user.UserID, nil
+}
+
+func (r channelResolver) resolveInvite(ctx context.Context, hash string) (int64, error) {
+	key := "invite:" + hash
+	invite, err := r.api.MessagesCheckChatInvite(ctx, hash)
+	if err != nil {
+		// Expired or revoked links keep working for the chats already joined through them
+		if cached, cacheErr := r.peers.Resolve(ctx, key); cacheErr == nil && (cached.Channel != nil || cached.Chat != nil) {
+			return cached.Key.ID, nil
+		}
+		return 0, fmt.Errorf("check invite %s: %w", hash, err)
+	}
+
+	var chat tg.ChatClass
+	switch invite := invite.(type) {
+	case *tg.ChatInviteAlready:
+		chat = invite.Chat
+	default:
+		updates, err := r.api.MessagesImportChatInvite(ctx, hash)
+		if err != nil && !tgerr.Is(err, "USER_ALREADY_PARTICIPANT") {
+			return 0, fmt.Errorf("join invite %s: %w", hash, err)
+		}
+		if withChats, ok := updates.(interface{ GetChats() []tg.ChatClass }); ok {
+			for _, c := range withChats.GetChats() {
+				switch c.(type) {
+				case *tg.Channel, *tg.Chat:
+					chat = c
+				}
+				if chat != nil {
+					break
+				}
+			}
+		}
+		if chat == nil {
+			if peek, ok := invite.(*tg.ChatInvitePeek); ok {
+				chat = peek.Chat
+			}
+		}
+		fmt.Printf("Joined channel of invite %s\n", hash)
+	}
+	// Private groups are basic groups until they grow into supergroups
+	switch chat.(type) {
+	case *tg.Channel, *tg.Chat:
+	default:
+		return 0, fmt.Errorf("invite %s is neither a channel nor a group", hash)
+	}
+	var p storage.Peer
+	if p.FromChat(chat) {
+		if err := r.peers.Assign(ctx, key, p); err != nil {
+			return 0, fmt.Errorf("cache invite %s: %w", hash, err)
+		}
+	}
+	return p.Key.ID, nil
+}
+
+// channel fetches the current state of the channel of input
+func (r channelResolver) channel(ctx context.Context, input tg.InputPeerClass) (*tg.Channel, error) {
+	inputChannel, ok := input.(*tg.InputPeerChannel)
+	if !ok {
+		return nil, errors.New("not a channel")
+	}
+	chats, err := r.api.ChannelsGetChannels(ctx, []tg.InputChannelClass{&tg.InputChannel{
+		ChannelID:  inputChannel.ChannelID,
+		AccessHash: inputChannel.AccessHash,
+	}})
+	if err != nil {
+		return nil, err
+	}
+	for _, chat := range chats.GetChats() {
+		if channel, ok := chat.(*tg.Channel); ok {
+			return channel, nil
+		}
+	}
+	return nil, errors.New("channel is not accessible")
+}
+
+func hasUsername(channel *tg.Channel, username string) bool {
+	if channel == nil {
+		return false
+	}
+	if strings.EqualFold(channel.Username, username) {
+		return true
+	}
+	for _, u := range channel.Usernames {
+		if u.Active && strings.EqualFold(u.Username, username) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"time"
+
+	"github.com/gotd/contrib/storage"
+	"github.com/gotd/td/telegram/query/dialogs"
+	"github.com/gotd/td/tg"
+)
+
+// SourceKind is the kind of chat the signals of a receiving channel are posted in
+type SourceKind string
+
+const (
+	SourceChannel SourceKind = "channel" // broadcast channel, the default
+	SourceGroup   SourceKind = "group"   // basic group or supergroup, forum topics included
+	SourcePrivate SourceKind = "private" // private chat with a user, e.g. one forwarding the signals
+)
+
+// GeneralTopic is the topic of the forum messages posted out of any created topic
+const GeneralTopic = 1
+
+// source is the chat a message is posted in
+type source struct {
+	kind SourceKind
+	id   int64
+	// forum tells the chat is a supergroup with topics
+	forum bool
+	// sharedIDs tells every account sees the messages of the chat under the same ids, true
+	// for channels and supergroups, false for basic groups and private chats
+	sharedIDs bool
+}
+
+func sourceOf(p storage.Peer) source {
+	s := source{id: p.Key.ID}
+	switch p.Key.Kind {
+	case dialogs.Channel:
+		s.kind, s.sharedIDs = SourceChannel, true
+		if p.Channel != nil && p.Channel.Megagroup {
+			s.kind, s.forum = SourceGroup, p.Channel.Forum
+		}
+	case dialogs.Chat:
+		s.kind = SourceGroup
+	case dialogs.User:
+		s.kind = SourcePrivate
+	}
+	return s
+}
+
+func newMessage(kind MessageKind, from source, msg *tg.Message) Message {
+	message := Message{
+		Kind:      kind,
+		ID:        msg.ID,
+		ChannelID: from.id,
+		Source:    from.kind,
+		Date:      time.Unix(int64(msg.Date), 0),
+		Text:      msg.Message,
+		sharedIDs: from.sharedIDs,
+	}
+
+	// Channel posts have no author, group posts are from a user or the group itself when an
+	// admin posts anonymously, private chats are with the author
+	if fromID, ok := msg.GetFromID(); ok {
+		switch fromID := fromID.(type) {
+		case *tg.PeerUser:
+			message.AuthorID = fromID.UserID
+		case *tg.PeerChannel:
+			message.AuthorID = fromID.ChannelID
+		}
+	}
+	if message.AuthorID == 0 && from.kind == SourcePrivate && !msg.Out {
+		message.AuthorID = from.id
+	}
+
+	if from.forum {
+		message.TopicID = GeneralTopic
+	}
+	if replyTo, ok := msg.GetReplyTo(); ok {
+		if header, ok := replyTo.(*tg.MessageReplyHeader); ok {
+			replyToID, _ := header.GetReplyToMsgID()
+			topID, hasTop := header.GetReplyToTopID()
+			switch {
+			case header.ForumTopic && hasTop:
+				// A reply within a topic
+				message.TopicID, message.ReplyTo = topID, replyToID
+			case header.ForumTopic:
+				// A post of a topic refers to the topic itself, it replies to nothing
+				message.TopicID = replyToID
+			default:
+				message.ReplyTo = replyToID
+			}
+		}
+	}
+	return message
+}
+
+// accepts tells whether the message belongs to the receiving channel. Deleted messages
+// only have an id, the filters on their topic and author are left to the original message.
+func (c ReceivingChannel) accepts(message Message) bool {
+	if c.ChannelID == 0 || message.ChannelID != c.ChannelID {
+		return false
+	}
+	kind := c.Source
+	if kind == "" {
+		kind = SourceChannel
+	}
+	if message.Source != "" && message.Source != kind {
+		return false
+	}
+	if message.Kind == MessageDeleted {
+		return true
+	}
+	if c.TopicID != 0 && message.TopicID != c.TopicID {
+		return false
+	}
+	if len(c.Authors) > 0 {
+		for _, author := range c.Authors {
+			if author == message.AuthorID {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
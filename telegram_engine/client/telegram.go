@@ -2,12 +2,12 @@ package client
 
 import (
 	"context"
-	"flag"
 	"fmt"
 	"github.com/moneyscripter/teletrade/channels"
 	"os"
-	"os/signal"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	pebbledb "github.com/cockroachdb/pebble"
@@ -27,8 +27,8 @@ import (
 	"github.com/gotd/td/examples"
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/telegram/auth"
-	"github.com/gotd/td/telegram/message/peer"
 	"github.com/gotd/td/telegram/query"
+	"github.com/gotd/td/telegram/query/dialogs"
 	"github.com/gotd/td/telegram/updates"
 	"github.com/gotd/td/tg"
 )
@@ -43,25 +43,58 @@ func sessionFolder(phone string) string {
 	return "phone-" + string(out)
 }
 
-func (t *Engine) run(ctx context.Context) error {
-	var arg struct {
-		FillPeerStorage bool
+// Options of an engine, the zero value keeps the session of the account under
+// session/phone-<digits> and its logs along
+type Options struct {
+	SessionDir      string        // session, peers and updates state of the account
+	LogPath         string        // rotated JSON logs, defaults to <session dir>/log.jsonl
+	EnvFile         string        // loaded into the environment when set and present, e.g. ".env"
+	FillPeerStorage bool          // collect the peers of every dialog on startup
+	RateInterval    time.Duration // minimum interval between requests, defaults to 100ms
+	RateBurst       int           // requests sent at once within the rate, defaults to 5
+	BackfillLimit   int           // messages read from the history of a channel, defaults to 100
+}
+
+const (
+	defaultRateInterval = 100 * time.Millisecond
+	defaultRateBurst    = 5
+)
+
+// SessionDir returns the directory of the session of the account
+func (t *Engine) SessionDir() string {
+	if t.Options.SessionDir != "" {
+		return t.Options.SessionDir
 	}
-	flag.BoolVar(&arg.FillPeerStorage, "fill-peer-storage", false, "fill peer storage")
-	flag.Parse()
+	return filepath.Join("session", sessionFolder(t.Phone))
+}
 
-	// Using ".env" file to load environment variables.
-	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
-		return errors.Wrap(err, "load env")
+// session holds what a logged in account is used through
+type session struct {
+	client  *telegram.Client
+	api     *tg.Client
+	peers   storage.PeerStorage
+	updates *updates.Manager
+	self    *tg.User
+}
+
+// connect sets the client of the account up, logs it in and calls f until it returns
+func (t *Engine) connect(ctx context.Context, f func(ctx context.Context, s session) error) error {
+	if t.Options.EnvFile != "" {
+		if err := godotenv.Load(t.Options.EnvFile); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "load env")
+		}
 	}
 
 	// Setting up session storage.
 	// This is needed to reuse session and not login every time.
-	sessionDir := filepath.Join("session", sessionFolder(t.Phone))
+	sessionDir := t.SessionDir()
 	if err := os.MkdirAll(sessionDir, 0700); err != nil {
 		return err
 	}
-	logFilePath := filepath.Join(sessionDir, "log.jsonl")
+	logFilePath := t.Options.LogPath
+	if logFilePath == "" {
+		logFilePath = filepath.Join(sessionDir, "log.jsonl")
+	}
 
 	fmt.Printf("Storing session in %s, logs in %s\n", sessionDir, logFilePath)
 
@@ -87,10 +120,12 @@ func (t *Engine) run(ctx context.Context) error {
 		Path: filepath.Join(sessionDir, "session.json"),
 	}
 	// Peer storage, for resolve caching and short updates handling.
+	// Storages are closed on return, so the account can be connected again by the same process.
 	db, err := pebbledb.Open(filepath.Join(sessionDir, "peers.pebble.db"), &pebbledb.Options{})
 	if err != nil {
 		return errors.Wrap(err, "create pebble storage")
 	}
+	defer db.Close()
 	peerDB := pebble.NewPeerStorage(db)
 	lg.Info("Storage", zap.String("path", sessionDir))
 
@@ -104,10 +139,11 @@ func (t *Engine) run(ctx context.Context) error {
 
 	// Setting up persistent storage for qts/pts to be able to
 	// recover after restart.
-	boltdb, err := bbolt.Open(filepath.Join(sessionDir, "updates.bolt.db"), 0666, nil)
+	boltdb, err := bbolt.Open(filepath.Join(sessionDir, "updates.bolt.db"), 0666, &bbolt.Options{Timeout: time.Second})
 	if err != nil {
 		return errors.Wrap(err, "create bolt storage")
 	}
+	defer boltdb.Close()
 	updatesRecovery := updates.New(updates.Config{
 		Handler: updateHandler, // using previous handler with peerDB
 		Logger:  lg.Named("updates.recovery"),
@@ -121,6 +157,13 @@ func (t *Engine) run(ctx context.Context) error {
 		fmt.Println("Got FLOOD_WAIT. Will retry after", wait.Duration)
 	})
 
+	rateInterval, rateBurst := t.Options.RateInterval, t.Options.RateBurst
+	if rateInterval == 0 {
+		rateInterval = defaultRateInterval
+	}
+	if rateBurst == 0 {
+		rateBurst = defaultRateBurst
+	}
 	// Filling client options.
 	options := telegram.Options{
 		Logger:         lg,              // Passing logger for observability.
@@ -130,46 +173,17 @@ func (t *Engine) run(ctx context.Context) error {
 			// Setting up FLOOD_WAIT handler to automatically wait and retry request.
 			waiter,
 			// Setting up general rate limits to less likely get flood wait errors.
-			ratelimit.New(rate.Every(time.Millisecond*100), 5),
+			ratelimit.New(rate.Every(rateInterval), rateBurst),
 		},
 	}
 	client := telegram.NewClient(t.AppID, t.AppHash, options)
-	api := client.API()
-
-	// Setting up resolver cache that will use peer storage.
-	resolver := storage.NewResolverCache(peer.Plain(api), peerDB)
-	// Usage:
-	//   if _, err := resolver.ResolveDomain(ctx, "tdlibchat"); err != nil {
-	//	   return errors.Wrap(err, "resolve")
-	//   }
-	_ = resolver
-
-	dispatcher.OnNewChannelMessage(func(ctx context.Context, e tg.Entities, update *tg.UpdateNewChannelMessage) error {
-		msg, ok := update.Message.(*tg.Message)
-		if !ok {
-			return nil
-		}
-		p, err := storage.FindPeer(ctx, peerDB, msg.GetPeerID())
-		if err != nil {
-			return err
-		}
-
-		for _, channel := range t.ReceivingChannels {
-			if p.Channel.ID == channel.ChannelID {
-				channel.Chan <- msg.Message
-			}
-		}
-		return nil
-	})
-
-	// Authentication flow handles authentication process, like prompting for code and 2FA password.
-	flow := auth.NewFlow(examples.Terminal{PhoneNumber: t.Phone}, auth.SendCodeOptions{})
+	t.handleMessages(dispatcher, peerDB)
 
 	return waiter.Run(ctx, func(ctx context.Context) error {
 		// Spawning main goroutine.
 		if err := client.Run(ctx, func(ctx context.Context) error {
 			// Perform auth if no session is available.
-			if err := client.Auth().IfNecessary(ctx, flow); err != nil {
+			if err := t.login(ctx, client); err != nil {
 				return errors.Wrap(err, "auth")
 			}
 
@@ -193,22 +207,12 @@ func (t *Engine) run(ctx context.Context) error {
 				zap.Int64("id", self.ID),
 			)
 
-			if arg.FillPeerStorage {
-				fmt.Println("Filling peer storage from dialogs to cache entities")
-				collector := storage.CollectPeers(peerDB)
-				if err := collector.Dialogs(ctx, query.GetDialogs(api).Iter()); err != nil {
-					return errors.Wrap(err, "collect peers")
-				}
-				fmt.Println("Filled")
-			}
-
-			// Waiting until context is done.
-			fmt.Println("Listening for updates. Interrupt (Ctrl+C) to stop.")
-			return updatesRecovery.Run(ctx, api, self.ID, updates.AuthOptions{
-				IsBot: self.Bot,
-				OnStart: func(ctx context.Context) {
-					fmt.Println("Update recovery initialized and started, listening for events")
-				},
+			return f(ctx, session{
+				client:  client,
+				api:     client.API(),
+				peers:   peerDB,
+				updates: updatesRecovery,
+				self:    self,
 			})
 		}); err != nil {
 			return errors.Wrap(err, "run")
@@ -217,10 +221,214 @@ func (t *Engine) run(ctx context.Context) error {
 	})
 }
 
+// handleMessages delivers the messages of the channels, groups and private chats to the
+// receiving channels
+func (t *Engine) handleMessages(dispatcher tg.UpdateDispatcher, peerDB storage.PeerStorage) {
+	chatMessage := func(ctx context.Context, kind MessageKind, m tg.MessageClass) error {
+		msg, ok := m.(*tg.Message)
+		if !ok {
+			return nil
+		}
+		p, err := storage.FindPeer(ctx, peerDB, msg.GetPeerID())
+		if err != nil {
+			return err
+		}
+		t.deliver(newMessage(kind, sourceOf(p), msg))
+		return nil
+	}
+
+	// Channels and supergroups
+	dispatcher.OnNewChannelMessage(func(ctx context.Context, e tg.Entities, update *tg.UpdateNewChannelMessage) error {
+		return chatMessage(ctx, MessageNew, update.Message)
+	})
+	dispatcher.OnEditChannelMessage(func(ctx context.Context, e tg.Entities, update *tg.UpdateEditChannelMessage) error {
+		return chatMessage(ctx, MessageEdited, update.Message)
+	})
+	dispatcher.OnDeleteChannelMessages(func(ctx context.Context, e tg.Entities, update *tg.UpdateDeleteChannelMessages) error {
+		for _, id := range update.Messages {
+			t.deliver(Message{
+				Kind:      MessageDeleted,
+				ID:        id,
+				ChannelID: update.ChannelID,
+				Date:      time.Now(),
+				sharedIDs: true,
+			})
+		}
+		return nil
+	})
+
+	// Basic groups and private chats. Their deletes do not tell the chat, they are not delivered.
+	dispatcher.OnNewMessage(func(ctx context.Context, e tg.Entities, update *tg.UpdateNewMessage) error {
+		return chatMessage(ctx, MessageNew, update.Message)
+	})
+	dispatcher.OnEditMessage(func(ctx context.Context, e tg.Entities, update *tg.UpdateEditMessage) error {
+		return chatMessage(ctx, MessageEdited, update.Message)
+	})
+}
+
+// deliver hands the message to the receiving channels accepting it. The filter only sees the
+// messages some channel accepts, so an account reading a chat it's not assigned to never
+// hides the message from the account assigned to it.
+func (t *Engine) deliver(message Message) {
+	var receivers []chan Message
+	t.mutex.RLock()
+	for _, channel := range t.ReceivingChannels {
+		if channel.accepts(message) {
+			receivers = append(receivers, channel.Chan)
+		}
+	}
+	t.mutex.RUnlock()
+
+	if len(receivers) == 0 || (t.Filter != nil && !t.Filter(message)) {
+		return
+	}
+	for _, receiver := range receivers {
+		receiver <- message
+	}
+}
+
+// listen resolves the receiving channels and delivers their posts until ctx is done
+func (t *Engine) listen(ctx context.Context, s session) error {
+	if t.Options.FillPeerStorage {
+		if err := fillPeers(ctx, s); err != nil {
+			return err
+		}
+	}
+
+	// Channels are looked up by username or invite link through the peer storage cache
+	resolver := newChannelResolver(s.api, s.peers)
+	t.resolveChannels(ctx, resolver)
+	t.backfillChannels(ctx, resolver)
+	t.mutex.Lock()
+	t.resolver = &resolver
+	t.mutex.Unlock()
+	defer func() {
+		t.mutex.Lock()
+		t.resolver = nil
+		t.mutex.Unlock()
+		if t.OnHealth != nil {
+			t.OnHealth(false)
+		}
+	}()
+
+	// Waiting until context is done.
+	fmt.Println("Listening for updates. Interrupt (Ctrl+C) to stop.")
+	return s.updates.Run(ctx, s.api, s.self.ID, updates.AuthOptions{
+		IsBot: s.self.Bot,
+		OnStart: func(ctx context.Context) {
+			fmt.Println("Update recovery initialized and started, listening for events")
+			if t.OnHealth != nil {
+				t.OnHealth(true)
+			}
+		},
+	})
+}
+
+func fillPeers(ctx context.Context, s session) error {
+	fmt.Println("Filling peer storage from dialogs to cache entities")
+	collector := storage.CollectPeers(s.peers)
+	if err := collector.Dialogs(ctx, query.GetDialogs(s.api).Iter()); err != nil {
+		return errors.Wrap(err, "collect peers")
+	}
+	fmt.Println("Filled")
+	return nil
+}
+
+// Login logs the account in if its session is missing or revoked
+func (t *Engine) Login(ctx context.Context) error {
+	return t.connect(ctx, func(ctx context.Context, s session) error {
+		return nil
+	})
+}
+
+// FillPeers caches the peers of every dialog of the account
+func (t *Engine) FillPeers(ctx context.Context) error {
+	return t.connect(ctx, fillPeers)
+}
+
+// Dialog is a chat, a group or a channel the account takes part in
+type Dialog struct {
+	Kind     string // user, chat or channel
+	ID       int64
+	Title    string
+	Username string
+}
+
+// Dialogs lists the dialogs of the account, e.g. to find the id of a private channel
+func (t *Engine) Dialogs(ctx context.Context) ([]Dialog, error) {
+	var list []Dialog
+	err := t.connect(ctx, func(ctx context.Context, s session) error {
+		return query.GetDialogs(s.api).ForEach(ctx, func(ctx context.Context, elem dialogs.Elem) error {
+			var dialog Dialog
+			switch p := elem.Peer.(type) {
+			case *tg.InputPeerChannel:
+				dialog = Dialog{Kind: "channel", ID: p.ChannelID}
+				if channel, ok := elem.Entities.Channel(p.ChannelID); ok {
+					dialog.Title, dialog.Username = channel.Title, channel.Username
+				}
+			case *tg.InputPeerChat:
+				dialog = Dialog{Kind: "chat", ID: p.ChatID}
+				if chat, ok := elem.Entities.Chat(p.ChatID); ok {
+					dialog.Title = chat.Title
+				}
+			case *tg.InputPeerUser:
+				dialog = Dialog{Kind: "user", ID: p.UserID}
+				if user, ok := elem.Entities.User(p.UserID); ok {
+					dialog.Title = strings.TrimSpace(user.FirstName + " " + user.LastName)
+					dialog.Username = user.Username
+				}
+			default:
+				return nil
+			}
+			list = append(list, dialog)
+			return nil
+		})
+	})
+	return list, err
+}
+
+type MessageKind string
+
+const (
+	MessageNew     MessageKind = "new"
+	MessageEdited  MessageKind = "edited"
+	MessageDeleted MessageKind = "deleted" // only the ID is known
+)
+
+// Message is a post received from a channel, a group or a private chat
+type Message struct {
+	Kind      MessageKind
+	ID        int
+	ChannelID int64      // id of the chat, the user for private chats
+	Source    SourceKind // empty for deleted messages of basic groups and private chats
+	TopicID   int        // forum topic, zero out of forums
+	AuthorID  int64      // user (or chat posting anonymously) who wrote it, zero for channel posts
+	Date      time.Time
+	Text      string
+	ReplyTo   int  // ID of the message it replies to, zero if none
+	Backfill  bool // read from the history after a downtime, not received live
+
+	sharedIDs bool // the ID is the same for every account, see source
+}
+
+// SharedIDs tells whether every account sees the message under the same ID, true for channels
+// and supergroups. Basic groups and private chats number their messages per account, their
+// IDs don't tell whether a message was processed.
+func (m Message) SharedIDs() bool {
+	return m.sharedIDs
+}
+
 type ReceivingChannel struct {
-	Chan      chan string
-	ChannelID int64
+	Name      string // channel key, as registered in channels.Register
+	Chan      chan Message
+	ChannelID int64  // resolved at startup when the channel has a username or an invite link
+	Username  string // public username, without the @
+	Link      string // t.me link of the channel, public or invite
 	Parser    channels.Channels
+
+	Source  SourceKind // kind of chat the signals are posted in, SourceChannel when empty
+	TopicID int        // forum topic the signals are posted in, zero for all of them
+	Authors []int64    // only the messages of these users are trusted, everyone's when empty
 }
 
 type Engine struct {
@@ -229,13 +437,126 @@ type Engine struct {
 	AppHash string
 
 	ReceivingChannels []ReceivingChannel
+	Options           Options
+	// Authenticator answers the login questions, the terminal when nil
+	Authenticator auth.UserAuthenticator
+	LoginRetries  int // login attempts after wrong or missing answers, LoginRetries when zero
+	// LastMessageID returns the last message processed of a receiving channel by name, the ones
+	// posted after it are read from the history on startup. Nil disables the backfill.
+	LastMessageID func(name string) int
+	// Filter drops the messages it returns false for, e.g. the ones another account delivered
+	Filter func(message Message) bool
+	// OnHealth is called with true once the account listens for updates and with false when it stops
+	OnHealth func(healthy bool)
+
+	mutex    sync.RWMutex
+	resolver *channelResolver // set while listening
 }
 
-func (t *Engine) Run(ctx context.Context) error {
-	ctxx, cancel := signal.NotifyContext(ctx, os.Interrupt)
-	defer cancel()
+// login authenticates the account when it has no session or it was revoked, it is attempted
+// again when the code or the password is wrong or not given in time
+func (t *Engine) login(ctx context.Context, client *telegram.Client) error {
+	authenticator := t.Authenticator
+	if authenticator == nil {
+		authenticator = examples.Terminal{PhoneNumber: t.Phone}
+	}
+	retries := t.LoginRetries
+	if retries == 0 {
+		retries = LoginRetries
+	}
+	if remote, ok := authenticator.(*RemoteAuth); ok {
+		remote.attempts = nil
+	}
+
+	var err error
+	for attempt := 1; attempt <= retries; attempt++ {
+		// Authentication flow handles authentication process, like prompting for code and 2FA password.
+		flow := auth.NewFlow(authenticator, auth.SendCodeOptions{})
+		if err = client.Auth().IfNecessary(ctx, flow); err == nil || !retryLogin(err) {
+			return err
+		}
+		fmt.Printf("login attempt %d of %s failed: %v\n", attempt, t.Phone, err)
+	}
+	return err
+}
+
+// resolveChannels looks the receiving channels up and joins them, a channel failing to
+// resolve keeps its previous id
+func (t *Engine) resolveChannels(ctx context.Context, resolver channelResolver) {
+	t.mutex.RLock()
+	n := len(t.ReceivingChannels)
+	t.mutex.RUnlock()
+
+	for i := 0; i < n; i++ {
+		t.resolveChannel(ctx, resolver, i)
+	}
+}
+
+func (t *Engine) resolveChannel(ctx context.Context, resolver channelResolver, i int) {
+	t.mutex.RLock()
+	channel := t.ReceivingChannels[i]
+	t.mutex.RUnlock()
+
+	channelID, err := resolver.Resolve(ctx, channel)
+	if err != nil {
+		fmt.Printf("channel %s is not resolved by %s: %v\n", channel.Name, t.Phone, err)
+		return
+	}
+	if channel.ChannelID != 0 && channel.ChannelID != channelID {
+		fmt.Printf("channel %s moved from id %d to %d\n", channel.Name, channel.ChannelID, channelID)
+	}
+
+	t.mutex.Lock()
+	t.ReceivingChannels[i].ChannelID = channelID
+	t.mutex.Unlock()
+}
 
-	if err := t.run(ctxx); err != nil {
+// AddChannel starts listening to channel, it is resolved and joined right away when the
+// account is listening and on its next start otherwise
+func (t *Engine) AddChannel(ctx context.Context, channel ReceivingChannel) {
+	t.mutex.Lock()
+	if t.listens(channel.Name) {
+		t.mutex.Unlock()
+		return
+	}
+	t.ReceivingChannels = append(t.ReceivingChannels, channel)
+	i := len(t.ReceivingChannels) - 1
+	resolver := t.resolver
+	t.mutex.Unlock()
+
+	if resolver != nil {
+		t.resolveChannel(ctx, *resolver, i)
+		t.backfill(ctx, *resolver, i)
+	}
+}
+
+// Listens tells whether the account receives the messages of the channel named name
+func (t *Engine) Listens(name string) bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	return t.listens(name)
+}
+
+func (t *Engine) channelCount() int {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	return len(t.ReceivingChannels)
+}
+
+func (t *Engine) listens(name string) bool {
+	for _, channel := range t.ReceivingChannels {
+		if channel.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Run listens to the receiving channels until ctx is done
+func (t *Engine) Run(ctx context.Context) error {
+	if err := t.connect(ctx, t.listen); err != nil {
 		if errors.Is(err, context.Canceled) && ctx.Err() == context.Canceled {
 			fmt.Println("\rClosed")
 			return nil
@@ -0,0 +1,50 @@
+package trades
+
+import (
+	"sync"
+
+	"github.com/moneyscripter/teletrade/models"
+)
+
+// Reconciler keeps the trades left unfinished by the previous run until the
+// exchange of their user is available again to resume them.
+type Reconciler struct {
+	mutex   *sync.Mutex
+	pending map[int64][]*models.Trade
+}
+
+// NewReconciler loads the unfinished trades of store and tracks them again
+func NewReconciler(store Store) (*Reconciler, error) {
+	unfinished, err := store.Unfinished()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make(map[int64][]*models.Trade)
+	for _, trade := range unfinished {
+		trade.OnChange = store.Save
+		pending[trade.ChatID] = append(pending[trade.ChatID], trade)
+	}
+	return &Reconciler{
+		mutex:   &sync.Mutex{},
+		pending: pending,
+	}, nil
+}
+
+// HasPending reports whether the user has trades waiting to be resumed
+func (r *Reconciler) HasPending(chatID int64) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return len(r.pending[chatID]) > 0
+}
+
+// Take returns the trades of the user waiting to be resumed and forgets them
+func (r *Reconciler) Take(chatID int64) []*models.Trade {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	trades := r.pending[chatID]
+	delete(r.pending, chatID)
+	return trades
+}
@@ -0,0 +1,96 @@
+package trades
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/moneyscripter/teletrade/models"
+	"go.etcd.io/bbolt"
+)
+
+// Store persists every transition of the trades, so unfinished ones can be recovered after a restart
+type Store interface {
+	Save(trade *models.Trade) error
+	Get(id string) (*models.Trade, error)
+	Unfinished() ([]*models.Trade, error)
+	Close() error
+}
+
+var tradesBucket = []byte("trades")
+
+type boltStore struct {
+	db *bbolt.DB
+}
+
+// NewBoltStore opens (or creates) the bbolt database at path
+func NewBoltStore(path string) (Store, error) {
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("open trade store: %w", err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(tradesBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+// Track persists the trade and every later transition of it
+func Track(s Store, trade *models.Trade) error {
+	trade.OnChange = s.Save
+	return s.Save(trade)
+}
+
+func (s *boltStore) Save(trade *models.Trade) error {
+	data, err := json.Marshal(trade)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(tradesBucket).Put([]byte(trade.ID), data)
+	})
+}
+
+func (s *boltStore) Get(id string) (*models.Trade, error) {
+	var trade *models.Trade
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(tradesBucket).Get([]byte(id))
+		if v == nil {
+			return fmt.Errorf("trade %s not found", id)
+		}
+		trade = &models.Trade{}
+		return json.Unmarshal(v, trade)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return trade, nil
+}
+
+func (s *boltStore) Unfinished() ([]*models.Trade, error) {
+	var unfinished []*models.Trade
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(tradesBucket).ForEach(func(k, v []byte) error {
+			trade := &models.Trade{}
+			if err := json.Unmarshal(v, trade); err != nil {
+				return fmt.Errorf("decode trade %s: %w", k, err)
+			}
+			if !trade.State.IsFinal() {
+				unfinished = append(unfinished, trade)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return unfinished, nil
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}